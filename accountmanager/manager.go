@@ -41,6 +41,7 @@ type AccountManager struct {
 	cachedBlockCount *big.Int
 	//block           *ChangedOfBlock
 	producerWrapped *kafka.MessageProducer
+	watchers        map[string]*eventemitter.Watcher
 }
 
 func isPackegeReady() error {
@@ -98,32 +99,37 @@ func sendBlockEndKafkaMsg(msg interface{}) error {
 }
 
 func (accountManager *AccountManager) Start() {
-	transferWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleTokenTransfer}
-	approveWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleApprove}
-	wethDepositWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleWethDeposit}
-	wethWithdrawalWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleWethWithdrawal}
-	blockForkWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleBlockFork}
-	blockEndWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleBlockEnd}
-	blockNewWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleBlockNew}
-	ethTransferWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleEthTransfer}
-	cancelOrderWather := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleCancelOrder}
-	cutoffAllWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleCutOff}
-	cutoffPairAllWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleCutOffPair}
-	unsupportedContractAllWatcher := &eventemitter.Watcher{Concurrent: false, Handle: accountManager.handleUnsupportedContract}
-
-	eventemitter.On(eventemitter.Transfer, transferWatcher)
-	eventemitter.On(eventemitter.Approve, approveWatcher)
-	eventemitter.On(eventemitter.EthTransfer, ethTransferWatcher)
-	eventemitter.On(eventemitter.Block_End, blockEndWatcher)
-	eventemitter.On(eventemitter.Block_New, blockNewWatcher)
-	eventemitter.On(eventemitter.WethDeposit, wethDepositWatcher)
-	eventemitter.On(eventemitter.WethWithdrawal, wethWithdrawalWatcher)
-	eventemitter.On(eventemitter.ChainForkDetected, blockForkWatcher)
-
-	eventemitter.On(eventemitter.CancelOrder, cancelOrderWather)
-	eventemitter.On(eventemitter.CutoffAll, cutoffAllWatcher)
-	eventemitter.On(eventemitter.CutoffPair, cutoffPairAllWatcher)
-	eventemitter.On(eventemitter.UnsupportedContract, unsupportedContractAllWatcher)
+	accountManager.watchers = map[string]*eventemitter.Watcher{
+		eventemitter.Transfer:          {Concurrent: false, Handle: accountManager.handleTokenTransfer},
+		eventemitter.Approve:           {Concurrent: false, Handle: accountManager.handleApprove},
+		eventemitter.EthTransfer:       {Concurrent: false, Handle: accountManager.handleEthTransfer},
+		eventemitter.Block_End:         {Concurrent: false, Handle: accountManager.handleBlockEnd},
+		eventemitter.Block_New:         {Concurrent: false, Handle: accountManager.handleBlockNew},
+		eventemitter.WethDeposit:       {Concurrent: false, Handle: accountManager.handleWethDeposit},
+		eventemitter.WethWithdrawal:    {Concurrent: false, Handle: accountManager.handleWethWithdrawal},
+		eventemitter.ChainForkDetected: {Concurrent: false, Handle: accountManager.handleBlockFork},
+
+		eventemitter.CancelOrder:         {Concurrent: false, Handle: accountManager.handleCancelOrder},
+		eventemitter.CutoffAll:           {Concurrent: false, Handle: accountManager.handleCutOff},
+		eventemitter.CutoffPair:          {Concurrent: false, Handle: accountManager.handleCutOffPair},
+		eventemitter.UnsupportedContract: {Concurrent: false, Handle: accountManager.handleUnsupportedContract},
+	}
+
+	for topic, watcher := range accountManager.watchers {
+		eventemitter.On(topic, watcher)
+	}
+}
+
+// Stop unregisters the event watchers and closes the kafka producer after the pending messages are sent
+func (accountManager *AccountManager) Stop() {
+	for topic, watcher := range accountManager.watchers {
+		eventemitter.Un(topic, watcher)
+	}
+	if nil != accountManager.producerWrapped {
+		if err := accountManager.producerWrapped.Close(); nil != err {
+			log.Errorf("accountmanager, close kafka producer error:%s", err.Error())
+		}
+	}
 }
 
 func (a *AccountManager) handleTokenTransfer(input eventemitter.EventData) (err error) {
@@ -20,6 +20,6 @@ fi
 cd $SRC_DIR
 rm -rf ./*
 cp -r $WORK_DIR/src/* ./
-go build -ldflags -s -v  -o build/bin/relay cmd/main.go
+go build -ldflags -s -v  -o build/bin/relay ./cmd
 echo "go build finished......."
 cp build/bin/relay $WORK_DIR/bin
@@ -30,6 +30,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"syscall"
 )
 
 func main() {
@@ -39,6 +40,10 @@ func main() {
 	app.Copyright = "Copyright 2013-2017 The Loopring Authors"
 	globalFlags := globalFlags()
 	app.Flags = append(app.Flags, globalFlags...)
+	app.Commands = []cli.Command{
+		rebuildTrendsCommand(),
+		orderKeysCommand(),
+	}
 
 	app.Before = func(ctx *cli.Context) error {
 		runtime.GOMAXPROCS(runtime.NumCPU())
@@ -67,25 +72,23 @@ func startNode(ctx *cli.Context) error {
 		}
 	}()
 
-	var n *node.Node
+	n := node.NewNode(logger, globalConfig)
+
+	// the first signal stops the node and Wait returns, the process exits 0 after main returns,
+	// a second signal during stopping exits immediately
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt)
-	signal.Notify(signalChan, os.Kill)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		for {
-			select {
-			case sig := <-signalChan:
-				log.Infof("captured %s, exiting...\n", sig.String())
-				if nil != n {
-					n.Stop()
-				}
-				os.Exit(1)
-			}
-		}
+		sig := <-signalChan
+		log.Infof("captured %s, stopping...", sig.String())
+		go func() {
+			sig := <-signalChan
+			log.Errorf("captured %s again, exiting...", sig.String())
+			os.Exit(1)
+		}()
+		n.Stop()
 	}()
 
-	n = node.NewNode(logger, globalConfig)
-
 	n.Start()
 
 	log.Info("started")
@@ -117,6 +120,8 @@ func setGlobalConfig(ctx *cli.Context) *node.GlobalConfig {
 	file := ""
 	if ctx.IsSet("config") {
 		file = ctx.String("config")
+	} else if ctx.GlobalIsSet("config") {
+		file = ctx.GlobalString("config")
 	}
 	globalConfig := node.LoadConfig(file)
 
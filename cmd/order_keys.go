@@ -0,0 +1,112 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/dao/envelope"
+	"github.com/Loopring/relay-lib/log"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var batchSizeFlag = cli.IntFlag{
+	Name:  "batch-size,b",
+	Usage: "orders read from lpr_orders at a time",
+	Value: 1000,
+}
+
+func orderKeysCommand() cli.Command {
+	return cli.Command{
+		Name:  "order-keys",
+		Usage: "manage encryption of auth private keys in lpr_orders, envelope must be enabled in config",
+		Subcommands: []cli.Command{
+			{
+				Name:   "migrate",
+				Usage:  "encrypt the plain keys saved before encryption, and the keys of old data keys, by the current data key",
+				Action: migrateOrderKeys,
+				Flags:  []cli.Flag{batchSizeFlag},
+			},
+			{
+				Name:   "rotate",
+				Usage:  "create a data key and encrypt all keys by it",
+				Action: rotateOrderKeys,
+				Flags:  []cli.Flag{batchSizeFlag},
+			},
+			{
+				Name:   "rewrap",
+				Usage:  "wrap all data keys by the current master key, run it after rotating the master key",
+				Action: rewrapOrderKeys,
+			},
+		},
+	}
+}
+
+func migrateOrderKeys(ctx *cli.Context) error {
+	rds, err := initOrderKeys(ctx)
+	if nil != err {
+		return err
+	}
+	if err := rds.WidenOrderPrivateKey(); nil != err {
+		return err
+	}
+	updated, err := rds.EncryptOrderPrivateKeys(envelope.Default(), ctx.Int("batch-size"))
+	fmt.Printf("encrypted auth private keys of %d orders\n", updated)
+	return err
+}
+
+func rotateOrderKeys(ctx *cli.Context) error {
+	rds, err := initOrderKeys(ctx)
+	if nil != err {
+		return err
+	}
+	id, err := envelope.Default().Rotate()
+	if nil != err {
+		return err
+	}
+	fmt.Printf("created data key %d\n", id)
+
+	updated, err := rds.EncryptOrderPrivateKeys(envelope.Default(), ctx.Int("batch-size"))
+	fmt.Printf("encrypted auth private keys of %d orders\n", updated)
+	return err
+}
+
+func rewrapOrderKeys(ctx *cli.Context) error {
+	if _, err := initOrderKeys(ctx); nil != err {
+		return err
+	}
+	rewrapped, err := envelope.Default().Rewrap()
+	fmt.Printf("rewrapped %d data keys\n", rewrapped)
+	return err
+}
+
+func initOrderKeys(ctx *cli.Context) (*dao.RdsService, error) {
+	globalConfig := setGlobalConfig(ctx)
+	log.Initialize(globalConfig.Log)
+
+	if !globalConfig.Envelope.Enabled {
+		return nil, fmt.Errorf("envelope isn't enabled in config")
+	}
+	rds := dao.NewDb(&globalConfig.Mysql)
+	if err := envelope.Initialize(globalConfig.Envelope, rds); nil != err {
+		return nil, err
+	}
+	return rds, nil
+}
@@ -0,0 +1,120 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-lib/cache"
+	"github.com/Loopring/relay-lib/log"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func rebuildTrendsCommand() cli.Command {
+	return cli.Command{
+		Name:   "rebuild-trends",
+		Usage:  "recompute trends of a market in a time range from lpr_fills",
+		Action: rebuildTrends,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "market,m",
+				Usage: "market of trends, e.g. LRC-WETH",
+			},
+			cli.StringFlag{
+				Name:  "intervals,i",
+				Usage: "comma separated intervals, e.g. 1Hr,1Day, default is trend.intervals in config",
+			},
+			cli.StringFlag{
+				Name:  "start,s",
+				Usage: "start of the range, unix seconds or utc time like 2018-07-01 or 2018-07-01T08:00:00Z",
+			},
+			cli.StringFlag{
+				Name:  "end,e",
+				Usage: "end of the range in the same format as start, default is now",
+			},
+		},
+	}
+}
+
+func rebuildTrends(ctx *cli.Context) error {
+	globalConfig := setGlobalConfig(ctx)
+
+	logger := log.Initialize(globalConfig.Log)
+	defer func() {
+		if nil != logger {
+			logger.Sync()
+		}
+	}()
+
+	mkt := ctx.String("market")
+	if "" == mkt {
+		return fmt.Errorf("market must be set")
+	}
+	start, err := parseTrendTime(ctx.String("start"))
+	if nil != err {
+		return err
+	}
+	end := time.Now().Unix()
+	if ctx.IsSet("end") {
+		if end, err = parseTrendTime(ctx.String("end")); nil != err {
+			return err
+		}
+	}
+
+	intervals := globalConfig.Trend.Intervals
+	if ctx.IsSet("intervals") {
+		intervals = strings.Split(ctx.String("intervals"), ",")
+		for i := range intervals {
+			intervals[i] = strings.TrimSpace(intervals[i])
+		}
+	}
+
+	rds := dao.NewDb(&globalConfig.Mysql)
+	cache.NewCache(globalConfig.Redis)
+	util.Initialize(&globalConfig.Market)
+
+	results, err := market.RebuildTrends(rds, mkt, intervals, start, end)
+	for _, result := range results {
+		fmt.Printf("%s %s %s - %s candles:%d changed:%d\n", strings.ToUpper(mkt), result.Interval,
+			time.Unix(result.Start, 0).UTC().Format(time.RFC3339), time.Unix(result.End, 0).UTC().Format(time.RFC3339),
+			result.Candles, result.Changed)
+	}
+	return err
+}
+
+func parseTrendTime(value string) (int64, error) {
+	if "" == value {
+		return 0, fmt.Errorf("start of the range must be set")
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); nil == err {
+		return ts, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if tm, err := time.Parse(layout, value); nil == err {
+			return tm.Unix(), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid time:%s", value)
+}
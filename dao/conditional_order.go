@@ -0,0 +1,163 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Loopring/relay-lib/crypto"
+	"github.com/Loopring/relay-lib/types"
+)
+
+// status of conditional orders, only dormant ones are watched by the trigger engine
+const (
+	ConditionalOrderDormant   = "dormant"
+	ConditionalOrderTriggered = "triggered"
+	ConditionalOrderFailed    = "failed"
+	ConditionalOrderCancelled = "cancelled"
+	ConditionalOrderExpired   = "expired"
+)
+
+// ConditionalOrder is a signed order held until its trigger price is reached, it isn't in lpr_orders until then,
+// so that it's neither in the order book nor matched by miners.
+// Order is the submitted order without the auth private key, which is saved in priv_key like lpr_orders
+type ConditionalOrder struct {
+	ID             int    `gorm:"column:id;primary_key;"`
+	OrderHash      string `gorm:"column:order_hash;type:varchar(82);unique_index"`
+	Owner          string `gorm:"column:owner;type:varchar(42);index"`
+	Market         string `gorm:"column:market;type:varchar(40)"`
+	Side           string `gorm:"column:side;type:varchar(40)"`
+	TriggerType    string `gorm:"column:trigger_type;type:varchar(20)"`
+	TriggerPrice   string `gorm:"column:trigger_price;type:varchar(40)"`
+	PriceSource    string `gorm:"column:price_source;type:varchar(20)"`
+	Order          string `gorm:"column:order_json;type:text"`
+	PrivateKey     string `gorm:"column:priv_key;type:varchar(256)"` // encrypted by envelope if it's enabled
+	ValidUntil     int64  `gorm:"column:valid_until;type:bigint"`
+	Status         string `gorm:"column:status;type:varchar(20);index"`
+	TriggeredPrice string `gorm:"column:triggered_price;type:varchar(40)"`
+	Error          string `gorm:"column:error;type:varchar(256)"`
+	TriggerTime    int64  `gorm:"column:trigger_time;type:bigint"`
+	CreateTime     int64  `gorm:"column:create_time;type:bigint"`
+	UpdateTime     int64  `gorm:"column:update_time;type:bigint"`
+}
+
+// ConvertDown saves order as Order and PrivateKey, the hash of order should have been generated
+func (o *ConditionalOrder) ConvertDown(order *types.OrderJsonRequest) error {
+	o.OrderHash = order.Hash.Hex()
+	o.Owner = order.Owner.Hex()
+	o.ValidUntil = order.ValidUntil.Int64()
+
+	auth, _ := order.AuthPrivateKey.MarshalText()
+	privateKey, err := encryptPrivateKey(string(auth), o.OrderHash)
+	if err != nil {
+		return err
+	}
+	o.PrivateKey = privateKey
+
+	withoutKey := *order
+	withoutKey.AuthPrivateKey = crypto.EthPrivateKeyCrypto{}
+	data, err := json.Marshal(withoutKey)
+	if err != nil {
+		return fmt.Errorf("marshal conditional order:%s error:%s", o.OrderHash, err.Error())
+	}
+	o.Order = string(data)
+	return nil
+}
+
+// ConvertUp restores the submitted order with its auth private key
+func (o *ConditionalOrder) ConvertUp(order *types.OrderJsonRequest) error {
+	if err := json.Unmarshal([]byte(o.Order), order); err != nil {
+		return fmt.Errorf("unmarshal conditional order:%s error:%s", o.OrderHash, err.Error())
+	}
+	privateKey, err := decryptPrivateKey(o.PrivateKey, o.OrderHash)
+	if err != nil {
+		return fmt.Errorf("decrypt auth private key of conditional order:%s error:%s", o.OrderHash, err.Error())
+	}
+	if len(privateKey) > 0 {
+		if order.AuthPrivateKey, err = crypto.NewPrivateKeyCrypto(false, privateKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RdsService) AddConditionalOrder(o *ConditionalOrder) error {
+	o.CreateTime = time.Now().Unix()
+	o.UpdateTime = o.CreateTime
+	o.Status = ConditionalOrderDormant
+	return s.Add(o)
+}
+
+func (s *RdsService) GetConditionalOrderByHash(orderHash string) (*ConditionalOrder, error) {
+	o := &ConditionalOrder{}
+	err := s.Db.Where("order_hash = ?", orderHash).First(o).Error
+	return o, err
+}
+
+// GetDormantConditionalOrders returns dormant orders after id, the trigger engine goes through them by it
+func (s *RdsService) GetDormantConditionalOrders(afterId, limit int) ([]ConditionalOrder, error) {
+	var list []ConditionalOrder
+	err := s.Db.Model(&ConditionalOrder{}).Where("id>? and status=?", afterId, ConditionalOrderDormant).Order("id").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// UpdateDormantConditionalOrder leaves dormant for status, it reports false if the order isn't dormant any more,
+// e.g. it's cancelled while triggered
+func (s *RdsService) UpdateDormantConditionalOrder(orderHash, status string, items map[string]interface{}) (bool, error) {
+	if items == nil {
+		items = make(map[string]interface{})
+	}
+	items["status"] = status
+	items["update_time"] = time.Now().Unix()
+	db := s.Db.Model(&ConditionalOrder{}).Where("order_hash=? and status=?", orderHash, ConditionalOrderDormant).Updates(items)
+	return db.RowsAffected > 0, db.Error
+}
+
+// UpdateTriggeredConditionalOrder records the result of activating an order which has left dormant
+func (s *RdsService) UpdateTriggeredConditionalOrder(orderHash, status, errMsg string) error {
+	items := map[string]interface{}{
+		"status":      status,
+		"error":       errMsg,
+		"update_time": time.Now().Unix(),
+	}
+	return s.Db.Model(&ConditionalOrder{}).Where("order_hash=? and status=?", orderHash, ConditionalOrderTriggered).Updates(items).Error
+}
+
+func (s *RdsService) ConditionalOrderPageQuery(query map[string]interface{}, pageIndex, pageSize int) (PageResult, error) {
+	var (
+		list  []ConditionalOrder
+		total int
+	)
+	pageResult := PageResult{PageIndex: pageIndex, PageSize: pageSize, Data: make([]interface{}, 0)}
+
+	db := s.Db.Model(&ConditionalOrder{}).Where(query)
+	if err := db.Count(&total).Error; err != nil {
+		return pageResult, err
+	}
+	if err := db.Order("id desc").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		return pageResult, err
+	}
+	for _, o := range list {
+		pageResult.Data = append(pageResult.Data, o)
+	}
+	pageResult.Total = total
+	return pageResult, nil
+}
@@ -62,6 +62,12 @@ func NewDb(options *libdao.MysqlOptions) *RdsService {
 	tables = append(tables, &CustumerInvitationInfo{})
 	tables = append(tables, &CityPartnerReceivedDetail{})
 	tables = append(tables, &TokenTicker{})
+	tables = append(tables, &Webhook{})
+	tables = append(tables, &WebhookDeadLetter{})
+	tables = append(tables, &DataKey{})
+	tables = append(tables, &OrderDrift{})
+	tables = append(tables, &ConditionalOrder{})
+	tables = append(tables, &P2pRelation{})
 
 	s.SetTables(tables)
 	if err := s.CreateTables(); err != nil {
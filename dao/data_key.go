@@ -0,0 +1,130 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao/envelope"
+	"time"
+)
+
+// DataKey is a data key of envelope encryption wrapped by the master key of MasterKeyId,
+// RdsService implements envelope.DataKeyStore by it
+type DataKey struct {
+	ID          int    `gorm:"column:id;primary_key;"`
+	MasterKeyId string `gorm:"column:master_key_id;type:varchar(128)"`
+	WrappedKey  []byte `gorm:"column:wrapped_key;type:blob"`
+	CreateTime  int64  `gorm:"column:create_time;type:bigint"`
+	UpdateTime  int64  `gorm:"column:update_time;type:bigint"`
+}
+
+func (s *RdsService) DataKeys() ([]envelope.DataKey, error) {
+	var list []DataKey
+	if err := s.Db.Model(&DataKey{}).Order("id").Find(&list).Error; err != nil {
+		return nil, err
+	}
+	keys := make([]envelope.DataKey, 0, len(list))
+	for _, k := range list {
+		keys = append(keys, envelope.DataKey{Id: k.ID, MasterKeyId: k.MasterKeyId, Wrapped: k.WrappedKey})
+	}
+	return keys, nil
+}
+
+func (s *RdsService) AddDataKey(masterKeyId string, wrapped []byte) (int, error) {
+	now := time.Now().Unix()
+	key := &DataKey{MasterKeyId: masterKeyId, WrappedKey: wrapped, CreateTime: now, UpdateTime: now}
+	if err := s.Add(key); err != nil {
+		return 0, err
+	}
+	return key.ID, nil
+}
+
+func (s *RdsService) UpdateDataKey(id int, masterKeyId string, wrapped []byte) error {
+	items := map[string]interface{}{
+		"master_key_id": masterKeyId,
+		"wrapped_key":   wrapped,
+		"update_time":   time.Now().Unix(),
+	}
+	return s.Db.Model(&DataKey{}).Where("id=?", id).Updates(items).Error
+}
+
+// OrderPrivateKeyWidth is the width of priv_key which holds an encrypted key
+const OrderPrivateKeyWidth = 256
+
+// GetOrderPrivateKeyWidth returns the width of priv_key in lpr_orders from information_schema
+func (s *RdsService) GetOrderPrivateKeyWidth() (int, error) {
+	var width int
+	row := s.Db.Raw("SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+		s.Db.NewScope(&Order{}).TableName(), "priv_key").Row()
+	if err := row.Scan(&width); err != nil {
+		return 0, err
+	}
+	return width, nil
+}
+
+// WidenOrderPrivateKey enlarges priv_key of tables created before encryption, which can't hold an encrypted key,
+// the table is altered only if priv_key is narrower than OrderPrivateKeyWidth
+func (s *RdsService) WidenOrderPrivateKey() error {
+	width, err := s.GetOrderPrivateKeyWidth()
+	if err != nil || width >= OrderPrivateKeyWidth {
+		return err
+	}
+	return s.Db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY priv_key varchar(%d)", s.Db.NewScope(&Order{}).TableName(), OrderPrivateKeyWidth)).Error
+}
+
+// GetOrderPrivateKeys returns id, order_hash and priv_key of orders after id which have an auth private key
+func (s *RdsService) GetOrderPrivateKeys(afterId, limit int) ([]Order, error) {
+	var list []Order
+	err := s.Db.Model(&Order{}).Select("id, order_hash, priv_key").Where("id>? and priv_key<>''", afterId).Order("id").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+func (s *RdsService) UpdateOrderPrivateKey(id int, privateKey string) error {
+	return s.Db.Model(&Order{}).Where("id=?", id).Update("priv_key", privateKey).Error
+}
+
+// EncryptOrderPrivateKeys encrypts priv_key of orders by the current data key of e,
+// including plain ones saved before encryption and ones encrypted by an old data key. it returns how many are updated
+func (s *RdsService) EncryptOrderPrivateKeys(e *envelope.Envelope, batchSize int) (int, error) {
+	updated, afterId := 0, 0
+	for {
+		list, err := s.GetOrderPrivateKeys(afterId, batchSize)
+		if err != nil || len(list) == 0 {
+			return updated, err
+		}
+		for _, o := range list {
+			afterId = o.ID
+			if e.IsCurrent(o.PrivateKey) {
+				continue
+			}
+			plain, err := o.decryptPrivateKey()
+			if err != nil {
+				return updated, fmt.Errorf("decrypt auth private key of order:%s error:%s", o.OrderHash, err.Error())
+			}
+			encrypted, err := e.Encrypt(plain, o.OrderHash)
+			if err != nil {
+				return updated, err
+			}
+			if err := s.UpdateOrderPrivateKey(o.ID, encrypted); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+}
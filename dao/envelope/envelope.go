@@ -0,0 +1,332 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package envelope encrypts secret columns by envelope encryption.
+// a value is encrypted by AES-256-GCM with a data key, the data keys are saved in mysql wrapped by
+// a master key of KeyProvider, so the master key never leaves the provider and can be rotated by rewrapping
+// the data keys only. the newest data key encrypts, the others only decrypt until values are rotated to it
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay-lib/log"
+)
+
+// Prefix marks encrypted values, the format is enc1:{data key id}:{base64 of nonce and sealed value}
+const Prefix = "enc1:"
+
+const dataKeyLength = 32
+
+// data keys are reloaded by Encrypt at this interval by default, so a data key rotated by another node encrypts after it
+const defaultReloadInterval = 60
+
+type EnvelopeOptions struct {
+	Enabled        bool
+	Provider       string // file or vault
+	KeyFile        string // file provider, lines of "{master key id}:{base64 of 32 bytes key}"
+	MasterKeyId    string // file provider, the master key wrapping new data keys, default is the first one
+	VaultAddr      string // vault provider, the address of a vault server with transit secrets engine
+	VaultToken     string // vault provider, default is env VAULT_TOKEN
+	VaultKey       string // vault provider, the transit key name
+	ReloadInterval int    // seconds between reloads of data keys, default is 60
+}
+
+// DataKey is a data key wrapped by the master key of MasterKeyId
+type DataKey struct {
+	Id          int
+	MasterKeyId string
+	Wrapped     []byte
+}
+
+// DataKeyStore saves the wrapped data keys, it's implemented by dao
+type DataKeyStore interface {
+	DataKeys() ([]DataKey, error)
+	AddDataKey(masterKeyId string, wrapped []byte) (int, error)
+	UpdateDataKey(id int, masterKeyId string, wrapped []byte) error
+}
+
+type Envelope struct {
+	provider KeyProvider
+	store    DataKeyStore
+
+	mtx            sync.RWMutex
+	keys           map[int]cipher.AEAD
+	current        int
+	reloadInterval time.Duration
+	loadedAt       time.Time
+}
+
+func NewEnvelope(provider KeyProvider, store DataKeyStore) *Envelope {
+	e := &Envelope{provider: provider, store: store}
+	e.keys = make(map[int]cipher.AEAD)
+	e.reloadInterval = defaultReloadInterval * time.Second
+	return e
+}
+
+// Load unwraps all data keys, a data key is created if there is none
+func (e *Envelope) Load() error {
+	if err := e.reload(); err != nil {
+		return err
+	}
+	e.mtx.RLock()
+	empty := e.current == 0
+	e.mtx.RUnlock()
+	if empty {
+		_, err := e.Rotate()
+		return err
+	}
+	return nil
+}
+
+func (e *Envelope) reload() error {
+	list, err := e.store.DataKeys()
+	if err != nil {
+		return err
+	}
+	keys := make(map[int]cipher.AEAD)
+	current := 0
+	for _, key := range list {
+		aead, err := e.unwrap(key)
+		if err != nil {
+			return err
+		}
+		keys[key.Id] = aead
+		if key.Id > current {
+			current = key.Id
+		}
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.keys = keys
+	e.current = current
+	e.loadedAt = time.Now()
+	return nil
+}
+
+// refresh reloads the data keys if they are loaded before reloadInterval, one caller reloads at a time
+func (e *Envelope) refresh() {
+	e.mtx.RLock()
+	stale := time.Since(e.loadedAt) >= e.reloadInterval
+	e.mtx.RUnlock()
+	if !stale {
+		return
+	}
+
+	e.mtx.Lock()
+	if time.Since(e.loadedAt) < e.reloadInterval {
+		e.mtx.Unlock()
+		return
+	}
+	e.loadedAt = time.Now()
+	e.mtx.Unlock()
+
+	if err := e.reload(); err != nil {
+		log.Errorf("envelope, reload data keys error:%s", err.Error())
+	}
+}
+
+func (e *Envelope) unwrap(key DataKey) (cipher.AEAD, error) {
+	plain, err := e.provider.Unwrap(key.MasterKeyId, key.Wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key:%d error:%s", key.Id, err.Error())
+	}
+	return newAEAD(plain)
+}
+
+// Rotate creates a data key which encrypts the values after, it returns the id of the data key
+func (e *Envelope) Rotate() (int, error) {
+	plain := make([]byte, dataKeyLength)
+	if _, err := rand.Read(plain); err != nil {
+		return 0, err
+	}
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return 0, err
+	}
+	masterKeyId, wrapped, err := e.provider.Wrap(plain)
+	if err != nil {
+		return 0, err
+	}
+	id, err := e.store.AddDataKey(masterKeyId, wrapped)
+	if err != nil {
+		return 0, err
+	}
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	e.keys[id] = aead
+	if id > e.current {
+		e.current = id
+	}
+	return id, nil
+}
+
+// Rewrap wraps all data keys by the current master key of provider, it returns how many are rewrapped
+func (e *Envelope) Rewrap() (int, error) {
+	list, err := e.store.DataKeys()
+	if err != nil {
+		return 0, err
+	}
+	for i, key := range list {
+		plain, err := e.provider.Unwrap(key.MasterKeyId, key.Wrapped)
+		if err != nil {
+			return i, fmt.Errorf("unwrap data key:%d error:%s", key.Id, err.Error())
+		}
+		masterKeyId, wrapped, err := e.provider.Wrap(plain)
+		if err != nil {
+			return i, err
+		}
+		if err := e.store.UpdateDataKey(key.Id, masterKeyId, wrapped); err != nil {
+			return i, err
+		}
+	}
+	return len(list), nil
+}
+
+// Encrypt encrypts value with the current data key, aad is authenticated with it,
+// so the encrypted value can't be moved to another row. the data keys are reloaded every reloadInterval,
+// so the data key rotated by another node is current after it
+func (e *Envelope) Encrypt(value, aad string) (string, error) {
+	e.refresh()
+	e.mtx.RLock()
+	id, aead := e.current, e.keys[e.current]
+	e.mtx.RUnlock()
+	if aead == nil {
+		return "", errors.New("envelope has no data key")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(aad))
+	return Prefix + strconv.Itoa(id) + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt, data keys are reloaded if its data key is created by another node
+func (e *Envelope) Decrypt(value, aad string) (string, error) {
+	id, sealed, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+
+	e.mtx.RLock()
+	aead := e.keys[id]
+	e.mtx.RUnlock()
+	if aead == nil {
+		if err := e.reload(); err != nil {
+			return "", err
+		}
+		e.mtx.RLock()
+		aead = e.keys[id]
+		e.mtx.RUnlock()
+		if aead == nil {
+			return "", fmt.Errorf("data key:%d not found", id)
+		}
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// IsCurrent returns true if value is encrypted by the current data key
+func (e *Envelope) IsCurrent(value string) bool {
+	id, _, err := parse(value)
+	if err != nil {
+		return false
+	}
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+	return id == e.current
+}
+
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+func parse(value string) (int, []byte, error) {
+	if !IsEncrypted(value) {
+		return 0, nil, errors.New("value isn't encrypted")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, Prefix), ":", 2)
+	if len(parts) != 2 {
+		return 0, nil, errors.New("invalid encrypted value")
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, nil, errors.New("invalid data key id of encrypted value")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, sealed, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var defaultEnvelope *Envelope
+
+// Initialize sets the envelope used by Default, nothing is encrypted if it's disabled
+func Initialize(options EnvelopeOptions, store DataKeyStore) error {
+	if !options.Enabled {
+		defaultEnvelope = nil
+		return nil
+	}
+	provider, err := NewKeyProvider(options)
+	if err != nil {
+		return err
+	}
+	e := NewEnvelope(provider, store)
+	if options.ReloadInterval > 0 {
+		e.reloadInterval = time.Duration(options.ReloadInterval) * time.Second
+	}
+	if err := e.Load(); err != nil {
+		return err
+	}
+	defaultEnvelope = e
+	return nil
+}
+
+// Default returns the envelope set by Initialize, it's nil if encryption is disabled
+func Default() *Envelope {
+	return defaultEnvelope
+}
@@ -0,0 +1,187 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package envelope
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	privateKey = "0x4c5496d2745fe9cc2e0aa3e1aad2b66cc792a716decf707ddb4be1d2bd7aa8c9"
+	orderHash  = "0x7b9bbe7bfe0a8f1e9adf69f4a4e9d5c1e1bd4aff0e1ac6b7e3b1a4b2e1ac0b1c"
+)
+
+type memoryStore struct {
+	keys []DataKey
+}
+
+func (s *memoryStore) DataKeys() ([]DataKey, error) {
+	return append([]DataKey{}, s.keys...), nil
+}
+
+func (s *memoryStore) AddDataKey(masterKeyId string, wrapped []byte) (int, error) {
+	id := len(s.keys) + 1
+	s.keys = append(s.keys, DataKey{Id: id, MasterKeyId: masterKeyId, Wrapped: wrapped})
+	return id, nil
+}
+
+func (s *memoryStore) UpdateDataKey(id int, masterKeyId string, wrapped []byte) error {
+	s.keys[id-1] = DataKey{Id: id, MasterKeyId: masterKeyId, Wrapped: wrapped}
+	return nil
+}
+
+func writeKeyFile(t *testing.T, ids ...string) string {
+	file, err := ioutil.TempFile("", "envelope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString("# master keys\n")
+	for i, id := range ids {
+		key := bytes.Repeat([]byte{byte(i + 1)}, dataKeyLength)
+		file.WriteString(id + ":" + base64.StdEncoding.EncodeToString(key) + "\n")
+	}
+	return file.Name()
+}
+
+func newTestEnvelope(t *testing.T, keyFile, masterKeyId string, store DataKeyStore) *Envelope {
+	provider, err := NewFileKeyProvider(keyFile, masterKeyId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEnvelope(provider, store)
+	if err := e.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyFile := writeKeyFile(t, "m1")
+	defer os.Remove(keyFile)
+	e := newTestEnvelope(t, keyFile, "", &memoryStore{})
+
+	encrypted, err := e.Encrypt(privateKey, orderHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(encrypted) || !e.IsCurrent(encrypted) {
+		t.Fatalf("unexpected encrypted value %s", encrypted)
+	}
+	if len(encrypted) > 256 {
+		t.Fatalf("encrypted value of %d chars doesn't fit priv_key", len(encrypted))
+	}
+	if plain, err := e.Decrypt(encrypted, orderHash); err != nil || plain != privateKey {
+		t.Fatalf("decrypt got %s, %v", plain, err)
+	}
+	if _, err := e.Decrypt(encrypted, "0x01"); err == nil {
+		t.Fatalf("expected decrypting with another order hash to fail")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	keyFile := writeKeyFile(t, "m1")
+	defer os.Remove(keyFile)
+	store := &memoryStore{}
+	e := newTestEnvelope(t, keyFile, "", store)
+	old, _ := e.Encrypt(privateKey, orderHash)
+
+	// another node rotates, e finds the new data key when it decrypts a value of it
+	other := newTestEnvelope(t, keyFile, "", store)
+	if id, err := other.Rotate(); err != nil || id != 2 {
+		t.Fatalf("rotate got %d, %v", id, err)
+	}
+	rotated, _ := other.Encrypt(privateKey, orderHash)
+	if other.IsCurrent(old) || !other.IsCurrent(rotated) {
+		t.Fatalf("expected only the rotated value to be current")
+	}
+	for _, value := range []string{old, rotated} {
+		if plain, err := e.Decrypt(value, orderHash); err != nil || plain != privateKey {
+			t.Fatalf("decrypt %s got %s, %v", value, plain, err)
+		}
+	}
+}
+
+func TestEncryptReloadsRotatedKey(t *testing.T) {
+	keyFile := writeKeyFile(t, "m1")
+	defer os.Remove(keyFile)
+	store := &memoryStore{}
+	e := newTestEnvelope(t, keyFile, "", store)
+	other := newTestEnvelope(t, keyFile, "", store)
+	if _, err := other.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the data keys of e are loaded within reloadInterval, it still encrypts by the old data key
+	if encrypted, _ := e.Encrypt(privateKey, orderHash); other.IsCurrent(encrypted) {
+		t.Fatalf("expected the data keys not reloaded yet")
+	}
+
+	e.mtx.Lock()
+	e.loadedAt = time.Now().Add(-e.reloadInterval)
+	e.mtx.Unlock()
+	if encrypted, _ := e.Encrypt(privateKey, orderHash); !other.IsCurrent(encrypted) {
+		t.Fatalf("expected the rotated data key to encrypt after reloading, got %s", encrypted)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	keyFile := writeKeyFile(t, "m1", "m2")
+	defer os.Remove(keyFile)
+	store := &memoryStore{}
+	e := newTestEnvelope(t, keyFile, "m1", store)
+	encrypted, _ := e.Encrypt(privateKey, orderHash)
+
+	rewrapper := newTestEnvelope(t, keyFile, "m2", store)
+	if n, err := rewrapper.Rewrap(); err != nil || n != 1 {
+		t.Fatalf("rewrap got %d, %v", n, err)
+	}
+	if store.keys[0].MasterKeyId != "m2" {
+		t.Fatalf("expected data key wrapped by m2, got %s", store.keys[0].MasterKeyId)
+	}
+
+	// m1 can be removed from the key file after rewrapping
+	onlyM2 := writeKeyFile(t, "skipped", "m2")
+	defer os.Remove(onlyM2)
+	e = newTestEnvelope(t, onlyM2, "m2", store)
+	if plain, err := e.Decrypt(encrypted, orderHash); err != nil || plain != privateKey {
+		t.Fatalf("decrypt got %s, %v", plain, err)
+	}
+}
+
+func TestFileKeyProviderErrors(t *testing.T) {
+	keyFile := writeKeyFile(t, "m1")
+	defer os.Remove(keyFile)
+	if _, err := NewFileKeyProvider(keyFile, "m2"); err == nil {
+		t.Fatalf("expected an error of a missing master key")
+	}
+
+	invalid, _ := ioutil.TempFile("", "envelope")
+	invalid.WriteString("m1:" + base64.StdEncoding.EncodeToString([]byte("short")) + "\n")
+	invalid.Close()
+	defer os.Remove(invalid.Name())
+	if _, err := NewFileKeyProvider(invalid.Name(), ""); err == nil {
+		t.Fatalf("expected an error of a short master key")
+	}
+}
@@ -0,0 +1,212 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ProviderFile  = "file"
+	ProviderVault = "vault"
+)
+
+// KeyProvider wraps data keys by master keys it holds
+type KeyProvider interface {
+	// Wrap wraps dataKey by the current master key, it returns the id of the master key and the wrapped key
+	Wrap(dataKey []byte) (string, []byte, error)
+	Unwrap(masterKeyId string, wrapped []byte) ([]byte, error)
+}
+
+func NewKeyProvider(options EnvelopeOptions) (KeyProvider, error) {
+	switch options.Provider {
+	case ProviderFile, "":
+		return NewFileKeyProvider(options.KeyFile, options.MasterKeyId)
+	case ProviderVault:
+		return NewVaultKeyProvider(options.VaultAddr, options.VaultToken, options.VaultKey)
+	default:
+		return nil, fmt.Errorf("unsupported key provider %s", options.Provider)
+	}
+}
+
+// FileKeyProvider holds master keys of a local key file, every line is "{master key id}:{base64 of 32 bytes key}",
+// blank lines and lines starting with # are ignored. old master keys should be kept in the file until rewrapped
+type FileKeyProvider struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewFileKeyProvider(keyFile, masterKeyId string) (*FileKeyProvider, error) {
+	if len(keyFile) == 0 {
+		return nil, errors.New("key file of envelope is empty")
+	}
+	file, err := os.Open(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := &FileKeyProvider{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	for line := 0; scanner.Scan(); {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		parts := strings.SplitN(text, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid master key at line %d of key file", line)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil || len(key) != dataKeyLength {
+			return nil, fmt.Errorf("master key at line %d of key file must be base64 of %d bytes", line, dataKeyLength)
+		}
+		if _, ok := p.keys[parts[0]]; ok {
+			return nil, fmt.Errorf("duplicate master key %s in key file", parts[0])
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		p.keys[parts[0]] = aead
+		if len(p.current) == 0 {
+			p.current = parts[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(masterKeyId) > 0 {
+		p.current = masterKeyId
+	}
+	if _, ok := p.keys[p.current]; !ok {
+		return nil, fmt.Errorf("master key %s isn't in key file", p.current)
+	}
+	return p, nil
+}
+
+func (p *FileKeyProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	aead := p.keys[p.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return p.current, aead.Seal(nonce, nonce, dataKey, []byte(p.current)), nil
+}
+
+func (p *FileKeyProvider) Unwrap(masterKeyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := p.keys[masterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("master key %s isn't in key file", masterKeyId)
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(masterKeyId))
+}
+
+// VaultKeyProvider wraps data keys by the transit secrets engine of vault, which is compatible with KMS.
+// the master key never leaves vault, it's rotated by `vault write -f transit/keys/{key}/rotate`
+type VaultKeyProvider struct {
+	addr   string
+	token  string
+	key    string
+	client *http.Client
+}
+
+func NewVaultKeyProvider(addr, token, key string) (*VaultKeyProvider, error) {
+	if len(token) == 0 {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if len(addr) == 0 || len(token) == 0 || len(key) == 0 {
+		return nil, errors.New("vault addr, token and key of envelope are required")
+	}
+	p := &VaultKeyProvider{addr: strings.TrimSuffix(addr, "/"), token: token, key: key}
+	p.client = &http.Client{Timeout: 10 * time.Second}
+	return p, nil
+}
+
+// Wrap returns "vault:{key}" as master key id, the version of vault key is kept in the wrapped key
+func (p *VaultKeyProvider) Wrap(dataKey []byte) (string, []byte, error) {
+	var res struct {
+		Ciphertext string `json:"ciphertext"`
+	}
+	req := map[string]string{"plaintext": base64.StdEncoding.EncodeToString(dataKey)}
+	if err := p.call("encrypt", req, &res); err != nil {
+		return "", nil, err
+	}
+	return ProviderVault + ":" + p.key, []byte(res.Ciphertext), nil
+}
+
+func (p *VaultKeyProvider) Unwrap(masterKeyId string, wrapped []byte) ([]byte, error) {
+	if masterKeyId != ProviderVault+":"+p.key {
+		return nil, fmt.Errorf("master key %s isn't the vault key", masterKeyId)
+	}
+	var res struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.call("decrypt", map[string]string{"ciphertext": string(wrapped)}, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Plaintext)
+}
+
+func (p *VaultKeyProvider) call(op string, body interface{}, data interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.addr+"/v1/transit/"+op+"/"+p.key, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []string        `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("vault transit %s responded status %d", op, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault transit %s responded status %d:%s", op, resp.StatusCode, strings.Join(res.Errors, ","))
+	}
+	return json.Unmarshal(res.Data, data)
+}
@@ -187,6 +187,12 @@ func (s *RdsService) QueryRecentFills(market, owner string, start int64, end int
 	return
 }
 
+// QueryFillsForTrend returns all fills of market orders in [start, end] ordered by create time
+func (s *RdsService) QueryFillsForTrend(market string, start, end int64) (fills []FillEvent, err error) {
+	err = s.Db.Where("market = ?", market).Where(buildTimeQueryString(start, end)).Where("fork=?", false).Where("order_type=?", types.ORDER_TYPE_MARKET).Order("create_time").Find(&fills).Error
+	return
+}
+
 func buildTimeQueryString(start, end int64) string {
 	rst := ""
 	if start != 0 && end == 0 {
@@ -20,6 +20,7 @@ package dao
 
 import (
 	"fmt"
+	"github.com/Loopring/relay-cluster/dao/envelope"
 	"github.com/Loopring/relay-lib/crypto"
 	"github.com/Loopring/relay-lib/log"
 	util "github.com/Loopring/relay-lib/marketutil"
@@ -39,7 +40,7 @@ type Order struct {
 	DelegateAddress       string  `gorm:"column:delegate_address;type:varchar(42)"`
 	Owner                 string  `gorm:"column:owner;type:varchar(42)"`
 	AuthAddress           string  `gorm:"column:auth_address;type:varchar(42)"`
-	PrivateKey            string  `gorm:"column:priv_key;type:varchar(128)"`
+	PrivateKey            string  `gorm:"column:priv_key;type:varchar(256)"` // encrypted by envelope if it's enabled
 	WalletAddress         string  `gorm:"column:wallet_address;type:varchar(42)"`
 	OrderHash             string  `gorm:"column:order_hash;type:varchar(82)"`
 	TokenS                string  `gorm:"column:token_s;type:varchar(42)"`
@@ -94,7 +95,11 @@ func (o *Order) ConvertDown(state *types.OrderState) error {
 	o.Owner = src.Owner.Hex()
 
 	auth, _ := src.AuthPrivateKey.MarshalText()
-	o.PrivateKey = string(auth)
+	privateKey, err := encryptPrivateKey(string(auth), src.Hash.Hex())
+	o.PrivateKey = privateKey
+	if err != nil {
+		return err
+	}
 	o.AuthAddress = src.AuthAddr.Hex()
 	o.WalletAddress = src.WalletAddress.Hex()
 
@@ -147,8 +152,10 @@ func (o *Order) ConvertUp(state *types.OrderState) error {
 	if len(o.AuthAddress) > 0 {
 		state.RawOrder.AuthAddr = common.HexToAddress(o.AuthAddress)
 	}
-	if len(o.PrivateKey) > 0 {
-		authPrivateKey, err := crypto.NewPrivateKeyCrypto(false, o.PrivateKey)
+	if privateKey, err := o.decryptPrivateKey(); err != nil {
+		log.Errorf("decrypt auth private key of order:%s error:%s", o.OrderHash, err.Error())
+	} else if len(privateKey) > 0 {
+		authPrivateKey, err := crypto.NewPrivateKeyCrypto(false, privateKey)
 		if err == nil {
 			state.RawOrder.AuthPrivateKey = authPrivateKey
 		}
@@ -187,6 +194,35 @@ func (o *Order) ConvertUp(state *types.OrderState) error {
 	return nil
 }
 
+// decryptPrivateKey returns the plain priv_key, the ones saved before encryption are returned as they are
+func (o *Order) decryptPrivateKey() (string, error) {
+	return decryptPrivateKey(o.PrivateKey, o.OrderHash)
+}
+
+// encryptPrivateKey encrypts the auth private key of an order if envelope is enabled
+func encryptPrivateKey(privateKey, orderHash string) (string, error) {
+	e := envelope.Default()
+	if e == nil || len(privateKey) == 0 {
+		return privateKey, nil
+	}
+	encrypted, err := e.Encrypt(privateKey, orderHash)
+	if err != nil {
+		return "", fmt.Errorf("encrypt auth private key of order:%s error:%s", orderHash, err.Error())
+	}
+	return encrypted, nil
+}
+
+func decryptPrivateKey(privateKey, orderHash string) (string, error) {
+	if !envelope.IsEncrypted(privateKey) {
+		return privateKey, nil
+	}
+	e := envelope.Default()
+	if e == nil {
+		return "", fmt.Errorf("envelope isn't enabled")
+	}
+	return e.Decrypt(privateKey, orderHash)
+}
+
 func (s *RdsService) GetOrderByHash(orderhash common.Hash) (*Order, error) {
 	order := &Order{}
 	err := s.Db.Where("order_hash = ?", orderhash.Hex()).First(order).Error
@@ -325,6 +361,21 @@ func (s *RdsService) GetOrderBook(delegate, tokenS, tokenB common.Address, lengt
 	return list, err
 }
 
+// GetOpenOrderBook is GetOrderBook including the orders valid since a future time
+func (s *RdsService) GetOpenOrderBook(delegate, tokenS, tokenB common.Address, length int) ([]Order, error) {
+	var list []Order
+	filterStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	err := s.Db.Where("delegate_address = ?", delegate.Hex()).
+		Where("token_s = ? and token_b = ?", tokenS.Hex(), tokenB.Hex()).
+		Where("status in (?)", filterStatus).
+		Where("order_type = ? ", types.ORDER_TYPE_MARKET).
+		Where("valid_until >= ? ", time.Now().Unix()).
+		Order("price desc").
+		Limit(length).
+		Find(&list).Error
+	return list, err
+}
+
 func (s *RdsService) OrderPageQuery(query map[string]interface{}, statusList []int, pageIndex, pageSize int) (PageResult, error) {
 	var (
 		orders        []Order
@@ -349,16 +400,15 @@ func (s *RdsService) OrderPageQuery(query map[string]interface{}, statusList []i
 
 	if len(statusList) == 1 {
 		if statusList[0] == 6 {
+			// orders past valid_until are expired, whether the expiry sweeper has moved them or not
 			if err = s.Db.Where(query).
-				Where("valid_until < ?", now).
-				Where("status in (?)", openedStatus).
+				Where("status = ? or (valid_until < ? and status in (?))", types.ORDER_EXPIRE, now, openedStatus).
 				Offset((pageIndex - 1) * pageSize).Order("create_time DESC").Limit(pageSize).Find(&orders).Error; err != nil {
 				return pageResult, err
 			}
 
 			err = s.Db.Model(&Order{}).Where(query).
-				Where("valid_until < ?", now).
-				Where("status in (?)", openedStatus).Count(&pageResult.Total).Error
+				Where("status = ? or (valid_until < ? and status in (?))", types.ORDER_EXPIRE, now, openedStatus).Count(&pageResult.Total).Error
 
 			if err != nil {
 				return pageResult, err
@@ -564,6 +614,17 @@ func (s *RdsService) FlexCancelOrderByHash(owner common.Address, orderhash commo
 		Update("status", status).RowsAffected
 }
 
+func (s *RdsService) FlexCancelOrdersByHashes(owner common.Address, orderhashes []string, validStatus []types.OrderStatus, status types.OrderStatus) int64 {
+	now := time.Now().Unix()
+
+	return s.Db.Model(&Order{}).
+		Where("owner=?", owner.Hex()).
+		Where("order_hash in (?)", orderhashes).
+		Where("valid_until >= ? ", now).
+		Where("status in (?)", validStatus).
+		Update("status", status).RowsAffected
+}
+
 func (s *RdsService) FlexCancelOrderByOwner(owner common.Address, validStatus []types.OrderStatus, status types.OrderStatus) int64 {
 	now := time.Now().Unix()
 	return s.Db.Model(&Order{}).
@@ -602,6 +663,27 @@ func (s *RdsService) FlexCancelOrderByMarket(owner common.Address, cutoff int64,
 		Update("status", status).RowsAffected
 }
 
+// GetExpiredOrders returns orders of validStatus whose valid_until is before now, earliest expired first
+func (s *RdsService) GetExpiredOrders(now int64, validStatus []types.OrderStatus, limit int) ([]Order, error) {
+	var list []Order
+	err := s.Db.Model(&Order{}).
+		Where("valid_until < ?", now).
+		Where("status in (?)", validStatus).
+		Order("valid_until").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// ExpireOrder sets status of an order still of validStatus and past valid_until,
+// it returns false if the order has been settled or cancelled meanwhile
+func (s *RdsService) ExpireOrder(orderhash common.Hash, now int64, validStatus []types.OrderStatus, status types.OrderStatus) (bool, error) {
+	db := s.Db.Model(&Order{}).
+		Where("order_hash=?", orderhash.Hex()).
+		Where("valid_until < ?", now).
+		Where("status in (?)", validStatus).
+		Update("status", status)
+	return db.RowsAffected > 0, db.Error
+}
+
 func (s *RdsService) IsOrderOwner(owner common.Address) bool {
 	var data Order
 	err := s.Db.Where("owner=?", owner.Hex()).First(&data).Error
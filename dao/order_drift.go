@@ -0,0 +1,109 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"time"
+)
+
+// OrderDrift is an order whose status or amounts in lpr_orders differ from the delegate at BlockNumber.
+// amounts are of the side settled by the delegate, amountB if the order is buyNoMoreThanAmountB, otherwise amountS
+type OrderDrift struct {
+	ID                   int    `gorm:"column:id;primary_key;" json:"id"`
+	OrderHash            string `gorm:"column:order_hash;type:varchar(82);index" json:"orderHash"`
+	Owner                string `gorm:"column:owner;type:varchar(42)" json:"owner"`
+	Market               string `gorm:"column:market;type:varchar(40)" json:"market"`
+	DelegateAddress      string `gorm:"column:delegate_address;type:varchar(42)" json:"delegateAddress"`
+	LocalStatus          uint8  `gorm:"column:local_status;type:tinyint(4)" json:"localStatus"`
+	ChainStatus          uint8  `gorm:"column:chain_status;type:tinyint(4)" json:"chainStatus"`
+	LocalDealtAmount     string `gorm:"column:local_dealt_amount;type:varchar(40)" json:"localDealtAmount"`
+	ChainDealtAmount     string `gorm:"column:chain_dealt_amount;type:varchar(40)" json:"chainDealtAmount"`
+	LocalCancelledAmount string `gorm:"column:local_cancelled_amount;type:varchar(40)" json:"localCancelledAmount"`
+	ChainCancelledAmount string `gorm:"column:chain_cancelled_amount;type:varchar(40)" json:"chainCancelledAmount"`
+	Cutoff               int64  `gorm:"column:cutoff;type:bigint" json:"cutoff"`
+	BlockNumber          int64  `gorm:"column:block_number;type:bigint" json:"blockNumber"`
+	Corrected            bool   `gorm:"column:corrected;index" json:"corrected"`
+	CreateTime           int64  `gorm:"column:create_time;type:bigint" json:"createTime"`
+	UpdateTime           int64  `gorm:"column:update_time;type:bigint" json:"updateTime"`
+}
+
+// SaveOrderDrift updates the uncorrected drift of the order if there is one, so an order is reported once until corrected
+func (s *RdsService) SaveOrderDrift(drift *OrderDrift) error {
+	drift.UpdateTime = time.Now().Unix()
+	var exists OrderDrift
+	err := s.Db.Model(&OrderDrift{}).Where("order_hash=? and corrected=?", drift.OrderHash, false).First(&exists).Error
+	if err != nil {
+		drift.CreateTime = drift.UpdateTime
+		return s.Add(drift)
+	}
+	drift.ID = exists.ID
+	drift.CreateTime = exists.CreateTime
+	return s.Save(drift)
+}
+
+// DeleteOrderDrift removes the uncorrected drift of an order which doesn't drift any more
+func (s *RdsService) DeleteOrderDrift(orderHash string) error {
+	return s.Db.Where("order_hash=? and corrected=?", orderHash, false).Delete(&OrderDrift{}).Error
+}
+
+func (s *RdsService) OrderDriftPageQuery(query map[string]interface{}, pageIndex, pageSize int) (PageResult, error) {
+	var (
+		list  []OrderDrift
+		total int
+	)
+	pageResult := PageResult{PageIndex: pageIndex, PageSize: pageSize, Data: make([]interface{}, 0)}
+
+	db := s.Db.Model(&OrderDrift{}).Where(query)
+	if err := db.Count(&total).Error; err != nil {
+		return pageResult, err
+	}
+	if err := db.Order("id desc").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		return pageResult, err
+	}
+	for _, drift := range list {
+		pageResult.Data = append(pageResult.Data, drift)
+	}
+	pageResult.Total = total
+	return pageResult, nil
+}
+
+// GetOpenOrdersAfter returns orders of validStatus after id, the reconciler goes through orders by it
+func (s *RdsService) GetOpenOrdersAfter(afterId int, validStatus []types.OrderStatus, limit int) ([]Order, error) {
+	var list []Order
+	err := s.Db.Model(&Order{}).Where("id>? and status in (?)", afterId, validStatus).Order("id").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// UpdateOrderWhileReconcile corrects the order read by the reconciler at status and updatedBlock,
+// it reports false if the order has been updated since it was read, e.g. by a fill of the order manager
+func (s *RdsService) UpdateOrderWhileReconcile(hash common.Hash, localStatus types.OrderStatus, localUpdatedBlock int64, status types.OrderStatus, dealtAmountS, dealtAmountB, cancelledAmountS, cancelledAmountB, blockNumber *big.Int) (bool, error) {
+	items := map[string]interface{}{
+		"status":             uint8(status),
+		"dealt_amount_s":     dealtAmountS.String(),
+		"dealt_amount_b":     dealtAmountB.String(),
+		"cancelled_amount_s": cancelledAmountS.String(),
+		"cancelled_amount_b": cancelledAmountB.String(),
+		"updated_block":      blockNumber.Int64(),
+	}
+	db := s.Db.Model(&Order{}).Where("order_hash = ? and updated_block <= ? and status = ?", hash.Hex(), localUpdatedBlock, uint8(localStatus)).Updates(items)
+	return db.RowsAffected > 0, db.Error
+}
@@ -0,0 +1,67 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao_test
+
+import (
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/test"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+)
+
+// the order manager fills the order after the reconciler read it, the correction of the stale read mustn't overwrite the fill
+func TestUpdateOrderWhileReconcile_ConcurrentFill(t *testing.T) {
+	rds := test.Rds()
+	hash := common.HexToHash("0x7265636f6e63696c65")
+	rds.Db.Where("order_hash = ?", hash.Hex()).Delete(&dao.Order{})
+	defer rds.Db.Where("order_hash = ?", hash.Hex()).Delete(&dao.Order{})
+
+	order := &dao.Order{OrderHash: hash.Hex(), Status: uint8(types.ORDER_PARTIAL), DealtAmountS: "10", UpdatedBlock: 100}
+	if err := rds.Add(order); err != nil {
+		t.Fatal(err)
+	}
+	local, err := rds.GetOrderByHash(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rds.UpdateOrderWhileFill(hash, types.ORDER_PARTIAL, big.NewInt(20), big.NewInt(2), big.NewInt(0), big.NewInt(0), big.NewInt(105)); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := rds.UpdateOrderWhileReconcile(hash, types.OrderStatus(local.Status), local.UpdatedBlock, types.ORDER_FINISHED,
+		big.NewInt(15), big.NewInt(1), big.NewInt(0), big.NewInt(0), big.NewInt(103))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated {
+		t.Fatalf("expected the correction of the stale order skipped")
+	}
+	if current, _ := rds.GetOrderByHash(hash); current.DealtAmountS != "20" || current.UpdatedBlock != 105 {
+		t.Fatalf("expected the fill kept, got dealt:%s block:%d", current.DealtAmountS, current.UpdatedBlock)
+	}
+
+	current, _ := rds.GetOrderByHash(hash)
+	updated, err = rds.UpdateOrderWhileReconcile(hash, types.OrderStatus(current.Status), current.UpdatedBlock, types.ORDER_FINISHED,
+		big.NewInt(30), big.NewInt(3), big.NewInt(0), big.NewInt(0), big.NewInt(110))
+	if err != nil || !updated {
+		t.Fatalf("expected the order corrected, %v", err)
+	}
+}
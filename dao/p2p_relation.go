@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"time"
+)
+
+// status of p2p relations, the pending amount of a maker is the sum of its pending relations
+const (
+	P2pRelationPending = "pending"
+	P2pRelationSettled = "settled"
+	P2pRelationFailed  = "failed"
+	P2pRelationExpired = "expired"
+)
+
+// P2pRelation is a taker order matched with a maker order by the ring submitted in TxHash,
+// PendingAmount of the maker is held until the ring is settled or failed. hashes are saved in lower case like the cache
+type P2pRelation struct {
+	ID             int    `gorm:"column:id;primary_key;" json:"id"`
+	TxHash         string `gorm:"column:tx_hash;type:varchar(82);unique_index" json:"txHash"`
+	MakerOrderHash string `gorm:"column:maker_order_hash;type:varchar(82);index" json:"makerOrderHash"`
+	MakerOwner     string `gorm:"column:maker_owner;type:varchar(42);index" json:"makerOwner"`
+	TakerOrderHash string `gorm:"column:taker_order_hash;type:varchar(82);index" json:"takerOrderHash"`
+	TakerOwner     string `gorm:"column:taker_owner;type:varchar(42);index" json:"takerOwner"`
+	PendingAmount  string `gorm:"column:pending_amount;type:varchar(40)" json:"pendingAmount"`
+	ValidUntil     int64  `gorm:"column:valid_until;type:bigint" json:"validUntil"`
+	Status         string `gorm:"column:status;type:varchar(20);index" json:"status"`
+	BlockNumber    int64  `gorm:"column:block_number;type:bigint" json:"blockNumber"`
+	CreateTime     int64  `gorm:"column:create_time;type:bigint" json:"createTime"`
+	UpdateTime     int64  `gorm:"column:update_time;type:bigint" json:"updateTime"`
+}
+
+func (s *RdsService) AddP2pRelation(r *P2pRelation) error {
+	r.CreateTime = time.Now().Unix()
+	r.UpdateTime = r.CreateTime
+	r.Status = P2pRelationPending
+	return s.Add(r)
+}
+
+func (s *RdsService) GetP2pRelationByTxHash(txHash string) (*P2pRelation, error) {
+	r := &P2pRelation{}
+	err := s.Db.Where("tx_hash = ?", txHash).First(r).Error
+	return r, err
+}
+
+// GetPendingP2pRelations returns pending relations after id, they're rebuilt into the cache by it
+func (s *RdsService) GetPendingP2pRelations(afterId, limit int) ([]P2pRelation, error) {
+	var list []P2pRelation
+	err := s.Db.Model(&P2pRelation{}).Where("id>? and status=?", afterId, P2pRelationPending).Order("id").Limit(limit).Find(&list).Error
+	return list, err
+}
+
+// GetPendingP2pRelationsOfMaker returns pending relations of a maker which are valid at now
+func (s *RdsService) GetPendingP2pRelationsOfMaker(makerOrderHash string, now int64) ([]P2pRelation, error) {
+	var list []P2pRelation
+	err := s.Db.Model(&P2pRelation{}).Where("maker_order_hash=? and status=? and valid_until>?", makerOrderHash, P2pRelationPending, now).Order("id").Find(&list).Error
+	return list, err
+}
+
+// SettleP2pRelation moves a pending relation to status, it reports false if the relation isn't pending
+func (s *RdsService) SettleP2pRelation(txHash, status string, blockNumber int64) (bool, error) {
+	items := map[string]interface{}{
+		"status":       status,
+		"block_number": blockNumber,
+		"update_time":  time.Now().Unix(),
+	}
+	db := s.Db.Model(&P2pRelation{}).Where("tx_hash=? and status=?", txHash, P2pRelationPending).Updates(items)
+	return db.RowsAffected > 0, db.Error
+}
+
+func (s *RdsService) P2pRelationPageQuery(query map[string]interface{}, statuses []string, pageIndex, pageSize int) (PageResult, error) {
+	var (
+		list  []P2pRelation
+		total int
+	)
+	pageResult := PageResult{PageIndex: pageIndex, PageSize: pageSize, Data: make([]interface{}, 0)}
+
+	db := s.Db.Model(&P2pRelation{}).Where(query)
+	if len(statuses) > 0 {
+		db = db.Where("status in (?)", statuses)
+	}
+	if err := db.Count(&total).Error; err != nil {
+		return pageResult, err
+	}
+	if err := db.Order("id desc").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		return pageResult, err
+	}
+	for _, r := range list {
+		pageResult.Data = append(pageResult.Data, r)
+	}
+	pageResult.Total = total
+	return pageResult, nil
+}
@@ -0,0 +1,143 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+)
+
+// Webhook is an url registered by an api client, Client is the hash of its api key.
+// Owners, Markets and Events are comma separated filters, an empty one matches all
+type Webhook struct {
+	ID         int    `gorm:"column:id;primary_key;" json:"id"`
+	Client     string `gorm:"column:client;type:varchar(64);index" json:"-"`
+	Url        string `gorm:"column:url;type:varchar(512)" json:"url"`
+	Secret     string `gorm:"column:secret;type:varchar(64)" json:"-"`
+	Owners     string `gorm:"column:owners;type:text" json:"owners"`
+	Markets    string `gorm:"column:markets;type:varchar(1024)" json:"markets"`
+	Events     string `gorm:"column:events;type:varchar(256)" json:"events"`
+	CreateTime int64  `gorm:"column:create_time;type:bigint" json:"createTime"`
+}
+
+// WebhookDeadLetter is a delivery which failed after all attempts, Payload is the body posted
+type WebhookDeadLetter struct {
+	ID         int    `gorm:"column:id;primary_key;" json:"id"`
+	WebhookId  int    `gorm:"column:webhook_id;index" json:"webhookId"`
+	DeliveryId string `gorm:"column:delivery_id;type:varchar(64)" json:"deliveryId"`
+	Event      string `gorm:"column:event;type:varchar(32)" json:"event"`
+	Payload    string `gorm:"column:payload;type:mediumtext" json:"payload"`
+	Attempts   int    `gorm:"column:attempts;type:int" json:"attempts"`
+	LastError  string `gorm:"column:last_error;type:varchar(512)" json:"lastError"`
+	CreateTime int64  `gorm:"column:create_time;type:bigint" json:"createTime"`
+	UpdateTime int64  `gorm:"column:update_time;type:bigint" json:"updateTime"`
+}
+
+func (s *RdsService) AddWebhook(hook *Webhook) error {
+	hook.CreateTime = time.Now().Unix()
+	return s.Add(hook)
+}
+
+func (s *RdsService) GetWebhooks() ([]Webhook, error) {
+	var list []Webhook
+	err := s.Db.Model(&Webhook{}).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (s *RdsService) GetWebhooksByClient(client string) ([]Webhook, error) {
+	var list []Webhook
+	err := s.Db.Model(&Webhook{}).Where("client=?", client).Order("id").Find(&list).Error
+	return list, err
+}
+
+func (s *RdsService) CountWebhooksByClient(client string) (int, error) {
+	count := 0
+	err := s.Db.Model(&Webhook{}).Where("client=?", client).Count(&count).Error
+	return count, err
+}
+
+func (s *RdsService) GetWebhook(client string, id int) (*Webhook, error) {
+	hook := &Webhook{}
+	err := s.Db.Model(&Webhook{}).Where("client=? and id=?", client, id).First(hook).Error
+	return hook, err
+}
+
+// DeleteWebhook deletes the webhook of client and its dead letters
+func (s *RdsService) DeleteWebhook(client string, id int) error {
+	tx := s.Db.Begin()
+	result := tx.Where("client=? and id=?", client, id).Delete(&Webhook{})
+	if result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return gorm.ErrRecordNotFound
+	}
+	if err := tx.Where("webhook_id=?", id).Delete(&WebhookDeadLetter{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func (s *RdsService) AddWebhookDeadLetter(letter *WebhookDeadLetter) error {
+	letter.CreateTime = time.Now().Unix()
+	letter.UpdateTime = letter.CreateTime
+	return s.Add(letter)
+}
+
+func (s *RdsService) UpdateWebhookDeadLetter(id, attempts int, lastError string) error {
+	return s.Db.Model(&WebhookDeadLetter{}).Where("id=?", id).Updates(map[string]interface{}{
+		"attempts":    attempts,
+		"last_error":  lastError,
+		"update_time": time.Now().Unix(),
+	}).Error
+}
+
+func (s *RdsService) DeleteWebhookDeadLetter(id int) error {
+	return s.Db.Where("id=?", id).Delete(&WebhookDeadLetter{}).Error
+}
+
+func (s *RdsService) WebhookDeadLetterPageQuery(webhookId, pageIndex, pageSize int) (res PageResult, err error) {
+	var list []WebhookDeadLetter
+	res = PageResult{PageIndex: pageIndex, PageSize: pageSize, Data: make([]interface{}, 0)}
+	db := s.Db.Model(&WebhookDeadLetter{}).Where("webhook_id=?", webhookId)
+	if err = db.Count(&res.Total).Error; err != nil {
+		return res, err
+	}
+	if err = db.Order("id").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&list).Error; err != nil {
+		return res, err
+	}
+	for _, v := range list {
+		res.Data = append(res.Data, v)
+	}
+	return res, nil
+}
+
+// GetWebhookDeadLetters returns the dead letters of webhookId in ids, or the first limit of them if ids is empty
+func (s *RdsService) GetWebhookDeadLetters(webhookId int, ids []int, limit int) ([]WebhookDeadLetter, error) {
+	var list []WebhookDeadLetter
+	db := s.Db.Model(&WebhookDeadLetter{}).Where("webhook_id=?", webhookId)
+	if len(ids) > 0 {
+		db = db.Where("id in (?)", ids)
+	}
+	err := db.Order("id").Limit(limit).Find(&list).Error
+	return list, err
+}
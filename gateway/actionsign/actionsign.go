@@ -0,0 +1,115 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package actionsign
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/Loopring/relay-cluster/gateway/typeddata"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// actionsign verifies the EIP-712 typed data signatures of the signed rpcs,
+// every action is signed with owner, timestamp and nonce appended to its params
+
+// Signature is the typed data signature of an action by Owner, Timestamp is in seconds
+type Signature struct {
+	Owner     string
+	Timestamp int64
+	Nonce     string
+	V         uint8
+	R         string
+	S         string
+}
+
+// NonceStore records the claimed nonces, a signature is accepted only once
+type NonceStore interface {
+	// Claim records nonce of owner until expireAt, it returns false if nonce has been claimed
+	Claim(owner common.Address, nonce common.Hash, expireAt int64) (bool, error)
+}
+
+type Verifier struct {
+	Domain       typeddata.Domain
+	Nonces       NonceStore
+	ValidSeconds int64
+}
+
+func NewAction(name string, params ...typeddata.Field) typeddata.Type {
+	fields := []typeddata.Field{{Name: "owner", Type: "address"}}
+	fields = append(fields, params...)
+	fields = append(fields, typeddata.Field{Name: "timestamp", Type: "uint256"}, typeddata.Field{Name: "nonce", Type: "bytes32"})
+	return typeddata.Type{Name: name, Fields: fields}
+}
+
+// Verify reports whether sign is the typed data signature of action with params by sign.Owner,
+// the nonce is claimed after the signature is verified
+func (v *Verifier) Verify(action typeddata.Type, params map[string]interface{}, sign Signature) (bool, error) {
+	if !common.IsHexAddress(sign.Owner) {
+		return false, errors.New("owner isn't an address")
+	}
+	nonce := common.FromHex(sign.Nonce)
+	if len(nonce) != common.HashLength || !strings.HasPrefix(sign.Nonce, "0x") {
+		return false, errors.New("nonce should be a hex string of 32 bytes")
+	}
+
+	message := map[string]interface{}{
+		"owner":     sign.Owner,
+		"timestamp": big.NewInt(sign.Timestamp),
+		"nonce":     common.BytesToHash(nonce),
+	}
+	for name, value := range params {
+		message[name] = value
+	}
+	digest, err := typeddata.Hash(v.Domain, action, message)
+	if nil != err {
+		return false, err
+	}
+	signer, err := Recover(digest, sign.V, common.FromHex(sign.R), common.FromHex(sign.S))
+	if nil != err {
+		return false, errors.New("sign is incorrect")
+	}
+	owner := common.HexToAddress(sign.Owner)
+	if signer != owner {
+		return false, errors.New("sign address not matched")
+	}
+	return v.Nonces.Claim(owner, common.BytesToHash(nonce), sign.Timestamp+v.ValidSeconds)
+}
+
+// Recover returns the signer of digest, which is signed as it is without the prefix of personal messages.
+// v is 27 or 28 as eth_signTypedData returns, 0 or 1 is accepted as well
+func Recover(digest common.Hash, v uint8, r, s []byte) (common.Address, error) {
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 || len(r) != 32 || len(s) != 32 {
+		return common.Address{}, errors.New("invalid signature")
+	}
+	sig := make([]byte, 65)
+	copy(sig[0:32], r)
+	copy(sig[32:64], s)
+	sig[64] = v
+	pub, err := crypto.SigToPub(digest.Bytes(), sig)
+	if nil != err {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
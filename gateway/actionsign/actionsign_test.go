@@ -0,0 +1,114 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package actionsign
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay-cluster/gateway/typeddata"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// signed by the private key 0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318
+const (
+	owner     = "0x2c7536E3605D9C16a7a3D7b1898e529396a65c23"
+	nonce     = "0x0101010101010101010101010101010101010101010101010101010101010101"
+	timestamp = 1530000000
+)
+
+var orderHash = common.HexToHash("0x02")
+
+type memoryNonceStore map[string]bool
+
+func (m memoryNonceStore) Claim(owner common.Address, nonce common.Hash, expireAt int64) (bool, error) {
+	key := owner.Hex() + nonce.Hex()
+	if m[key] {
+		return false, errors.New("nonce has been used")
+	}
+	m[key] = true
+	return true, nil
+}
+
+func newVerifier() *Verifier {
+	return &Verifier{
+		Domain:       typeddata.Domain{Name: "Loopring Relay", Version: "1", ChainId: big.NewInt(1)},
+		Nonces:       memoryNonceStore{},
+		ValidSeconds: 600,
+	}
+}
+
+var cancelAction = NewAction("CancelConditionalOrder", typeddata.Field{Name: "orderHash", Type: "bytes32"})
+
+// the result of eth_signTypedData_v3 of the digest 0x5bce101e1faeb9f2b2c561ca4c936e8eb0f6959a0417e09adca8967bbfdbb2dc
+func typedDataSign() Signature {
+	return Signature{
+		Owner:     owner,
+		Timestamp: timestamp,
+		Nonce:     nonce,
+		V:         27,
+		R:         "0x200553fcf3cd9644f8bd39fbefb0b059afcc904a69f2853b6d0e7769466c3817",
+		S:         "0x2387d616a3062ab9dd2c6ba7b3fa11bd559df58ddbb769965e8817538e956bba",
+	}
+}
+
+func TestVerifyTypedDataSign(t *testing.T) {
+	if ok, err := newVerifier().Verify(cancelAction, map[string]interface{}{"orderHash": orderHash}, typedDataSign()); !ok {
+		t.Fatalf("expected the typed data signature verified, %v", err)
+	}
+
+	sign := typedDataSign()
+	sign.V = 0
+	if ok, err := newVerifier().Verify(cancelAction, map[string]interface{}{"orderHash": orderHash}, sign); !ok {
+		t.Fatalf("expected v of 0 accepted, %v", err)
+	}
+
+	if ok, _ := newVerifier().Verify(cancelAction, map[string]interface{}{"orderHash": common.HexToHash("0x03")}, typedDataSign()); ok {
+		t.Fatalf("expected the signature rejected for other params")
+	}
+
+	// the personal message signature of the same digest
+	personal := typedDataSign()
+	personal.V = 28
+	personal.R = "0xe6ab5743104e2873b569263f53a775195eb7d441bc512bf838e927b2357c2e27"
+	personal.S = "0x05024bbccc1e13f89c24bbcdd01df2ebe4a2dcc2bef2d2560a83cfbfd807634e"
+	if ok, _ := newVerifier().Verify(cancelAction, map[string]interface{}{"orderHash": orderHash}, personal); ok {
+		t.Fatalf("expected the prefixed signature rejected")
+	}
+}
+
+func TestVerifyNonceReplay(t *testing.T) {
+	verifier := newVerifier()
+	params := map[string]interface{}{"orderHash": orderHash}
+	if ok, err := verifier.Verify(cancelAction, params, typedDataSign()); !ok {
+		t.Fatalf("expected the first use accepted, %v", err)
+	}
+	if ok, err := verifier.Verify(cancelAction, params, typedDataSign()); ok || err == nil {
+		t.Fatalf("expected the replay rejected")
+	}
+}
+
+func TestVerifyRejectsInvalidNonce(t *testing.T) {
+	sign := typedDataSign()
+	sign.Nonce = "0x01"
+	if ok, err := newVerifier().Verify(cancelAction, map[string]interface{}{"orderHash": orderHash}, sign); ok || err == nil {
+		t.Fatalf("expected a short nonce rejected")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/ordermanager/manager"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// AdminServiceImpl serves the methods of relay operators, every request carries one of jsonrpc.admin_keys.
+// it's only registered on the json-rpc http endpoint
+type AdminServiceImpl struct {
+	rds       *dao.RdsService
+	adminKeys []string
+}
+
+type OrderDriftQuery struct {
+	AdminKey  string `json:"adminKey"`
+	OrderHash string `json:"orderHash"`
+	Owner     string `json:"owner"`
+	Corrected *bool  `json:"corrected"`
+	PageIndex int    `json:"pageIndex"`
+	PageSize  int    `json:"pageSize"`
+}
+
+type ReconcileOrderReq struct {
+	AdminKey  string `json:"adminKey"`
+	OrderHash string `json:"orderHash"`
+	Correct   bool   `json:"correct"`
+}
+
+func NewAdminService(rds *dao.RdsService, adminKeys []string) *AdminServiceImpl {
+	s := &AdminServiceImpl{rds: rds}
+	for _, key := range adminKeys {
+		if len(key) > 0 {
+			s.adminKeys = append(s.adminKeys, key)
+		}
+	}
+	return s
+}
+
+func (s *AdminServiceImpl) authorize(adminKey string) error {
+	for _, key := range s.adminKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1 {
+			return nil
+		}
+	}
+	return errors.New("invalid admin key")
+}
+
+// GetOrderDrifts returns the orders the reconciler found drifting from the chain, latest first
+func (s *AdminServiceImpl) GetOrderDrifts(req *OrderDriftQuery) (res dao.PageResult, err error) {
+	if err = s.authorize(req.AdminKey); err != nil {
+		return res, err
+	}
+	query := make(map[string]interface{})
+	if len(req.OrderHash) > 0 {
+		query["order_hash"] = common.HexToHash(req.OrderHash).Hex()
+	}
+	if len(req.Owner) > 0 {
+		query["owner"] = common.HexToAddress(req.Owner).Hex()
+	}
+	if req.Corrected != nil {
+		query["corrected"] = *req.Corrected
+	}
+	if req.PageIndex <= 0 {
+		req.PageIndex = 1
+	}
+	if req.PageSize <= 0 || req.PageSize > 50 {
+		req.PageSize = 50
+	}
+	return s.rds.OrderDriftPageQuery(query, req.PageIndex, req.PageSize)
+}
+
+// ReconcileOrder checks an order against the chain now and corrects it if Correct,
+// it returns null if the order doesn't drift
+func (s *AdminServiceImpl) ReconcileOrder(req *ReconcileOrderReq) (res *dao.OrderDrift, err error) {
+	if err = s.authorize(req.AdminKey); err != nil {
+		return res, err
+	}
+	if len(req.OrderHash) == 0 {
+		return res, errors.New("orderHash can't be null string")
+	}
+	return manager.ReconcileOrder(common.HexToHash(req.OrderHash), req.Correct)
+}
@@ -208,8 +208,16 @@ func (w *WalletServiceImpl) GetCityPartnerStatus(req *dao.CityPartner) (*CityPar
 
 func (w *WalletServiceImpl) Start() {
 	//activateMtx = sync.Mutex{}
-	orderFilledEventWatcher := &eventemitter.Watcher{Concurrent: false, Handle: w.HandleFilledEventForCityPartner}
-	eventemitter.On(eventemitter.OrderFilled, orderFilledEventWatcher)
+	w.orderFilledEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: w.HandleFilledEventForCityPartner}
+	eventemitter.On(eventemitter.OrderFilled, w.orderFilledEventWatcher)
+}
+
+// StopWalletService is not a method of WalletServiceImpl,
+// otherwise it will be exported by jsonrpc as loopring_stop
+func StopWalletService(w *WalletServiceImpl) {
+	if nil != w.orderFilledEventWatcher {
+		eventemitter.Un(eventemitter.OrderFilled, w.orderFilledEventWatcher)
+	}
 }
 
 func (w *WalletServiceImpl) HandleFilledEventForCityPartner(input eventemitter.EventData) error {
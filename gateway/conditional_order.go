@@ -0,0 +1,337 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/gateway/trigger"
+	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-cluster/metrics"
+	kafkaUtil "github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-cluster/util/zkjob"
+	"github.com/Loopring/relay-lib/log"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"github.com/Loopring/relay-lib/types"
+)
+
+const (
+	ConditionalOrderZkLock   = "zklock_conditional_order_trigger"
+	maxConditionalOrderError = 256
+)
+
+var triggeredOrders = metrics.NewCounterVec("relay_conditional_orders_total", "Dormant conditional orders handled by the trigger engine by result.", "result")
+
+// ConditionalOrderOptions configures the engine activating conditional orders whose trigger price is reached
+type ConditionalOrderOptions struct {
+	Enabled   bool
+	Interval  int // seconds between checks of prices
+	BatchSize int // orders read from lpr_conditional_orders at a time
+}
+
+// ConditionalOrderJson is a conditional order, Order is the submitted order without the auth private key
+type ConditionalOrderJson struct {
+	OrderHash      string          `json:"orderHash"`
+	Owner          string          `json:"owner"`
+	Market         string          `json:"market"`
+	Side           string          `json:"side"`
+	TriggerType    string          `json:"triggerType"`
+	TriggerPrice   string          `json:"triggerPrice"`
+	PriceSource    string          `json:"priceSource"`
+	Status         string          `json:"status"`
+	TriggeredPrice string          `json:"triggeredPrice"`
+	Error          string          `json:"error"`
+	ValidUntil     int64           `json:"validUntil"`
+	TriggerTime    int64           `json:"triggerTime"`
+	CreateTime     int64           `json:"createTime"`
+	UpdateTime     int64           `json:"updateTime"`
+	Order          json.RawMessage `json:"order"`
+}
+
+func toConditionalOrderJson(o dao.ConditionalOrder) ConditionalOrderJson {
+	return ConditionalOrderJson{
+		OrderHash:      o.OrderHash,
+		Owner:          o.Owner,
+		Market:         o.Market,
+		Side:           o.Side,
+		TriggerType:    o.TriggerType,
+		TriggerPrice:   o.TriggerPrice,
+		PriceSource:    o.PriceSource,
+		Status:         o.Status,
+		TriggeredPrice: o.TriggeredPrice,
+		Error:          o.Error,
+		ValidUntil:     o.ValidUntil,
+		TriggerTime:    o.TriggerTime,
+		CreateTime:     o.CreateTime,
+		UpdateTime:     o.UpdateTime,
+		Order:          json.RawMessage(o.Order),
+	}
+}
+
+// HandleInputConditionalOrder saves a dormant order activated by trigger, the order is checked as far as it can be
+// before activation, balances and cutoffs are checked by HandleInputOrder when it's activated
+func HandleInputConditionalOrder(rds *dao.RdsService, req *types.OrderJsonRequest, triggerType, triggerPrice, priceSource string) (orderHash string, err error) {
+	order := types.ToOrder(req)
+	order.Hash = order.GenerateHash()
+	orderHash = order.Hash.Hex()
+
+	market, err := util.WrapMarketByAddress(order.TokenB.Hex(), order.TokenS.Hex())
+	if err != nil {
+		return orderHash, err
+	}
+	side := util.GetSide(order.TokenS.Hex(), order.TokenB.Hex())
+	condition, err := trigger.ParseCondition(triggerType, side, triggerPrice, priceSource)
+	if err != nil {
+		return orderHash, err
+	}
+
+	if valid, err := (&SignFilter{}).Filter(order); !valid {
+		return orderHash, err
+	}
+	if err = generatePrice(order); err != nil {
+		return orderHash, err
+	}
+	if order.OrderType == types.ORDER_TYPE_MARKET && order.AuthPrivateKey.Address() != order.AuthAddr {
+		return orderHash, errors.New("market order auth private key not correct")
+	}
+	if order.ValidUntil == nil || order.ValidUntil.Int64() < time.Now().Unix() {
+		return orderHash, errors.New("order expired, please check validUntil")
+	}
+
+	if _, err = gateway.om.GetOrderByHash(order.Hash); err == nil {
+		return orderHash, errors.New("order existed, please not submit again")
+	}
+	if _, err = rds.GetConditionalOrderByHash(orderHash); err == nil {
+		return orderHash, errors.New("conditional order existed, please not submit again")
+	}
+
+	req.Hash = order.Hash
+	model := &dao.ConditionalOrder{
+		Market:       market,
+		Side:         side,
+		TriggerType:  condition.Type,
+		TriggerPrice: triggerPrice,
+		PriceSource:  condition.Source,
+	}
+	if err = model.ConvertDown(req); err != nil {
+		return orderHash, err
+	}
+	if err = rds.AddConditionalOrder(model); err != nil {
+		log.Errorf("gateway,save conditional order:%s error:%s", orderHash, err.Error())
+		return orderHash, errors.New("conditional order can't be saved now")
+	}
+	notifyConditionalOrderUpdate(model)
+	return orderHash, nil
+}
+
+func notifyConditionalOrderUpdate(o *dao.ConditionalOrder) {
+	if err := kafkaUtil.ProducerSocketIOMessage(Kafka_Topic_SocketIO_Conditional_Order_Updated, toConditionalOrderJson(*o)); err != nil {
+		log.Errorf("notify conditional order:%s error:%s", o.OrderHash, err.Error())
+	}
+}
+
+// TriggerEngine activates dormant conditional orders by HandleInputOrder once their trigger price is reached,
+// and expires the ones past valid_until. the node holding ConditionalOrderZkLock runs it
+type TriggerEngine struct {
+	options      ConditionalOrderOptions
+	rds          *dao.RdsService
+	trendManager *market.TrendManager
+	collector    *market.CollectorImpl
+	job          *zkjob.Job
+}
+
+func NewTriggerEngine(options ConditionalOrderOptions, rds *dao.RdsService, trendManager *market.TrendManager, collector *market.CollectorImpl) *TriggerEngine {
+	if options.Interval <= 0 {
+		options.Interval = 5
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 200
+	}
+	e := &TriggerEngine{options: options, rds: rds, trendManager: trendManager, collector: collector}
+	e.job = zkjob.New(ConditionalOrderZkLock, time.Duration(options.Interval)*time.Second, func() {
+		if err := e.Check(); err != nil {
+			log.Errorf("conditional order trigger, check error:%s", err.Error())
+		}
+	})
+	return e
+}
+
+func (e *TriggerEngine) Start() {
+	if !e.options.Enabled {
+		return
+	}
+	e.job.Start()
+}
+
+func (e *TriggerEngine) Stop() {
+	if !e.options.Enabled {
+		return
+	}
+	e.job.Stop()
+}
+
+// Check goes through dormant orders batch by batch, a price is read once for a check
+func (e *TriggerEngine) Check() error {
+	prices := make(map[string]float64)
+	now := time.Now().Unix()
+	afterId := 0
+	for {
+		select {
+		case <-e.job.Stopping():
+			return nil
+		default:
+		}
+
+		list, err := e.rds.GetDormantConditionalOrders(afterId, e.options.BatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range list {
+			afterId = list[i].ID
+			e.check(&list[i], prices, now)
+		}
+		if len(list) < e.options.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (e *TriggerEngine) check(o *dao.ConditionalOrder, prices map[string]float64, now int64) {
+	if o.ValidUntil < now {
+		e.leaveDormant(o, dao.ConditionalOrderExpired, nil)
+		return
+	}
+	condition, err := trigger.ParseCondition(o.TriggerType, o.Side, o.TriggerPrice, o.PriceSource)
+	if err != nil {
+		e.leaveDormant(o, dao.ConditionalOrderFailed, map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	key := condition.Source + "_" + o.Market
+	price, ok := prices[key]
+	if !ok {
+		price = e.price(o.Market, condition.Source)
+		prices[key] = price
+	}
+	if !condition.Triggered(price) {
+		return
+	}
+
+	items := map[string]interface{}{
+		"triggered_price": strconv.FormatFloat(price, 'f', -1, 64),
+		"trigger_time":    now,
+	}
+	if !e.leaveDormant(o, dao.ConditionalOrderTriggered, items) {
+		return
+	}
+	e.activate(o)
+}
+
+// price is the price of market by source, it's 0 if the price is unknown
+func (e *TriggerEngine) price(market, source string) float64 {
+	if source == trigger.SourceReference {
+		ref, err := e.collector.GetReferencePrice(market)
+		if err != nil {
+			log.Debugf("conditional order trigger, reference price of %s error:%s", market, err.Error())
+			return 0
+		}
+		return ref.Price
+	}
+	ticker, err := e.trendManager.GetTickerByMarket(market)
+	if err != nil {
+		log.Debugf("conditional order trigger, ticker of %s error:%s", market, err.Error())
+		return 0
+	}
+	return ticker.Last
+}
+
+// leaveDormant updates a dormant order to status, it reports false if the order has been cancelled meanwhile
+func (e *TriggerEngine) leaveDormant(o *dao.ConditionalOrder, status string, items map[string]interface{}) bool {
+	ok, err := e.rds.UpdateDormantConditionalOrder(o.OrderHash, status, items)
+	if err != nil {
+		triggeredOrders.Inc("failed")
+		log.Errorf("conditional order trigger, update order:%s to %s error:%s", o.OrderHash, status, err.Error())
+		return false
+	}
+	if !ok {
+		triggeredOrders.Inc("skipped")
+		return false
+	}
+	triggeredOrders.Inc(status)
+	e.notify(o.OrderHash)
+	return true
+}
+
+// activate submits a triggered order as a new order, the order fails if it's rejected by the gateway
+func (e *TriggerEngine) activate(o *dao.ConditionalOrder) {
+	req := &types.OrderJsonRequest{}
+	err := o.ConvertUp(req)
+	if err == nil {
+		_, err = HandleInputOrder(types.ToOrder(req))
+	}
+	if err == nil {
+		triggeredOrders.Inc("activated")
+		log.Infof("conditional order trigger, order:%s activated", o.OrderHash)
+		return
+	}
+
+	triggeredOrders.Inc("rejected")
+	log.Infof("conditional order trigger, order:%s rejected, err:%s", o.OrderHash, err.Error())
+	errMsg := err.Error()
+	if len(errMsg) > maxConditionalOrderError {
+		errMsg = errMsg[:maxConditionalOrderError]
+	}
+	if err := e.rds.UpdateTriggeredConditionalOrder(o.OrderHash, dao.ConditionalOrderFailed, errMsg); err != nil {
+		log.Errorf("conditional order trigger, update order:%s to failed error:%s", o.OrderHash, err.Error())
+	}
+	e.notify(o.OrderHash)
+}
+
+func (e *TriggerEngine) notify(orderHash string) {
+	if o, err := e.rds.GetConditionalOrderByHash(orderHash); err == nil {
+		notifyConditionalOrderUpdate(o)
+	}
+}
+
+// CancelConditionalOrder cancels a dormant order of owner
+func CancelConditionalOrder(rds *dao.RdsService, owner, orderHash string) error {
+	o, err := rds.GetConditionalOrderByHash(orderHash)
+	if err != nil {
+		return errors.New("conditional order not found")
+	}
+	if !strings.EqualFold(o.Owner, owner) {
+		return errors.New("conditional order isn't of the owner")
+	}
+	ok, err := rds.UpdateDormantConditionalOrder(orderHash, dao.ConditionalOrderCancelled, nil)
+	if err != nil {
+		log.Errorf("gateway,cancel conditional order:%s error:%s", orderHash, err.Error())
+		return errors.New("conditional order can't be cancelled now")
+	}
+	if !ok {
+		return errors.New("conditional order isn't dormant any more")
+	}
+	o.Status = dao.ConditionalOrderCancelled
+	notifyConditionalOrderUpdate(o)
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/Loopring/relay-cluster/gateway/depthdiff"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/googollee/go-socket.io"
+	"strings"
+	"sync"
+)
+
+// DepthDiffResp is pushed by depthDiff_res, a snapshot has Depth and a diff has PrevSeq, Buy and Sell.
+// depthDiff_req is emitted again to resync when a client finds prevSeq of a diff isn't its seq
+type DepthDiffResp struct {
+	DelegateAddress string            `json:"delegateAddress"`
+	Market          string            `json:"market"`
+	Precision       *int              `json:"precision,omitempty"`
+	Snapshot        bool              `json:"snapshot"`
+	Seq             uint64            `json:"seq"`
+	PrevSeq         uint64            `json:"prevSeq,omitempty"`
+	Depth           *depthdiff.Levels `json:"depth,omitempty"`
+	Buy             [][]string        `json:"buy,omitempty"`
+	Sell            [][]string        `json:"sell,omitempty"`
+}
+
+// emitDepthSnapshot refreshes the depth of the subscribed market and emits its snapshot to conn,
+// then calls register to let conn receive the diffs. both are done under the lock of the market,
+// so the first diff reaching conn follows the snapshot
+func (so *SocketIOServiceImpl) emitDepthSnapshot(conn socketio.Conn, ctx string, register func()) {
+	query := DepthQuery{}
+	if err := json.Unmarshal([]byte(ctx), &query); err != nil || len(query.DelegateAddress) == 0 || len(query.Market) == 0 {
+		errJson, _ := json.Marshal(SocketIOJsonResp{Error: "delegateAddress and market must be applied"})
+		conn.Emit(eventKeyDepthDiff+EventPostfixRes, string(errJson[:]))
+		register()
+		return
+	}
+
+	key := depthKey(query)
+	lock := so.depthDiffLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	defer register()
+
+	if err := so.pushDepthDiff(key); err != nil {
+		errJson, _ := json.Marshal(SocketIOJsonResp{Error: err.Error()})
+		conn.Emit(eventKeyDepthDiff+EventPostfixRes, string(errJson[:]))
+		return
+	}
+
+	snapshot, _ := so.depthDiffs.Snapshot(key)
+	resp := DepthDiffResp{
+		DelegateAddress: query.DelegateAddress,
+		Market:          strings.ToUpper(query.Market),
+		Precision:       query.Precision,
+		Snapshot:        true,
+		Seq:             snapshot.Seq,
+		Depth:           &snapshot.Depth,
+	}
+	respJson, _ := json.Marshal(SocketIOJsonResp{Data: resp})
+	conn.Emit(eventKeyDepthDiff+EventPostfixRes, string(respJson[:]))
+}
+
+// broadcastDepthDiff pushes diffs of the subscribed markets, or the market of input,
+// the tracked depths without subscribers are dropped when all markets are checked
+func (so *SocketIOServiceImpl) broadcastDepthDiff(input interface{}) (err error) {
+	markets := so.getConnectedMarketForDepth(eventKeyDepthDiff, input)
+	for key := range markets {
+		if err := so.updateDepthDiff(key); err != nil {
+			log.Debugf("update depth diff of %s error:%s", key, err.Error())
+		}
+	}
+
+	if input == nil {
+		for _, key := range so.depthDiffs.Keys() {
+			if !markets[key] {
+				so.depthDiffs.Remove(key)
+			}
+		}
+	}
+	return nil
+}
+
+// depthDiffLock returns the lock of key, which orders the diffs and snapshots of the market
+func (so *SocketIOServiceImpl) depthDiffLock(key string) *sync.Mutex {
+	lock, _ := so.depthDiffLocks.LoadOrStore(key, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// updateDepthDiff updates the tracked depth of key and pushes the diff to its subscribers,
+// the diffs of a market are pushed in the order of their seq
+func (so *SocketIOServiceImpl) updateDepthDiff(key string) error {
+	lock := so.depthDiffLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+	return so.pushDepthDiff(key)
+}
+
+// pushDepthDiff is updateDepthDiff without the lock of key
+func (so *SocketIOServiceImpl) pushDepthDiff(key string) error {
+	query := parseDepthKey(key)
+	depth, err := so.walletService.GetDepth(query)
+	if err != nil {
+		return err
+	}
+
+	diff, changed := so.depthDiffs.Update(key, depthdiff.Levels{Buy: depth.Depth.Buy, Sell: depth.Depth.Sell})
+	if !changed {
+		return nil
+	}
+
+	resp := DepthDiffResp{
+		DelegateAddress: query.DelegateAddress,
+		Market:          strings.ToUpper(query.Market),
+		Precision:       query.Precision,
+		Seq:             diff.Seq,
+		PrevSeq:         diff.PrevSeq,
+		Buy:             diff.Buy,
+		Sell:            diff.Sell,
+	}
+	respJson, _ := json.Marshal(SocketIOJsonResp{Data: resp})
+	so.pushDepthData(eventKeyDepthDiff, map[string]string{key: string(respJson[:])})
+	return nil
+}
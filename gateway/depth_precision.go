@@ -0,0 +1,148 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"io/ioutil"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	// depth options are the element of markets.json whose ListType is depth
+	depthListType = "depth"
+	// the options of markets not in markets.json
+	defaultDepthMarket    = "default"
+	defaultDepthPrecision = 10
+	maxDepthPrecision     = 18
+)
+
+// DepthPrecisionOptions are the depth options of a market.
+// price levels are merged by Precisions decimals, the first is used when a query doesn't apply precision.
+// levels whose amount and size are both less than DustThresholds of their tokens are removed
+type DepthPrecisionOptions struct {
+	Precisions     []int
+	DustThresholds map[string]float64
+}
+
+type depthPrecisions map[string]DepthPrecisionOptions
+
+type depthMarketList struct {
+	ListType string
+	Markets  map[string]DepthPrecisionOptions
+}
+
+// loadDepthPrecisions reads the depth options of markets from marketFile,
+// markets without options and a default merge levels by 10 decimals and keep dust levels
+func loadDepthPrecisions(marketFile string) (depthPrecisions, error) {
+	precisions := depthPrecisions{}
+	if len(marketFile) == 0 {
+		return precisions, nil
+	}
+
+	bs, err := ioutil.ReadFile(marketFile)
+	if err != nil {
+		return nil, err
+	}
+	var lists []depthMarketList
+	if err := json.Unmarshal(bs, &lists); err != nil {
+		return nil, err
+	}
+
+	for _, list := range lists {
+		if list.ListType != depthListType {
+			continue
+		}
+		for mkt, options := range list.Markets {
+			for _, p := range options.Precisions {
+				if p < 0 || p > maxDepthPrecision {
+					return nil, fmt.Errorf("depth precision %d of market %s should be in [0, %d]", p, mkt, maxDepthPrecision)
+				}
+			}
+			thresholds := make(map[string]float64)
+			for token, v := range options.DustThresholds {
+				thresholds[strings.ToUpper(token)] = v
+			}
+			options.DustThresholds = thresholds
+			if mkt != defaultDepthMarket {
+				mkt = strings.ToUpper(mkt)
+			}
+			precisions[mkt] = options
+		}
+	}
+	return precisions, nil
+}
+
+func (p depthPrecisions) options(mkt string) DepthPrecisionOptions {
+	options, ok := p[strings.ToUpper(mkt)]
+	if !ok {
+		options = p[defaultDepthMarket]
+	}
+	if len(options.Precisions) == 0 {
+		options.Precisions = []int{defaultDepthPrecision}
+	}
+	return options
+}
+
+// precision returns the precision of the depth of mkt, it's the default one of mkt if precision is nil
+func (p depthPrecisions) precision(mkt string, precision *int) (int, error) {
+	options := p.options(mkt)
+	if precision == nil {
+		return options.Precisions[0], nil
+	}
+	for _, v := range options.Precisions {
+		if v == *precision {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported depth precision %d of market %s", *precision, mkt)
+}
+
+// isDust reports whether both amount of token s and size of token b in market s-b are less than their thresholds
+func (p depthPrecisions) isDust(mkt string, amount, size string) bool {
+	s, b := util.UnWrap(strings.ToUpper(mkt))
+	thresholds := p.options(mkt).DustThresholds
+	return isDustAmount(thresholds, s, amount) && isDustAmount(thresholds, b, size)
+}
+
+func isDustAmount(thresholds map[string]float64, token string, amount string) bool {
+	threshold, ok := thresholds[strings.ToUpper(token)]
+	if !ok {
+		return false
+	}
+	amountF, _ := strconv.ParseFloat(amount, 64)
+	return amountF < threshold
+}
+
+// depthPriceLevel is price rounded to precision decimals, sell prices are rounded up and buy prices down,
+// so that a merged level is never better than the orders in it
+func depthPriceLevel(price *big.Rat, precision int, roundUp bool) string {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	scaled := new(big.Rat).Mul(price, new(big.Rat).SetInt(scale))
+
+	level, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if roundUp && rem.Sign() > 0 {
+		level.Add(level, big.NewInt(1))
+	}
+	return new(big.Rat).SetFrac(level, scale).FloatString(precision)
+}
@@ -0,0 +1,170 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package depthdiff sequences depth snapshots of markets and computes price level deltas between them.
+// a level is [price, amount, size], a removed level has zero amount and size
+package depthdiff
+
+import "sync"
+
+const removedValue = "0"
+
+// Levels are the buy and sell price levels of a depth
+type Levels struct {
+	Buy  [][]string `json:"buy"`
+	Sell [][]string `json:"sell"`
+}
+
+// Snapshot is the full depth at Seq
+type Snapshot struct {
+	Seq   uint64 `json:"seq"`
+	Depth Levels `json:"depth"`
+}
+
+// Diff changes the depth at PrevSeq to the one at Seq, clients apply it only if PrevSeq is their seq,
+// ignore it if Seq isn't greater than their seq, otherwise request a snapshot again
+type Diff struct {
+	Seq     uint64     `json:"seq"`
+	PrevSeq uint64     `json:"prevSeq"`
+	Buy     [][]string `json:"buy"`
+	Sell    [][]string `json:"sell"`
+}
+
+type book struct {
+	seq    uint64
+	levels Levels
+}
+
+// Tracker keeps the latest snapshot of every key, e.g. delegate and market
+type Tracker struct {
+	mtx   sync.Mutex
+	books map[string]*book
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{books: make(map[string]*book)}
+}
+
+// Update replaces the depth of key with levels, the returned diff is false if nothing changed.
+// the first depth of key starts at seq 1 and has no diff
+func (t *Tracker) Update(key string, levels Levels) (Diff, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b, ok := t.books[key]
+	if !ok {
+		t.books[key] = &book{seq: 1, levels: copyLevels(levels)}
+		return Diff{}, false
+	}
+
+	diff := Diff{PrevSeq: b.seq, Buy: diffLevels(b.levels.Buy, levels.Buy), Sell: diffLevels(b.levels.Sell, levels.Sell)}
+	if len(diff.Buy) == 0 && len(diff.Sell) == 0 {
+		return Diff{}, false
+	}
+	b.seq++
+	b.levels = copyLevels(levels)
+	diff.Seq = b.seq
+	return diff, true
+}
+
+// Snapshot returns the latest depth of key
+func (t *Tracker) Snapshot(key string) (Snapshot, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	b, ok := t.books[key]
+	if !ok {
+		return Snapshot{}, false
+	}
+	return Snapshot{Seq: b.seq, Depth: copyLevels(b.levels)}, true
+}
+
+// Remove drops key, the next depth of it starts at seq 1 again
+func (t *Tracker) Remove(key string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.books, key)
+}
+
+// Keys returns the tracked keys
+func (t *Tracker) Keys() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	keys := make([]string, 0, len(t.books))
+	for k := range t.books {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// diffLevels returns the levels of next which are new or changed, then the levels of prev removed in next
+func diffLevels(prev, next [][]string) [][]string {
+	prevMap := make(map[string][]string)
+	for _, level := range prev {
+		if len(level) > 0 {
+			prevMap[level[0]] = level
+		}
+	}
+
+	diff := make([][]string, 0)
+	nextPrices := make(map[string]bool)
+	for _, level := range next {
+		if len(level) == 0 {
+			continue
+		}
+		nextPrices[level[0]] = true
+		if old, ok := prevMap[level[0]]; !ok || !sameLevel(old, level) {
+			diff = append(diff, level)
+		}
+	}
+	for _, level := range prev {
+		if len(level) > 0 && !nextPrices[level[0]] {
+			removed := make([]string, len(level))
+			removed[0] = level[0]
+			for i := 1; i < len(level); i++ {
+				removed[i] = removedValue
+			}
+			diff = append(diff, removed)
+		}
+	}
+	return diff
+}
+
+func sameLevel(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func copyLevels(levels Levels) Levels {
+	return Levels{Buy: copySide(levels.Buy), Sell: copySide(levels.Sell)}
+}
+
+func copySide(side [][]string) [][]string {
+	rst := make([][]string, len(side))
+	for i, level := range side {
+		rst[i] = append([]string{}, level...)
+	}
+	return rst
+}
@@ -0,0 +1,67 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package depthdiff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTracker_Update(t *testing.T) {
+	tracker := NewTracker()
+	key := "0x17233e07c67d086464fd408148c3abb56245fa64_lrc-weth"
+
+	first := Levels{
+		Buy:  [][]string{{"0.0009", "100", "0.09"}, {"0.0008", "50", "0.04"}},
+		Sell: [][]string{{"0.0011", "10", "0.011"}},
+	}
+	if _, changed := tracker.Update(key, first); changed {
+		t.Fatalf("first depth shouldn't have a diff")
+	}
+	if snapshot, ok := tracker.Snapshot(key); !ok || snapshot.Seq != 1 || !reflect.DeepEqual(snapshot.Depth, first) {
+		t.Fatalf("unexpected snapshot:%+v", snapshot)
+	}
+	if _, changed := tracker.Update(key, first); changed {
+		t.Fatalf("same depth shouldn't have a diff")
+	}
+
+	second := Levels{
+		Buy:  [][]string{{"0.0009", "80", "0.072"}, {"0.00085", "20", "0.017"}},
+		Sell: [][]string{{"0.0011", "10", "0.011"}},
+	}
+	diff, changed := tracker.Update(key, second)
+	if !changed || diff.PrevSeq != 1 || diff.Seq != 2 {
+		t.Fatalf("unexpected diff:%+v", diff)
+	}
+	expectBuy := [][]string{{"0.0009", "80", "0.072"}, {"0.00085", "20", "0.017"}, {"0.0008", "0", "0"}}
+	if !reflect.DeepEqual(diff.Buy, expectBuy) || len(diff.Sell) != 0 {
+		t.Fatalf("unexpected levels of diff:%+v", diff)
+	}
+
+	// the tracked depth isn't changed by callers
+	second.Buy[0][1] = "1"
+	if snapshot, _ := tracker.Snapshot(key); snapshot.Seq != 2 || snapshot.Depth.Buy[0][1] != "80" {
+		t.Fatalf("unexpected snapshot:%+v", snapshot)
+	}
+
+	tracker.Remove(key)
+	if _, ok := tracker.Snapshot(key); ok {
+		t.Fatalf("removed key shouldn't have snapshot")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"github.com/Loopring/relay-cluster/ordermanager/viewer"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+)
+
+const (
+	FILTER_POW    = "pow"
+	FILTER_BASE   = "base"
+	FILTER_SIGN   = "sign"
+	FILTER_TOKEN  = "token"
+	FILTER_CUTOFF = "cutoff"
+)
+
+// DefaultFilterChain is used when gateway_filters.chain is not set in relay.toml
+var DefaultFilterChain = []string{FILTER_POW, FILTER_BASE, FILTER_SIGN, FILTER_TOKEN, FILTER_CUTOFF}
+
+// Filter checks an order before it is accepted by the relay.
+// an order is rejected by the first filter which returns false.
+type Filter interface {
+	Filter(o *types.Order) (bool, error)
+}
+
+// FilterCreator builds a filter from the gateway_filters section of relay.toml,
+// filters registered outside this package read their own settings from options.Params[name]
+type FilterCreator func(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error)
+
+type namedFilter struct {
+	name   string
+	filter Filter
+}
+
+var (
+	filterCreators    = make(map[string]FilterCreator)
+	filterCreatorsMtx sync.RWMutex
+)
+
+// RegisterFilter makes a filter available to gateway_filters.chain,
+// it must be called before gateway.Initialize
+func RegisterFilter(name string, creator FilterCreator) error {
+	filterCreatorsMtx.Lock()
+	defer filterCreatorsMtx.Unlock()
+
+	if name == "" || creator == nil {
+		return fmt.Errorf("gateway,register filter,name and creator can't be empty")
+	}
+	if _, exists := filterCreators[name]; exists {
+		return fmt.Errorf("gateway,register filter,filter:%s has been registered", name)
+	}
+	filterCreators[name] = creator
+	return nil
+}
+
+// RegisteredFilters returns names of all filters that can be used in gateway_filters.chain
+func RegisteredFilters() []string {
+	filterCreatorsMtx.RLock()
+	defer filterCreatorsMtx.RUnlock()
+
+	names := make([]string, 0, len(filterCreators))
+	for name := range filterCreators {
+		names = append(names, name)
+	}
+	return names
+}
+
+func newFilterChain(options *GatewayFiltersOptions, om viewer.OrderViewer) ([]namedFilter, error) {
+	chain := options.Chain
+	if len(chain) == 0 {
+		chain = DefaultFilterChain
+	}
+
+	filterCreatorsMtx.RLock()
+	defer filterCreatorsMtx.RUnlock()
+
+	filters := make([]namedFilter, 0, len(chain))
+	used := make(map[string]bool)
+	for _, name := range chain {
+		if used[name] {
+			return nil, fmt.Errorf("gateway,filter:%s appears more than once in chain", name)
+		}
+		creator, ok := filterCreators[name]
+		if !ok {
+			return nil, fmt.Errorf("gateway,filter:%s has not been registered", name)
+		}
+		f, err := creator(name, options, om)
+		if err != nil {
+			return nil, fmt.Errorf("gateway,create filter:%s error:%s", name, err.Error())
+		}
+		filters = append(filters, namedFilter{name: name, filter: f})
+		used[name] = true
+	}
+
+	return filters, nil
+}
+
+// FilterError is returned by HandleInputOrder when an order is rejected by a filter,
+// Error() keeps the message of the filter so that the response of rpc is unchanged
+type FilterError struct {
+	Filter    string
+	OrderHash common.Hash
+	Err       error
+}
+
+func (e *FilterError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("order:%s rejected by filter:%s", e.OrderHash.Hex(), e.Filter)
+	}
+	return e.Err.Error()
+}
+
+// IsFilterError returns the FilterError if err is caused by a filter
+func IsFilterError(err error) (*FilterError, bool) {
+	fe, ok := err.(*FilterError)
+	return fe, ok
+}
+
+func init() {
+	RegisterFilter(FILTER_POW, newPowFilter)
+	RegisterFilter(FILTER_BASE, newBaseFilter)
+	RegisterFilter(FILTER_SIGN, newSignFilter)
+	RegisterFilter(FILTER_TOKEN, newTokenFilter)
+	RegisterFilter(FILTER_CUTOFF, newCutoffFilter)
+}
+
+func newPowFilter(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error) {
+	return &PowFilter{Difficulty: types.HexToBigint(options.PowFilter.Difficulty)}, nil
+}
+
+func newBaseFilter(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error) {
+	baseFilter := &BaseFilter{
+		MinLrcFee:             big.NewInt(options.BaseFilter.MinLrcFee),
+		MinLrcHold:            options.BaseFilter.MinLrcHold,
+		MaxPrice:              big.NewInt(options.BaseFilter.MaxPrice),
+		MinSplitPercentage:    options.BaseFilter.MinSplitPercentage,
+		MaxSplitPercentage:    options.BaseFilter.MaxSplitPercentage,
+		MinTokeSAmount:        make(map[string]*big.Int),
+		MinTokenSUsdAmount:    options.BaseFilter.MinTokenSUsdAmount,
+		MaxValidSinceInterval: options.BaseFilter.MaxValidSinceInterval,
+		OwnerBlackList:        options.BaseFilter.OwnerBlackList,
+	}
+	for k, v := range options.BaseFilter.MinTokeSAmount {
+		minAmount := big.NewInt(0)
+		amount, succ := minAmount.SetString(v, 10)
+		if succ {
+			baseFilter.MinTokeSAmount[k] = amount
+		}
+	}
+	return baseFilter, nil
+}
+
+func newSignFilter(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error) {
+	return &SignFilter{}, nil
+}
+
+func newTokenFilter(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error) {
+	return &TokenFilter{}, nil
+}
+
+func newCutoffFilter(name string, options *GatewayFiltersOptions, om viewer.OrderViewer) (Filter, error) {
+	return &CutoffFilter{om: om}, nil
+}
@@ -24,6 +24,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/Loopring/relay-cluster/accountmanager"
+	"github.com/Loopring/relay-cluster/gateway/order_difficulty"
+	"github.com/Loopring/relay-cluster/metrics"
 	"github.com/Loopring/relay-cluster/ordermanager/manager"
 	"github.com/Loopring/relay-cluster/ordermanager/viewer"
 	"github.com/Loopring/relay-lib/broadcast"
@@ -41,21 +43,20 @@ import (
 )
 
 type Gateway struct {
-	filters          []Filter
+	filters          []namedFilter
 	om               viewer.OrderViewer
 	am               accountmanager.AccountManager
 	isBroadcast      bool
 	maxBroadcastTime int
+	maxBatchSize     int
 	marketCap        marketcap.MarketCapProvider
 }
 
 var gateway Gateway
 
-type Filter interface {
-	filter(o *types.Order) (bool, error)
-}
-
 type GatewayFiltersOptions struct {
+	Chain      []string
+	Params     map[string]map[string]string
 	BaseFilter struct {
 		MinLrcFee             int64
 		MinLrcHold            int64
@@ -75,55 +76,27 @@ type GatewayFiltersOptions struct {
 type GateWayOptions struct {
 	IsBroadcast      bool
 	MaxBroadcastTime int
+	MaxBatchSize     int // orders or hashes of loopring_submitOrders and loopring_flexCancelOrders
+	ConditionalOrder ConditionalOrderOptions
 	MatrixPubOptions []matrix.MatrixPublisherOption
 	MatrixSubOptions []matrix.MatrixSubscriberOption
 }
 
 func Initialize(filterOptions *GatewayFiltersOptions, options *GateWayOptions, om viewer.OrderViewer, marketCap marketcap.MarketCapProvider, am accountmanager.AccountManager) {
-	gateway = Gateway{filters: make([]Filter, 0), om: om, isBroadcast: options.IsBroadcast, maxBroadcastTime: options.MaxBroadcastTime, am: am}
+	gateway = Gateway{om: om, isBroadcast: options.IsBroadcast, maxBroadcastTime: options.MaxBroadcastTime, maxBatchSize: options.MaxBatchSize, am: am}
+	if gateway.maxBatchSize <= 0 {
+		gateway.maxBatchSize = defaultMaxBatchSize
+	}
 
 	gateway.marketCap = marketCap
 
-	// new pow filter
-	powFilter := &PowFilter{Difficulty: types.HexToBigint(filterOptions.PowFilter.Difficulty)}
-
-	// new base filter
-	baseFilter := &BaseFilter{
-		MinLrcFee:             big.NewInt(filterOptions.BaseFilter.MinLrcFee),
-		MinLrcHold:            filterOptions.BaseFilter.MinLrcHold,
-		MaxPrice:              big.NewInt(filterOptions.BaseFilter.MaxPrice),
-		MinSplitPercentage:    filterOptions.BaseFilter.MinSplitPercentage,
-		MaxSplitPercentage:    filterOptions.BaseFilter.MaxSplitPercentage,
-		MinTokeSAmount:        make(map[string]*big.Int),
-		MinTokenSUsdAmount:    filterOptions.BaseFilter.MinTokenSUsdAmount,
-		MaxValidSinceInterval: filterOptions.BaseFilter.MaxValidSinceInterval,
-		OwnerBlackList:        filterOptions.BaseFilter.OwnerBlackList,
-	}
-	for k, v := range filterOptions.BaseFilter.MinTokeSAmount {
-		minAmount := big.NewInt(0)
-		amount, succ := minAmount.SetString(v, 10)
-		if succ {
-			baseFilter.MinTokeSAmount[k] = amount
-		}
+	filters, err := newFilterChain(filterOptions, om)
+	if nil != err {
+		log.Fatalf("err:%s", err.Error())
 	}
-
-	// new token filter
-	tokenFilter := &TokenFilter{}
-
-	// new sign filter
-	signFilter := &SignFilter{}
-
-	// new cutoff filter
-	cutoffFilter := &CutoffFilter{om: om}
-
-	gateway.filters = append(gateway.filters, powFilter)
-	gateway.filters = append(gateway.filters, baseFilter)
-	gateway.filters = append(gateway.filters, signFilter)
-	gateway.filters = append(gateway.filters, tokenFilter)
-	gateway.filters = append(gateway.filters, cutoffFilter)
+	gateway.filters = filters
 
 	if gateway.isBroadcast {
-		var err error
 		var publishers []broadcast.Publisher
 		var subscribers []broadcast.Subscriber
 		publishers, err = matrix.NewPublishers(options.MatrixPubOptions)
@@ -166,31 +139,54 @@ func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error
 			return orderHash, err
 		}
 
-		for _, v := range gateway.filters {
-			valid, err := v.filter(order)
-			if !valid {
-				log.Info(err.Error())
-				return orderHash, err
-			}
+		if err = filterOrder(order, nil); err != nil {
+			return orderHash, err
 		}
 		state = &types.OrderState{}
 		state.RawOrder = *order
 		eventemitter.Emit(eventemitter.NewOrder, state)
 	} else {
-		broadcastTime := state.BroadcastTime + 1
-		if gateway.isBroadcast && broadcastTime < gateway.maxBroadcastTime {
-			eventemitter.Emit(eventemitter.NewOrderForBroadcast, state.RawOrder)
-			if err = manager.UpdateBroadcastTimeByHash(state.RawOrder.Hash, broadcastTime+1); nil != err {
-				return orderHash, err
-			}
-		}
-		log.Infof("gateway,order %s exist,will not insert again", order.Hash.Hex())
-		return orderHash, errors.New("order existed, please not submit again")
+		return orderHash, handleExistedOrder(state)
 	}
 
 	return orderHash, err
 }
 
+// filterOrder checks order by the filter chain, batch is nil unless the order is submitted in a batch
+func filterOrder(order *types.Order, batch *orderBatch) error {
+	for _, v := range gateway.filters {
+		var (
+			valid bool
+			err   error
+		)
+		if f, ok := v.filter.(batchFilter); ok && batch != nil {
+			valid, err = f.FilterInBatch(order, batch)
+		} else {
+			valid, err = v.filter.Filter(order)
+		}
+		if !valid {
+			filterErr := &FilterError{Filter: v.name, OrderHash: order.Hash, Err: err}
+			log.Infof("gateway,order:%s rejected by filter:%s, err:%s", order.Hash.Hex(), v.name, filterErr.Error())
+			metrics.OrderFilterRejections.Inc(v.name)
+			return filterErr
+		}
+	}
+	return nil
+}
+
+// handleExistedOrder broadcasts an order submitted again if it hasn't been broadcast enough
+func handleExistedOrder(state *types.OrderState) error {
+	broadcastTime := state.BroadcastTime + 1
+	if gateway.isBroadcast && broadcastTime < gateway.maxBroadcastTime {
+		eventemitter.Emit(eventemitter.NewOrderForBroadcast, state.RawOrder)
+		if err := manager.UpdateBroadcastTimeByHash(state.RawOrder.Hash, broadcastTime+1); nil != err {
+			return err
+		}
+	}
+	log.Infof("gateway,order %s exist,will not insert again", state.RawOrder.Hash.Hex())
+	return errors.New("order existed, please not submit again")
+}
+
 func generatePrice(order *types.Order) error {
 	tokenS, err := util.AddressToToken(order.TokenS)
 	if err != nil {
@@ -236,7 +232,12 @@ type BaseFilter struct {
 	OwnerBlackList        []string
 }
 
-func (f *BaseFilter) filter(o *types.Order) (bool, error) {
+func (f *BaseFilter) Filter(o *types.Order) (bool, error) {
+	return f.FilterInBatch(o, nil)
+}
+
+// FilterInBatch looks up balances and frozen amounts by batch, orders accepted earlier in the batch are frozen as well
+func (f *BaseFilter) FilterInBatch(o *types.Order, batch *orderBatch) (bool, error) {
 	const (
 		addrLength = 20
 		hashLength = 32
@@ -256,7 +257,7 @@ func (f *BaseFilter) filter(o *types.Order) (bool, error) {
 		return false, fmt.Errorf("market order auth private key not correct")
 	}
 
-	balances, err := accountmanager.GetBalanceWithSymbolResult(o.Owner)
+	balances, err := batch.balances(o.Owner)
 	if err != nil {
 		return false, fmt.Errorf("gateway,base filter,owner holds lrc less than %d ", f.MinLrcHold)
 	}
@@ -275,7 +276,7 @@ func (f *BaseFilter) filter(o *types.Order) (bool, error) {
 
 	}
 
-	if isExceedBalance(o, balances) {
+	if isExceedBalance(o, balances, batch) {
 		return false, fmt.Errorf("balance is not enough to submit order, 用户余额不足或者下单总量已经超过余额上限")
 	}
 
@@ -353,7 +354,7 @@ func (f *BaseFilter) filter(o *types.Order) (bool, error) {
 type SignFilter struct {
 }
 
-func (f *SignFilter) filter(o *types.Order) (bool, error) {
+func (f *SignFilter) Filter(o *types.Order) (bool, error) {
 	o.Hash = o.GenerateHash()
 
 	if addr, err := o.SignerAddress(); nil != err {
@@ -370,7 +371,7 @@ type TokenFilter struct {
 	DeniedTokens map[common.Address]bool
 }
 
-func (f *TokenFilter) filter(o *types.Order) (bool, error) {
+func (f *TokenFilter) Filter(o *types.Order) (bool, error) {
 	supportTokenS := false
 	supportTokenB := false
 	for _, v := range util.AllTokens {
@@ -397,7 +398,7 @@ type CutoffFilter struct {
 }
 
 // 如果订单接收在cutoff(cancel)事件之后，则该订单直接过滤
-func (f *CutoffFilter) filter(o *types.Order) (bool, error) {
+func (f *CutoffFilter) Filter(o *types.Order) (bool, error) {
 	if f.om.IsOrderCutoff(o.Protocol, o.Owner, o.TokenS, o.TokenB, o.ValidSince) {
 		return false, fmt.Errorf("gateway,cutoff filter order:%s should be cutoff", o.Owner.Hex())
 	}
@@ -409,7 +410,7 @@ type PowFilter struct {
 	Difficulty *big.Int
 }
 
-func (f *PowFilter) filter(o *types.Order) (bool, error) {
+func (f *PowFilter) Filter(o *types.Order) (bool, error) {
 
 	if o.PowNonce <= 0 {
 		return false, fmt.Errorf("invalid pow nonce")
@@ -417,7 +418,13 @@ func (f *PowFilter) filter(o *types.Order) (bool, error) {
 
 	pow := GetPow(o.V, o.R, o.S, o.PowNonce)
 
-	if pow.Cmp(f.Difficulty) < 0 {
+	// the adaptive difficulty rises above the configured one during order floods
+	difficulty := f.Difficulty
+	if current := order_difficulty.CurrentDifficulty(); nil != current && current.Cmp(difficulty) > 0 {
+		difficulty = current
+	}
+
+	if pow.Cmp(difficulty) < 0 {
 		return false, fmt.Errorf("invalid pow")
 	}
 	return true, nil
@@ -449,13 +456,10 @@ func Uint64ToByteArray(src uint64) []byte {
 	return rst
 }
 
-func isExceedBalance(order *types.Order, balances map[string]*big.Int) bool {
+func isExceedBalance(order *types.Order, balances map[string]*big.Int, batch *orderBatch) bool {
 	tokenS := order.TokenS
 	balance := balances[util.SymbolTokenMap[tokenS]]
-	statusSet := make([]types.OrderStatus, 0)
-	statusSet = append(statusSet, types.ORDER_NEW)
-	statusSet = append(statusSet, types.ORDER_PARTIAL)
-	amount, err := gateway.om.GetFrozenAmount(order.Owner, order.TokenS, statusSet, order.DelegateAddress)
+	amount, err := batch.frozenAmount(order)
 	if err != nil {
 		return true
 	}
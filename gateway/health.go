@@ -0,0 +1,224 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-lib/cache"
+	"github.com/Loopring/relay-lib/eth/accessor"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/Loopring/relay-lib/zklock"
+	"github.com/Shopify/sarama"
+	"github.com/samuel/go-zookeeper/zk"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const healthCheckKey = "relay_health_check"
+
+// HealthChecker returns nil if the dependency is available
+type HealthChecker func() error
+
+type namedChecker struct {
+	name    string
+	checker HealthChecker
+}
+
+// HealthService serves /healthz and /readyz of jsonrpc.
+// /healthz is the liveness of the process and doesn't touch dependencies,
+// /readyz runs all checkers and also requires the node to be started and not stopping
+type HealthService struct {
+	checkers []namedChecker
+	mtx      sync.RWMutex
+	ready    int32
+	timeout  time.Duration
+}
+
+type HealthResult struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func NewHealthService(timeout time.Duration) *HealthService {
+	return &HealthService{timeout: timeout}
+}
+
+func (h *HealthService) RegisterChecker(name string, checker HealthChecker) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.checkers = append(h.checkers, namedChecker{name: name, checker: checker})
+}
+
+func (h *HealthService) SetReady(ready bool) {
+	if ready {
+		atomic.StoreInt32(&h.ready, 1)
+	} else {
+		atomic.StoreInt32(&h.ready, 0)
+	}
+}
+
+func (h *HealthService) IsReady() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// Check runs checkers concurrently, a checker not returned in timeout is failed
+func (h *HealthService) Check() (bool, HealthResult) {
+	h.mtx.RLock()
+	checkers := make([]namedChecker, len(h.checkers))
+	copy(checkers, h.checkers)
+	h.mtx.RUnlock()
+
+	type checkRes struct {
+		name string
+		err  error
+	}
+	resChan := make(chan checkRes, len(checkers))
+	for _, c := range checkers {
+		go func(c namedChecker) {
+			resChan <- checkRes{c.name, c.checker()}
+		}(c)
+	}
+
+	result := HealthResult{Status: "ok", Checks: make(map[string]string)}
+	for _, c := range checkers {
+		result.Checks[c.name] = "timeout"
+	}
+	timeout := time.After(h.timeout)
+WAIT:
+	for i := 0; i < len(checkers); i++ {
+		select {
+		case res := <-resChan:
+			if nil != res.err {
+				result.Checks[res.name] = res.err.Error()
+			} else {
+				result.Checks[res.name] = "ok"
+			}
+		case <-timeout:
+			break WAIT
+		}
+	}
+
+	healthy := true
+	for _, v := range result.Checks {
+		if v != "ok" {
+			healthy = false
+			result.Status = "unavailable"
+		}
+	}
+	return healthy, result
+}
+
+// HandleHealthz answers as long as the process serves http,
+// a dependency outage shouldn't get the node restarted
+func (h *HealthService) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthResult(w, true, HealthResult{Status: "ok", Checks: make(map[string]string)})
+}
+
+func (h *HealthService) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	healthy, result := h.Check()
+	if !h.IsReady() {
+		healthy = false
+		result.Status = "not ready"
+	}
+	writeHealthResult(w, healthy, result)
+}
+
+func writeHealthResult(w http.ResponseWriter, healthy bool, result HealthResult) {
+	w.Header().Set("Content-Type", "application/json")
+	if !healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func MysqlChecker(rds *dao.RdsService) HealthChecker {
+	return func() error {
+		if nil == rds || nil == rds.Db {
+			return errors.New("mysql is not initialized")
+		}
+		return rds.Db.DB().Ping()
+	}
+}
+
+func RedisChecker() HealthChecker {
+	return func() error {
+		if !cache.IsInit() {
+			return errors.New("redis is not initialized")
+		}
+		_, err := cache.Exists(healthCheckKey)
+		return err
+	}
+}
+
+// KafkaChecker refreshes the metadata with one client shared by the probes,
+// the client is created on the first probe and recreated only after it's closed
+func KafkaChecker(brokers []string) HealthChecker {
+	var (
+		mtx    sync.Mutex
+		client sarama.Client
+	)
+	return func() error {
+		if len(brokers) == 0 {
+			return errors.New("kafka brokers are not configured")
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		if nil == client || client.Closed() {
+			var err error
+			if client, err = sarama.NewClient(brokers, sarama.NewConfig()); nil != err {
+				client = nil
+				return err
+			}
+		}
+		if err := client.RefreshMetadata(); nil != err {
+			return err
+		}
+		if len(client.Brokers()) == 0 {
+			return errors.New("no kafka broker is available")
+		}
+		return nil
+	}
+}
+
+func ZookeeperChecker() HealthChecker {
+	return func() error {
+		if !zklock.IsLockInitialed() || nil == zklock.ZkClient {
+			return errors.New("zookeeper is not initialized")
+		}
+		if state := zklock.ZkClient.State(); state != zk.StateHasSession {
+			return fmt.Errorf("zookeeper session state:%s", state.String())
+		}
+		return nil
+	}
+}
+
+func AccessorChecker() HealthChecker {
+	return func() error {
+		if !accessor.IsInit() {
+			return errors.New("ethereum accessor is not initialized")
+		}
+		var blockNumber types.Big
+		return accessor.BlockNumber(&blockNumber)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package inproc calls the methods of a json-rpc server in process, one request at a time.
+// a request is served by rpc.Server.ServeSingleRequest, which runs the method in the calling goroutine
+// and recovers it from panics, rather than the codec of rpc.DialInProc whose calls run in goroutines without recover
+package inproc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ErrCodeInternal is returned when the method panics and no response is written
+const ErrCodeInternal = -32603
+
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type request struct {
+	Version string            `json:"jsonrpc"`
+	Id      int               `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *Error          `json:"error"`
+}
+
+// buffer is the connection of a single request, it reads the request and keeps the response
+type buffer struct {
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func (b *buffer) Read(p []byte) (int, error)  { return b.in.Read(p) }
+func (b *buffer) Write(p []byte) (int, error) { return b.out.Write(p) }
+func (b *buffer) Close() error                { return nil }
+
+// Call calls method of server with params and returns its result,
+// the error of a failed call is *Error with the code written by server
+func Call(server *rpc.Server, method string, params []json.RawMessage) (json.RawMessage, error) {
+	if params == nil {
+		params = []json.RawMessage{}
+	}
+	reqJson, err := json.Marshal(request{Version: "2.0", Id: 1, Method: method, Params: params})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &buffer{in: bytes.NewReader(reqJson)}
+	server.ServeSingleRequest(rpc.NewJSONCodec(buf), rpc.OptionMethodInvocation)
+	if buf.out.Len() == 0 {
+		return nil, &Error{Code: ErrCodeInternal, Message: fmt.Sprintf("internal error of %s", method)}
+	}
+
+	var resp response
+	if err := json.Unmarshal(buf.out.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	return resp.Result, nil
+}
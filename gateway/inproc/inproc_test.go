@@ -0,0 +1,82 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package inproc
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/rpc"
+	"testing"
+)
+
+type EchoRequest struct {
+	Text string `json:"text"`
+}
+
+type TestService struct{}
+
+func (s *TestService) Echo(req *EchoRequest) (string, error) {
+	return req.Text, nil
+}
+
+func (s *TestService) Fail() error {
+	return errors.New("failed")
+}
+
+func newTestServer(t *testing.T) *rpc.Server {
+	server := rpc.NewServer()
+	if err := server.RegisterName("test", &TestService{}); err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestCall(t *testing.T) {
+	server := newTestServer(t)
+
+	result, err := Call(server, "test_echo", []json.RawMessage{json.RawMessage(`{"text":"hi"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `"hi"` {
+		t.Fatalf("expected \"hi\", got %s", string(result))
+	}
+
+	if _, err := Call(server, "test_fail", nil); err == nil || err.Error() != "failed" {
+		t.Fatalf("expected the error of the method, got %v", err)
+	}
+	if _, err := Call(server, "test_unknown", nil); err == nil {
+		t.Fatalf("expected an error of unknown method")
+	}
+}
+
+func TestCallRecoversPanic(t *testing.T) {
+	server := newTestServer(t)
+
+	// a null param is a nil *EchoRequest, Echo panics on it
+	_, err := Call(server, "test_echo", []json.RawMessage{json.RawMessage("null")})
+	rpcErr, ok := err.(*Error)
+	if !ok || rpcErr.Code != ErrCodeInternal {
+		t.Fatalf("expected an internal error, got %v", err)
+	}
+
+	if result, err := Call(server, "test_echo", []json.RawMessage{json.RawMessage(`{"text":"alive"}`)}); err != nil || string(result) != `"alive"` {
+		t.Fatalf("expected server to keep serving after a panic, got %s %v", string(result), err)
+	}
+}
@@ -19,16 +19,30 @@
 package gateway
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/Loopring/relay-cluster/gateway/ratelimit"
+	"github.com/Loopring/relay-cluster/metrics"
 	"github.com/Loopring/relay-lib/log"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/rs/cors"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"reflect"
+	"strings"
+	"time"
 )
 
 type JsonrpcOptions struct {
-	Port string
+	Port           string
+	AllowedOrigins []string
+	TlsCertFile    string
+	TlsKeyFile     string
+	RateLimit      ratelimit.RateLimitOptions
+	AdminKeys      []string // keys of the admin methods, they are rejected if it's empty
 }
 
 func (*JsonrpcServiceImpl) Ping(val string, val2 int) (res string, err error) {
@@ -37,7 +51,7 @@ func (*JsonrpcServiceImpl) Ping(val string, val2 int) (res string, err error) {
 }
 
 type JsonrpcService interface {
-	Start(port string)
+	Start()
 	Stop()
 }
 
@@ -46,14 +60,31 @@ type JsonrpcServiceImpl struct {
 	walletService *WalletServiceImpl
 	ringTrackerService *RingTrackerServiceImpl
 	contestRankService *ContestRankServiceImpl
+	webhookService     *WebhookServiceImpl
+	adminService       *AdminServiceImpl
+	healthService      *HealthService
+	rateLimiter        *ratelimit.RateLimiter
+	httpServer         *http.Server
+	shutdownTimeout    time.Duration
+	allowedOrigins     []string
+	tlsCertFile        string
+	tlsKeyFile         string
 }
 
-func NewJsonrpcService(port string, walletService *WalletServiceImpl, ringTrackerService *RingTrackerServiceImpl, contestRankService *ContestRankServiceImpl) *JsonrpcServiceImpl {
+func NewJsonrpcService(options *JsonrpcOptions, shutdownTimeout time.Duration, walletService *WalletServiceImpl, ringTrackerService *RingTrackerServiceImpl, contestRankService *ContestRankServiceImpl, webhookService *WebhookServiceImpl, adminService *AdminServiceImpl, healthService *HealthService, rateLimiter *ratelimit.RateLimiter) *JsonrpcServiceImpl {
 	l := &JsonrpcServiceImpl{}
-	l.port = port
+	l.port = options.Port
+	l.allowedOrigins = allowedOriginsOrDefault(options.AllowedOrigins)
+	l.tlsCertFile = options.TlsCertFile
+	l.tlsKeyFile = options.TlsKeyFile
+	l.shutdownTimeout = shutdownTimeout
+	l.healthService = healthService
+	l.rateLimiter = rateLimiter
 	l.walletService = walletService
 	l.ringTrackerService = ringTrackerService
 	l.contestRankService = contestRankService
+	l.webhookService = webhookService
+	l.adminService = adminService
 	return l
 }
 
@@ -75,29 +106,141 @@ func (j *JsonrpcServiceImpl) Start() {
 		return
 	}
 
+	if err := handler.RegisterName("loopring", j.webhookService); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if err := handler.RegisterName("loopring", j.adminService); err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	var (
 		listener net.Listener
 		err      error
 	)
 
+	useTls, err := checkTlsFiles(j.tlsCertFile, j.tlsKeyFile)
+	if nil != err {
+		log.Fatalf("jsonrpc, %s", err.Error())
+	}
+
 	if listener, err = net.Listen("tcp", ":"+j.port); err != nil {
+		log.Errorf("jsonrpc, listen on port:%s error:%s", j.port, err.Error())
 		return
 	}
 	//httpServer := rpc.NewHTTPServer([]string{"*"}, handler)
 	lprServer := &http.ServeMux{}
-	lprServer.Handle("/", handler)
+	knownMethods := rpcMethodNames("loopring", j.walletService, j.ringTrackerService, j.contestRankService, j.webhookService, j.adminService)
+	lprServer.Handle("/", newRpcMetricsHandler(handler, knownMethods))
+	lprServer.HandleFunc("/healthz", j.healthService.HandleHealthz)
+	lprServer.HandleFunc("/readyz", j.healthService.HandleReadyz)
+	lprServer.Handle("/metrics", metrics.Handler())
 	lprServer.HandleFunc("/city_partner/add_customer/", j.walletService.CreateCustomerInvitationInfo)
 	lprServer.HandleFunc("/city_partner/activate_customer", j.walletService.ActivateCustomerInvitation)
+	registerRestHandlers(lprServer, j.walletService, j.rateLimiter)
 
-	httpServer := &http.Server{Handler: newCorsHandler(lprServer, []string{"*"})}
+	// the limiter wraps the mux, so a json-rpc body posted to any path is limited by its method
+	j.httpServer = &http.Server{Handler: newCorsHandler(j.rateLimiter.Handler(lprServer), j.allowedOrigins)}
 	//httpServer.Handler = newCorsHandler(handler, []string{"*"})
-	go httpServer.Serve(listener)
-	log.Info(fmt.Sprintf("HTTP endpoint opened on " + j.port))
+	go func() {
+		if err := serveHttp(j.httpServer, listener, j.tlsCertFile, j.tlsKeyFile); err != nil && err != http.ErrServerClosed {
+			log.Errorf("jsonrpc, serve error:%s", err.Error())
+		}
+	}()
+	if useTls {
+		log.Info(fmt.Sprintf("HTTPS endpoint opened on " + j.port))
+	} else {
+		log.Info(fmt.Sprintf("HTTP endpoint opened on " + j.port))
+	}
 
 	return
 }
 
-func newCorsHandler(srv *http.ServeMux, allowedOrigins []string) http.Handler {
+// Stop closes the listener and waits for the pending requests until shutdownTimeout
+func (j *JsonrpcServiceImpl) Stop() {
+	if j.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), j.shutdownTimeout)
+	defer cancel()
+	if err := j.httpServer.Shutdown(ctx); err != nil {
+		log.Errorf("jsonrpc, shutdown error:%s", err.Error())
+	}
+	log.Info("HTTP endpoint closed on " + j.port)
+}
+
+type rpcMethod struct {
+	Method string `json:"method"`
+}
+
+// newRpcMetricsHandler observes metrics.RpcDuration by the method of request,
+// every method of a batch request is observed with the duration of the whole batch,
+// methods not in knownMethods are observed as "unknown" so that clients can't add labels
+func newRpcMetricsHandler(next http.Handler, knownMethods map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || nil == r.Body {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		cost := time.Since(start).Seconds()
+
+		for _, method := range rpcMethods(body) {
+			if !knownMethods[method] {
+				method = "unknown"
+			}
+			metrics.RpcDuration.Observe(method, cost)
+		}
+	})
+}
+
+func rpcMethods(body []byte) []string {
+	body = bytes.TrimSpace(body)
+	var reqs []rpcMethod
+	if len(body) > 0 && body[0] == '[' {
+		if err := json.Unmarshal(body, &reqs); nil != err {
+			return []string{""}
+		}
+	} else {
+		var req rpcMethod
+		if err := json.Unmarshal(body, &req); nil != err {
+			return []string{""}
+		}
+		reqs = append(reqs, req)
+	}
+
+	methods := make([]string, 0, len(reqs))
+	for _, req := range reqs {
+		methods = append(methods, req.Method)
+	}
+	return methods
+}
+
+// rpcMethodNames returns names of methods exported by rpc.Server.RegisterName, e.g. loopring_getDepth
+func rpcMethodNames(namespace string, receivers ...interface{}) map[string]bool {
+	names := make(map[string]bool)
+	for _, receiver := range receivers {
+		typ := reflect.TypeOf(receiver)
+		for i := 0; i < typ.NumMethod(); i++ {
+			name := typ.Method(i).Name
+			names[namespace+"_"+strings.ToLower(name[:1])+name[1:]] = true
+		}
+	}
+	return names
+}
+
+func newCorsHandler(srv http.Handler, allowedOrigins []string) http.Handler {
 	// disable CORS support if user has not specified a custom CORS configuration
 	if len(allowedOrigins) == 0 {
 		return srv
@@ -0,0 +1,77 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// allowed_origins of jsonrpc and websocket, all origins are allowed if it's not set
+var defaultAllowedOrigins = []string{"*"}
+
+func allowedOriginsOrDefault(origins []string) []string {
+	if len(origins) == 0 {
+		return defaultAllowedOrigins
+	}
+	return origins
+}
+
+// originAllowed matches origin with allowed origins, which can contain one wildcard, e.g. https://*.loopring.io
+func originAllowed(allowedOrigins []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range allowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if i := strings.Index(allowed, "*"); i >= 0 {
+			prefix, suffix := allowed[:i], allowed[i+1:]
+			if len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTlsFiles returns whether the listener serves https, cert and key must be set together
+func checkTlsFiles(certFile, keyFile string) (bool, error) {
+	if "" == certFile && "" == keyFile {
+		return false, nil
+	}
+	if "" == certFile || "" == keyFile {
+		return false, fmt.Errorf("tls_cert_file and tls_key_file must be set together")
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); nil != err {
+		return false, fmt.Errorf("load tls key pair error:%s", err.Error())
+	}
+	return true, nil
+}
+
+// serveHttp serves https if certFile and keyFile are set, otherwise http
+func serveHttp(server *http.Server, listener net.Listener, certFile, keyFile string) error {
+	if "" != certFile && "" != keyFile {
+		return server.ServeTLS(listener, certFile, keyFile)
+	}
+	return server.Serve(listener)
+}
@@ -19,20 +19,36 @@
 package gateway
 
 import (
+	motango "github.com/Loopring/motan-go"
 	"github.com/Loopring/relay-cluster/accountmanager"
 	"github.com/Loopring/relay-cluster/ordermanager/manager"
 	"github.com/Loopring/relay-cluster/ordermanager/viewer"
 	"github.com/Loopring/relay-lib/log"
 	"github.com/Loopring/relay-lib/motan"
 	"math/big"
+	"sync"
 	"time"
 )
 
 type MotanService struct {
 	accountManager accountmanager.AccountManager
+
+	// requests in process, MotanServer.Stop waits for them
+	pending sync.WaitGroup
+}
+
+// MotanServer starts and stops MotanService,
+// Start and Stop can't be methods of MotanService, which are all exported by motan
+type MotanServer struct {
+	service         *MotanService
+	options         motan.MotanServerOptions
+	context         *motango.MSContext
+	shutdownTimeout time.Duration
 }
 
 func (s *MotanService) GetBalanceAndAllowance(req *motan.AccountBalanceAndAllowanceReq) *motan.AccountBalanceAndAllowanceRes {
+	s.pending.Add(1)
+	defer s.pending.Done()
 	//start := msecNow()
 
 	res := &motan.AccountBalanceAndAllowanceRes{}
@@ -62,6 +78,8 @@ func (s *MotanService) GetBalanceAndAllowance(req *motan.AccountBalanceAndAllowa
 }
 
 func (s *MotanService) GetMinerOrders(req *motan.MinerOrdersReq) *motan.MinerOrdersRes {
+	s.pending.Add(1)
+	defer s.pending.Done()
 	start := msecNow()
 
 	res := &motan.MinerOrdersRes{}
@@ -73,11 +91,47 @@ func (s *MotanService) GetMinerOrders(req *motan.MinerOrdersReq) *motan.MinerOrd
 	return res
 }
 
-func StartMotanService(options motan.MotanServerOptions, accountManager accountmanager.AccountManager, orderViewer viewer.OrderViewer) {
+func NewMotanServer(options motan.MotanServerOptions, shutdownTimeout time.Duration, accountManager accountmanager.AccountManager, orderViewer viewer.OrderViewer) *MotanServer {
 	service := &MotanService{}
 	service.accountManager = accountManager
 	options.ServerInstance = service
-	go motan.RunServer(options)
+
+	s := &MotanServer{}
+	s.service = service
+	s.options = options
+	s.shutdownTimeout = shutdownTimeout
+	return s
+}
+
+// Start is the same as motan.RunServer, but keeps the server context for Stop
+func (s *MotanServer) Start() {
+	s.context = motango.GetMotanServerContext(s.options.ConfFile)
+	if err := s.context.RegisterService(s.options.ServerInstance, ""); nil != err {
+		log.Errorf("motan service, register service error:%s", err.Error())
+	}
+	go s.context.Start(nil)
+}
+
+// Stop unregisters the services from registries so that miners stop calling this node,
+// then waits for the requests in process until shutdownTimeout.
+// motan-go can't close its listeners, they are closed when the process exits
+func (s *MotanServer) Stop() {
+	if nil == s.context {
+		return
+	}
+	s.context.ServicesUnavailable()
+
+	done := make(chan struct{})
+	go func() {
+		s.service.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("motan service, all pending requests finished")
+	case <-time.After(s.shutdownTimeout):
+		log.Errorf("motan service, pending requests are not finished in %s", s.shutdownTimeout.String())
+	}
 }
 
 func msecNow() int64 {
@@ -0,0 +1,172 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay-cluster/accountmanager"
+	omcm "github.com/Loopring/relay-cluster/ordermanager/common"
+	"github.com/Loopring/relay-lib/eventemitter"
+	"github.com/Loopring/relay-lib/log"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+const defaultMaxBatchSize = 50
+
+// batchFilter is implemented by filters which look up what they need once for orders of a batch
+type batchFilter interface {
+	FilterInBatch(o *types.Order, batch *orderBatch) (bool, error)
+}
+
+type frozenKey struct {
+	owner, token, delegate common.Address
+}
+
+// orderBatch keeps lookups of orders submitted at once, a nil batch looks up every time
+type orderBatch struct {
+	balanceMap map[common.Address]map[string]*big.Int
+	frozenMap  map[frozenKey]*big.Int
+}
+
+func newOrderBatch() *orderBatch {
+	return &orderBatch{
+		balanceMap: make(map[common.Address]map[string]*big.Int),
+		frozenMap:  make(map[frozenKey]*big.Int),
+	}
+}
+
+func (b *orderBatch) balances(owner common.Address) (map[string]*big.Int, error) {
+	if b == nil {
+		return accountmanager.GetBalanceWithSymbolResult(owner)
+	}
+	if balances, ok := b.balanceMap[owner]; ok {
+		return balances, nil
+	}
+	balances, err := accountmanager.GetBalanceWithSymbolResult(owner)
+	if err == nil {
+		b.balanceMap[owner] = balances
+	}
+	return balances, err
+}
+
+// frozenAmount returns amountS frozen by open orders of the owner, it can be changed by the caller
+func (b *orderBatch) frozenAmount(order *types.Order) (*big.Int, error) {
+	statusSet := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	if b == nil {
+		return gateway.om.GetFrozenAmount(order.Owner, order.TokenS, statusSet, order.DelegateAddress)
+	}
+	key := frozenKey{owner: order.Owner, token: order.TokenS, delegate: order.DelegateAddress}
+	if _, ok := b.frozenMap[key]; !ok {
+		amount, err := gateway.om.GetFrozenAmount(order.Owner, order.TokenS, statusSet, order.DelegateAddress)
+		if err != nil {
+			return nil, err
+		}
+		b.frozenMap[key] = amount
+	}
+	return new(big.Int).Set(b.frozenMap[key]), nil
+}
+
+// accept freezes amountS of an order which passed all filters for the orders after it
+func (b *orderBatch) accept(order *types.Order) {
+	key := frozenKey{owner: order.Owner, token: order.TokenS, delegate: order.DelegateAddress}
+	if amount, ok := b.frozenMap[key]; ok {
+		amount.Add(amount, order.AmountS)
+	}
+}
+
+// HandleInputOrders is HandleInputOrder of orders submitted at once, orders are checked in the order of submission,
+// existed orders are looked up by one query and accepted orders are emitted as one omcm.GatewayOrders,
+// it returns the hash and the error of every order, err is not nil only if none of orders can be handled
+func HandleInputOrders(orders []*types.Order) (orderHashes []string, errs []error, err error) {
+	if len(orders) == 0 {
+		return nil, nil, errors.New("orders can't be empty")
+	}
+	if len(orders) > gateway.maxBatchSize {
+		return nil, nil, fmt.Errorf("orders can't be more than %d", gateway.maxBatchSize)
+	}
+
+	orderHashes = make([]string, len(orders))
+	errs = make([]error, len(orders))
+	submitted := make(map[common.Hash]bool)
+	var hashes []common.Hash
+	for i, order := range orders {
+		order.Hash = order.GenerateHash()
+		orderHashes[i] = order.Hash.Hex()
+		if submitted[order.Hash] {
+			errs[i] = errors.New("order is duplicated in the batch")
+			continue
+		}
+		submitted[order.Hash] = true
+
+		market, err := util.WrapMarketByAddress(order.TokenB.Hex(), order.TokenS.Hex())
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		order.Market = market
+		order.Side = util.GetSide(order.TokenS.Hex(), order.TokenB.Hex())
+		hashes = append(hashes, order.Hash)
+	}
+
+	existed := make(map[common.Hash]*types.OrderState)
+	if len(hashes) > 0 {
+		states, err := gateway.om.GetOrdersByHashes(hashes)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range states {
+			existed[states[i].RawOrder.Hash] = &states[i]
+		}
+	}
+
+	batch := newOrderBatch()
+	accepted := &omcm.GatewayOrders{}
+	for i, order := range orders {
+		if errs[i] != nil {
+			continue
+		}
+		if state, ok := existed[order.Hash]; ok {
+			errs[i] = handleExistedOrder(state)
+			continue
+		}
+
+		eventemitter.Emit(eventemitter.NewOrderForBroadcast, order)
+		if errs[i] = generatePrice(order); errs[i] != nil {
+			continue
+		}
+		if errs[i] = filterOrder(order, batch); errs[i] != nil {
+			continue
+		}
+		batch.accept(order)
+
+		state := &types.OrderState{}
+		state.RawOrder = *order
+		accepted.States = append(accepted.States, state)
+	}
+
+	if len(accepted.States) > 0 {
+		eventemitter.Emit(eventemitter.NewOrder, accepted)
+	}
+	log.Infof("gateway,accepted %d of %d orders submitted at once", len(accepted.States), len(orders))
+	return orderHashes, errs, nil
+}
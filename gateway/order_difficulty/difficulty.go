@@ -19,15 +19,18 @@
 package order_difficulty
 
 import (
+	"errors"
+	omcm "github.com/Loopring/relay-cluster/ordermanager/common"
+	"github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-cluster/util/zkjob"
 	"github.com/Loopring/relay-lib/cache"
 	"github.com/Loopring/relay-lib/eventemitter"
+	"github.com/Loopring/relay-lib/log"
 	"github.com/Loopring/relay-lib/types"
-	"github.com/Loopring/relay-lib/zklock"
 	"github.com/ethereum/go-ethereum/common"
-	"gonum.org/v1/gonum/stat"
 	"math/big"
-	"qiniupkg.com/x/log.v7"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -35,79 +38,220 @@ const (
 	OrderCountPerSecond = "o_cnt_per_s_"
 	OrderDifficulty     = "order_diff"
 	ZklockDifficulty    = "zklock_diff"
+
+	Kafka_Topic_SocketIO_Order_Difficulty_Updated = "Kafka_Topic_SocketIO_Order_Difficulty_Updated"
+
+	defaultCalCount = 5
 )
 
+var maxHash = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+var ErrDifficultyNotInitialized = errors.New("order difficulty evaluator has not been initialized")
+
+type OrderDifficultyOptions struct {
+	Enabled          bool
+	CalCount         int64   // seconds of order traffic used to predict the next second, should be odd
+	TriggerThreshold float64 // orders per second, the difficulty rises when the predicted traffic exceeds it
+	MaxDifficulty    string
+}
+
+type OrderDifficultyUpdateMsg struct {
+	Difficulty string `json:"difficulty"`
+}
+
 type OrderDifficultyEvaluator struct {
-	//currentDifficult *OrderDifficulty
-	//parentDifficult  *OrderDifficulty
 	evaluator        Evaluator
 	baseDifficulty   *big.Int
-	orderTraffic     int64
+	maxDifficulty    *big.Int
 	triggerThreshold float64
+	enabled          bool
 	stopFuns         []func()
 	calCount         int64 //must be odd
+
+	current  *big.Int
+	mtx      sync.RWMutex
+	stopChan chan bool
+	job      *zkjob.Job
 }
 
-type OrderDifficulty struct {
-	difficulty *big.Int
-	ordersNum  int64
-	timeStamp  int64
+var difficultyEvaluator *OrderDifficultyEvaluator
+
+// Initialize creates the evaluator used by the pow filter,
+// baseDifficulty is gateway_filters.pow_filter.difficulty and the difficulty never goes below it
+func Initialize(options OrderDifficultyOptions, baseDifficulty *big.Int) *OrderDifficultyEvaluator {
+	evaluator := &OrderDifficultyEvaluator{}
+	evaluator.evaluator = &LinearEvaluator{}
+	evaluator.enabled = options.Enabled
+	evaluator.baseDifficulty = new(big.Int).Set(baseDifficulty)
+	evaluator.current = new(big.Int).Set(baseDifficulty)
+	evaluator.triggerThreshold = options.TriggerThreshold
+	evaluator.calCount = options.CalCount
+	if evaluator.calCount <= 0 {
+		evaluator.calCount = defaultCalCount
+	}
+	if evaluator.calCount%2 == 0 {
+		evaluator.calCount = evaluator.calCount + 1
+	}
+	if "" != options.MaxDifficulty {
+		evaluator.maxDifficulty = types.HexToBigint(options.MaxDifficulty)
+	} else {
+		evaluator.maxDifficulty = new(big.Int).Set(maxHash)
+	}
+	evaluator.stopChan = make(chan bool)
+
+	difficultyEvaluator = evaluator
+	return evaluator
+}
+
+// CurrentDifficulty returns the difficulty orders must reach now, nil if Initialize is not called
+func CurrentDifficulty() *big.Int {
+	if nil == difficultyEvaluator {
+		return nil
+	}
+	return difficultyEvaluator.Current()
+}
+
+func (evaluator *OrderDifficultyEvaluator) Current() *big.Int {
+	evaluator.mtx.RLock()
+	defer evaluator.mtx.RUnlock()
+	return new(big.Int).Set(evaluator.current)
+}
+
+func (evaluator *OrderDifficultyEvaluator) setCurrent(difficulty *big.Int) bool {
+	evaluator.mtx.Lock()
+	defer evaluator.mtx.Unlock()
+	if evaluator.current.Cmp(difficulty) == 0 {
+		return false
+	}
+	evaluator.current = difficulty
+	return true
 }
 
 func (evaluator *OrderDifficultyEvaluator) getCacheKey(createTime int64) (key string, expireAt int64) {
-	mod := createTime % evaluator.calCount
-	orderSection := createTime - mod
-	orderSectionStr := strconv.FormatInt(orderSection, 10)
-	return OrderCountPerSecond + orderSectionStr, createTime + evaluator.calCount
+	return OrderCountPerSecond + strconv.FormatInt(createTime, 10), createTime + evaluator.calCount + 1
 }
 
 func (evaluator *OrderDifficultyEvaluator) Start() {
+	if !evaluator.enabled {
+		log.Infof("order difficulty evaluator is disabled, use base difficulty:%s", common.BytesToHash(evaluator.baseDifficulty.Bytes()).Hex())
+		return
+	}
+
 	evaluator.HandleNewOrder()
+
+	// the job is created before the reload goroutine reads it
+	job := zkjob.New(ZklockDifficulty, 1*time.Second, evaluator.calculator())
+	evaluator.job = job
+
+	// every node reloads the difficulty calculated by the node which holds the zklock
 	go func() {
-		if err := zklock.TryLock(ZklockDifficulty); nil != err {
-			log.Errorf("erro:%s", err.Error())
-		} else {
-			now := time.Now().Unix()
-			orderCntList := []int64{}
-			for i := evaluator.calCount; i > 0; i-- {
-				t := now - i
-				cacheKey, _ := evaluator.getCacheKey(t)
-				if data, err := cache.Get(cacheKey); nil == err {
-					cnt, _ := strconv.ParseInt(string(data), 10, 0)
-					orderCntList = append(orderCntList, cnt)
+		for {
+			select {
+			case <-evaluator.stopChan:
+				return
+			case <-time.After(1 * time.Second):
+				if job.LockHolded() {
+					continue
 				}
-			}
-			for {
-				select {
-				case <-time.After(2 * time.Second):
-					cacheKey, _ := evaluator.getCacheKey(time.Now().Unix() - 1)
-					if data, err := cache.Get(cacheKey); nil == err {
-						cnt, _ := strconv.ParseInt(string(data), 10, 0)
-						orderCntList = append(orderCntList, cnt)
-					}
-					diff := evaluator.evaluator.CalcAndSaveDifficulty(orderCntList)
-					diffHash := common.BytesToHash(diff.Bytes())
-					cache.Set(OrderDifficulty, []byte(diffHash.Hex()), int64(0))
-					orderCntList = orderCntList[1:]
+				if diffHash, err := GetDifficulty(); nil == err {
+					evaluator.setCurrent(diffHash.Big())
 				}
 			}
 		}
 	}()
+
+	job.Start()
+}
+
+// calculator returns the task of the lock holder, which saves the difficulty for the other nodes
+// and pushes it whenever it differs from the last pushed one
+func (evaluator *OrderDifficultyEvaluator) calculator() func() {
+	var (
+		orderCntList []int64
+		pushed       *big.Int
+	)
+	return func() {
+		if nil == orderCntList {
+			now := time.Now().Unix()
+			for i := evaluator.calCount; i > 0; i-- {
+				orderCntList = append(orderCntList, evaluator.orderCount(now-i))
+			}
+			if diffHash, err := GetDifficulty(); nil == err {
+				pushed = diffHash.Big()
+			}
+		}
+
+		orderCntList = append(orderCntList[1:], evaluator.orderCount(time.Now().Unix()-1))
+		diff := evaluator.calcDifficulty(evaluator.evaluator.PredictTraffic(orderCntList))
+		diffHash := common.BytesToHash(diff.Bytes())
+		if err := cache.Set(OrderDifficulty, []byte(diffHash.Hex()), int64(0)); nil != err {
+			log.Errorf("order difficulty evaluator, save difficulty err:%s", err.Error())
+			return
+		}
+		evaluator.setCurrent(diff)
+		if nil == pushed || pushed.Cmp(diff) != 0 {
+			log.Debugf("order difficulty changed to:%s", diffHash.Hex())
+			util.ProducerSocketIOMessage(Kafka_Topic_SocketIO_Order_Difficulty_Updated, &OrderDifficultyUpdateMsg{Difficulty: diffHash.Hex()})
+			pushed = diff
+		}
+	}
 }
 
 func (evaluator *OrderDifficultyEvaluator) Stop() {
+	if !evaluator.enabled {
+		return
+	}
 	for _, f := range evaluator.stopFuns {
 		f()
 	}
+	close(evaluator.stopChan)
+	if evaluator.job != nil {
+		evaluator.job.Stop()
+	}
+}
+
+func (evaluator *OrderDifficultyEvaluator) orderCount(t int64) int64 {
+	cacheKey, _ := evaluator.getCacheKey(t)
+	if data, err := cache.Get(cacheKey); nil == err {
+		cnt, _ := strconv.ParseInt(string(data), 10, 0)
+		return cnt
+	}
+	return 0
+}
+
+// the difficulty space above base is shrunk by triggerThreshold/traffic,
+// so the work of a valid pow grows linearly with the traffic
+func (evaluator *OrderDifficultyEvaluator) calcDifficulty(traffic float64) *big.Int {
+	if evaluator.triggerThreshold <= 0 || traffic <= evaluator.triggerThreshold {
+		return new(big.Int).Set(evaluator.baseDifficulty)
+	}
+
+	space := new(big.Int).Sub(maxHash, evaluator.baseDifficulty)
+	ratio := new(big.Rat).SetFloat64(evaluator.triggerThreshold / traffic)
+	space.Mul(space, ratio.Num())
+	space.Quo(space, ratio.Denom())
+
+	difficulty := new(big.Int).Sub(maxHash, space)
+	if difficulty.Cmp(evaluator.maxDifficulty) > 0 {
+		difficulty.Set(evaluator.maxDifficulty)
+	}
+	return difficulty
 }
 
-//add ordersNum
+// add ordersNum
 func (evaluator *OrderDifficultyEvaluator) HandleNewOrder() {
 	watcher := &eventemitter.Watcher{
 		Concurrent: false, Handle: func(input eventemitter.EventData) error {
-			state := input.(*types.OrderState)
-			cacheKey, expireAt := evaluator.getCacheKey(state.RawOrder.CreateTime)
-			_, err := cache.Incr(cacheKey)
+			// orders submitted in a batch are counted one by one
+			count := 1
+			if orders, ok := input.(*omcm.GatewayOrders); ok {
+				count = len(orders.States)
+			}
+			cacheKey, expireAt := evaluator.getCacheKey(time.Now().Unix())
+			var err error
+			for i := 0; i < count && nil == err; i++ {
+				_, err = cache.Incr(cacheKey)
+			}
 			if nil == err {
 				err = cache.ExpireAt(cacheKey, expireAt)
 			}
@@ -122,23 +266,40 @@ func (evaluator *OrderDifficultyEvaluator) HandleNewOrder() {
 }
 
 type Evaluator interface {
-	CalcAndSaveDifficulty(orderCntList []int64) *big.Int
+	PredictTraffic(orderCntList []int64) float64
 }
 
 type LinearEvaluator struct {
 }
 
-//控制订单的提交速度，随着订单的流量增大而增大
-func (evaluator *LinearEvaluator) CalcAndSaveDifficulty(orderCntList []int64) *big.Int {
-	xes := []float64{}
-	yes := []float64{}
-	now := time.Now().Unix()
+// 控制订单的提交速度，随着订单的流量增大而增大
+func (evaluator *LinearEvaluator) PredictTraffic(orderCntList []int64) float64 {
+	n := float64(len(orderCntList))
+	if n == 0 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX float64
 	for idx, cnt := range orderCntList {
-		xes = append(xes, float64(idx))
-		yes = append(yes, float64(cnt))
+		x := float64(idx)
+		y := float64(cnt)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	var alpha, beta float64
+	if denominator := n*sumXX - sumX*sumX; denominator != 0 {
+		beta = (n*sumXY - sumX*sumY) / denominator
+	}
+	alpha = (sumY - beta*sumX) / n
+
+	traffic := beta*n + alpha
+	if traffic < 0 {
+		return 0
 	}
-	alpha, beta := stat.LinearRegression(xes, yes, nil, false)
-	return int64(beta*float64(now) + alpha)
+	return traffic
 }
 
 func GetDifficulty() (common.Hash, error) {
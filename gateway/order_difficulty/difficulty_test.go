@@ -0,0 +1,58 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package order_difficulty
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestLinearEvaluator_PredictTraffic(t *testing.T) {
+	e := &LinearEvaluator{}
+	if traffic := e.PredictTraffic([]int64{1, 2, 3, 4, 5}); traffic < 5.99 || traffic > 6.01 {
+		t.Fatalf("predict traffic of increasing orders, expect 6 got %f", traffic)
+	}
+	if traffic := e.PredictTraffic([]int64{10, 10, 10}); traffic < 9.99 || traffic > 10.01 {
+		t.Fatalf("predict traffic of constant orders, expect 10 got %f", traffic)
+	}
+	if traffic := e.PredictTraffic([]int64{9, 3, 0}); traffic != 0 {
+		t.Fatalf("predict traffic should not be negative, got %f", traffic)
+	}
+}
+
+func TestOrderDifficultyEvaluator_CalcDifficulty(t *testing.T) {
+	base := new(big.Int).Lsh(big.NewInt(1), 255)
+	evaluator := Initialize(OrderDifficultyOptions{Enabled: true, TriggerThreshold: 10}, base)
+
+	if d := evaluator.calcDifficulty(5); d.Cmp(base) != 0 {
+		t.Fatalf("difficulty under threshold should be base, got %s", d.String())
+	}
+
+	// double traffic halves the space above the difficulty
+	d := evaluator.calcDifficulty(20)
+	space := new(big.Int).Sub(maxHash, d)
+	expect := new(big.Int).Rsh(new(big.Int).Sub(maxHash, base), 1)
+	if space.Cmp(expect) != 0 {
+		t.Fatalf("difficulty space expect %s got %s", expect.String(), space.String())
+	}
+
+	if CurrentDifficulty().Cmp(base) != 0 {
+		t.Fatalf("current difficulty should be base before start")
+	}
+}
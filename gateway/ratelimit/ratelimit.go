@@ -0,0 +1,295 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ratelimit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Loopring/relay-cluster/metrics"
+	"github.com/Loopring/relay-lib/log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	DefaultApiKeyHeader = "X-Api-Key"
+
+	ErrCodeUnauthorized  = -32001
+	ErrCodeLimitExceeded = -32005
+
+	keyPrefix = "rate_limit_"
+)
+
+// MethodLimit limits calls of a json-rpc method,
+// requests with a valid api key use the key bucket, others use the bucket of client ip
+type MethodLimit struct {
+	IpRate         float64
+	IpBurst        int64
+	KeyRate        float64
+	KeyBurst       int64
+	ApiKeyRequired bool
+}
+
+// RateLimitOptions is jsonrpc.rate_limit in relay.toml,
+// Methods is keyed by the full method name, e.g. loopring_submitOrder, others use Default
+type RateLimitOptions struct {
+	Enabled           bool
+	ApiKeys           []string
+	ApiKeyHeader      string
+	TrustForwardedFor bool
+	Default           MethodLimit
+	Methods           map[string]MethodLimit
+}
+
+var rateLimited = metrics.NewCounterVec("relay_rpc_rate_limited_total",
+	"Json-rpc calls rejected by rate limit or api key authentication.", "method")
+
+type RateLimiter struct {
+	options  RateLimitOptions
+	apiKeys  map[string]bool
+	store    Store
+	fallback *LocalStore
+}
+
+func NewRateLimiter(options RateLimitOptions, store Store) *RateLimiter {
+	l := &RateLimiter{}
+	l.options = options
+	if "" == l.options.ApiKeyHeader {
+		l.options.ApiKeyHeader = DefaultApiKeyHeader
+	}
+	l.apiKeys = make(map[string]bool)
+	for _, key := range options.ApiKeys {
+		l.apiKeys[key] = true
+	}
+	l.store = store
+	l.fallback = NewLocalStore()
+	return l
+}
+
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RpcError) Error() string {
+	return e.Message
+}
+
+type rpcRequest struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+}
+
+type rpcErrorResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Error   *RpcError       `json:"error"`
+}
+
+func (l *RateLimiter) limitOf(method string) MethodLimit {
+	if limit, ok := l.options.Methods[method]; ok {
+		return limit
+	}
+	return l.options.Default
+}
+
+// hashApiKey keeps api keys out of redis and logs, the first 16 bytes of sha256 tell them apart
+func hashApiKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Check returns nil if the call of method is allowed, apiKey must be empty or valid
+func (l *RateLimiter) Check(method, ip, apiKey string) *RpcError {
+	limit := l.limitOf(method)
+	if "" == apiKey && limit.ApiKeyRequired {
+		return &RpcError{Code: ErrCodeUnauthorized, Message: "api key is required by " + method}
+	}
+
+	var key string
+	var bucket Bucket
+	if "" != apiKey {
+		key = keyPrefix + "key_" + hashApiKey(apiKey) + "_" + method
+		bucket = Bucket{Rate: limit.KeyRate, Burst: limit.KeyBurst}
+	} else {
+		key = keyPrefix + "ip_" + ip + "_" + method
+		bucket = Bucket{Rate: limit.IpRate, Burst: limit.IpBurst}
+	}
+	if !bucket.Valid() {
+		return nil
+	}
+
+	allowed, err := l.store.Take(key, bucket)
+	if nil != err {
+		log.Errorf("ratelimit, take token of %s error:%s, use local bucket", key, err.Error())
+		allowed, _ = l.fallback.Take(key, bucket)
+	}
+	if !allowed {
+		return &RpcError{Code: ErrCodeLimitExceeded, Message: "rate limit exceeded for " + method}
+	}
+	return nil
+}
+
+// Handler checks api key and limits of every call in the request before next,
+// rejected requests get json-rpc error responses with http status 200 like other rpc errors.
+// a batch is rejected as a whole if any call in it is rejected
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.options.Enabled || r.Method != http.MethodPost || nil == r.Body {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if nil != err {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		reqs, batch, err := parseRequests(body)
+		if nil != err {
+			// let rpc server respond the parse error
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		apiKey := r.Header.Get(l.options.ApiKeyHeader)
+		var authErr *RpcError
+		if "" != apiKey && !l.apiKeys[apiKey] {
+			authErr = &RpcError{Code: ErrCodeUnauthorized, Message: "invalid api key"}
+		}
+		ip := l.clientIp(r.RemoteAddr, r.Header)
+
+		rejected := false
+		errs := make([]*RpcError, len(reqs))
+		for i, req := range reqs {
+			if nil != authErr {
+				errs[i] = authErr
+			} else {
+				errs[i] = l.Check(req.Method, ip, apiKey)
+			}
+			if nil != errs[i] {
+				rejected = true
+				rateLimited.Inc(l.metricLabel(req.Method))
+			}
+		}
+		if !rejected {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		resps := make([]rpcErrorResponse, len(reqs))
+		for i, req := range reqs {
+			resps[i] = rpcErrorResponse{Version: "2.0", Id: req.Id, Error: errs[i]}
+			if nil == errs[i] {
+				resps[i].Error = &RpcError{Code: ErrCodeLimitExceeded, Message: "batch rejected by rate limit"}
+			}
+			if len(resps[i].Id) == 0 {
+				resps[i].Id = json.RawMessage("null")
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if batch {
+			json.NewEncoder(w).Encode(resps)
+		} else {
+			json.NewEncoder(w).Encode(resps[0])
+		}
+	})
+}
+
+// RestHandler limits the requests of a rest endpoint like the calls of method, e.g. rest_tickers,
+// rejected requests get status 401 or 429 with the error as json body
+func (l *RateLimiter) RestHandler(method string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rpcErr := l.CheckHeader(method, r.RemoteAddr, r.Header)
+		if nil == rpcErr {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status := http.StatusTooManyRequests
+		if rpcErr.Code == ErrCodeUnauthorized {
+			status = http.StatusUnauthorized
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(rpcErr)
+	})
+}
+
+// CheckHeader checks api key and limits of a call of method from remoteAddr with header,
+// it's used by the transports which aren't http handlers, e.g. the messages of a websocket connection
+func (l *RateLimiter) CheckHeader(method, remoteAddr string, header http.Header) *RpcError {
+	if !l.options.Enabled {
+		return nil
+	}
+	apiKey := header.Get(l.options.ApiKeyHeader)
+	var rpcErr *RpcError
+	if "" != apiKey && !l.apiKeys[apiKey] {
+		rpcErr = &RpcError{Code: ErrCodeUnauthorized, Message: "invalid api key"}
+	} else {
+		rpcErr = l.Check(method, l.clientIp(remoteAddr, header), apiKey)
+	}
+	if nil != rpcErr {
+		rateLimited.Inc(l.metricLabel(method))
+	}
+	return rpcErr
+}
+
+// method names come from clients, only configured ones are used as label
+func (l *RateLimiter) metricLabel(method string) string {
+	if _, ok := l.options.Methods[method]; ok {
+		return method
+	}
+	return "default"
+}
+
+func (l *RateLimiter) clientIp(remoteAddr string, header http.Header) string {
+	if l.options.TrustForwardedFor {
+		if forwarded := header.Get("X-Forwarded-For"); "" != forwarded {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); nil == err {
+		return host
+	}
+	return remoteAddr
+}
+
+func parseRequests(body []byte) ([]rpcRequest, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var reqs []rpcRequest
+		if err := json.Unmarshal(body, &reqs); nil != err {
+			return nil, true, err
+		}
+		return reqs, true, nil
+	}
+
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); nil != err {
+		return nil, false, err
+	}
+	return []rpcRequest{req}, false, nil
+}
@@ -0,0 +1,199 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore_Take(t *testing.T) {
+	now := time.Unix(1530000000, 0)
+	store := NewLocalStore()
+	store.now = func() time.Time { return now }
+	bucket := Bucket{Rate: 2, Burst: 3}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := store.Take("k", bucket); !allowed {
+			t.Fatalf("take %d should be allowed in burst", i)
+		}
+	}
+	if allowed, _ := store.Take("k", bucket); allowed {
+		t.Fatalf("take should be rejected after burst")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ := store.Take("k", bucket); !allowed {
+		t.Fatalf("take should be allowed after refill")
+	}
+	if allowed, _ := store.Take("k", bucket); allowed {
+		t.Fatalf("only one token is refilled in 500ms")
+	}
+}
+
+func newTestLimiter() *RateLimiter {
+	return NewRateLimiter(RateLimitOptions{
+		Enabled: true,
+		ApiKeys: []string{"key1"},
+		Default: MethodLimit{IpRate: 100, IpBurst: 100, KeyRate: 100, KeyBurst: 100},
+		Methods: map[string]MethodLimit{
+			"loopring_submitOrder": {IpRate: 0.001, IpBurst: 1, KeyRate: 0.001, KeyBurst: 2},
+			"loopring_flexCancel":  {IpRate: 1, IpBurst: 1, KeyRate: 1, KeyBurst: 1, ApiKeyRequired: true},
+		},
+	}, NewLocalStore())
+}
+
+func call(handler http.Handler, body, apiKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:5000"
+	if "" != apiKey {
+		req.Header.Set(DefaultApiKeyHeader, apiKey)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimiter_HashesApiKey(t *testing.T) {
+	store := NewLocalStore()
+	limiter := NewRateLimiter(RateLimitOptions{
+		Enabled: true,
+		ApiKeys: []string{"key1"},
+		Default: MethodLimit{KeyRate: 1, KeyBurst: 1},
+	}, store)
+	if err := limiter.Check("loopring_getBalance", "127.0.0.1", "key1"); err != nil {
+		t.Fatalf("first call is rejected: %s", err.Message)
+	}
+	want := keyPrefix + "key_" + hashApiKey("key1") + "_loopring_getBalance"
+	if _, ok := store.buckets[want]; !ok || len(store.buckets) != 1 {
+		t.Fatalf("bucket %s isn't the only one taken", want)
+	}
+	for key := range store.buckets {
+		if strings.Contains(key, "key1") {
+			t.Fatalf("api key in bucket key %s", key)
+		}
+	}
+}
+
+func TestRateLimiter_Handler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("passed"))
+	})
+	handler := newTestLimiter().Handler(next)
+	submit := `{"jsonrpc":"2.0","id":1,"method":"loopring_submitOrder","params":[]}`
+
+	if w := call(handler, submit, ""); w.Body.String() != "passed" {
+		t.Fatalf("first call should pass, got %s", w.Body.String())
+	}
+
+	w := call(handler, submit, "")
+	var resp rpcErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); nil != err || nil == resp.Error {
+		t.Fatalf("second call should get a json-rpc error, got %s", w.Body.String())
+	}
+	if w.Code != http.StatusOK || resp.Error.Code != ErrCodeLimitExceeded || string(resp.Id) != "1" {
+		t.Fatalf("unexpected response of limited call:%d %s", w.Code, w.Body.String())
+	}
+
+	// api key uses its own bucket
+	if w := call(handler, submit, "key1"); w.Body.String() != "passed" {
+		t.Fatalf("call with api key should pass, got %s", w.Body.String())
+	}
+
+	w = call(handler, submit, "wrong")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); nil != err || nil == resp.Error || resp.Error.Code != ErrCodeUnauthorized {
+		t.Fatalf("call with invalid api key should be unauthorized, got %s", w.Body.String())
+	}
+
+	w = call(handler, `[{"jsonrpc":"2.0","id":1,"method":"loopring_getDepth"},{"jsonrpc":"2.0","id":2,"method":"loopring_flexCancel"}]`, "")
+	var resps []rpcErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resps); nil != err || len(resps) != 2 {
+		t.Fatalf("batch should get an error for every call, got %s", w.Body.String())
+	}
+	if resps[1].Error.Code != ErrCodeUnauthorized || resps[0].Error.Code != ErrCodeLimitExceeded {
+		t.Fatalf("unexpected errors of batch:%s", w.Body.String())
+	}
+}
+
+func TestRateLimiter_RestHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("passed"))
+	})
+	l := newTestLimiter()
+	l.options.Methods["rest_trades"] = MethodLimit{IpRate: 0.001, IpBurst: 1, KeyRate: 0.001, KeyBurst: 1}
+	handler := l.RestHandler("rest_trades", next)
+
+	get := func(apiKey string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/trades/LRC-WETH", nil)
+		req.RemoteAddr = "10.0.0.1:5000"
+		if "" != apiKey {
+			req.Header.Set(DefaultApiKeyHeader, apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get(""); w.Body.String() != "passed" {
+		t.Fatalf("first request should pass, got %s", w.Body.String())
+	}
+	w := get("")
+	var rpcErr RpcError
+	if err := json.Unmarshal(w.Body.Bytes(), &rpcErr); nil != err || w.Code != http.StatusTooManyRequests || rpcErr.Code != ErrCodeLimitExceeded {
+		t.Fatalf("second request should be limited, got %d %s", w.Code, w.Body.String())
+	}
+	if w := get("key1"); w.Body.String() != "passed" {
+		t.Fatalf("request with api key should pass, got %s", w.Body.String())
+	}
+	if w := get("wrong"); w.Code != http.StatusUnauthorized {
+		t.Fatalf("request with invalid api key should be unauthorized, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter_CheckHeader(t *testing.T) {
+	l := newTestLimiter()
+	header := http.Header{}
+
+	if err := l.CheckHeader("loopring_submitOrder", "10.0.0.2:6000", header); nil != err {
+		t.Fatalf("first call should pass, got %s", err.Error())
+	}
+	if err := l.CheckHeader("loopring_submitOrder", "10.0.0.2:6001", header); nil == err || err.Code != ErrCodeLimitExceeded {
+		t.Fatalf("second call from the same ip should be limited, got %v", err)
+	}
+
+	// the bucket is shared with http requests from the same ip
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("passed"))
+	})
+	if w := call(l.Handler(next), `{"jsonrpc":"2.0","id":1,"method":"loopring_submitOrder"}`, ""); w.Body.String() != "passed" {
+		t.Fatalf("call from another ip should pass, got %s", w.Body.String())
+	}
+	if err := l.CheckHeader("loopring_submitOrder", "10.0.0.1:7000", header); nil == err {
+		t.Fatalf("call should share the bucket of http requests from the same ip")
+	}
+
+	header.Set(DefaultApiKeyHeader, "wrong")
+	if err := l.CheckHeader("loopring_getDepth", "10.0.0.2:6000", header); nil == err || err.Code != ErrCodeUnauthorized {
+		t.Fatalf("call with invalid api key should be unauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ratelimit
+
+import (
+	"fmt"
+	relayredis "github.com/Loopring/relay-lib/cache/redis"
+	"github.com/garyburd/redigo/redis"
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket which holds at most Burst tokens and is refilled with Rate tokens per second
+type Bucket struct {
+	Rate  float64
+	Burst int64
+}
+
+func (b Bucket) Valid() bool {
+	return b.Rate > 0 && b.Burst > 0
+}
+
+// Store takes a token from the bucket of key
+type Store interface {
+	Take(key string, bucket Bucket) (bool, error)
+}
+
+// the bucket is saved in a redis hash, the script makes refill and take atomic across relay nodes
+var tokenBucketScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return allowed
+`)
+
+// RedisStore shares buckets between relay nodes.
+// relay-lib cache can't run scripts, so it keeps its own pool of the same redis
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+func NewRedisStore(options relayredis.RedisOptions) *RedisStore {
+	s := &RedisStore{}
+	s.pool = &redis.Pool{
+		IdleTimeout: time.Duration(options.IdleTimeout) * time.Second,
+		MaxIdle:     options.MaxIdle,
+		MaxActive:   options.MaxActive,
+		Wait:        true,
+		Dial: func() (redis.Conn, error) {
+			address := fmt.Sprintf("%s:%s", options.Host, options.Port)
+			if len(options.Password) > 0 {
+				return redis.Dial("tcp", address, redis.DialPassword(options.Password))
+			}
+			return redis.Dial("tcp", address)
+		},
+	}
+	return s
+}
+
+func (s *RedisStore) Take(key string, bucket Bucket) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	nowMs := time.Now().UnixNano() / int64(time.Millisecond)
+	allowed, err := redis.Int(tokenBucketScript.Do(conn, key, bucket.Rate, bucket.Burst, nowMs))
+	if nil != err {
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+func (s *RedisStore) Close() error {
+	return s.pool.Close()
+}
+
+type localBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// LocalStore keeps buckets in memory of this node,
+// RateLimiter falls back to it when redis is unavailable
+type LocalStore struct {
+	buckets   map[string]*localBucket
+	mtx       sync.Mutex
+	now       func() time.Time
+	lastClean time.Time
+}
+
+const localBucketIdle = 10 * time.Minute
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{buckets: make(map[string]*localBucket), now: time.Now, lastClean: time.Now()}
+}
+
+func (s *LocalStore) Take(key string, bucket Bucket) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.now()
+	if now.Sub(s.lastClean) > time.Minute {
+		for k, b := range s.buckets {
+			if now.Sub(b.last) > localBucketIdle {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastClean = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(bucket.Burst), last: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(bucket.Burst), b.tokens+elapsed*bucket.Rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, nil
+	}
+	b.tokens = b.tokens - 1
+	return true, nil
+}
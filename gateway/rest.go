@@ -0,0 +1,225 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Loopring/relay-cluster/gateway/ratelimit"
+	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-lib/eth/loopringaccessor"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"github.com/ethereum/go-ethereum/common"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the rest endpoints serve the market data of WalletServiceImpl by GET, so they can be cached by CDNs and aggregators.
+// every response has an ETag of its body and a Cache-Control of its route,
+// each route is rate limited like a json-rpc method named by it, e.g. rest_trades
+
+const restPathPrefix = "/api/v1/"
+
+type restError struct {
+	status  int
+	Message string `json:"message"`
+}
+
+func (e *restError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) *restError {
+	return &restError{status: http.StatusBadRequest, Message: fmt.Sprintf(format, args...)}
+}
+
+type restRoute struct {
+	path   string // the path after restPathPrefix, routes ending with / take the last segment as param
+	name   string
+	maxAge int // seconds of Cache-Control
+	handle func(r *http.Request, param string) (interface{}, error)
+}
+
+func registerRestHandlers(mux *http.ServeMux, w *WalletServiceImpl, rateLimiter *ratelimit.RateLimiter) {
+	routes := []restRoute{
+		{"tickers", "rest_tickers", 10, w.restTickers},
+		{"trades/", "rest_trades", 5, w.restTrades},
+		{"candles/", "rest_candles", 60, w.restCandles},
+		{"orderbook/", "rest_orderbook", 2, w.restOrderBook},
+	}
+	for _, route := range routes {
+		mux.Handle(restPathPrefix+route.path, rateLimiter.RestHandler(route.name, restHandler(route)))
+	}
+}
+
+func restHandler(route restRoute) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeRestError(w, &restError{status: http.StatusMethodNotAllowed, Message: "method not allowed"})
+			return
+		}
+
+		param := strings.TrimPrefix(r.URL.Path, restPathPrefix+route.path)
+		if strings.HasSuffix(route.path, "/") && (len(param) == 0 || strings.Contains(param, "/")) {
+			writeRestError(w, &restError{status: http.StatusNotFound, Message: "not found"})
+			return
+		}
+
+		data, err := route.handle(r, param)
+		if err != nil {
+			writeRestError(w, err)
+			return
+		}
+		body, err := json.Marshal(data)
+		if err != nil {
+			writeRestError(w, err)
+			return
+		}
+
+		sum := sha1.Sum(body)
+		etag := `"` + hex.EncodeToString(sum[:]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(route.maxAge))
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+}
+
+// etagMatch returns true if the If-None-Match header has etag, weak ones are compared by their opaque tags
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, v := range strings.Split(ifNoneMatch, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeRestError(w http.ResponseWriter, err error) {
+	e, ok := err.(*restError)
+	if !ok {
+		e = &restError{status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func restMarket(param string) (string, error) {
+	mkt := strings.ToUpper(param)
+	if !util.IsSupportedMarket(mkt) {
+		return "", &restError{status: http.StatusNotFound, Message: "unsupported market " + param}
+	}
+	return mkt, nil
+}
+
+// restDelegateAddress returns the delegateAddress of query, or the first supported one if it's absent
+func restDelegateAddress(r *http.Request) (string, error) {
+	if delegate := r.URL.Query().Get("delegateAddress"); len(delegate) > 0 {
+		if !common.IsHexAddress(delegate) || !loopringaccessor.SupportedDelegateAddress(common.HexToAddress(delegate)) {
+			return "", badRequest("unsupported delegateAddress %s", delegate)
+		}
+		return common.HexToAddress(delegate).Hex(), nil
+	}
+
+	var delegates []string
+	for delegate := range loopringaccessor.DelegateAddresses() {
+		delegates = append(delegates, delegate.Hex())
+	}
+	if len(delegates) == 0 {
+		return "", &restError{status: http.StatusServiceUnavailable, Message: "no delegate address is supported"}
+	}
+	sort.Strings(delegates)
+	return delegates[0], nil
+}
+
+func (w *WalletServiceImpl) restTickers(r *http.Request, param string) (interface{}, error) {
+	tickers, err := w.GetTicker()
+	if err != nil {
+		return nil, err
+	}
+	// tickers come from a map, they are sorted so the etag is stable
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Market < tickers[j].Market })
+	return tickers, nil
+}
+
+func (w *WalletServiceImpl) restTrades(r *http.Request, param string) (interface{}, error) {
+	mkt, err := restMarket(param)
+	if err != nil {
+		return nil, err
+	}
+	query := FillQuery{Market: mkt, DelegateAddress: r.URL.Query().Get("delegateAddress")}
+	return w.GetLatestFills(query)
+}
+
+func (w *WalletServiceImpl) restCandles(r *http.Request, param string) (interface{}, error) {
+	mkt, err := restMarket(param)
+	if err != nil {
+		return nil, err
+	}
+	interval := r.URL.Query().Get("interval")
+	if len(interval) == 0 {
+		interval = market.OneHour
+	}
+	if !market.IsSupportedInterval(interval) {
+		return nil, badRequest("unsupported interval %s", interval)
+	}
+	trends, err := w.GetTrend(TrendQuery{Market: mkt, Interval: interval})
+	if err != nil {
+		return nil, err
+	}
+	if trends == nil {
+		trends = make([]market.Trend, 0)
+	}
+	return trends, nil
+}
+
+func (w *WalletServiceImpl) restOrderBook(r *http.Request, param string) (interface{}, error) {
+	mkt, err := restMarket(param)
+	if err != nil {
+		return nil, err
+	}
+	delegate, err := restDelegateAddress(r)
+	if err != nil {
+		return nil, err
+	}
+	query := DepthQuery{DelegateAddress: delegate, Market: mkt}
+	if precision := r.URL.Query().Get("precision"); len(precision) > 0 {
+		p, err := strconv.Atoi(precision)
+		if err != nil {
+			return nil, badRequest("precision must be an integer")
+		}
+		query.Precision = &p
+		if _, err = w.depthPrecisions.precision(mkt, query.Precision); err != nil {
+			return nil, badRequest("%s", err.Error())
+		}
+	}
+	return w.GetDepth(query)
+}
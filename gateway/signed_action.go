@@ -0,0 +1,152 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/Loopring/relay-cluster/gateway/actionsign"
+	"github.com/Loopring/relay-cluster/gateway/typeddata"
+	"github.com/Loopring/relay-lib/cache"
+	"github.com/Loopring/relay-lib/crypto"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	SignNoncePreKey  = "SIGN_NONCE_"
+	signValidSeconds = 60 * 10
+)
+
+// SignOptions is the EIP712Domain of signed rpcs, a SignInfo with a nonce is the typed data signature of its action.
+// AllowLegacy also accepts the signature of the bare timestamp, keep it until clients sign typed data
+type SignOptions struct {
+	ChainId           int64
+	VerifyingContract string
+	AllowLegacy       bool
+}
+
+var (
+	actionVerifier = &actionsign.Verifier{
+		Domain:       typeddata.Domain{Name: "Loopring Relay", Version: "1", ChainId: big.NewInt(1)},
+		Nonces:       redisNonceStore{},
+		ValidSeconds: signValidSeconds,
+	}
+	allowLegacySign bool
+)
+
+var (
+	flexCancelOrderAction = actionsign.NewAction("FlexCancelOrder",
+		typeddata.Field{Name: "type", Type: "uint8"},
+		typeddata.Field{Name: "orderHash", Type: "bytes32"},
+		typeddata.Field{Name: "tokenS", Type: "address"},
+		typeddata.Field{Name: "tokenB", Type: "address"},
+		typeddata.Field{Name: "cutoff", Type: "uint256"})
+	flexCancelOrdersAction = actionsign.NewAction("FlexCancelOrders",
+		typeddata.Field{Name: "orderHashes", Type: "bytes32[]"})
+	notifyScanLoginAction = actionsign.NewAction("NotifyScanLogin",
+		typeddata.Field{Name: "uuid", Type: "string"})
+	applyTicketAction = actionsign.NewAction("ApplyTicket",
+		typeddata.Field{Name: "name", Type: "string"},
+		typeddata.Field{Name: "email", Type: "string"},
+		typeddata.Field{Name: "phone", Type: "string"})
+	queryTicketAction            = actionsign.NewAction("QueryTicket")
+	submitConditionalOrderAction = actionsign.NewAction("SubmitConditionalOrder",
+		typeddata.Field{Name: "orderHash", Type: "bytes32"},
+		typeddata.Field{Name: "triggerType", Type: "string"},
+		typeddata.Field{Name: "triggerPrice", Type: "string"},
+		typeddata.Field{Name: "priceSource", Type: "string"})
+	cancelConditionalOrderAction = actionsign.NewAction("CancelConditionalOrder",
+		typeddata.Field{Name: "orderHash", Type: "bytes32"})
+)
+
+func InitializeSign(options SignOptions) {
+	if options.ChainId > 0 {
+		actionVerifier.Domain.ChainId = big.NewInt(options.ChainId)
+	}
+	if len(options.VerifyingContract) > 0 {
+		if !common.IsHexAddress(options.VerifyingContract) {
+			log.Fatalf("sign.verifying_contract %s isn't an address", options.VerifyingContract)
+		}
+		actionVerifier.Domain.VerifyingContract = common.HexToAddress(options.VerifyingContract).Hex()
+	}
+	allowLegacySign = options.AllowLegacy
+}
+
+// verifyActionSign reports whether sign is the typed data signature of action with params by sign.Owner,
+// the nonce is claimed in redis so that a signature is accepted only once
+func verifyActionSign(action typeddata.Type, params map[string]interface{}, sign SignInfo) (bool, error) {
+	ts, err := verifySignTimestamp(sign)
+	if nil != err {
+		return false, err
+	}
+	if len(sign.Nonce) == 0 {
+		if !allowLegacySign {
+			return false, errors.New("nonce is required, sign the typed data of " + action.Name)
+		}
+		return verifySignedMessage(sign.Timestamp, sign)
+	}
+	return actionVerifier.Verify(action, params, actionsign.Signature{
+		Owner:     sign.Owner,
+		Timestamp: ts,
+		Nonce:     sign.Nonce,
+		V:         sign.V,
+		R:         sign.R,
+		S:         sign.S,
+	})
+}
+
+type redisNonceStore struct{}
+
+// Claim records the nonce until the signature expires, a nonce claimed before is a replay
+func (redisNonceStore) Claim(owner common.Address, nonce common.Hash, expireAt int64) (bool, error) {
+	key := SignNoncePreKey + strings.ToLower(owner.Hex()) + "_" + nonce.Hex()
+	count, err := cache.Incr(key)
+	if nil != err {
+		log.Errorf("claim sign nonce error:%s", err.Error())
+		return false, errors.New("nonce can't be checked now")
+	}
+	if count == 1 {
+		if err := cache.ExpireAt(key, expireAt); nil != err {
+			log.Errorf("expire sign nonce %s error:%s", key, err.Error())
+		}
+	}
+	if count > 1 {
+		return false, errors.New("nonce has been used")
+	}
+	return true, nil
+}
+
+// verifySignedHash reports whether sign is the signature of the personal message of hash by sign.Owner,
+// i.e. hash is signed with the prefix "\x19Ethereum Signed Message:\n32"
+func verifySignedHash(hash common.Hash, sign SignInfo) (bool, error) {
+	sig, _ := crypto.VRSToSig(sign.V, types.HexToBytes32(sign.R).Bytes(), types.HexToBytes32(sign.S).Bytes())
+	addressBytes, err := crypto.SigToAddress(hash.Bytes(), sig)
+	if nil != err {
+		log.Errorf("signer address error:%s", err.Error())
+		return false, errors.New("sign is incorrect")
+	}
+	if strings.ToLower(common.BytesToAddress(addressBytes).Hex()) != strings.ToLower(sign.Owner) {
+		return false, errors.New("sign address not matched")
+	}
+	return true, nil
+}
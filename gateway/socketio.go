@@ -1,12 +1,18 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/gateway/depthdiff"
+	"github.com/Loopring/relay-cluster/gateway/order_difficulty"
+	"github.com/Loopring/relay-cluster/gateway/subindex"
 	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-cluster/metrics"
 	txtyp "github.com/Loopring/relay-cluster/txmanager/types"
+	kafkaUtil "github.com/Loopring/relay-cluster/util"
 	"github.com/Loopring/relay-lib/eth/loopringaccessor"
 	"github.com/Loopring/relay-lib/kafka"
 	"github.com/Loopring/relay-lib/log"
@@ -19,6 +25,7 @@ import (
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -47,10 +54,12 @@ const (
 
 const Kafka_Topic_SocketIO_Order_Transfer = "Kafka_Topic_SocketIO_Order_Transfer"
 const Kafka_Topic_SocketIO_Scan_Login = "Kafka_Topic_SocketIO_Scan_Login"
+const Kafka_Topic_SocketIO_Conditional_Order_Updated = "Kafka_Topic_SocketIO_Conditional_Order_Updated"
 const Kafka_Topic_SocketIO_Notify_Circulr = "Kafka_Topic_SocketIO_Notify_Circulr"
 
 type Server struct {
 	socketio.Server
+	allowedOrigins []string
 }
 
 type SocketIOJsonResp struct {
@@ -59,8 +68,8 @@ type SocketIOJsonResp struct {
 	Data  interface{} `json:"data"`
 }
 
-func NewServer(s socketio.Server) Server {
-	return Server{s}
+func NewServer(s socketio.Server, allowedOrigins []string) Server {
+	return Server{s, allowedOriginsOrDefault(allowedOrigins)}
 }
 
 func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -69,6 +78,10 @@ func (s Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if len(OriginList) > 0 {
 		Origin = OriginList[0]
 	}
+	if Origin != "" && !originAllowed(s.allowedOrigins, Origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
 	w.Header().Add("Access-Control-Allow-Origin", Origin)
 	w.Header().Add("Access-Control-Allow-Credentials", "true")
 	w.Header().Add("Access-Control-Allow-Headers", "accept, origin, content-type")
@@ -96,12 +109,16 @@ const (
 	eventKeyPendingTx           = "pendingTx"
 	eventkeyTransactionStatus   = "transactionStatus"
 	eventKeyDepth               = "depth"
+	eventKeyDepthDiff           = "depthDiff"
 	eventKeyOrderBook           = "orderBook"
 	eventKeyTrades              = "trades"
 	eventKeyOrders              = "orders"
 	eventKeyOrderTracing        = "orderTracing"
 	eventKeyEstimatedGasPrice   = "estimatedGasPrice"
 	eventKeyOrderAllocateChange = "orderAllocateChange"
+	eventKeyOrderDifficulty     = "orderDifficulty"
+	eventKeyReferencePrice      = "referencePrice"
+	eventKeyConditionalOrders   = "conditionalOrders"
 
 	eventKeyGlobalTicker       = "globalTicker"
 	eventKeyGlobalTrend        = "globalTrend"
@@ -121,9 +138,21 @@ type SocketIOServiceImpl struct {
 	port           string
 	walletService  WalletServiceImpl
 	connIdMap      *sync.Map
+	subscriptions  *subindex.Index
+	auths          *sync.Map
+	privateEvents  map[string]bool
 	cron           *cron.Cron
 	consumer       *kafka.ConsumerRegister
 	eventTypeRoute map[string]InvokeInfo
+	depthDiffs     *depthdiff.Tracker
+	depthDiffLocks sync.Map
+
+	server          *socketio.Server
+	httpServer      *http.Server
+	shutdownTimeout time.Duration
+	allowedOrigins  []string
+	tlsCertFile     string
+	tlsKeyFile      string
 }
 
 type SocketMsgHandler struct {
@@ -131,11 +160,20 @@ type SocketMsgHandler struct {
 	Handler func(data interface{}) error
 }
 
-func NewSocketIOService(port string, walletService WalletServiceImpl, brokers []string) *SocketIOServiceImpl {
+func NewSocketIOService(options *WebsocketOptions, shutdownTimeout time.Duration, walletService WalletServiceImpl, brokers []string) *SocketIOServiceImpl {
 	so := &SocketIOServiceImpl{}
-	so.port = port
+	so.port = options.Port
+	so.allowedOrigins = options.AllowedOrigins
+	so.tlsCertFile = options.TlsCertFile
+	so.tlsKeyFile = options.TlsKeyFile
+	so.shutdownTimeout = shutdownTimeout
 	so.walletService = walletService
 	so.connIdMap = &sync.Map{}
+	so.subscriptions = subindex.NewIndex()
+	so.auths = &sync.Map{}
+	so.depthDiffs = depthdiff.NewTracker()
+	metrics.NewGaugeFunc("relay_socketio_connections", "Connections of socket.io.", so.connectionCount)
+	metrics.NewGaugeVecFunc("relay_socketio_subscriptions", "Subscriptions of socket.io by topic.", "topic", so.subscriptionCounts)
 	so.cron = cron.New()
 	so.consumer = &kafka.ConsumerRegister{}
 	so.consumer.Initialize(brokers)
@@ -147,15 +185,19 @@ func NewSocketIOService(port string, walletService WalletServiceImpl, brokers []
 		kafka.Kafka_Topic_SocketIO_Trades_Updated: {dao.FillEvent{}, so.broadcastTrades},
 		kafka.Kafka_Topic_SocketIO_Trends_Updated: {market.TrendUpdateMsg{}, so.broadcastTrends},
 
-		kafka.Kafka_Topic_SocketIO_Order_Updated: {types.OrderState{}, so.handleOrderUpdate},
-		kafka.Kafka_Topic_SocketIO_Cutoff:        {types.CutoffEvent{}, so.handleCutOff},
-		kafka.Kafka_Topic_SocketIO_Cutoff_Pair:   {types.CutoffPairEvent{}, so.handleCutOffPair},
+		kafka.Kafka_Topic_SocketIO_Order_Updated:      {types.OrderState{}, so.handleOrderUpdate},
+		kafkaUtil.Kafka_Topic_SocketIO_Orders_Updated: {kafkaUtil.OrdersUpdatedEvent{}, so.handleOrdersOfOwnerUpdate},
+		kafka.Kafka_Topic_SocketIO_Cutoff:             {types.CutoffEvent{}, so.handleCutOff},
+		kafka.Kafka_Topic_SocketIO_Cutoff_Pair:        {types.CutoffPairEvent{}, so.handleCutOffPair},
 
 		kafka.Kafka_Topic_SocketIO_BalanceUpdated:      {types.BalanceUpdateEvent{}, so.handleBalanceUpdate},
 		kafka.Kafka_Topic_SocketIO_Transaction_Updated: {txtyp.TransactionView{}, so.handleTransactionUpdate},
 		Kafka_Topic_SocketIO_Order_Transfer:            {OrderTransfer{}, so.handleOrderTransfer},
 		Kafka_Topic_SocketIO_Scan_Login:                {LoginInfo{}, so.handleScanLogin},
+		Kafka_Topic_SocketIO_Conditional_Order_Updated: {ConditionalOrderJson{}, so.handleConditionalOrderUpdate},
 		Kafka_Topic_SocketIO_Notify_Circulr:            {NotifyCirculrBody{}, so.handleCirculrNotify},
+
+		order_difficulty.Kafka_Topic_SocketIO_Order_Difficulty_Updated: {order_difficulty.OrderDifficultyUpdateMsg{}, so.broadcastOrderDifficulty},
 	}
 
 	so.eventTypeRoute = map[string]InvokeInfo{
@@ -165,9 +207,12 @@ func NewSocketIOService(port string, walletService WalletServiceImpl, brokers []
 		eventKeyTrends:            {"GetTrend", TrendQuery{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyMarketCap:         {"GetPriceQuote", PriceQuoteQuery{}, true, emitTypeByCron, DefaultCronSpec5Minute},
 		eventKeyDepth:             {"GetDepth", DepthQuery{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
+		eventKeyDepthDiff:         {"", DepthQuery{}, true, emitTypeByEvent, DefaultCronSpec5Second},
 		eventKeyOrderBook:         {"GetUnmergedOrderBook", DepthQuery{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyTrades:            {"GetLatestFills", FillQuery{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyEstimatedGasPrice: {"GetEstimateGasPrice", nil, true, emitTypeByEvent, DefaultCronSpec5Minute},
+		eventKeyOrderDifficulty:   {"GetOrderDifficulty", nil, true, emitTypeByEvent, DefaultCronSpec1Minute},
+		eventKeyReferencePrice:    {"GetReferencePrice", SingleMarket{}, true, emitTypeByCron, DefaultCronSpec10Second},
 
 		eventKeyBalance:             {"GetBalance", CommonTokenRequest{}, false, emitTypeByEvent, DefaultCronSpec1Minute},
 		eventKeyTransaction:         {"GetTransactions", TransactionQuery{}, false, emitTypeByEvent, DefaultCronSpec1Minute},
@@ -177,6 +222,7 @@ func NewSocketIOService(port string, walletService WalletServiceImpl, brokers []
 		eventKeyOrders:              {"GetLatestOrders", LatestOrderQuery{}, false, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyOrderTracing:        {"GetOrderByHash", OrderQuery{}, false, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyOrderAllocateChange: {"GetAllEstimatedAllocatedAmount", EstimatedAllocatedAllowanceQuery{}, false, emitTypeByEvent, DefaultCronSpec1Minute},
+		eventKeyConditionalOrders:   {"GetConditionalOrders", ConditionalOrderQuery{}, false, emitTypeByEvent, DefaultCronSpec5Minute},
 
 		eventKeyGlobalTicker:       {"GetGlobalTicker", SingleToken{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
 		eventKeyGlobalTrend:        {"GetGlobalTrend", SingleToken{}, true, emitTypeByEvent, DefaultCronSpec5Minute},
@@ -186,6 +232,14 @@ func NewSocketIOService(port string, walletService WalletServiceImpl, brokers []
 		eventKeyCirculrNotify:      {"", nil, true, emitTypeByEvent, DefaultCronSpec30Day},
 	}
 
+	so.privateEvents = make(map[string]bool)
+	for _, eventType := range options.PrivateEvents {
+		if _, ok := so.eventTypeRoute[eventType]; !ok {
+			log.Fatalf("socketio, private event %s isn't supported", eventType)
+		}
+		so.privateEvents[eventType] = true
+	}
+
 	var groupId string
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -222,31 +276,23 @@ func (so *SocketIOServiceImpl) Start() {
 		fmt.Println(s.RemoteAddr())
 	})
 
-	for v, event := range so.eventTypeRoute {
+	server.OnEvent("/", eventKeyAuthChallenge+EventPostfixReq, so.handleAuthChallenge)
+	server.OnEvent("/", eventKeyAuth+EventPostfixReq, so.handleAuth)
+
+	for v := range so.eventTypeRoute {
 		aliasOfV := v
-		aliasOfEvent := event
 
 		server.OnEvent("/", aliasOfV+EventPostfixReq, func(s socketio.Conn, msg string) {
-
-			context := make(map[string]string)
-			if s != nil && s.Context() != nil {
-				context = s.Context().(map[string]string)
-			}
-			context[aliasOfV] = msg
-			s.SetContext(context)
-			so.connIdMap.Store(s.ID(), s)
-
-			if len(aliasOfEvent.MethodName) != 0 {
-				so.EmitNowByEventType(aliasOfV, s, msg)
+			if err := so.authorize(s.ID(), aliasOfV, msg); err != nil {
+				errJson, _ := json.Marshal(SocketIOJsonResp{Error: err.Error()})
+				s.Emit(aliasOfV+EventPostfixRes, string(errJson[:]))
+				return
 			}
+			so.subscribe(s, aliasOfV, msg)
 		})
 
 		server.OnEvent("/", aliasOfV+EventPostfixEnd, func(s socketio.Conn, msg string) {
-			if s != nil && s.Context() != nil {
-				businesses := s.Context().(map[string]string)
-				delete(businesses, aliasOfV)
-				s.SetContext(businesses)
-			}
+			so.unsubscribe(s, aliasOfV)
 		})
 	}
 
@@ -261,6 +307,8 @@ func (so *SocketIOServiceImpl) Start() {
 			so.cron.AddFunc(spec, func() { so.broadcastLoopringTicker(nil) })
 		case eventKeyDepth:
 			so.cron.AddFunc(spec, func() { so.broadcastDepth(nil) })
+		case eventKeyDepthDiff:
+			so.cron.AddFunc(spec, func() { so.broadcastDepthDiff(nil) })
 		case eventKeyOrderBook:
 			so.cron.AddFunc(spec, func() { so.broadcastOrderBook(nil) })
 		case eventKeyTrades:
@@ -269,6 +317,10 @@ func (so *SocketIOServiceImpl) Start() {
 			so.cron.AddFunc(spec, func() { so.broadcastMarketCap(nil) })
 		case eventKeyEstimatedGasPrice:
 			so.cron.AddFunc(spec, func() { so.broadcastGasPrice(nil) })
+		case eventKeyOrderDifficulty:
+			so.cron.AddFunc(spec, func() { so.broadcastOrderDifficulty(nil) })
+		case eventKeyReferencePrice:
+			so.cron.AddFunc(spec, func() { so.broadcastReferencePrice(nil) })
 		case eventKeyGlobalTicker:
 			so.cron.AddFunc(spec, func() { so.broadcastGlobalTicker(nil) })
 		case eventKeyGlobalMarketTicker:
@@ -283,18 +335,10 @@ func (so *SocketIOServiceImpl) Start() {
 			}
 
 			so.cron.AddFunc(spec, func() {
-				so.connIdMap.Range(func(key, value interface{}) bool {
-					v := value.(socketio.Conn)
-					if v.Context() != nil {
-						businesses := v.Context().(map[string]string)
-						eventContext, ok := businesses[copyOfK]
-						if ok {
-							//log.Infof("[SOCKETIO-EMIT]cron emit by key : %s, connId : %s", copyOfK, v.ID())
-							so.EmitNowByEventType(copyOfK, v, eventContext)
-						}
-					}
-					return true
-				})
+				for _, sub := range so.subscriptions.All(copyOfK) {
+					//log.Infof("[SOCKETIO-EMIT]cron emit by key : %s, connId : %s", copyOfK, sub.ConnId)
+					so.EmitNowByEventType(copyOfK, sub.Conn.(socketio.Conn), sub.Query)
+				}
 			})
 
 		}
@@ -306,23 +350,116 @@ func (so *SocketIOServiceImpl) Start() {
 		fmt.Println("meet error:", e)
 		infos := strings.Split(e.Error(), "SOCKETFORLOOPRING")
 		if len(infos) == 2 {
-			so.connIdMap.Delete(infos[0])
+			so.disconnect(infos[0])
 		}
 
 	})
 
 	server.OnDisconnect("/", func(s socketio.Conn, msg string) {
 		s.Close()
-		so.connIdMap.Delete(s.ID())
+		so.disconnect(s.ID())
 		fmt.Println("closed", msg)
 	})
 	go server.Serve()
-	defer server.Close()
+	so.server = server
+
+	useTls, err := checkTlsFiles(so.tlsCertFile, so.tlsKeyFile)
+	if nil != err {
+		log.Fatalf("socketio, %s", err.Error())
+	}
+	listener, err := net.Listen("tcp", ":"+so.port)
+	if nil != err {
+		log.Fatal(err.Error())
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/socket.io/", NewServer(*server, so.allowedOrigins))
+	mux.HandleFunc("/stats", so.handleStats)
+	so.httpServer = &http.Server{Handler: mux}
+	if useTls {
+		log.Info("Serving wss at localhost: " + so.port)
+	} else {
+		log.Info("Serving at localhost: " + so.port)
+	}
+	go func() {
+		if err := serveHttp(so.httpServer, listener, so.tlsCertFile, so.tlsKeyFile); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err.Error())
+		}
+	}()
+}
+
+// Stop stops the cron broadcasts, drains the http listener until shutdownTimeout,
+// then closes the socket.io connections and the kafka consumers, which commit their offsets on close
+func (so *SocketIOServiceImpl) Stop() {
+	so.cron.Stop()
 
-	http.Handle("/socket.io/", NewServer(*server))
-	log.Info("Serving at localhost: " + so.port)
-	log.Fatal(http.ListenAndServe(":"+so.port, nil).Error())
+	if so.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), so.shutdownTimeout)
+		defer cancel()
+		if err := so.httpServer.Shutdown(ctx); err != nil {
+			log.Errorf("socketio, shutdown error:%s", err.Error())
+		}
+	}
+	if so.server != nil {
+		so.server.Close()
+	}
+
+	so.consumer.Close()
+	log.Info("socketio closed at localhost: " + so.port)
+}
+
+// subscribe saves msg as the query of eventType in the context of conn, and emits the current data of eventType to it.
+// the pushes of eventType reach conn after it's subscribed, no matter it's a socket.io or websocket connection
+func (so *SocketIOServiceImpl) subscribe(conn socketio.Conn, eventType string, msg string) {
+	context := make(map[string]string)
+	if conn.Context() != nil {
+		context = conn.Context().(map[string]string)
+	}
+	context[eventType] = msg
+	conn.SetContext(context)
+	so.connIdMap.Store(conn.ID(), conn)
+	register := func() {
+		so.subscriptions.Add(eventType, subscriptionKey(eventType, msg), conn.ID(), conn, msg)
+	}
+
+	if eventType == eventKeyDepthDiff {
+		so.emitDepthSnapshot(conn, msg, register)
+		return
+	}
+	register()
+	if len(so.eventTypeRoute[eventType].MethodName) != 0 {
+		so.EmitNowByEventType(eventType, conn, msg)
+	}
+}
+
+func (so *SocketIOServiceImpl) unsubscribe(conn socketio.Conn, eventType string) {
+	so.subscriptions.Remove(eventType, conn.ID())
+	if conn.Context() != nil {
+		businesses := conn.Context().(map[string]string)
+		delete(businesses, eventType)
+		conn.SetContext(businesses)
+	}
+}
 
+// disconnect stops the pushes to the connection of connId
+func (so *SocketIOServiceImpl) disconnect(connId string) {
+	so.connIdMap.Delete(connId)
+	so.subscriptions.RemoveConn(connId)
+	so.auths.Delete(connId)
+}
+
+func (so *SocketIOServiceImpl) isEventType(eventType string) bool {
+	_, ok := so.eventTypeRoute[eventType]
+	return ok
+}
+
+func (so *SocketIOServiceImpl) connectionCount() float64 {
+	count := 0
+	so.connIdMap.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	return float64(count)
 }
 
 func (so *SocketIOServiceImpl) EmitNowByEventType(bk string, v socketio.Conn, bv string) {
@@ -375,12 +512,10 @@ func (so *SocketIOServiceImpl) handleAfterEmit(eventType string, query interface
 }
 
 func (so *SocketIOServiceImpl) broadcastTpTickers(input interface{}) (err error) {
-
-	mkts, _ := so.walletService.GetSupportedMarket()
-
-	tickerMap := make(map[string]string)
-
-	for _, mkt := range mkts {
+	for _, mkt := range so.subscriptions.Keys(eventKeyTickers) {
+		if !util.IsSupportedMarket(mkt) {
+			continue
+		}
 		ticker, err := so.walletService.GetTickers(SingleMarket{mkt})
 		resp := SocketIOJsonResp{}
 
@@ -390,28 +525,27 @@ func (so *SocketIOServiceImpl) broadcastTpTickers(input interface{}) (err error)
 			resp.Data = ticker
 		}
 		respJson, _ := json.Marshal(resp)
-		tickerMap[mkt] = string(respJson[:])
+		so.emitByKey(eventKeyTickers, mkt, string(respJson[:]))
 	}
+	return nil
+}
 
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTickers]
-			if ok {
-				var singleMarket SingleMarket
-				err = json.Unmarshal([]byte(ctx), &singleMarket)
-				if err != nil {
-					return true
-				}
-				tks, ok := tickerMap[strings.ToUpper(singleMarket.Market)]
-				if ok {
-					v.Emit(eventKeyTickers+EventPostfixRes, tks)
-				}
-			}
+func (so *SocketIOServiceImpl) broadcastReferencePrice(input interface{}) (err error) {
+	for _, mkt := range so.subscriptions.Keys(eventKeyReferencePrice) {
+		if !util.IsSupportedMarket(mkt) {
+			continue
 		}
-		return true
-	})
+		price, err := so.walletService.GetReferencePrice(SingleMarket{mkt})
+		resp := SocketIOJsonResp{}
+
+		if err != nil {
+			resp = SocketIOJsonResp{Error: err.Error()}
+		} else {
+			resp.Data = price
+		}
+		respJson, _ := json.Marshal(resp)
+		so.emitByKey(eventKeyReferencePrice, mkt, string(respJson[:]))
+	}
 	return nil
 }
 
@@ -426,51 +560,29 @@ func (so *SocketIOServiceImpl) broadcastLoopringTicker(input interface{}) (err e
 	}
 
 	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			_, ok := businesses[eventKeyLoopringTickers]
-			if ok {
-				//log.Info("emit loopring ticker info")
-				v.Emit(eventKeyLoopringTickers+EventPostfixRes, string(respJson[:]))
-			}
-		}
-		return true
-	})
+	so.emitByKey(eventKeyLoopringTickers, "", string(respJson[:]))
 	return nil
 }
 
 func (so *SocketIOServiceImpl) broadcastSourceOfTicker(input interface{}) (err error) {
 	req := input.(*market.TickerUpdateMsg)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTickersOfSource]
-			if ok {
-				tickerReq := &TickerRequest{}
-				err = json.Unmarshal([]byte(ctx), tickerReq)
-				if err != nil {
-					log.Error("SourceOfTicker request unmarshal error, " + err.Error())
-				} else if tickerReq.TickerSource == req.TickerSource {
-					log.Info("emit SourceOfTicker " + ctx)
-					tickers, err := so.walletService.GetTickerBySource(*tickerReq)
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = tickers
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyTickersOfSource+EventPostfixRes, string(respJson[:]))
-				}
-			}
+	for _, sub := range so.subscriptions.Get(eventKeyTickersOfSource, req.TickerSource) {
+		tickerReq := &TickerRequest{}
+		if err := json.Unmarshal([]byte(sub.Query), tickerReq); err != nil {
+			log.Error("SourceOfTicker request unmarshal error, " + err.Error())
+			continue
 		}
-		return true
-	})
+		tickers, err := so.walletService.GetTickerBySource(*tickerReq)
+		resp := SocketIOJsonResp{}
+
+		if err != nil {
+			resp = SocketIOJsonResp{Error: err.Error()}
+		} else {
+			resp.Data = tickers
+		}
+		respJson, _ := json.Marshal(resp)
+		sub.Conn.(socketio.Conn).Emit(eventKeyTickersOfSource+EventPostfixRes, string(respJson[:]))
+	}
 
 	return nil
 }
@@ -492,17 +604,15 @@ func (so *SocketIOServiceImpl) broadcastOrderBook(input interface{}) (err error)
 func (so *SocketIOServiceImpl) getDepthPushData(eventKey string, markets map[string]bool) map[string]string {
 	respMap := make(map[string]string, 0)
 	for mk := range markets {
-		mktAndDelegate := strings.Split(mk, "_")
-		delegate := mktAndDelegate[0]
-		mkt := mktAndDelegate[1]
+		query := parseDepthKey(mk)
 		resp := SocketIOJsonResp{}
 
 		var data interface{}
 		var err error
 		if eventKeyDepth == eventKey {
-			data, err = so.walletService.GetDepth(DepthQuery{delegate, mkt})
+			data, err = so.walletService.GetDepth(query)
 		} else {
-			data, err = so.walletService.GetUnmergedOrderBook(DepthQuery{delegate, mkt})
+			data, err = so.walletService.GetUnmergedOrderBook(query)
 		}
 
 		if err == nil {
@@ -517,24 +627,9 @@ func (so *SocketIOServiceImpl) getDepthPushData(eventKey string, markets map[str
 }
 
 func (so *SocketIOServiceImpl) pushDepthData(eventKey string, respMap map[string]string) {
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKey]
-			if ok {
-				dQuery := &DepthQuery{}
-				err := json.Unmarshal([]byte(ctx), dQuery)
-				if err == nil && len(dQuery.DelegateAddress) > 0 && len(dQuery.Market) > 0 {
-					depthKey := strings.ToLower(dQuery.DelegateAddress) + "_" + strings.ToLower(dQuery.Market)
-					if len(respMap[depthKey]) > 0 {
-						v.Emit(eventKey+EventPostfixRes, respMap[depthKey])
-					}
-				}
-			}
-		}
-		return true
-	})
+	for key, data := range respMap {
+		so.emitByKey(eventKey, key, data)
+	}
 }
 
 func (so *SocketIOServiceImpl) broadcastTrades(input interface{}) (err error) {
@@ -544,16 +639,16 @@ func (so *SocketIOServiceImpl) broadcastTrades(input interface{}) (err error) {
 
 	if input != nil {
 		fillEvent := input.(*dao.FillEvent)
-		delegateAddress := fillEvent.DelegateAddress
-		market := fillEvent.Market
-		markets[strings.ToLower(delegateAddress)+"_"+strings.ToLower(market)] = true
+		markets[lowerKey(fillEvent.DelegateAddress, fillEvent.Market)] = true
 	} else {
 		markets = so.getConnectedMarketForFill()
 	}
 
-	respMap := make(map[string]string, 0)
 	for mk := range markets {
 		mktAndDelegate := strings.Split(mk, "_")
+		if len(mktAndDelegate) != 2 {
+			continue
+		}
 		delegate := mktAndDelegate[0]
 		mkt := mktAndDelegate[1]
 		resp := SocketIOJsonResp{}
@@ -565,50 +660,18 @@ func (so *SocketIOServiceImpl) broadcastTrades(input interface{}) (err error) {
 			resp = SocketIOJsonResp{Error: err.Error()}
 		}
 		respJson, _ := json.Marshal(resp)
-		respMap[mk] = string(respJson[:])
+		so.emitByKey(eventKeyTrades, mk, string(respJson[:]))
 	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTrades]
-			if ok {
-				fQuery := &FillQuery{}
-				err := json.Unmarshal([]byte(ctx), fQuery)
-				if err == nil && len(fQuery.DelegateAddress) > 0 && len(fQuery.Market) > 0 {
-					fillKey := strings.ToLower(fQuery.DelegateAddress) + "_" + strings.ToLower(fQuery.Market)
-					v.Emit(eventKeyTrades+EventPostfixRes, respMap[fillKey])
-				}
-			}
-		}
-		return true
-	})
 	return nil
 }
 
 func (so *SocketIOServiceImpl) broadcastMarketCap(input interface{}) (err error) {
-
-	cnyResp := so.getPriceQuoteResp(priceQuoteCNY)
-	usdResp := so.getPriceQuoteResp(priceQuoteUSD)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyMarketCap]
-			if ok {
-				query := &PriceQuoteQuery{}
-				err := json.Unmarshal([]byte(ctx), query)
-				if err == nil && strings.ToLower(priceQuoteCNY) == strings.ToLower(query.Currency) {
-					v.Emit(eventKeyMarketCap+EventPostfixRes, cnyResp)
-				} else if err == nil && strings.ToLower(priceQuoteUSD) == strings.ToLower(query.Currency) {
-					v.Emit(eventKeyMarketCap+EventPostfixRes, usdResp)
-				}
-			}
+	for _, currency := range []string{priceQuoteCNY, priceQuoteUSD} {
+		key := strings.ToLower(currency)
+		if len(so.subscriptions.Get(eventKeyMarketCap, key)) > 0 {
+			so.emitByKey(eventKeyMarketCap, key, so.getPriceQuoteResp(currency))
 		}
-		return true
-	})
+	}
 	return nil
 }
 
@@ -624,19 +687,23 @@ func (so *SocketIOServiceImpl) broadcastGasPrice(input interface{}) (err error)
 	}
 
 	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyEstimatedGasPrice, "", string(respJson[:]))
+	return nil
+}
 
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			_, ok := businesses[eventKeyEstimatedGasPrice]
-			if ok {
-				//log.Info("emit loopring gas price info")
-				v.Emit(eventKeyEstimatedGasPrice+EventPostfixRes, string(respJson[:]))
-			}
-		}
-		return true
-	})
+func (so *SocketIOServiceImpl) broadcastOrderDifficulty(input interface{}) (err error) {
+
+	resp := SocketIOJsonResp{}
+	if input != nil {
+		resp.Data = input.(*order_difficulty.OrderDifficultyUpdateMsg).Difficulty
+	} else if difficulty, err := so.walletService.GetOrderDifficulty(); err != nil {
+		resp = SocketIOJsonResp{Error: err.Error()}
+	} else {
+		resp.Data = difficulty
+	}
+
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyOrderDifficulty, "", string(respJson[:]))
 	return nil
 }
 
@@ -652,19 +719,7 @@ func (so *SocketIOServiceImpl) broadcastGlobalTicker(input interface{}) (err err
 	}
 
 	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			_, ok := businesses[eventKeyGlobalTicker]
-			if ok && len(string(respJson[:])) > 0 {
-				//log.Info("emit loopring gas price info")
-				v.Emit(eventKeyGlobalTicker+EventPostfixRes, string(respJson[:]))
-			}
-		}
-		return true
-	})
+	so.emitByKey(eventKeyGlobalTicker, "", string(respJson[:]))
 	return nil
 }
 
@@ -673,7 +728,6 @@ func (so *SocketIOServiceImpl) broadcastGlobalTrend(input interface{}) (err erro
 	resp := SocketIOJsonResp{}
 	trendMap, err := so.walletService.GetAllGlobalTrend(SingleToken{})
 
-	respMap := make(map[string]string)
 	for k, v := range trendMap {
 		if err != nil {
 			resp = SocketIOJsonResp{Error: err.Error()}
@@ -682,24 +736,8 @@ func (so *SocketIOServiceImpl) broadcastGlobalTrend(input interface{}) (err erro
 		}
 
 		respJson, _ := json.Marshal(resp)
-		respMap[k] = string(respJson[:])
+		so.emitByKey(eventKeyGlobalTrend, strings.ToUpper(k), string(respJson[:]))
 	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyGlobalTrend]
-			if ok {
-				query := &SingleToken{}
-				err := json.Unmarshal([]byte(ctx), query)
-				if err == nil && len(query.Token) > 0 && len(respMap[strings.ToUpper(query.Token)]) > 0 {
-					v.Emit(eventKeyGlobalTrend+EventPostfixRes, respMap[strings.ToUpper(query.Token)])
-				}
-			}
-		}
-		return true
-	})
 	return nil
 }
 
@@ -708,7 +746,6 @@ func (so *SocketIOServiceImpl) broadcastGlobalMarketTicker(input interface{}) (e
 	resp := SocketIOJsonResp{}
 	tickerMap, err := so.walletService.GetGlobalMarketTicker(SingleToken{})
 
-	respMap := make(map[string]string)
 	for k, v := range tickerMap {
 		if err != nil {
 			resp = SocketIOJsonResp{Error: err.Error()}
@@ -719,24 +756,8 @@ func (so *SocketIOServiceImpl) broadcastGlobalMarketTicker(input interface{}) (e
 		}
 
 		respJson, _ := json.Marshal(resp)
-		respMap[k] = string(respJson[:])
+		so.emitByKey(eventKeyGlobalMarketTicker, strings.ToUpper(k), string(respJson[:]))
 	}
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyGlobalMarketTicker]
-			if ok {
-				query := &SingleToken{}
-				err := json.Unmarshal([]byte(ctx), query)
-				if err == nil && len(query.Token) > 0 && len(respMap[strings.ToUpper(query.Token)]) > 0 {
-					v.Emit(eventKeyGlobalMarketTicker+EventPostfixRes, respMap[strings.ToUpper(query.Token)])
-				}
-			}
-		}
-		return true
-	})
 	return nil
 }
 
@@ -754,53 +775,60 @@ func (so *SocketIOServiceImpl) getPriceQuoteResp(currency string) string {
 	return string(respJson)
 }
 
+// depthKey is delegate_market of a depth subscription, followed by _precision if it's applied
+func depthKey(query DepthQuery) string {
+	key := strings.ToLower(query.DelegateAddress) + "_" + strings.ToLower(query.Market)
+	if query.Precision != nil {
+		key += "_" + strconv.Itoa(*query.Precision)
+	}
+	return key
+}
+
+func parseDepthKey(key string) DepthQuery {
+	parts := strings.Split(key, "_")
+	query := DepthQuery{DelegateAddress: parts[0]}
+	if len(parts) > 1 {
+		query.Market = parts[1]
+	}
+	if len(parts) > 2 {
+		precision, _ := strconv.Atoi(parts[2])
+		query.Precision = &precision
+	}
+	return query
+}
+
+// getConnectedMarketForDepth returns the depth keys subscribed by eventKey, only the ones of the market of input if it's not nil
 func (so *SocketIOServiceImpl) getConnectedMarketForDepth(eventKey string, input interface{}) map[string]bool {
 
 	markets := make(map[string]bool, 0)
 
+	var market string
 	if input != nil {
-		depthQuery := input.(DepthQuery)
-		markets[strings.ToLower(depthQuery.DelegateAddress)+"_"+strings.ToLower(depthQuery.Market)] = true
-		return markets
+		query := input.(DepthQuery)
+		query.Precision = nil
+		market = depthKey(query)
 	}
 
-	count := 0
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		count++
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			DCtx, ok := businesses[eventKey]
-			if ok {
-				dQuery := &DepthQuery{}
-				err := json.Unmarshal([]byte(DCtx), dQuery)
-				if err == nil && len(dQuery.DelegateAddress) > 0 && len(dQuery.Market) > 0 {
-					markets[strings.ToLower(dQuery.DelegateAddress)+"_"+strings.ToLower(dQuery.Market)] = true
-				}
-			}
+	for _, key := range so.subscriptions.Keys(eventKey) {
+		if len(key) == 0 {
+			continue
 		}
-		return true
-	})
+		query := parseDepthKey(key)
+		query.Precision = nil
+		if len(market) == 0 || depthKey(query) == market {
+			markets[key] = true
+		}
+	}
 	return markets
 }
 
 func (so *SocketIOServiceImpl) getConnectedMarketForFill() map[string]bool {
 	markets := make(map[string]bool, 0)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			fCtx, ok := businesses[eventKeyTrades]
-			if ok {
-				fQuery := &FillQuery{}
-				err := json.Unmarshal([]byte(fCtx), fQuery)
-				if err == nil && len(fQuery.DelegateAddress) > 0 && len(fQuery.Market) > 0 {
-					markets[strings.ToLower(fQuery.DelegateAddress)+"_"+strings.ToLower(fQuery.Market)] = true
-				}
-			}
+	for _, key := range so.subscriptions.Keys(eventKeyTrades) {
+		if len(key) > 0 {
+			markets[key] = true
 		}
-		return true
-	})
+	}
 	return markets
 }
 
@@ -809,6 +837,11 @@ func (so *SocketIOServiceImpl) broadcastTrends(input interface{}) (err error) {
 	//log.Infof("[SOCKETIO-RECEIVE-EVENT] trend input. %s", input)
 
 	req := input.(*market.TrendUpdateMsg)
+	key := upperKey(req.Market, req.Interval)
+	if len(so.subscriptions.Get(eventKeyTrends, key)) == 0 {
+		return nil
+	}
+
 	trendQuery := TrendQuery{Market: req.Market, Interval: req.Interval}
 	resp := SocketIOJsonResp{}
 	trends, err := so.walletService.GetTrend(trendQuery)
@@ -820,27 +853,7 @@ func (so *SocketIOServiceImpl) broadcastTrends(input interface{}) (err error) {
 	}
 
 	respJson, _ := json.Marshal(resp)
-
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTrends]
-
-			if ok {
-				trendQuery := &TrendQuery{}
-				err = json.Unmarshal([]byte(ctx), trendQuery)
-				if err != nil {
-					log.Error("trend query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(trendQuery.Market) == strings.ToUpper(trendQuery.Market) &&
-					strings.ToUpper(trendQuery.Interval) == strings.ToUpper(trendQuery.Interval) {
-					log.Info("emit trend " + ctx)
-					v.Emit(eventKeyTrends+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
+	so.emitByKey(eventKeyTrends, key, string(respJson[:]))
 	return nil
 }
 
@@ -869,6 +882,11 @@ func (so *SocketIOServiceImpl) notifyBalanceUpdateByDelegateAddress(owner, deleg
 		return nil
 	}
 
+	key := lowerKey(owner, delegateAddress)
+	if len(so.subscriptions.Get(eventKeyBalance, key)) == 0 {
+		return nil
+	}
+
 	req := CommonTokenRequest{delegateAddress, owner}
 	resp := SocketIOJsonResp{}
 	balance, err := so.walletService.GetBalance(req)
@@ -880,152 +898,54 @@ func (so *SocketIOServiceImpl) notifyBalanceUpdateByDelegateAddress(owner, deleg
 	}
 
 	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyBalance, key, string(respJson[:]))
+	return nil
+}
 
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyBalance]
-			if ok {
-				query := &CommonTokenRequest{}
-				err = json.Unmarshal([]byte(ctx), query)
-				if err != nil {
-					return true
-				}
+// emitTransactions emits the transactions queried by the subscription of every subscriber of owner to eventType,
+// their queries are paged differently
+func (so *SocketIOServiceImpl) emitTransactions(eventType, emitType, owner string, query func(TransactionQuery) (interface{}, error)) {
+	for _, sub := range so.subscriptions.Get(eventType, strings.ToLower(owner)) {
+		txQuery := &TransactionQuery{}
+		if err := json.Unmarshal([]byte(sub.Query), txQuery); err != nil {
+			log.Error("tx query unmarshal error, " + err.Error())
+			continue
+		}
 
-				if strings.ToLower(query.Owner) == strings.ToLower(req.Owner) && strings.ToLower(query.DelegateAddress) == strings.ToLower(req.DelegateAddress) {
-					//log.Info("emit balance info")
-					v.Emit(eventKeyBalance+EventPostfixRes, string(respJson[:]))
-				}
-			}
+		txs, err := query(*txQuery)
+		resp := SocketIOJsonResp{}
+
+		if err != nil {
+			resp = SocketIOJsonResp{Error: err.Error()}
+		} else {
+			resp.Data = txs
 		}
-		return true
-	})
-	return nil
+		respJson, _ := json.Marshal(resp)
+		sub.Conn.(socketio.Conn).Emit(emitType+EventPostfixRes, string(respJson[:]))
+	}
 }
 
 func (so *SocketIOServiceImpl) handleTransactions(input interface{}) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] transactions input.")
 	req := input.(*txtyp.TransactionView)
-	owner := req.Owner.Hex()
-	//log.Infof("received owner is %s ", owner)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyTransaction]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &TransactionQuery{}
-				//log.Info("txQuery owner is " + txQuery.Owner)
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Info("emit trend " + ctx)
-
-					txs, err := so.walletService.GetTransactions(*txQuery)
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyTransaction+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
+	so.emitTransactions(eventKeyTransaction, eventKeyTransaction, req.Owner.Hex(), func(query TransactionQuery) (interface{}, error) {
+		return so.walletService.GetTransactions(query)
 	})
-
 	return nil
 }
 
 func (so *SocketIOServiceImpl) handleLatestTransactions(input interface{}) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] latest transactions input")
-
 	req := input.(*txtyp.TransactionView)
-	owner := req.Owner.Hex()
-	//log.Infof("received owner is %s ", owner)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyLatestTransaction]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &TransactionQuery{}
-				//log.Info("txQuery owner is " + txQuery.Owner)
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Info("emit trend " + ctx)
-
-					txs, err := so.walletService.GetLatestTransactions(*txQuery)
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyTransaction+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
+	so.emitTransactions(eventKeyLatestTransaction, eventKeyTransaction, req.Owner.Hex(), func(query TransactionQuery) (interface{}, error) {
+		return so.walletService.GetLatestTransactions(query)
 	})
-
 	return nil
 }
 
 func (so *SocketIOServiceImpl) handleTransactionsStatus(input interface{}) (err error) {
-
-	//log.Infof("[SOCKETIO-RECEIVE-EVENT] monitoring transactions input")
-
 	req := input.(*txtyp.TransactionView)
-	owner := req.Owner.Hex()
-	//log.Infof("received owner is %s ", owner)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventkeyTransactionStatus]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &TransactionQuery{}
-				//log.Info("txQuery owner is " + txQuery.Owner)
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Info("emit trend " + ctx)
-
-					txs, err := so.walletService.GetTransactionsByHash(*txQuery)
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventkeyTransactionStatus+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
+	so.emitTransactions(eventkeyTransactionStatus, eventkeyTransactionStatus, req.Owner.Hex(), func(query TransactionQuery) (interface{}, error) {
+		return so.walletService.GetTransactionsByHash(query)
 	})
-
 	return nil
 }
 
@@ -1042,38 +962,22 @@ func (so *SocketIOServiceImpl) handlePendingTransaction(input interface{}) (err
 
 	req := input.(*txtyp.TransactionView)
 	owner := req.Owner.Hex()
-	//log.Infof("received owner is %s ", owner)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyPendingTx]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				txQuery := &SingleOwner{}
-				err = json.Unmarshal([]byte(ctx), txQuery)
-				// log.Info("single owner is: " + txQuery.Owner)
-				if err != nil {
-					log.Error("tx query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
-					log.Debugf("emit tx pending, owner:%s, ctx:%s", txQuery.Owner, ctx)
-					txs, err := so.walletService.GetPendingTransactions(SingleOwner{owner})
-					resp := SocketIOJsonResp{}
-
-					if err != nil {
-						resp = SocketIOJsonResp{Error: err.Error()}
-					} else {
-						resp.Data = txs
-					}
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyPendingTx+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
+	key := strings.ToLower(owner)
+	if len(so.subscriptions.Get(eventKeyPendingTx, key)) == 0 {
+		return nil
+	}
+
+	log.Debugf("emit tx pending, owner:%s", owner)
+	txs, err := so.walletService.GetPendingTransactions(SingleOwner{owner})
+	resp := SocketIOJsonResp{}
 
+	if err != nil {
+		resp = SocketIOJsonResp{Error: err.Error()}
+	} else {
+		resp.Data = txs
+	}
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyPendingTx, key, string(respJson[:]))
 	return nil
 }
 
@@ -1083,38 +987,18 @@ func (so *SocketIOServiceImpl) handleOrdersUpdate(input interface{}) (err error)
 
 	req := input.(*types.OrderState)
 	owner := req.RawOrder.Owner.Hex()
-	//log.Infof("received owner is %s ", owner)
+	key := lowerKey(owner, req.RawOrder.Market, req.RawOrder.OrderType)
+	if len(so.subscriptions.Get(eventKeyOrders, key)) == 0 {
+		return nil
+	}
 
 	orderQuery := LatestOrderQuery{Owner: owner, Market: req.RawOrder.Market, OrderType: req.RawOrder.OrderType}
 	orderList, err := so.walletService.GetLatestOrders(orderQuery)
 
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyOrders]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				query := &LatestOrderQuery{}
-				err = json.Unmarshal([]byte(ctx), query)
-				//log.Info("single owner is: " + query.Owner)
-				if err != nil {
-					//log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(query.Owner) &&
-					strings.ToLower(req.RawOrder.Market) == strings.ToLower(query.Market) &&
-					strings.ToLower(req.RawOrder.OrderType) == strings.ToLower(query.OrderType) {
-					//log.Info("emit " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = orderList
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyOrders+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
-
+	resp := SocketIOJsonResp{}
+	resp.Data = orderList
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyOrders, key, string(respJson[:]))
 	return nil
 }
 
@@ -1123,37 +1007,18 @@ func (so *SocketIOServiceImpl) handleOrderAllocateChange(input interface{}) (err
 	req := input.(*types.OrderState)
 	owner := req.RawOrder.Owner.Hex()
 	delegateAddress := req.RawOrder.DelegateAddress.Hex()
-	//log.Infof("received order allocated owner  is %s ", owner)
+	key := lowerKey(owner, delegateAddress)
+	if len(so.subscriptions.Get(eventKeyOrderAllocateChange, key)) == 0 {
+		return nil
+	}
 
 	allocatedQuery := EstimatedAllocatedAllowanceQuery{Owner: owner, DelegateAddress: delegateAddress}
 	allocateMap, err := so.walletService.GetAllEstimatedAllocatedAmount(allocatedQuery)
 
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyOrderAllocateChange]
-
-			if ok {
-				query := &EstimatedAllocatedAllowanceQuery{}
-				err = json.Unmarshal([]byte(ctx), query)
-				//log.Info("single owner is: " + query.Owner)
-				if err != nil {
-					log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(owner) == strings.ToUpper(query.Owner) &&
-					strings.ToLower(req.RawOrder.DelegateAddress.Hex()) == strings.ToLower(delegateAddress) {
-					log.Info("emit ctx " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = allocateMap
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyOrderAllocateChange+EventPostfixRes, string(respJson[:]))
-					log.Info("emit data " + string(respJson))
-				}
-			}
-		}
-		return true
-	})
-
+	resp := SocketIOJsonResp{}
+	resp.Data = allocateMap
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyOrderAllocateChange, key, string(respJson[:]))
 	return nil
 }
 
@@ -1162,33 +1027,15 @@ func (so *SocketIOServiceImpl) handleOrderTracing(input interface{}) (err error)
 	//log.Infof("[SOCKETIO-RECEIVE-EVENT] order hash tracing input")
 
 	req := input.(*types.OrderState)
-	orderHash := req.RawOrder.Hash.Hex()
-	log.Infof("received orderHash is %s ", orderHash)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyOrderTracing]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				query := &OrderQuery{}
-				err = json.Unmarshal([]byte(ctx), query)
-				log.Info("single owner is: " + query.Owner)
-				if err != nil {
-					log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToUpper(orderHash) == strings.ToUpper(query.OrderHash) {
-					log.Info("emit " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = so.walletService.orderStateToJson(*req)
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyOrderTracing+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
+	key := strings.ToLower(req.RawOrder.Hash.Hex())
+	if len(so.subscriptions.Get(eventKeyOrderTracing, key)) == 0 {
+		return nil
+	}
 
+	resp := SocketIOJsonResp{}
+	resp.Data = so.walletService.orderStateToJson(*req)
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyOrderTracing, key, string(respJson[:]))
 	return nil
 }
 
@@ -1208,6 +1055,59 @@ func (so *SocketIOServiceImpl) handleOrderUpdate(input interface{}) (err error)
 	//TODO finish the depth cache.
 	so.broadcastOrderBook(DepthQuery{DelegateAddress: order.RawOrder.DelegateAddress.Hex(), Market: order.RawOrder.Market})
 	so.broadcastDepth(DepthQuery{DelegateAddress: order.RawOrder.DelegateAddress.Hex(), Market: order.RawOrder.Market})
+	so.broadcastDepthDiff(DepthQuery{DelegateAddress: order.RawOrder.DelegateAddress.Hex(), Market: order.RawOrder.Market})
+	return nil
+}
+
+// handleOrdersOfOwnerUpdate pushes orders of an owner updated at once like handleOrderUpdate,
+// but once for every market and delegate of the orders instead of once for every order
+func (so *SocketIOServiceImpl) handleOrdersOfOwnerUpdate(input interface{}) (err error) {
+	event := input.(*kafkaUtil.OrdersUpdatedEvent)
+	pushed := make(map[string]bool)
+	push := func(key string) bool {
+		if pushed[key] {
+			return false
+		}
+		pushed[key] = true
+		return true
+	}
+
+	for i := range event.Orders {
+		order := &event.Orders[i]
+		market, delegateAddress := order.RawOrder.Market, order.RawOrder.DelegateAddress.Hex()
+		if push(eventKeyOrders + market + order.RawOrder.OrderType) {
+			so.handleOrdersUpdate(order)
+		}
+		if push(eventKeyOrderAllocateChange + delegateAddress) {
+			so.handleOrderAllocateChange(order)
+		}
+		if order.RawOrder.OrderType == types.ORDER_TYPE_P2P || !push(eventKeyDepth+delegateAddress+market) {
+			continue
+		}
+		so.broadcastOrderBook(DepthQuery{DelegateAddress: delegateAddress, Market: market})
+		so.broadcastDepth(DepthQuery{DelegateAddress: delegateAddress, Market: market})
+		so.broadcastDepthDiff(DepthQuery{DelegateAddress: delegateAddress, Market: market})
+	}
+	return nil
+}
+
+// handleConditionalOrderUpdate pushes the conditional orders of the owner, an order activated is pushed as orders as well
+func (so *SocketIOServiceImpl) handleConditionalOrderUpdate(input interface{}) (err error) {
+	req := input.(*ConditionalOrderJson)
+	key := strings.ToLower(req.Owner)
+	if len(so.subscriptions.Get(eventKeyConditionalOrders, key)) == 0 {
+		return nil
+	}
+
+	resp := SocketIOJsonResp{}
+	orders, err := so.walletService.GetConditionalOrders(ConditionalOrderQuery{Owner: req.Owner})
+	if err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Data = orders
+	}
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyConditionalOrders, key, string(respJson[:]))
 	return nil
 }
 
@@ -1218,6 +1118,7 @@ func (so *SocketIOServiceImpl) handleCutOff(input interface{}) (err error) {
 	//so.e
 	so.broadcastOrderBook(nil)
 	so.broadcastDepth(nil)
+	so.broadcastDepthDiff(nil)
 	return nil
 }
 
@@ -1231,6 +1132,7 @@ func (so *SocketIOServiceImpl) handleCutOffPair(input interface{}) (err error) {
 	}
 	so.broadcastOrderBook(DepthQuery{DelegateAddress: cutoffPair.DelegateAddress.Hex(), Market: market})
 	so.broadcastDepth(DepthQuery{DelegateAddress: cutoffPair.DelegateAddress.Hex(), Market: market})
+	so.broadcastDepthDiff(DepthQuery{DelegateAddress: cutoffPair.DelegateAddress.Hex(), Market: market})
 	return nil
 }
 
@@ -1241,30 +1143,11 @@ func (so *SocketIOServiceImpl) handleOrderTransfer(input interface{}) (err error
 	ot := input.(*OrderTransfer)
 	ot.Origin = ""
 	log.Infof("received hash is %s ", ot.Hash)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyOrderTransfer]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				query := &OrderTransferQuery{}
-				err = json.Unmarshal([]byte(ctx), query)
-				if err != nil {
-					log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToLower(ot.Hash) == strings.ToLower(query.Hash) {
-					log.Info("emit " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = ot
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyOrderTransfer+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
 
+	resp := SocketIOJsonResp{}
+	resp.Data = ot
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyOrderTransfer, strings.ToLower(ot.Hash), string(respJson[:]))
 	return nil
 }
 
@@ -1274,30 +1157,11 @@ func (so *SocketIOServiceImpl) handleScanLogin(input interface{}) (err error) {
 
 	ot := input.(*LoginInfo)
 	log.Infof("received UUID is %s ", ot.UUID)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyScanLogin]
-			log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				query := &LoginInfo{}
-				err = json.Unmarshal([]byte(ctx), query)
-				if err != nil {
-					log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToLower(ot.UUID) == strings.ToLower(query.UUID) {
-					log.Info("emit " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = ot
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyScanLogin+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
 
+	resp := SocketIOJsonResp{}
+	resp.Data = ot
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyScanLogin, strings.ToLower(ot.UUID), string(respJson[:]))
 	return nil
 }
 
@@ -1305,29 +1169,10 @@ func (so *SocketIOServiceImpl) handleCirculrNotify(input interface{}) (err error
 
 	ot := input.(*NotifyCirculrBody)
 	log.Infof("received owner is %s ", ot.Owner)
-	so.connIdMap.Range(func(key, value interface{}) bool {
-		v := value.(socketio.Conn)
-		if v.Context() != nil {
-			businesses := v.Context().(map[string]string)
-			ctx, ok := businesses[eventKeyCirculrNotify]
-			//log.Infof("cxt contains event key %b", ok)
-
-			if ok {
-				query := &SingleOwner{}
-				err = json.Unmarshal([]byte(ctx), query)
-				if err != nil {
-					log.Error("query unmarshal error, " + err.Error())
-				} else if strings.ToLower(ot.Owner) == strings.ToLower(query.Owner) {
-					log.Info("emit " + ctx)
-					resp := SocketIOJsonResp{}
-					resp.Data = ot
-					respJson, _ := json.Marshal(resp)
-					v.Emit(eventKeyCirculrNotify+EventPostfixRes, string(respJson[:]))
-				}
-			}
-		}
-		return true
-	})
 
+	resp := SocketIOJsonResp{}
+	resp.Data = ot
+	respJson, _ := json.Marshal(resp)
+	so.emitByKey(eventKeyCirculrNotify, strings.ToLower(ot.Owner), string(respJson[:]))
 	return nil
 }
@@ -0,0 +1,150 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/googollee/go-socket.io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a connection proves it owns an address by signing a challenge issued to it, like the timestamp of SignInfo,
+// the owners proven are bound to the connection until it's closed,
+// then it can subscribe the private events of them
+
+const (
+	eventKeyAuthChallenge = "authChallenge"
+	eventKeyAuth          = "auth"
+
+	authChallengeTTL    = 5 * time.Minute
+	authChallengePrefix = "Loopring relay socket authentication "
+)
+
+type AuthChallenge struct {
+	Challenge string `json:"challenge"`
+	ExpireAt  int64  `json:"expireAt"`
+}
+
+type AuthRequest struct {
+	Sign SignInfo `json:"sign"`
+}
+
+type AuthResult struct {
+	Owners []string `json:"owners"`
+}
+
+type connAuth struct {
+	mtx           sync.Mutex
+	challenge     string
+	challengeTime time.Time
+	owners        map[string]bool
+}
+
+func (so *SocketIOServiceImpl) connAuth(connId string) *connAuth {
+	auth, _ := so.auths.LoadOrStore(connId, &connAuth{owners: make(map[string]bool)})
+	return auth.(*connAuth)
+}
+
+// newChallenge issues a challenge to the connection of connId, it replaces the previous one and can be signed once
+func (so *SocketIOServiceImpl) newChallenge(connId string) AuthChallenge {
+	auth := so.connAuth(connId)
+	auth.mtx.Lock()
+	defer auth.mtx.Unlock()
+
+	auth.challenge = authChallengePrefix + randomHex(16)
+	auth.challengeTime = time.Now()
+	return AuthChallenge{Challenge: auth.challenge, ExpireAt: auth.challengeTime.Add(authChallengeTTL).Unix()}
+}
+
+// authenticate binds sign.Owner to the connection of connId if sign is the signature of its challenge,
+// it returns all owners bound to the connection
+func (so *SocketIOServiceImpl) authenticate(connId string, sign SignInfo) (AuthResult, error) {
+	var result AuthResult
+	if !common.IsHexAddress(sign.Owner) {
+		return result, errors.New("owner must be applied")
+	}
+
+	auth := so.connAuth(connId)
+	auth.mtx.Lock()
+	defer auth.mtx.Unlock()
+
+	if len(auth.challenge) == 0 || time.Since(auth.challengeTime) > authChallengeTTL {
+		return result, errors.New("challenge had expired, request a new one")
+	}
+	challenge := auth.challenge
+	auth.challenge = ""
+	if ok, err := verifySignedMessage(challenge, sign); !ok {
+		return result, err
+	}
+
+	auth.owners[strings.ToLower(sign.Owner)] = true
+	for owner := range auth.owners {
+		result.Owners = append(result.Owners, owner)
+	}
+	sort.Strings(result.Owners)
+	return result, nil
+}
+
+// authorize checks the owner in query of a private event type is bound to the connection of connId
+func (so *SocketIOServiceImpl) authorize(connId, eventType, query string) error {
+	if !so.privateEvents[eventType] {
+		return nil
+	}
+
+	q := SingleOwner{}
+	json.Unmarshal([]byte(query), &q)
+	if len(q.Owner) == 0 {
+		return errors.New("owner must be applied")
+	}
+
+	if auth, ok := so.auths.Load(connId); ok {
+		auth := auth.(*connAuth)
+		auth.mtx.Lock()
+		defer auth.mtx.Unlock()
+		if auth.owners[strings.ToLower(q.Owner)] {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s of owner %s requires authentication, sign the challenge of %s and send it by %s first", eventType, q.Owner, eventKeyAuthChallenge, eventKeyAuth)
+}
+
+func (so *SocketIOServiceImpl) handleAuthChallenge(conn socketio.Conn, msg string) {
+	respJson, _ := json.Marshal(SocketIOJsonResp{Data: so.newChallenge(conn.ID())})
+	conn.Emit(eventKeyAuthChallenge+EventPostfixRes, string(respJson[:]))
+}
+
+func (so *SocketIOServiceImpl) handleAuth(conn socketio.Conn, msg string) {
+	resp := SocketIOJsonResp{}
+	req := AuthRequest{}
+	if err := json.Unmarshal([]byte(msg), &req); err != nil {
+		resp.Error = err.Error()
+	} else if result, err := so.authenticate(conn.ID(), req.Sign); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp.Data = result
+	}
+	respJson, _ := json.Marshal(resp)
+	conn.Emit(eventKeyAuth+EventPostfixRes, string(respJson[:]))
+}
@@ -0,0 +1,213 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/Loopring/relay-cluster/gateway/subindex"
+	"github.com/googollee/go-socket.io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// subscriptionKeyFunc returns the key of a subscription query in the subscription index,
+// pushes of the event type are emitted to the subscriptions of the key they are about.
+// queries without the parameters of the key are indexed with an empty key, they are only reached by cron emits
+type subscriptionKeyFunc func(query string) string
+
+func lowerKey(parts ...string) string {
+	for i := range parts {
+		if len(parts[i]) == 0 {
+			return ""
+		}
+		parts[i] = strings.ToLower(parts[i])
+	}
+	return strings.Join(parts, "_")
+}
+
+func upperKey(parts ...string) string {
+	return strings.ToUpper(lowerKey(parts...))
+}
+
+// noKey is the key function of event types pushed to all their subscriptions
+func noKey(query string) string {
+	return ""
+}
+
+func marketKey(query string) string {
+	q := SingleMarket{}
+	json.Unmarshal([]byte(query), &q)
+	return upperKey(q.Market)
+}
+
+func ownerKey(query string) string {
+	q := SingleOwner{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Owner)
+}
+
+func tokenKey(query string) string {
+	q := SingleToken{}
+	json.Unmarshal([]byte(query), &q)
+	return upperKey(q.Token)
+}
+
+func depthSubscriptionKey(query string) string {
+	q := DepthQuery{}
+	if err := json.Unmarshal([]byte(query), &q); err != nil || len(q.DelegateAddress) == 0 || len(q.Market) == 0 {
+		return ""
+	}
+	return depthKey(q)
+}
+
+func fillSubscriptionKey(query string) string {
+	q := FillQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.DelegateAddress, q.Market)
+}
+
+func tickerSourceKey(query string) string {
+	q := TickerRequest{}
+	json.Unmarshal([]byte(query), &q)
+	return q.TickerSource
+}
+
+func trendKey(query string) string {
+	q := TrendQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return upperKey(q.Market, q.Interval)
+}
+
+func currencyKey(query string) string {
+	q := PriceQuoteQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Currency)
+}
+
+func balanceKey(query string) string {
+	q := CommonTokenRequest{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Owner, q.DelegateAddress)
+}
+
+func allocatedKey(query string) string {
+	q := EstimatedAllocatedAllowanceQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Owner, q.DelegateAddress)
+}
+
+func latestOrdersKey(query string) string {
+	q := LatestOrderQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Owner, q.Market, q.OrderType)
+}
+
+func orderHashKey(query string) string {
+	q := OrderQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.OrderHash)
+}
+
+func transferHashKey(query string) string {
+	q := OrderTransferQuery{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.Hash)
+}
+
+func uuidKey(query string) string {
+	q := LoginInfo{}
+	json.Unmarshal([]byte(query), &q)
+	return lowerKey(q.UUID)
+}
+
+var subscriptionKeys = map[string]subscriptionKeyFunc{
+	eventKeyTickers:             marketKey,
+	eventKeyLoopringTickers:     noKey,
+	eventKeyTickersOfSource:     tickerSourceKey,
+	eventKeyTrends:              trendKey,
+	eventKeyMarketCap:           currencyKey,
+	eventKeyDepth:               depthSubscriptionKey,
+	eventKeyDepthDiff:           depthSubscriptionKey,
+	eventKeyOrderBook:           depthSubscriptionKey,
+	eventKeyTrades:              fillSubscriptionKey,
+	eventKeyEstimatedGasPrice:   noKey,
+	eventKeyOrderDifficulty:     noKey,
+	eventKeyReferencePrice:      marketKey,
+	eventKeyBalance:             balanceKey,
+	eventKeyTransaction:         ownerKey,
+	eventKeyLatestTransaction:   ownerKey,
+	eventKeyPendingTx:           ownerKey,
+	eventkeyTransactionStatus:   ownerKey,
+	eventKeyOrders:              latestOrdersKey,
+	eventKeyOrderTracing:        orderHashKey,
+	eventKeyOrderAllocateChange: allocatedKey,
+	eventKeyConditionalOrders:   ownerKey,
+	eventKeyGlobalTicker:        noKey,
+	eventKeyGlobalTrend:         tokenKey,
+	eventKeyGlobalMarketTicker:  tokenKey,
+	eventKeyOrderTransfer:       transferHashKey,
+	eventKeyScanLogin:           uuidKey,
+	eventKeyCirculrNotify:       ownerKey,
+}
+
+func subscriptionKey(eventType, query string) string {
+	if fn, ok := subscriptionKeys[eventType]; ok {
+		return fn(query)
+	}
+	return ""
+}
+
+// emitByKey emits data to the subscriptions of eventType with key
+func (so *SocketIOServiceImpl) emitByKey(eventType, key string, data string) {
+	if len(data) == 0 {
+		return
+	}
+	for _, sub := range so.subscriptions.Get(eventType, key) {
+		sub.Conn.(socketio.Conn).Emit(eventType+EventPostfixRes, data)
+	}
+}
+
+// SocketIOStats are the connections and subscriptions of the socket.io service of a node,
+// which include the ones of the json-rpc websocket
+type SocketIOStats struct {
+	Node        string `json:"node"`
+	Connections int    `json:"connections"`
+	subindex.Stats
+}
+
+func (so *SocketIOServiceImpl) Stats() SocketIOStats {
+	stats := SocketIOStats{Connections: int(so.connectionCount()), Stats: so.subscriptions.Stats()}
+	stats.Node, _ = os.Hostname()
+	return stats
+}
+
+func (so *SocketIOServiceImpl) subscriptionCounts() map[string]float64 {
+	counts := make(map[string]float64)
+	for eventType, topic := range so.subscriptions.Stats().Topics {
+		counts[eventType] = float64(topic.Subscriptions)
+	}
+	return counts
+}
+
+// handleStats serves the stats of the node, load balancers can route new connections by them
+func (so *SocketIOServiceImpl) handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(so.Stats())
+}
@@ -0,0 +1,188 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package subindex indexes the subscriptions of socket.io connections by event type and key,
+// the key is the query parameter pushes are filtered by, e.g. market or owner,
+// so that a push only visits the connections interested in it
+package subindex
+
+import (
+	"sort"
+	"sync"
+)
+
+// Subscription is the subscription of a connection to an event type, Query is its raw query
+type Subscription struct {
+	ConnId string
+	Conn   interface{}
+	Key    string
+	Query  string
+}
+
+// TopicStats are the subscriptions of an event type, Keys is how many distinct keys they have
+type TopicStats struct {
+	Subscriptions int `json:"subscriptions"`
+	Keys          int `json:"keys"`
+}
+
+// Stats are the subscriptions of an index, Connections is how many connections have one at least
+type Stats struct {
+	Connections   int                   `json:"subscribedConnections"`
+	Subscriptions int                   `json:"subscriptions"`
+	Topics        map[string]TopicStats `json:"topics"`
+}
+
+type Index struct {
+	mtx    sync.RWMutex
+	topics map[string]map[string]map[string]*Subscription // event type to key to conn id
+	conns  map[string]map[string]*Subscription            // conn id to event type
+}
+
+func NewIndex() *Index {
+	idx := &Index{}
+	idx.topics = make(map[string]map[string]map[string]*Subscription)
+	idx.conns = make(map[string]map[string]*Subscription)
+	return idx
+}
+
+// Add subscribes conn to eventType with key, the previous subscription of conn to eventType is replaced
+func (idx *Index) Add(eventType, key, connId string, conn interface{}, query string) {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+
+	idx.remove(eventType, connId)
+
+	sub := &Subscription{ConnId: connId, Conn: conn, Key: key, Query: query}
+	keys, ok := idx.topics[eventType]
+	if !ok {
+		keys = make(map[string]map[string]*Subscription)
+		idx.topics[eventType] = keys
+	}
+	subs, ok := keys[key]
+	if !ok {
+		subs = make(map[string]*Subscription)
+		keys[key] = subs
+	}
+	subs[connId] = sub
+
+	connSubs, ok := idx.conns[connId]
+	if !ok {
+		connSubs = make(map[string]*Subscription)
+		idx.conns[connId] = connSubs
+	}
+	connSubs[eventType] = sub
+}
+
+// Remove unsubscribes the connection of connId from eventType
+func (idx *Index) Remove(eventType, connId string) bool {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+	return idx.remove(eventType, connId)
+}
+
+// RemoveConn unsubscribes the connection of connId from all event types and returns how many they are
+func (idx *Index) RemoveConn(connId string) int {
+	idx.mtx.Lock()
+	defer idx.mtx.Unlock()
+
+	removed := 0
+	for eventType := range idx.conns[connId] {
+		if idx.remove(eventType, connId) {
+			removed++
+		}
+	}
+	return removed
+}
+
+func (idx *Index) remove(eventType, connId string) bool {
+	sub, ok := idx.conns[connId][eventType]
+	if !ok {
+		return false
+	}
+
+	delete(idx.conns[connId], eventType)
+	if len(idx.conns[connId]) == 0 {
+		delete(idx.conns, connId)
+	}
+
+	keys := idx.topics[eventType]
+	delete(keys[sub.Key], connId)
+	if len(keys[sub.Key]) == 0 {
+		delete(keys, sub.Key)
+	}
+	if len(keys) == 0 {
+		delete(idx.topics, eventType)
+	}
+	return true
+}
+
+// Get returns the subscriptions to eventType with key
+func (idx *Index) Get(eventType, key string) []Subscription {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	subs := idx.topics[eventType][key]
+	list := make([]Subscription, 0, len(subs))
+	for _, sub := range subs {
+		list = append(list, *sub)
+	}
+	return list
+}
+
+// All returns the subscriptions to eventType of all keys
+func (idx *Index) All(eventType string) []Subscription {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	var list []Subscription
+	for _, subs := range idx.topics[eventType] {
+		for _, sub := range subs {
+			list = append(list, *sub)
+		}
+	}
+	return list
+}
+
+// Keys returns the sorted keys subscribed of eventType
+func (idx *Index) Keys(eventType string) []string {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	keys := make([]string, 0, len(idx.topics[eventType]))
+	for key := range idx.topics[eventType] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (idx *Index) Stats() Stats {
+	idx.mtx.RLock()
+	defer idx.mtx.RUnlock()
+
+	stats := Stats{Connections: len(idx.conns), Topics: make(map[string]TopicStats)}
+	for eventType, keys := range idx.topics {
+		topic := TopicStats{Keys: len(keys)}
+		for _, subs := range keys {
+			topic.Subscriptions += len(subs)
+		}
+		stats.Topics[eventType] = topic
+		stats.Subscriptions += topic.Subscriptions
+	}
+	return stats
+}
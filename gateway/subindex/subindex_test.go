@@ -0,0 +1,121 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package subindex
+
+import (
+	"sort"
+	"testing"
+)
+
+func connIds(subs []Subscription) []string {
+	var ids []string
+	for _, sub := range subs {
+		ids = append(ids, sub.ConnId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func assertConnIds(t *testing.T, subs []Subscription, expected ...string) {
+	got := connIds(subs)
+	if len(got) != len(expected) {
+		t.Fatalf("expected conns %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected conns %v, got %v", expected, got)
+		}
+	}
+}
+
+func TestGetByKey(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("depth", "lrc-weth", "c1", nil, `{"market":"LRC-WETH"}`)
+	idx.Add("depth", "lrc-weth", "c2", nil, `{"market":"lrc-weth"}`)
+	idx.Add("depth", "vite-weth", "c3", nil, `{"market":"VITE-WETH"}`)
+	idx.Add("trades", "lrc-weth", "c1", nil, `{"market":"LRC-WETH"}`)
+
+	assertConnIds(t, idx.Get("depth", "lrc-weth"), "c1", "c2")
+	assertConnIds(t, idx.Get("depth", "vite-weth"), "c3")
+	assertConnIds(t, idx.Get("depth", "eos-weth"))
+	assertConnIds(t, idx.All("depth"), "c1", "c2", "c3")
+
+	keys := idx.Keys("depth")
+	if len(keys) != 2 || keys[0] != "lrc-weth" || keys[1] != "vite-weth" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if sub := idx.Get("trades", "lrc-weth")[0]; sub.Query != `{"market":"LRC-WETH"}` {
+		t.Fatalf("unexpected query %s", sub.Query)
+	}
+}
+
+func TestAddReplacesSubscription(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("depth", "lrc-weth", "c1", nil, "")
+	idx.Add("depth", "vite-weth", "c1", nil, "")
+
+	assertConnIds(t, idx.Get("depth", "lrc-weth"))
+	assertConnIds(t, idx.Get("depth", "vite-weth"), "c1")
+	if keys := idx.Keys("depth"); len(keys) != 1 {
+		t.Fatalf("expected the empty key removed, got %v", keys)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("depth", "lrc-weth", "c1", nil, "")
+	idx.Add("trades", "lrc-weth", "c1", nil, "")
+	idx.Add("balance", "owner", "c1", nil, "")
+	idx.Add("depth", "lrc-weth", "c2", nil, "")
+
+	if !idx.Remove("depth", "c1") || idx.Remove("depth", "c1") {
+		t.Fatalf("expected removed only once")
+	}
+	assertConnIds(t, idx.Get("depth", "lrc-weth"), "c2")
+
+	if removed := idx.RemoveConn("c1"); removed != 2 {
+		t.Fatalf("expected 2 subscriptions removed, got %d", removed)
+	}
+	assertConnIds(t, idx.All("trades"))
+	assertConnIds(t, idx.All("balance"))
+
+	stats := idx.Stats()
+	if stats.Connections != 1 || stats.Subscriptions != 1 || len(stats.Topics) != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestStats(t *testing.T) {
+	idx := NewIndex()
+	idx.Add("depth", "lrc-weth", "c1", nil, "")
+	idx.Add("depth", "vite-weth", "c2", nil, "")
+	idx.Add("depth", "vite-weth", "c3", nil, "")
+	idx.Add("trades", "lrc-weth", "c1", nil, "")
+
+	stats := idx.Stats()
+	if stats.Connections != 3 || stats.Subscriptions != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if depth := stats.Topics["depth"]; depth.Subscriptions != 3 || depth.Keys != 2 {
+		t.Fatalf("unexpected depth stats %+v", depth)
+	}
+	if trades := stats.Topics["trades"]; trades.Subscriptions != 1 || trades.Keys != 1 {
+		t.Fatalf("unexpected trades stats %+v", trades)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package trigger decides when a conditional order is activated by the price of its market
+package trigger
+
+import (
+	"errors"
+	"strconv"
+
+	util "github.com/Loopring/relay-lib/marketutil"
+)
+
+const (
+	TypeStopLoss   = "stopLoss"
+	TypeTakeProfit = "takeProfit"
+
+	SourceLast      = "last"      // last price of the loopring ticker
+	SourceReference = "reference" // reference price of the collected exchange tickers
+)
+
+// Condition is when an order of Side is activated, Price is quoted in the market like the tickers.
+// a stop loss activates an order when the price moves against the position of it, a take profit when it moves in favor
+type Condition struct {
+	Type   string
+	Side   string
+	Price  float64
+	Source string
+}
+
+// ParseCondition validates the trigger of an order of side, source is SourceLast if it's empty
+func ParseCondition(triggerType, side, price, source string) (Condition, error) {
+	c := Condition{Type: triggerType, Side: side, Source: source}
+	if c.Type != TypeStopLoss && c.Type != TypeTakeProfit {
+		return c, errors.New("trigger type should be " + TypeStopLoss + " or " + TypeTakeProfit)
+	}
+	if c.Side != util.SideBuy && c.Side != util.SideSell {
+		return c, errors.New("side should be " + util.SideBuy + " or " + util.SideSell)
+	}
+	if len(c.Source) == 0 {
+		c.Source = SourceLast
+	}
+	if c.Source != SourceLast && c.Source != SourceReference {
+		return c, errors.New("price source should be " + SourceLast + " or " + SourceReference)
+	}
+	p, err := strconv.ParseFloat(price, 64)
+	if err != nil || p <= 0 {
+		return c, errors.New("trigger price should be a positive number")
+	}
+	c.Price = p
+	return c, nil
+}
+
+// Triggered reports whether price meets the condition, a price not above zero is unknown and never meets it.
+// a sell is stopped when the price falls to Price and takes profit when it rises to Price, a buy the other way round
+func (c Condition) Triggered(price float64) bool {
+	if price <= 0 {
+		return false
+	}
+	falling := (c.Side == util.SideSell) == (c.Type == TypeStopLoss)
+	if falling {
+		return price <= c.Price
+	}
+	return price >= c.Price
+}
@@ -0,0 +1,73 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package trigger
+
+import (
+	"testing"
+
+	util "github.com/Loopring/relay-lib/marketutil"
+)
+
+func TestParseCondition(t *testing.T) {
+	c, err := ParseCondition(TypeStopLoss, util.SideSell, "0.0012", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Price != 0.0012 || c.Source != SourceLast {
+		t.Fatalf("unexpected condition %+v", c)
+	}
+
+	invalids := [][4]string{
+		{"stop", util.SideSell, "0.0012", SourceLast},
+		{TypeTakeProfit, "short", "0.0012", SourceLast},
+		{TypeTakeProfit, util.SideBuy, "0", SourceLast},
+		{TypeTakeProfit, util.SideBuy, "abc", SourceLast},
+		{TypeTakeProfit, util.SideBuy, "0.0012", "binance"},
+	}
+	for _, v := range invalids {
+		if _, err := ParseCondition(v[0], v[1], v[2], v[3]); err == nil {
+			t.Errorf("condition %v should be invalid", v)
+		}
+	}
+}
+
+func TestTriggered(t *testing.T) {
+	cases := []struct {
+		triggerType, side string
+		price             float64
+		triggered         bool
+	}{
+		{TypeStopLoss, util.SideSell, 0.0009, true},
+		{TypeStopLoss, util.SideSell, 0.001, true},
+		{TypeStopLoss, util.SideSell, 0.0012, false},
+		{TypeTakeProfit, util.SideSell, 0.0012, true},
+		{TypeTakeProfit, util.SideSell, 0.0009, false},
+		{TypeStopLoss, util.SideBuy, 0.0012, true},
+		{TypeStopLoss, util.SideBuy, 0.0009, false},
+		{TypeTakeProfit, util.SideBuy, 0.0009, true},
+		{TypeTakeProfit, util.SideBuy, 0.0012, false},
+		{TypeStopLoss, util.SideSell, 0, false},
+	}
+	for _, v := range cases {
+		c := Condition{Type: v.triggerType, Side: v.side, Price: 0.001, Source: SourceLast}
+		if got := c.Triggered(v.price); got != v.triggered {
+			t.Errorf("%s %s at %f, triggered %t, expected %t", v.triggerType, v.side, v.price, got, v.triggered)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package typeddata
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// typeddata hashes the typed structured data of EIP-712, only structs of atomic and dynamic fields are supported:
+// string, bytes, bytes32, address, bool, uint8 ~ uint256 and dynamic arrays of them
+
+type Field struct {
+	Name string
+	Type string
+}
+
+type Type struct {
+	Name   string
+	Fields []Field
+}
+
+// EncodeType returns the type signature, such as "Mail(address from,address to,string contents)"
+func (t Type) EncodeType() string {
+	fields := make([]string, len(t.Fields))
+	for i, field := range t.Fields {
+		fields[i] = field.Type + " " + field.Name
+	}
+	return t.Name + "(" + strings.Join(fields, ",") + ")"
+}
+
+func (t Type) TypeHash() common.Hash {
+	return crypto.Keccak256Hash([]byte(t.EncodeType()))
+}
+
+// HashStruct returns keccak256(typeHash ‖ encodeData(message)), every field of t must be in message
+func (t Type) HashStruct(message map[string]interface{}) (common.Hash, error) {
+	data := make([]byte, 0, 32*(len(t.Fields)+1))
+	data = append(data, t.TypeHash().Bytes()...)
+	for _, field := range t.Fields {
+		value, ok := message[field.Name]
+		if !ok {
+			return common.Hash{}, fmt.Errorf("%s.%s is missing", t.Name, field.Name)
+		}
+		encoded, err := encodeValue(field.Type, value)
+		if nil != err {
+			return common.Hash{}, fmt.Errorf("%s.%s: %s", t.Name, field.Name, err.Error())
+		}
+		data = append(data, encoded...)
+	}
+	return crypto.Keccak256Hash(data), nil
+}
+
+// Domain is the EIP712Domain, VerifyingContract is left out of the type if it's empty
+type Domain struct {
+	Name              string
+	Version           string
+	ChainId           *big.Int
+	VerifyingContract string
+}
+
+func (d Domain) Type() Type {
+	t := Type{Name: "EIP712Domain", Fields: []Field{
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+	}}
+	if len(d.VerifyingContract) > 0 {
+		t.Fields = append(t.Fields, Field{Name: "verifyingContract", Type: "address"})
+	}
+	return t
+}
+
+func (d Domain) Separator() (common.Hash, error) {
+	message := map[string]interface{}{
+		"name":    d.Name,
+		"version": d.Version,
+		"chainId": d.ChainId,
+	}
+	if len(d.VerifyingContract) > 0 {
+		message["verifyingContract"] = d.VerifyingContract
+	}
+	return d.Type().HashStruct(message)
+}
+
+// Hash returns the digest to be signed, keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message))
+func Hash(domain Domain, t Type, message map[string]interface{}) (common.Hash, error) {
+	separator, err := domain.Separator()
+	if nil != err {
+		return common.Hash{}, err
+	}
+	structHash, err := t.HashStruct(message)
+	if nil != err {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash([]byte{0x19, 0x01}, separator.Bytes(), structHash.Bytes()), nil
+}
+
+func encodeValue(typ string, value interface{}) ([]byte, error) {
+	switch {
+	case strings.HasSuffix(typ, "[]"):
+		// an array is encoded as keccak256 of the concatenated encodings of its elements
+		items := reflect.ValueOf(value)
+		if items.Kind() != reflect.Slice {
+			return nil, errors.New("array expected")
+		}
+		data := make([]byte, 0, 32*items.Len())
+		for i := 0; i < items.Len(); i++ {
+			encoded, err := encodeValue(strings.TrimSuffix(typ, "[]"), items.Index(i).Interface())
+			if nil != err {
+				return nil, fmt.Errorf("[%d] %s", i, err.Error())
+			}
+			data = append(data, encoded...)
+		}
+		return crypto.Keccak256(data), nil
+	case typ == "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("string expected")
+		}
+		return crypto.Keccak256([]byte(s)), nil
+	case typ == "bytes":
+		b, err := toBytes(value)
+		if nil != err {
+			return nil, err
+		}
+		return crypto.Keccak256(b), nil
+	case typ == "bytes32":
+		switch v := value.(type) {
+		case common.Hash:
+			return v.Bytes(), nil
+		case string:
+			b, err := toBytes(v)
+			if nil != err {
+				return nil, err
+			}
+			if len(b) != common.HashLength {
+				return nil, errors.New("32 bytes expected")
+			}
+			return b, nil
+		}
+		return nil, errors.New("bytes32 expected")
+	case typ == "address":
+		switch v := value.(type) {
+		case common.Address:
+			return common.LeftPadBytes(v.Bytes(), 32), nil
+		case string:
+			if !common.IsHexAddress(v) {
+				return nil, errors.New("invalid address")
+			}
+			return common.LeftPadBytes(common.HexToAddress(v).Bytes(), 32), nil
+		}
+		return nil, errors.New("address expected")
+	case typ == "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("bool expected")
+		}
+		if b {
+			return math.PaddedBigBytes(big.NewInt(1), 32), nil
+		}
+		return make([]byte, 32), nil
+	case strings.HasPrefix(typ, "uint"):
+		n, err := toBigInt(value)
+		if nil != err {
+			return nil, err
+		}
+		bits := 256
+		if len(typ) > 4 {
+			if _, err := fmt.Sscanf(typ[4:], "%d", &bits); nil != err || bits <= 0 || bits > 256 || bits%8 != 0 {
+				return nil, fmt.Errorf("unsupported type %s", typ)
+			}
+		}
+		if n.Sign() < 0 || n.BitLen() > bits {
+			return nil, fmt.Errorf("%s overflows %s", n.String(), typ)
+		}
+		return math.PaddedBigBytes(n, 32), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
+
+func toBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		if !strings.HasPrefix(v, "0x") && !strings.HasPrefix(v, "0X") {
+			return nil, errors.New("hex string expected")
+		}
+		return common.FromHex(v), nil
+	}
+	return nil, errors.New("bytes expected")
+}
+
+func toBigInt(value interface{}) (*big.Int, error) {
+	switch v := value.(type) {
+	case *big.Int:
+		if nil == v {
+			return nil, errors.New("integer expected")
+		}
+		return v, nil
+	case int64:
+		return big.NewInt(v), nil
+	case int:
+		return big.NewInt(int64(v)), nil
+	case uint8:
+		return new(big.Int).SetUint64(uint64(v)), nil
+	case uint64:
+		return new(big.Int).SetUint64(v), nil
+	case string:
+		n, ok := new(big.Int).SetString(v, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %s", v)
+		}
+		return n, nil
+	}
+	return nil, errors.New("integer expected")
+}
@@ -0,0 +1,117 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package typeddata
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// the example of EIP-712
+func TestDomainSeparator(t *testing.T) {
+	domain := Domain{Name: "Ether Mail", Version: "1", ChainId: big.NewInt(1), VerifyingContract: "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"}
+	if encoded := domain.Type().EncodeType(); encoded != "EIP712Domain(string name,string version,uint256 chainId,address verifyingContract)" {
+		t.Fatalf("unexpected domain type %s", encoded)
+	}
+	separator, err := domain.Separator()
+	if nil != err {
+		t.Fatal(err)
+	}
+	if separator.Hex() != "0xf2cee375fa42b42143804025fc449deafd50cc031ca257e0b194a650a912090f" {
+		t.Fatalf("unexpected domain separator %s", separator.Hex())
+	}
+}
+
+func TestHashStruct(t *testing.T) {
+	person := Type{Name: "Person", Fields: []Field{{Name: "name", Type: "string"}, {Name: "wallet", Type: "address"}}}
+	hash, err := person.HashStruct(map[string]interface{}{"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"})
+	if nil != err {
+		t.Fatal(err)
+	}
+	if hash.Hex() != "0xfc71e5fa27ff56c350aa531bc129ebdf613b772b6604664f5d8dbe21b85eb0c8" {
+		t.Fatalf("unexpected struct hash %s", hash.Hex())
+	}
+	if _, err := person.HashStruct(map[string]interface{}{"name": "Cow"}); nil == err {
+		t.Fatalf("expected an error of a missing field")
+	}
+}
+
+func TestEncodeValueErrors(t *testing.T) {
+	for _, c := range []struct {
+		typ   string
+		value interface{}
+	}{
+		{"uint8", 256},
+		{"uint256", -1},
+		{"address", "0x01"},
+		{"bytes32", "0x01"},
+		{"string", 1},
+		{"int256", 1},
+		{"bytes32[]", "0x01"},
+		{"address[]", []string{"0x01"}},
+	} {
+		if _, err := encodeValue(c.typ, c.value); nil == err {
+			t.Fatalf("expected an error of %s %v", c.typ, c.value)
+		}
+	}
+}
+
+func TestSignAndRecover(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	owner := crypto.PubkeyToAddress(key.PublicKey)
+	action := Type{Name: "FlexCancelOrder", Fields: []Field{
+		{Name: "owner", Type: "address"},
+		{Name: "type", Type: "uint8"},
+		{Name: "orderHash", Type: "bytes32"},
+	}}
+	domain := Domain{Name: "Loopring Relay", Version: "1", ChainId: big.NewInt(1)}
+	message := map[string]interface{}{"owner": owner, "type": uint8(1), "orderHash": common.HexToHash("0x01")}
+
+	digest, err := Hash(domain, action, message)
+	if nil != err {
+		t.Fatal(err)
+	}
+	sig, _ := crypto.Sign(digest.Bytes(), key)
+	pub, err := crypto.SigToPub(digest.Bytes(), sig)
+	if nil != err || crypto.PubkeyToAddress(*pub) != owner {
+		t.Fatalf("recovered another signer, %v", err)
+	}
+
+	message["type"] = uint8(2)
+	if other, _ := Hash(domain, action, message); other == digest {
+		t.Fatalf("expected another digest of other params")
+	}
+}
+
+func TestEncodeArray(t *testing.T) {
+	hashes := []common.Hash{common.HexToHash("0x01"), common.HexToHash("0x02")}
+	encoded, err := encodeValue("bytes32[]", hashes)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected := crypto.Keccak256(hashes[0].Bytes(), hashes[1].Bytes()); common.BytesToHash(encoded) != common.BytesToHash(expected) {
+		t.Fatalf("unexpected encoded array %x", encoded)
+	}
+	if empty, _ := encodeValue("bytes32[]", []common.Hash{}); common.BytesToHash(empty) != crypto.Keccak256Hash() {
+		t.Fatalf("unexpected encoded empty array %x", empty)
+	}
+}
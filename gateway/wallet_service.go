@@ -24,8 +24,11 @@ import (
 	"fmt"
 	"github.com/Loopring/relay-cluster/accountmanager"
 	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/gateway/order_difficulty"
 	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-cluster/market/orderbook"
 	"github.com/Loopring/relay-cluster/ordermanager/manager"
+	"github.com/Loopring/relay-cluster/ordermanager/p2p"
 	"github.com/Loopring/relay-cluster/ordermanager/viewer"
 	txtyp "github.com/Loopring/relay-cluster/txmanager/types"
 	txmanager "github.com/Loopring/relay-cluster/txmanager/viewer"
@@ -36,6 +39,7 @@ import (
 	"github.com/Loopring/relay-lib/eth/gasprice_evaluator"
 	"github.com/Loopring/relay-lib/eth/loopringaccessor"
 	ethtyp "github.com/Loopring/relay-lib/eth/types"
+	"github.com/Loopring/relay-lib/eventemitter"
 	"github.com/Loopring/relay-lib/kafka"
 	"github.com/Loopring/relay-lib/log"
 	"github.com/Loopring/relay-lib/marketcap"
@@ -74,12 +78,6 @@ const TS_REDIS_PRE_KEY = "tsrpk_"
 const DEPTH_MAX_BUY = "depth_max_buy_"
 const DEPTH_MIN_SELL = "depth_min_sell_"
 
-var depthTheshHold = map[string]float64{
-	"LRC":  100.0,
-	"VITE": 200.0,
-	"WETH": 0.05,
-}
-
 type Portfolio struct {
 	Token      string `json:"token"`
 	Amount     string `json:"amount"`
@@ -96,6 +94,7 @@ type PageResult struct {
 type Depth struct {
 	DelegateAddress string `json:"delegateAddress"`
 	Market          string `json:"market"`
+	Precision       int    `json:"precision"`
 	Depth           AskBid `json:"depth"`
 }
 
@@ -254,9 +253,11 @@ type OrderQuery struct {
 	OrderType       string   `json:"orderType"`
 }
 
+// DepthQuery applies no Precision for the default one of the market, 0 is a precision like the others
 type DepthQuery struct {
 	DelegateAddress string `json:"delegateAddress"`
 	Market          string `json:"market"`
+	Precision       *int   `json:"precision,omitempty"`
 }
 
 type FillQuery struct {
@@ -384,12 +385,48 @@ type CancelOrderQuery struct {
 	Type       uint8    `json:"type"`
 }
 
+type CancelOrdersQuery struct {
+	Sign        SignInfo `json:"sign"`
+	OrderHashes []string `json:"orderHashes"`
+}
+
+// BatchItemResult is the result of an order of loopring_submitOrders or loopring_flexCancelOrders
+type BatchItemResult struct {
+	OrderHash string `json:"orderHash"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ConditionalOrderRequest is an order activated when the price of its market reaches TriggerPrice,
+// PriceSource is "last" of loopring tickers or "reference" of exchange tickers
+type ConditionalOrderRequest struct {
+	Sign         SignInfo                `json:"sign"`
+	Order        *types.OrderJsonRequest `json:"order"`
+	TriggerType  string                  `json:"triggerType"`
+	TriggerPrice string                  `json:"triggerPrice"`
+	PriceSource  string                  `json:"priceSource"`
+}
+
+type ConditionalOrderQuery struct {
+	Owner     string `json:"owner"`
+	OrderHash string `json:"orderHash"`
+	Market    string `json:"market"`
+	Status    string `json:"status"`
+	PageIndex int    `json:"pageIndex"`
+	PageSize  int    `json:"pageSize"`
+}
+
+type CancelConditionalOrderQuery struct {
+	Sign      SignInfo `json:"sign"`
+	OrderHash string   `json:"orderHash"`
+}
+
 type SignInfo struct {
 	Timestamp string `json:"timestamp"`
 	V         uint8  `json:"v"`
 	R         string `json:"r"`
 	S         string `json:"s"`
 	Owner     string `json:"owner"`
+	Nonce     string `json:"nonce"` // bytes32, the signature is of the typed data of the action if it's not empty
 }
 
 type Ticket struct {
@@ -419,6 +456,23 @@ type P2PRingRequest struct {
 	MakerOrderHash string `json:"makerOrderHash"`
 }
 
+// P2PRelationQuery filters p2p relations, by the maker order or by owners of makers and takers
+type P2PRelationQuery struct {
+	MakerOrderHash string `json:"makerOrderHash"`
+	MakerOwner     string `json:"makerOwner"`
+	TakerOwner     string `json:"takerOwner"`
+	PageIndex      int    `json:"pageIndex"`
+	PageSize       int    `json:"pageSize"`
+}
+
+// P2PPendingAmount is the amountS of a maker held by rings submitted but not settled yet
+type P2PPendingAmount struct {
+	MakerOrderHash  string `json:"makerOrderHash"`
+	PendingAmount   string `json:"pendingAmount"`
+	AvailableAmount string `json:"availableAmount"`
+	Takers          int    `json:"takers"`
+}
+
 type AddTokenReq struct {
 	Owner                string `json:"owner"`
 	TokenContractAddress string `json:"tokenContractAddress"`
@@ -434,13 +488,18 @@ type WalletServiceImpl struct {
 	tickerCollector market.CollectorImpl
 	tickerManager   market.GetTickerImpl
 	globalMarket    market.GlobalMarket
+	orderBook       *orderbook.OrderBook
+	depthPrecisions depthPrecisions
 	rds             *dao.RdsService
 	oldWethAddress  string
 	localCache      *localcache.Cache
+
+	orderFilledEventWatcher *eventemitter.Watcher
 }
 
 func NewWalletService(trendManager market.TrendManager, orderViewer viewer.OrderViewer, accountManager accountmanager.AccountManager,
-	capProvider marketcap.MarketCapProvider, collector market.CollectorImpl, tickerManager market.GetTickerImpl, rds *dao.RdsService, oldWethAddress string, globalMarket market.GlobalMarket) *WalletServiceImpl {
+	capProvider marketcap.MarketCapProvider, collector market.CollectorImpl, tickerManager market.GetTickerImpl, rds *dao.RdsService, oldWethAddress string, globalMarket market.GlobalMarket,
+	orderBook *orderbook.OrderBook, marketFile string) *WalletServiceImpl {
 	w := &WalletServiceImpl{}
 	w.trendManager = trendManager
 	w.orderViewer = orderViewer
@@ -451,6 +510,12 @@ func NewWalletService(trendManager market.TrendManager, orderViewer viewer.Order
 	w.rds = rds
 	w.oldWethAddress = oldWethAddress
 	w.globalMarket = globalMarket
+	w.orderBook = orderBook
+	precisions, err := loadDepthPrecisions(marketFile)
+	if err != nil {
+		log.Fatalf("wallet service,load depth precisions from %s error:%s", marketFile, err.Error())
+	}
+	w.depthPrecisions = precisions
 	w.localCache = localcache.New(1*time.Hour, 1*time.Hour)
 	return w
 }
@@ -599,6 +664,15 @@ func (w *WalletServiceImpl) GetTickers(mkt SingleMarket) (result map[string]mark
 	return result, nil
 }
 
+// GetReferencePrice returns the price of market weighted by volume of other exchanges,
+// stale and outlying tickers are dropped
+func (w *WalletServiceImpl) GetReferencePrice(mkt SingleMarket) (result market.ReferencePrice, err error) {
+	if len(mkt.Market) == 0 {
+		return result, errors.New("market can't be null string")
+	}
+	return w.tickerCollector.GetReferencePrice(strings.ToUpper(mkt.Market))
+}
+
 func (w *WalletServiceImpl) UnlockWallet(owner SingleOwner) (result string, err error) {
 	if len(owner.Owner) == 0 {
 		return "", errors.New("owner can't be null string")
@@ -693,6 +767,33 @@ func (w *WalletServiceImpl) SubmitOrder(order *types.OrderJsonRequest) (res stri
 	return HandleInputOrder(types.ToOrder(order))
 }
 
+// SubmitOrders submits orders at once, it returns a result for every order in the order of submission
+func (w *WalletServiceImpl) SubmitOrders(orders []*types.OrderJsonRequest) (res []BatchItemResult, err error) {
+	inputs := make([]*types.Order, len(orders))
+	for i, order := range orders {
+		if order.OrderType != types.ORDER_TYPE_MARKET && order.OrderType != types.ORDER_TYPE_P2P {
+			order.OrderType = types.ORDER_TYPE_MARKET
+		}
+		if order.OrderType == types.ORDER_TYPE_P2P {
+			order.P2PSide = types.P2P_SIDE_MAKER
+		}
+		inputs[i] = types.ToOrder(order)
+	}
+
+	orderHashes, errs, err := HandleInputOrders(inputs)
+	if err != nil {
+		return res, err
+	}
+	res = make([]BatchItemResult, len(orderHashes))
+	for i, orderHash := range orderHashes {
+		res[i].OrderHash = orderHash
+		if errs[i] != nil {
+			res[i].Error = errs[i].Error()
+		}
+	}
+	return res, nil
+}
+
 func (w *WalletServiceImpl) GetOrders(query *OrderQuery) (res PageResult, err error) {
 	orderQuery, statusList, pi, ps := convertFromQuery(query)
 	src, err := w.orderViewer.GetOrders(orderQuery, statusList, pi, ps)
@@ -794,18 +895,86 @@ func (w *WalletServiceImpl) SubmitRingForP2P(p2pRing P2PRingRequest) (res string
 		}
 	}
 
-	var txHashRst string
-	err = accessor.SendRawTransaction(&txHashRst, p2pRing.RawTx)
+	// the relation is saved before the ring is broadcast, so the pending amount is held by the time another taker comes
+	txHash, err := p2p.TxHash(p2pRing.RawTx)
 	if err != nil {
 		return res, err
 	}
+	relations := w.p2pRelations()
+	if err := relations.Save(taker.RawOrder.Owner.Hex(), taker.RawOrder.Hash.Hex(), maker.RawOrder.Owner.Hex(), maker.RawOrder.Hash.Hex(), txHash, taker.RawOrder.AmountB.String(), maker.RawOrder.ValidUntil.String()); err != nil {
+		return res, errors.New(SYS_10001)
+	}
 
-	err = manager.SaveP2POrderRelation(taker.RawOrder.Owner.Hex(), taker.RawOrder.Hash.Hex(), maker.RawOrder.Owner.Hex(), maker.RawOrder.Hash.Hex(), txHashRst, taker.RawOrder.AmountB.String(), maker.RawOrder.ValidUntil.String())
+	var txHashRst string
+	if err := accessor.SendRawTransaction(&txHashRst, p2pRing.RawTx); err != nil {
+		if releaseErr := relations.Release(txHash, dao.P2pRelationFailed, nil); releaseErr != nil {
+			log.Errorf("p2p order SubmitRingForP2P, release relation of tx:%s error:%s", txHash, releaseErr.Error())
+		}
+		return res, err
+	}
+	if !strings.EqualFold(txHashRst, txHash) {
+		log.Errorf("p2p order SubmitRingForP2P, tx hash:%s of the node differs from the relation of tx:%s", txHashRst, txHash)
+	}
+
+	return txHash, nil
+}
+
+// GetP2PTakers returns takers of a maker order whose rings are pending, latest first
+func (w *WalletServiceImpl) GetP2PTakers(query P2PRelationQuery) (res dao.PageResult, err error) {
+	if len(query.MakerOrderHash) == 0 {
+		return res, errors.New("makerOrderHash can't be empty")
+	}
+	return w.p2pRelations().Takers(p2pQuery(query))
+}
+
+// GetP2PPendingAmount returns the amount of a maker order held by pending rings and the amount left to take
+func (w *WalletServiceImpl) GetP2PPendingAmount(query P2PRelationQuery) (res P2PPendingAmount, err error) {
+	maker, err := w.orderViewer.GetOrderByHash(common.HexToHash(query.MakerOrderHash))
+	if err != nil {
+		return res, errors.New(P2P_50001)
+	}
+	pendingAmount, takers, err := w.p2pRelations().PendingAmount(maker.RawOrder.Hash.Hex())
 	if err != nil {
 		return res, errors.New(SYS_10001)
 	}
 
-	return txHashRst, nil
+	remainedAmountS, _ := maker.RemainedAmount()
+	availableAmount := new(big.Rat).Sub(remainedAmountS, pendingAmount)
+	if availableAmount.Sign() < 0 {
+		availableAmount.SetInt64(0)
+	}
+	res.MakerOrderHash = maker.RawOrder.Hash.Hex()
+	res.PendingAmount = pendingAmount.FloatString(0)
+	res.AvailableAmount = availableAmount.FloatString(0)
+	res.Takers = takers
+	return res, nil
+}
+
+// GetP2PHistory returns rings of p2p orders which are settled, failed or expired, latest first
+func (w *WalletServiceImpl) GetP2PHistory(query P2PRelationQuery) (res dao.PageResult, err error) {
+	if len(query.MakerOrderHash) == 0 && len(query.MakerOwner) == 0 && len(query.TakerOwner) == 0 {
+		return res, errors.New("makerOrderHash, makerOwner and takerOwner can't be all empty")
+	}
+	return w.p2pRelations().History(p2pQuery(query))
+}
+
+func (w *WalletServiceImpl) p2pRelations() *p2p.Relations {
+	return p2p.NewRelations(w.rds, p2p.RedisCache{})
+}
+
+// p2pQuery normalizes the hash and the owners of query, relations are matched in lower case
+func p2pQuery(query P2PRelationQuery) p2p.Query {
+	q := p2p.Query{PageIndex: query.PageIndex, PageSize: query.PageSize}
+	if len(query.MakerOrderHash) > 0 {
+		q.MakerOrderHash = common.HexToHash(query.MakerOrderHash).Hex()
+	}
+	if len(query.MakerOwner) > 0 {
+		q.MakerOwner = common.HexToAddress(query.MakerOwner).Hex()
+	}
+	if len(query.TakerOwner) > 0 {
+		q.TakerOwner = common.HexToAddress(query.TakerOwner).Hex()
+	}
+	return q
 }
 
 func (w *WalletServiceImpl) GetLatestOrders(query LatestOrderQuery) (res []OrderJsonResult, err error) {
@@ -838,7 +1007,12 @@ func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 		return res, errors.New("depth market is nil")
 	}
 
-	cacheKey := "depth_rst_cache_" + strings.ToLower(query.Market)
+	precision, err := w.depthPrecisions.precision(query.Market, query.Precision)
+	if err != nil {
+		return res, err
+	}
+
+	cacheKey := "depth_rst_cache_" + strings.ToLower(query.Market) + "_" + strconv.Itoa(precision)
 	depthByte, ok := w.localCache.Get(cacheKey)
 	if ok {
 		depthRst := depthByte.(Depth)
@@ -861,9 +1035,9 @@ func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 		empty[i] = make([]string, 0)
 	}
 	askBid := AskBid{Buy: empty, Sell: empty}
-	depth := Depth{DelegateAddress: delegateAddress, Market: mkt, Depth: askBid}
-	depth.Depth.Sell = w.calculateDepth(asks, defaultDepthLength, true, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
-	depth.Depth.Buy = w.calculateDepth(bids, defaultDepthLength, false, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
+	depth := Depth{DelegateAddress: delegateAddress, Market: mkt, Precision: precision, Depth: askBid}
+	depth.Depth.Sell = w.calculateDepth(asks, defaultDepthLength, true, precision, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
+	depth.Depth.Buy = w.calculateDepth(bids, defaultDepthLength, false, precision, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
 
 	if len(depth.Depth.Sell) > 0 && len(depth.Depth.Buy) > 0 {
 
@@ -872,10 +1046,10 @@ func (w *WalletServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 		w.localCache.Set(DEPTH_MAX_BUY+strings.ToUpper(depth.Market), maxBuy, 1*time.Hour)
 		w.localCache.Set(DEPTH_MIN_SELL+strings.ToUpper(depth.Market), minSell, 1*time.Hour)
 		crossRemoved := w.removeCross(depth)
-		w.localCache.Set("depth_rst_cache_"+strings.ToLower(query.Market), crossRemoved, 5*time.Second)
+		w.localCache.Set(cacheKey, crossRemoved, 5*time.Second)
 		return crossRemoved, err
 	} else {
-		w.localCache.Set("depth_rst_cache_"+strings.ToLower(query.Market), depth, 5*time.Second)
+		w.localCache.Set(cacheKey, depth, 5*time.Second)
 		return depth, err
 	}
 }
@@ -884,7 +1058,7 @@ func (w *WalletServiceImpl) removeCross(depth Depth) Depth {
 	if len(depth.Depth.Buy) == 0 || len(depth.Depth.Sell) == 0 {
 		return depth
 	}
-	rst := Depth{Market: depth.Market, DelegateAddress: depth.DelegateAddress}
+	rst := Depth{Market: depth.Market, DelegateAddress: depth.DelegateAddress, Precision: depth.Precision}
 	//maxBuy, _ := strconv.ParseFloat(depth.Depth.Buy[0][0], 64)
 	//minSell, _ := strconv.ParseFloat(depth.Depth.Sell[len(depth.Depth.Sell) - 1][0], 64)
 
@@ -900,7 +1074,7 @@ func (w *WalletServiceImpl) removeCross(depth Depth) Depth {
 	for _, v := range depth.Depth.Buy {
 		//buy, _ := strconv.ParseFloat(v[0], 64)
 		//if buy < minSell && checkDepthThreshHold(depth.Market, v[0], v[1]) {
-		if checkDepthThreshHold(depth.Market, v[1], v[2]) {
+		if !w.depthPrecisions.isDust(depth.Market, v[1], v[2]) {
 			newBuy = append(newBuy, v)
 		}
 	}
@@ -908,7 +1082,7 @@ func (w *WalletServiceImpl) removeCross(depth Depth) Depth {
 	for _, vv := range depth.Depth.Sell {
 		//sell, _ := strconv.ParseFloat(vv[0], 64)
 		//if sell > maxBuy && checkDepthThreshHold(depth.Market, vv[0], vv[1]) {
-		if checkDepthThreshHold(depth.Market, vv[1], vv[2]) {
+		if !w.depthPrecisions.isDust(depth.Market, vv[1], vv[2]) {
 			newSell = append(newSell, vv)
 		}
 	}
@@ -917,23 +1091,6 @@ func (w *WalletServiceImpl) removeCross(depth Depth) Depth {
 	return rst
 }
 
-func checkDepthThreshHold(market string, amount string, size string) bool {
-	s, b := util.UnWrap(market)
-	return checkDepthAmountThreshHold(s, amount) || checkDepthAmountThreshHold(b, size)
-}
-
-func checkDepthAmountThreshHold(token string, amount string) bool {
-	amountIsOK := false
-	st, ok := depthTheshHold[strings.ToUpper(token)]
-	if ok {
-		amountF, _ := strconv.ParseFloat(amount, 64)
-		amountIsOK = amountF >= st
-	} else {
-		amountIsOK = true
-	}
-	return amountIsOK
-}
-
 func (w *WalletServiceImpl) getDepthCrossPrice(market string) (maxBuy float64, minSell float64, err error) {
 	maxBuyRelectable, ok := w.localCache.Get(DEPTH_MAX_BUY + strings.ToUpper(market))
 	if !ok {
@@ -990,8 +1147,7 @@ func (w *WalletServiceImpl) getInnerOrderBook(query DepthQuery, defaultDepthLeng
 		empty[i] = make([]string, 0)
 	}
 
-	//(TODO) 考虑到需要聚合的情况，所以每次取2倍的数据，先聚合完了再cut, 不是完美方案，后续再优化
-	asks, askErr := w.orderViewer.GetOrderBook(
+	asks, askErr := w.orderBook.GetOrderBook(
 		common.HexToAddress(delegateAddress),
 		util.AllTokens[a].Protocol,
 		util.AllTokens[b].Protocol, defaultDepthLength)
@@ -1001,7 +1157,7 @@ func (w *WalletServiceImpl) getInnerOrderBook(query DepthQuery, defaultDepthLeng
 		return
 	}
 
-	bids, bidErr := w.orderViewer.GetOrderBook(
+	bids, bidErr := w.orderBook.GetOrderBook(
 		common.HexToAddress(delegateAddress),
 		util.AllTokens[b].Protocol,
 		util.AllTokens[a].Protocol, defaultDepthLength)
@@ -1396,10 +1552,22 @@ func (w *WalletServiceImpl) GetEstimateGasPrice() (result string, err error) {
 	return types.BigintToHex(gasprice_evaluator.EstimateGasPrice(nil, nil)), nil
 }
 
+func (w *WalletServiceImpl) GetOrderDifficulty() (result string, err error) {
+	difficulty := order_difficulty.CurrentDifficulty()
+	if nil == difficulty {
+		return "", order_difficulty.ErrDifficultyNotInitialized
+	}
+	return common.BytesToHash(difficulty.Bytes()).Hex(), nil
+}
+
 func (w *WalletServiceImpl) ApplyTicket(ticket Ticket) (result string, err error) {
 
 	ticket.Ticket.Address = ticket.Sign.Owner
-	isSignCorrect, err := verifySign(ticket.Sign)
+	isSignCorrect, err := verifyActionSign(applyTicketAction, map[string]interface{}{
+		"name":  ticket.Ticket.Name,
+		"email": ticket.Ticket.Email,
+		"phone": ticket.Ticket.Phone,
+	}, ticket.Sign)
 	if isSignCorrect {
 		exist, err := w.rds.QueryTicketByAddress(ticket.Ticket.Address)
 		if err == nil && exist.ID > 0 {
@@ -1414,7 +1582,7 @@ func (w *WalletServiceImpl) ApplyTicket(ticket Ticket) (result string, err error
 
 func (w *WalletServiceImpl) QueryTicket(query TicketQuery) (ticket dao.TicketReceiver, err error) {
 
-	isSignCorrect, err := verifySign(query.Sign)
+	isSignCorrect, err := verifyActionSign(queryTicketAction, nil, query.Sign)
 	if isSignCorrect {
 		return w.rds.QueryTicketByAddress(query.Sign.Owner)
 	} else {
@@ -1555,7 +1723,7 @@ func (w *WalletServiceImpl) getStringStatus(order types.OrderState) string {
 	return "ORDER_UNKNOWN"
 }
 
-func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) [][]string {
+func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int, isAsk bool, precision int, tokenSDecimal, tokenBDecimal *big.Int) [][]string {
 
 	if len(states) == 0 {
 		return [][]string{}
@@ -1580,7 +1748,7 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 
 		if isAsk {
 			price = *price.Inv(&price)
-			priceFloatStr := price.FloatString(10)
+			priceFloatStr := depthPriceLevel(&price, precision, true)
 			if v, ok := depthMap[priceFloatStr]; ok {
 				amount := v.Amount
 				size := v.Size
@@ -1591,7 +1759,7 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 				depthMap[priceFloatStr] = DepthElement{Price: priceFloatStr, Amount: minAmountS, Size: minAmountB}
 			}
 		} else {
-			priceFloatStr := price.FloatString(10)
+			priceFloatStr := depthPriceLevel(&price, precision, false)
 			if v, ok := depthMap[priceFloatStr]; ok {
 				amount := v.Amount
 				size := v.Size
@@ -1831,7 +1999,13 @@ func (w *WalletServiceImpl) NotifyCirculr(req NotifyCirculrBody) (owner string,
 
 func (w *WalletServiceImpl) FlexCancelOrder(req CancelOrderQuery) (rst string, err error) {
 
-	isCorrect, err := verifySign(req.Sign)
+	isCorrect, err := verifyActionSign(flexCancelOrderAction, map[string]interface{}{
+		"type":      req.Type,
+		"orderHash": common.HexToHash(req.OrderHash),
+		"tokenS":    common.HexToAddress(req.TokenS),
+		"tokenB":    common.HexToAddress(req.TokenB),
+		"cutoff":    req.CutoffTime,
+	}, req.Sign)
 	if !isCorrect {
 		return rst, err
 	}
@@ -1845,6 +2019,7 @@ func (w *WalletServiceImpl) FlexCancelOrder(req CancelOrderQuery) (rst string, e
 	cancelOrderEvent.Type = types.FlexCancelType(req.Type)
 	err = manager.FlexCancelOrder(&cancelOrderEvent)
 	if err == nil {
+		kafkaUtil.ProducerNormalMessage(kafka.Kafka_Topic_OrderManager_FlexCancelOrder, &cancelOrderEvent)
 		go func() {
 
 			orderQuery := OrderQuery{Owner: req.Sign.Owner, OrderType: types.ORDER_TYPE_MARKET}
@@ -1865,6 +2040,113 @@ func (w *WalletServiceImpl) FlexCancelOrder(req CancelOrderQuery) (rst string, e
 	return rst, err
 }
 
+// FlexCancelOrders flex cancels orders of sign.Owner by hashes at once, orders cancelled are pushed once
+func (w *WalletServiceImpl) FlexCancelOrders(req CancelOrdersQuery) (res []BatchItemResult, err error) {
+	if len(req.OrderHashes) == 0 {
+		return res, errors.New("orderHashes can't be empty")
+	}
+	if len(req.OrderHashes) > gateway.maxBatchSize {
+		return res, fmt.Errorf("orderHashes can't be more than %d", gateway.maxBatchSize)
+	}
+	hashes := make([]common.Hash, len(req.OrderHashes))
+	for i, orderHash := range req.OrderHashes {
+		hashes[i] = common.HexToHash(orderHash)
+	}
+
+	isCorrect, err := verifyActionSign(flexCancelOrdersAction, map[string]interface{}{"orderHashes": hashes}, req.Sign)
+	if !isCorrect {
+		return res, err
+	}
+
+	owner := common.HexToAddress(req.Sign.Owner)
+	states, err := manager.FlexCancelOrdersByHash(owner, hashes)
+	if err != nil {
+		return res, err
+	}
+	cancelled := make(map[common.Hash]bool)
+	for _, state := range states {
+		cancelled[state.RawOrder.Hash] = true
+	}
+	res = make([]BatchItemResult, len(hashes))
+	for i, hash := range hashes {
+		res[i].OrderHash = hash.Hex()
+		if !cancelled[hash] {
+			res[i].Error = "no valid order exist"
+		}
+	}
+
+	if len(states) > 0 {
+		kafkaUtil.NotifyOrdersUpdate(owner, states)
+	}
+	return res, nil
+}
+
+// SubmitConditionalOrder holds a signed order dormant until its trigger price is reached, it's out of the order book until then
+func (w *WalletServiceImpl) SubmitConditionalOrder(req ConditionalOrderRequest) (orderHash string, err error) {
+	if req.Order == nil {
+		return orderHash, errors.New("order can't be nil")
+	}
+	order := req.Order
+	if !strings.EqualFold(order.Owner.Hex(), req.Sign.Owner) {
+		return orderHash, errors.New("sign owner and order owner are not matched")
+	}
+	isCorrect, err := verifyActionSign(submitConditionalOrderAction, map[string]interface{}{
+		"orderHash":    types.ToOrder(order).GenerateHash(),
+		"triggerType":  req.TriggerType,
+		"triggerPrice": req.TriggerPrice,
+		"priceSource":  req.PriceSource,
+	}, req.Sign)
+	if !isCorrect {
+		return orderHash, err
+	}
+
+	if order.OrderType != types.ORDER_TYPE_MARKET && order.OrderType != types.ORDER_TYPE_P2P {
+		order.OrderType = types.ORDER_TYPE_MARKET
+	}
+	if order.OrderType == types.ORDER_TYPE_P2P {
+		order.P2PSide = types.P2P_SIDE_MAKER
+	}
+	return HandleInputConditionalOrder(w.rds, order, req.TriggerType, req.TriggerPrice, req.PriceSource)
+}
+
+// GetConditionalOrders returns conditional orders of an owner, latest first
+func (w *WalletServiceImpl) GetConditionalOrders(req ConditionalOrderQuery) (res dao.PageResult, err error) {
+	if !common.IsHexAddress(req.Owner) {
+		return res, errors.New("owner isn't an address")
+	}
+	query := map[string]interface{}{"owner": common.HexToAddress(req.Owner).Hex()}
+	if len(req.OrderHash) > 0 {
+		query["order_hash"] = common.HexToHash(req.OrderHash).Hex()
+	}
+	if len(req.Market) > 0 {
+		query["market"] = req.Market
+	}
+	if len(req.Status) > 0 {
+		query["status"] = req.Status
+	}
+	if req.PageIndex <= 0 {
+		req.PageIndex = 1
+	}
+	if req.PageSize <= 0 || req.PageSize > 50 {
+		req.PageSize = 50
+	}
+	res, err = w.rds.ConditionalOrderPageQuery(query, req.PageIndex, req.PageSize)
+	for i, v := range res.Data {
+		res.Data[i] = toConditionalOrderJson(v.(dao.ConditionalOrder))
+	}
+	return res, err
+}
+
+// CancelConditionalOrder cancels a dormant conditional order of sign.Owner, the triggered ones are cancelled as orders
+func (w *WalletServiceImpl) CancelConditionalOrder(req CancelConditionalOrderQuery) (orderHash string, err error) {
+	hash := common.HexToHash(req.OrderHash)
+	isCorrect, err := verifyActionSign(cancelConditionalOrderAction, map[string]interface{}{"orderHash": hash}, req.Sign)
+	if !isCorrect {
+		return orderHash, err
+	}
+	return hash.Hex(), CancelConditionalOrder(w.rds, req.Sign.Owner, hash.Hex())
+}
+
 func (w *WalletServiceImpl) SetOrderTransfer(req OrderTransfer) (hash string, err error) {
 	if len(req.Hash) == 0 {
 		return hash, errors.New("hash can't be nil")
@@ -1906,7 +2188,7 @@ func (w *WalletServiceImpl) UpdateOrderTransfer(req OrderTransfer) (hash string,
 
 func (w *WalletServiceImpl) NotifyScanLogin(req SignedLoginInfo) (rst string, err error) {
 
-	isCorrect, err := verifySign(req.Sign)
+	isCorrect, err := verifyActionSign(notifyScanLoginAction, map[string]interface{}{"uuid": req.UUID}, req.Sign)
 	if !isCorrect {
 		return req.UUID, err
 	}
@@ -2123,34 +2405,24 @@ func fmtFloat(src *big.Rat) float64 {
 	return rst
 }
 
-func verifySign(sign SignInfo) (bool, error) {
+// verifySignTimestamp returns the timestamp of sign if it's within 10 minutes from now
+func verifySignTimestamp(sign SignInfo) (int64, error) {
 
 	now := time.Now().Unix()
 	ts, err := strconv.ParseInt(sign.Timestamp, 10, 64)
 	if err != nil {
-		return false, err
+		return ts, err
 	}
 
-	if math.Abs(float64(now-ts)) > 60*10 {
-		return false, errors.New("timestamp had expired")
+	if math.Abs(float64(now-ts)) > signValidSeconds {
+		return ts, errors.New("timestamp had expired")
 	}
+	return ts, nil
+}
 
-	h := &common.Hash{}
-	address := &common.Address{}
-	hashBytes := crypto.GenerateHash([]byte(sign.Timestamp))
-	h.SetBytes(hashBytes)
-	sig, _ := crypto.VRSToSig(sign.V, types.HexToBytes32(sign.R).Bytes(), types.HexToBytes32(sign.S).Bytes())
-	if addressBytes, err := crypto.SigToAddress(h.Bytes(), sig); nil != err {
-		log.Errorf("signer address error:%s", err.Error())
-		return false, errors.New("sign is incorrect")
-	} else {
-		address.SetBytes(addressBytes)
-		if strings.ToLower(address.Hex()) == strings.ToLower(sign.Owner) {
-			return true, nil
-		} else {
-			return false, errors.New("sign address not matched")
-		}
-	}
+// verifySignedMessage reports whether sign is the signature of message by sign.Owner
+func verifySignedMessage(message string, sign SignInfo) (bool, error) {
+	return verifySignedHash(common.BytesToHash(crypto.GenerateHash([]byte(message))), sign)
 }
 
 /**
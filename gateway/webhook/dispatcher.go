@@ -0,0 +1,384 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/metrics"
+	"github.com/Loopring/relay-lib/log"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxErrorLength = 512
+
+var deliveries = metrics.NewCounterVec("relay_webhook_deliveries_total", "Posts of webhooks by result.", "result")
+
+// Payload is the body posted to a webhook, Id is unique for a delivery and kept when it's replayed
+type Payload struct {
+	Id        string      `json:"id"`
+	WebhookId int         `json:"webhookId"`
+	Event     string      `json:"event"`
+	Timestamp int64       `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+type delivery struct {
+	hook     *Hook
+	id       string
+	event    string
+	body     []byte
+	letterId int // the dead letter replayed, 0 for a new delivery
+	attempts int // attempts of the dead letter before
+	tries    int // attempts of this delivery
+}
+
+// Dispatcher posts deliveries by a pool of workers. a failed attempt doesn't hold its worker,
+// the delivery waits for its backoff in retries and is queued again after it
+type Dispatcher struct {
+	options WebhookOptions
+	rds     *dao.RdsService
+	client  *http.Client
+
+	mtx       sync.RWMutex
+	hooks     map[int]*Hook
+	deleted   map[int]bool // ids of deleted webhooks, a reload started before a deletion doesn't load them again
+	replaying map[int]bool // dead letters queued
+	retries   map[*delivery]*time.Timer
+	stopped   bool
+
+	queue    chan *delivery
+	quit     chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+func NewDispatcher(options WebhookOptions, rds *dao.RdsService) *Dispatcher {
+	options.setDefaults()
+	d := &Dispatcher{options: options, rds: rds}
+	d.client = newClient(time.Duration(options.Timeout)*time.Second, guardedDial)
+	d.hooks = make(map[int]*Hook)
+	d.deleted = make(map[int]bool)
+	d.replaying = make(map[int]bool)
+	d.retries = make(map[*delivery]*time.Timer)
+	d.queue = make(chan *delivery, options.QueueSize)
+	d.quit = make(chan struct{})
+	return d
+}
+
+func (d *Dispatcher) Start() {
+	if err := d.Reload(); err != nil {
+		log.Errorf("webhook, load webhooks error:%s", err.Error())
+	}
+	d.startWorkers()
+	d.wg.Add(1)
+	go d.reloadLoop()
+}
+
+func (d *Dispatcher) startWorkers() {
+	for i := 0; i < d.options.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop waits for the posts in progress, the deliveries waiting for an attempt are dead lettered
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.quit)
+		d.wg.Wait()
+
+		d.mtx.Lock()
+		d.stopped = true
+		var waiting []*delivery
+		for dl, timer := range d.retries {
+			timer.Stop()
+			waiting = append(waiting, dl)
+		}
+		d.retries = make(map[*delivery]*time.Timer)
+		d.mtx.Unlock()
+
+		for _, dl := range waiting {
+			d.fail(dl, dl.tries, errors.New("relay stopped"))
+		}
+		for {
+			select {
+			case dl := <-d.queue:
+				d.fail(dl, dl.tries, errors.New("relay stopped"))
+			default:
+				return
+			}
+		}
+	})
+}
+
+// Reload replaces the hooks with the webhooks in mysql, which include the ones registered by other nodes
+func (d *Dispatcher) Reload() error {
+	list, err := d.rds.GetWebhooks()
+	if err != nil {
+		return err
+	}
+	hooks := make(map[int]*Hook)
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	for _, w := range list {
+		if !d.deleted[w.ID] {
+			hooks[w.ID] = NewHook(w)
+		}
+	}
+	d.hooks = hooks
+	return nil
+}
+
+func (d *Dispatcher) reloadLoop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(time.Duration(d.options.ReloadInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Reload(); err != nil {
+				log.Errorf("webhook, reload webhooks error:%s", err.Error())
+			}
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) AddHook(w dao.Webhook) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if !d.deleted[w.ID] {
+		d.hooks[w.ID] = NewHook(w)
+	}
+}
+
+// RemoveHook removes the deleted webhook of id, ids of webhooks aren't reused
+func (d *Dispatcher) RemoveHook(id int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.hooks, id)
+	d.deleted[id] = true
+}
+
+// Dispatch queues a delivery of e to every hook it matches, it doesn't block
+func (d *Dispatcher) Dispatch(e Event) {
+	var matched []*Hook
+	d.mtx.RLock()
+	for _, hook := range d.hooks {
+		if hook.Match(e) {
+			matched = append(matched, hook)
+		}
+	}
+	d.mtx.RUnlock()
+
+	for _, hook := range matched {
+		p := Payload{Id: newDeliveryId(), WebhookId: hook.Id, Event: e.Type, Timestamp: time.Now().Unix(), Data: e.Data}
+		body, err := json.Marshal(p)
+		if err != nil {
+			log.Errorf("webhook, marshal %s payload error:%s", e.Type, err.Error())
+			return
+		}
+		d.enqueue(&delivery{hook: hook, id: p.Id, event: p.Event, body: body})
+	}
+}
+
+// Replay queues the dead letters again, it returns how many are queued,
+// the letters whose webhook isn't loaded or which are being replayed are skipped
+func (d *Dispatcher) Replay(letters []dao.WebhookDeadLetter) int {
+	var queued []*delivery
+	d.mtx.Lock()
+	for _, letter := range letters {
+		hook, ok := d.hooks[letter.WebhookId]
+		if !ok || d.replaying[letter.ID] {
+			continue
+		}
+		d.replaying[letter.ID] = true
+		queued = append(queued, &delivery{
+			hook:     hook,
+			id:       letter.DeliveryId,
+			event:    letter.Event,
+			body:     []byte(letter.Payload),
+			letterId: letter.ID,
+			attempts: letter.Attempts,
+		})
+	}
+	d.mtx.Unlock()
+
+	for _, dl := range queued {
+		d.enqueue(dl)
+	}
+	return len(queued)
+}
+
+// enqueue doesn't block, dl is dead lettered if the queue is full or the dispatcher is stopped
+func (d *Dispatcher) enqueue(dl *delivery) {
+	d.mtx.RLock()
+	var err error
+	if d.stopped {
+		err = errors.New("relay stopped")
+	} else {
+		select {
+		case d.queue <- dl:
+		default:
+			err = errors.New("delivery queue is full")
+		}
+	}
+	d.mtx.RUnlock()
+
+	if err != nil {
+		d.fail(dl, dl.tries, err)
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case dl := <-d.queue:
+			d.deliver(dl)
+		case <-d.quit:
+			return
+		}
+	}
+}
+
+// deliver posts dl once, a failed one is retried after its backoff until MaxAttempts
+func (d *Dispatcher) deliver(dl *delivery) {
+	err := post(d.client, dl)
+	dl.tries++
+	if err == nil {
+		deliveries.Inc("delivered")
+		d.succeed(dl)
+		return
+	}
+	if dl.tries >= d.options.MaxAttempts {
+		d.fail(dl, dl.tries, err)
+		return
+	}
+	deliveries.Inc("retried")
+	d.retry(dl)
+}
+
+// retry queues dl again after its backoff, the timers are stopped by Stop and their deliveries dead lettered
+func (d *Dispatcher) retry(dl *delivery) {
+	wait := backoff(dl.tries, time.Duration(d.options.InitialBackoff)*time.Millisecond, time.Duration(d.options.MaxBackoff)*time.Millisecond)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.stopped {
+		go d.fail(dl, dl.tries, errors.New("relay stopped"))
+		return
+	}
+	d.retries[dl] = time.AfterFunc(wait, func() {
+		d.mtx.Lock()
+		_, ok := d.retries[dl]
+		delete(d.retries, dl)
+		d.mtx.Unlock()
+		if ok {
+			d.enqueue(dl)
+		}
+	})
+}
+
+func (d *Dispatcher) succeed(dl *delivery) {
+	if dl.letterId == 0 {
+		return
+	}
+	d.doneReplaying(dl.letterId)
+	if err := d.rds.DeleteWebhookDeadLetter(dl.letterId); err != nil {
+		log.Errorf("webhook, delete dead letter:%d error:%s", dl.letterId, err.Error())
+	}
+}
+
+// fail saves dl as a dead letter, or updates the dead letter replayed
+func (d *Dispatcher) fail(dl *delivery, attempts int, err error) {
+	deliveries.Inc("dead")
+	msg := err.Error()
+	if len(msg) > maxErrorLength {
+		msg = msg[:maxErrorLength]
+	}
+	log.Errorf("webhook, delivery:%s of webhook:%d failed after %d attempts, last error:%s", dl.id, dl.hook.Id, attempts, msg)
+
+	if dl.letterId > 0 {
+		d.doneReplaying(dl.letterId)
+		if err := d.rds.UpdateWebhookDeadLetter(dl.letterId, dl.attempts+attempts, msg); err != nil {
+			log.Errorf("webhook, update dead letter:%d error:%s", dl.letterId, err.Error())
+		}
+		return
+	}
+
+	letter := &dao.WebhookDeadLetter{
+		WebhookId:  dl.hook.Id,
+		DeliveryId: dl.id,
+		Event:      dl.event,
+		Payload:    string(dl.body),
+		Attempts:   attempts,
+		LastError:  msg,
+	}
+	if err := d.rds.AddWebhookDeadLetter(letter); err != nil {
+		log.Errorf("webhook, save dead letter of delivery:%s error:%s", dl.id, err.Error())
+	}
+}
+
+func (d *Dispatcher) doneReplaying(letterId int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.replaying, letterId)
+}
+
+// post sends dl to its hook, a response of status other than 2xx is an error
+func post(client *http.Client, dl *delivery) error {
+	req, err := http.NewRequest(http.MethodPost, dl.hook.Url, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.event)
+	req.Header.Set(DeliveryHeader, dl.id)
+	req.Header.Set(SignatureHeader, Sign(dl.hook.Secret, dl.body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func newDeliveryId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
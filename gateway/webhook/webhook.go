@@ -0,0 +1,181 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package webhook posts order lifecycle events to the urls registered by api clients.
+// a payload is signed by HMAC-SHA256 with the secret of its webhook, failed posts are retried with
+// exponential backoff, the ones failed after all attempts are saved as dead letters, which can be replayed.
+// urls must resolve to public addresses, they're resolved again when posting and redirects aren't followed
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/Loopring/relay-cluster/dao"
+	"strings"
+	"time"
+)
+
+const (
+	EventOrderUpdated   = "orderUpdated"
+	EventOrderFilled    = "orderFilled"
+	EventOrderCancelled = "orderCancelled"
+	EventCutoff         = "cutoff"
+
+	SignatureHeader = "X-Loopring-Signature"
+	EventHeader     = "X-Loopring-Event"
+	DeliveryHeader  = "X-Loopring-Delivery"
+)
+
+var Events = []string{EventOrderUpdated, EventOrderFilled, EventOrderCancelled, EventCutoff}
+
+func IsEvent(event string) bool {
+	for _, v := range Events {
+		if v == event {
+			return true
+		}
+	}
+	return false
+}
+
+type WebhookOptions struct {
+	Enabled        bool
+	Workers        int   // concurrent posts
+	QueueSize      int   // pending posts, events are dead lettered when it's full
+	MaxAttempts    int   // posts of a delivery before it's dead lettered
+	InitialBackoff int64 // milliseconds before the second attempt, doubled for every next one
+	MaxBackoff     int64 // milliseconds
+	Timeout        int64 // seconds of a post
+	ReloadInterval int64 // seconds between reloading webhooks registered by other nodes
+}
+
+const (
+	defaultWorkers        = 8
+	defaultQueueSize      = 10000
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 1000
+	defaultMaxBackoff     = 60000
+	defaultTimeout        = 10
+	defaultReloadInterval = 30
+)
+
+func (o *WebhookOptions) setDefaults() {
+	if o.Workers <= 0 {
+		o.Workers = defaultWorkers
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultQueueSize
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultMaxAttempts
+	}
+	if o.InitialBackoff <= 0 {
+		o.InitialBackoff = defaultInitialBackoff
+	}
+	if o.MaxBackoff < o.InitialBackoff {
+		o.MaxBackoff = defaultMaxBackoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.ReloadInterval <= 0 {
+		o.ReloadInterval = defaultReloadInterval
+	}
+}
+
+// Event is an order lifecycle event, Owner and Market are what webhooks are filtered by
+type Event struct {
+	Type   string
+	Owner  string
+	Market string
+	Data   interface{}
+}
+
+// Hook is a registered webhook, its filters are lowercase owners, uppercase markets and events
+type Hook struct {
+	Id      int
+	Url     string
+	Secret  string
+	owners  map[string]bool
+	markets map[string]bool
+	events  map[string]bool
+}
+
+func NewHook(w dao.Webhook) *Hook {
+	return &Hook{
+		Id:      w.ID,
+		Url:     w.Url,
+		Secret:  w.Secret,
+		owners:  filterSet(w.Owners, strings.ToLower),
+		markets: filterSet(w.Markets, strings.ToUpper),
+		events:  filterSet(w.Events, func(s string) string { return s }),
+	}
+}
+
+func filterSet(list string, normalize func(string) string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range SplitFilter(list) {
+		set[normalize(v)] = true
+	}
+	return set
+}
+
+// SplitFilter splits a comma separated filter of dao.Webhook
+func SplitFilter(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Match returns true if the event passes all filters of the hook,
+// an event without market, e.g. a cutoff of all markets, passes the market filter
+func (h *Hook) Match(e Event) bool {
+	if len(h.events) > 0 && !h.events[e.Type] {
+		return false
+	}
+	if len(h.owners) > 0 && !h.owners[strings.ToLower(e.Owner)] {
+		return false
+	}
+	if len(h.markets) > 0 && len(e.Market) > 0 && !h.markets[strings.ToUpper(e.Market)] {
+		return false
+	}
+	return true
+}
+
+// Sign returns the hex HMAC-SHA256 of body with secret, it's sent in SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff returns the wait before the attempt after the attempt-th one
+func backoff(attempt int, initial, max time.Duration) time.Duration {
+	wait := initial
+	for i := 1; i < attempt && wait < max; i++ {
+		wait *= 2
+	}
+	if wait > max {
+		wait = max
+	}
+	return wait
+}
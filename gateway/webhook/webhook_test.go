@@ -0,0 +1,225 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package webhook
+
+import (
+	"github.com/Loopring/relay-cluster/dao"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const owner = "0x8311804426A24495bD4306DAf5f595A443a52E32"
+
+func TestHookMatch(t *testing.T) {
+	hook := NewHook(dao.Webhook{Owners: owner + ", 0xb1018949b241D76A1AB2094f473E9bEfeAbB5Ead", Markets: "lrc-weth", Events: EventOrderFilled + "," + EventCutoff})
+
+	cases := []struct {
+		event    Event
+		expected bool
+	}{
+		{Event{Type: EventOrderFilled, Owner: "0x8311804426a24495bd4306daf5f595a443a52e32", Market: "LRC-WETH"}, true},
+		{Event{Type: EventOrderUpdated, Owner: owner, Market: "LRC-WETH"}, false},
+		{Event{Type: EventOrderFilled, Owner: "0x0000000000000000000000000000000000000001", Market: "LRC-WETH"}, false},
+		{Event{Type: EventOrderFilled, Owner: owner, Market: "VITE-WETH"}, false},
+		{Event{Type: EventCutoff, Owner: owner}, true},
+	}
+	for i, c := range cases {
+		if hook.Match(c.event) != c.expected {
+			t.Fatalf("case %d: expected match %t", i, c.expected)
+		}
+	}
+
+	if all := NewHook(dao.Webhook{}); !all.Match(Event{Type: EventOrderUpdated, Owner: owner, Market: "VITE-WETH"}) {
+		t.Fatalf("expected a webhook without filters matching all events")
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"id":"1"}' | openssl dgst -sha256 -hmac secret
+	expected := "6146142a2ce0159e84c0767881e4ec80bc397da62526e7d19f70795eb79460c0"
+	if got := Sign("secret", []byte(`{"id":"1"}`)); got != expected {
+		t.Fatalf("unexpected signature %s", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, wait := range expected {
+		if got := backoff(i+1, time.Second, 10*time.Second); got != wait {
+			t.Fatalf("attempt %d: expected %s, got %s", i+1, wait, got)
+		}
+	}
+}
+
+// newTestDispatcher posts to the local test servers, which the guarded client refuses
+func newTestDispatcher(options WebhookOptions) *Dispatcher {
+	d := NewDispatcher(options, nil)
+	d.client = newClient(time.Second, (&net.Dialer{}).DialContext)
+	d.startWorkers()
+	return d
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) != Sign("secret", received) || r.Header.Get(EventHeader) != EventOrderFilled {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	d := newTestDispatcher(WebhookOptions{Workers: 1, MaxAttempts: 3, InitialBackoff: 1, MaxBackoff: 1})
+	defer close(d.quit)
+	d.enqueue(&delivery{hook: &Hook{Id: 1, Url: server.URL, Secret: "secret"}, id: "1", event: EventOrderFilled, body: body})
+
+	waitFor(t, 5*time.Second, func() bool { return atomic.LoadInt32(&calls) == 3 })
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestRetryDoesNotHoldWorker(t *testing.T) {
+	var bad, good int32
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bad, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer badServer.Close()
+	goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&good, 1)
+	}))
+	defer goodServer.Close()
+
+	// the backoff of the failing hook is much longer than the test
+	d := newTestDispatcher(WebhookOptions{Workers: 1, MaxAttempts: 10, InitialBackoff: 60000, MaxBackoff: 60000})
+	defer close(d.quit)
+	d.enqueue(&delivery{hook: &Hook{Id: 1, Url: badServer.URL}, id: "1", event: EventOrderFilled, body: []byte("{}")})
+	d.enqueue(&delivery{hook: &Hook{Id: 2, Url: goodServer.URL}, id: "2", event: EventOrderFilled, body: []byte("{}")})
+
+	waitFor(t, 2*time.Second, func() bool { return atomic.LoadInt32(&good) == 1 })
+	if got := atomic.LoadInt32(&bad); got != 1 {
+		t.Fatalf("expected 1 attempt of the failing hook, got %d", got)
+	}
+	d.mtx.Lock()
+	waiting := len(d.retries)
+	for _, timer := range d.retries {
+		timer.Stop()
+	}
+	d.mtx.Unlock()
+	if waiting != 1 {
+		t.Fatalf("expected 1 delivery waiting for retry, got %d", waiting)
+	}
+}
+
+func TestValidateUrl(t *testing.T) {
+	cases := []struct {
+		url     string
+		allowed bool
+	}{
+		{"http://8.8.8.8/hook", true},
+		{"https://8.8.8.8:8443/hook", true},
+		{"ftp://8.8.8.8/hook", false},
+		{"/hook", false},
+		{"http://127.0.0.1:8080/hook", false},
+		{"http://localhost/hook", false},
+		{"http://10.0.0.1/hook", false},
+		{"http://192.168.1.1/hook", false},
+		{"http://172.31.255.255/hook", false},
+		{"http://172.32.0.1/hook", true},
+		{"http://100.64.0.1/hook", false},
+		{"http://[fd00::1]/hook", false},
+		{"http://[::ffff:10.0.0.1]/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://[::1]/hook", false},
+		{"http://[fe80::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+	}
+	for _, c := range cases {
+		if err := ValidateUrl(c.url); (err == nil) != c.allowed {
+			t.Fatalf("%s: expected allowed %t, got error %v", c.url, c.allowed, err)
+		}
+	}
+}
+
+func TestGuardedClientRefusesLocalAddress(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	client := newClient(time.Second, guardedDial)
+	if err := post(client, &delivery{hook: &Hook{Id: 1, Url: server.URL}, id: "1", body: []byte("{}")}); err == nil {
+		t.Fatalf("expected the post to a loopback address refused")
+	}
+	if calls != 0 {
+		t.Fatalf("expected no request received, got %d", calls)
+	}
+}
+
+func TestRedirectNotFollowed(t *testing.T) {
+	var redirected int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&redirected, 1)
+	}))
+	defer target.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	client := newClient(time.Second, (&net.Dialer{}).DialContext)
+	if err := post(client, &delivery{hook: &Hook{Id: 1, Url: server.URL}, id: "1", body: []byte("{}")}); err == nil {
+		t.Fatalf("expected a redirect to be an error")
+	}
+	if redirected != 0 {
+		t.Fatalf("expected the redirect not followed")
+	}
+}
+
+func TestRemovedHookNotReloaded(t *testing.T) {
+	d := NewDispatcher(WebhookOptions{}, nil)
+	d.AddHook(dao.Webhook{ID: 1})
+	d.RemoveHook(1)
+	d.AddHook(dao.Webhook{ID: 1})
+	if len(d.hooks) != 0 {
+		t.Fatalf("expected the deleted webhook not added again")
+	}
+}
@@ -0,0 +1,403 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/gateway/webhook"
+	kafkaUtil "github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-lib/kafka"
+	"github.com/Loopring/relay-lib/log"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strings"
+)
+
+// webhooks are registered by the api keys of jsonrpc rate limit, which are sent in params
+// since the rpc methods can't read headers. all nodes consume the order events in one group,
+// so an event is posted once by the cluster. a deletion is sent to every node by
+// Kafka_Topic_Webhook_Deleted, which is consumed without a group
+
+const (
+	Kafka_Group_Webhook         = "Webhook_Dispatcher"
+	Kafka_Topic_Webhook_Deleted = "Kafka_Topic_Webhook_Deleted"
+
+	maxWebhooksPerClient = 10
+	maxReplayLetters     = 100
+	webhookSecretLength  = 32
+)
+
+type WebhookRegisterReq struct {
+	ApiKey  string   `json:"apiKey"`
+	Url     string   `json:"url"`
+	Owners  []string `json:"owners"`
+	Markets []string `json:"markets"`
+	Events  []string `json:"events"`
+}
+
+type WebhookReq struct {
+	ApiKey    string `json:"apiKey"`
+	WebhookId int    `json:"webhookId"`
+	Ids       []int  `json:"ids"`
+	PageIndex int    `json:"pageIndex"`
+	PageSize  int    `json:"pageSize"`
+}
+
+// WebhookResult is a registered webhook, Secret is only returned by RegisterWebhook
+type WebhookResult struct {
+	Id         int      `json:"id"`
+	Url        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	Owners     []string `json:"owners"`
+	Markets    []string `json:"markets"`
+	Events     []string `json:"events"`
+	CreateTime int64    `json:"createTime"`
+}
+
+type WebhookReplayResult struct {
+	Queued int `json:"queued"`
+}
+
+// WebhookCutoff is the data of cutoff events, Market is empty for the cutoff of all markets
+type WebhookCutoff struct {
+	Owner       string   `json:"owner"`
+	Market      string   `json:"market"`
+	Cutoff      int64    `json:"cutoff"`
+	OrderHashes []string `json:"orderHashes"`
+	TxHash      string   `json:"txHash"`
+	BlockNumber int64    `json:"blockNumber"`
+}
+
+// WebhookFlexCancel is the data of orderCancelled events for flex cancels of many orders,
+// the ones by order hash are sent as order updates
+type WebhookFlexCancel struct {
+	Owner      string `json:"owner"`
+	Market     string `json:"market"`
+	CutoffTime int64  `json:"cutoffTime"`
+	Type       uint8  `json:"type"`
+}
+
+type WebhookServiceImpl struct {
+	enabled       bool
+	rds           *dao.RdsService
+	walletService *WalletServiceImpl
+	brokers       []string
+	apiKeys       map[string]bool
+	dispatcher    *webhook.Dispatcher
+	consumer      *kafka.ConsumerRegister
+	deletions     *kafkaUtil.PartitionConsumer
+}
+
+type WebhookDeletedEvent struct {
+	Id int `json:"id"`
+}
+
+func NewWebhookService(options webhook.WebhookOptions, rds *dao.RdsService, walletService *WalletServiceImpl, brokers []string, apiKeys []string) *WebhookServiceImpl {
+	s := &WebhookServiceImpl{}
+	s.enabled = options.Enabled
+	s.rds = rds
+	s.walletService = walletService
+	s.brokers = brokers
+	s.apiKeys = make(map[string]bool)
+	for _, key := range apiKeys {
+		s.apiKeys[key] = true
+	}
+	s.dispatcher = webhook.NewDispatcher(options, rds)
+	return s
+}
+
+// StartWebhookService is not a method of WebhookServiceImpl,
+// otherwise it will be exported by jsonrpc as loopring_start
+func StartWebhookService(s *WebhookServiceImpl) {
+	if !s.enabled {
+		return
+	}
+	s.dispatcher.Start()
+
+	// without the deletions consumed, a webhook deleted by another node is removed by the next reload
+	if deletions, err := kafkaUtil.NewPartitionConsumer(s.brokers); err != nil {
+		log.Errorf("webhook,create kafka consumer of %s error:%s", Kafka_Topic_Webhook_Deleted, err.Error())
+	} else {
+		s.deletions = deletions
+		if err := deletions.Register(Kafka_Topic_Webhook_Deleted, WebhookDeletedEvent{}, s.handleWebhookDeleted); err != nil {
+			log.Errorf("webhook,register kafka consumer of %s error:%s", Kafka_Topic_Webhook_Deleted, err.Error())
+		}
+	}
+
+	s.consumer = &kafka.ConsumerRegister{}
+	s.consumer.Initialize(s.brokers)
+	topics := map[string]struct {
+		data    interface{}
+		handler kafka.HandlerFunc
+	}{
+		kafka.Kafka_Topic_SocketIO_Order_Updated:       {types.OrderState{}, s.handleOrderUpdate},
+		kafkaUtil.Kafka_Topic_SocketIO_Orders_Updated:  {kafkaUtil.OrdersUpdatedEvent{}, s.handleOrdersUpdate},
+		kafka.Kafka_Topic_SocketIO_Trades_Updated:      {dao.FillEvent{}, s.handleFill},
+		kafka.Kafka_Topic_SocketIO_Cutoff:              {types.CutoffEvent{}, s.handleCutoff},
+		kafka.Kafka_Topic_SocketIO_Cutoff_Pair:         {types.CutoffPairEvent{}, s.handleCutoffPair},
+		kafka.Kafka_Topic_OrderManager_FlexCancelOrder: {types.FlexCancelOrderEvent{}, s.handleFlexCancel},
+	}
+	for topic, v := range topics {
+		if err := s.consumer.RegisterTopicAndHandler(topic, Kafka_Group_Webhook, v.data, v.handler); err != nil {
+			log.Fatalf("webhook,register kafka consumer of %s error:%s", topic, err.Error())
+		}
+	}
+}
+
+// StopWebhookService stops consuming before the dispatcher, so no event is dispatched after it stopped
+func StopWebhookService(s *WebhookServiceImpl) {
+	if !s.enabled {
+		return
+	}
+	if s.consumer != nil {
+		s.consumer.Close()
+	}
+	if s.deletions != nil {
+		s.deletions.Close()
+	}
+	s.dispatcher.Stop()
+}
+
+func (s *WebhookServiceImpl) handleWebhookDeleted(input interface{}) error {
+	event := input.(*WebhookDeletedEvent)
+	s.dispatcher.RemoveHook(event.Id)
+	return nil
+}
+
+func (s *WebhookServiceImpl) handleOrderUpdate(input interface{}) error {
+	state := input.(*types.OrderState)
+	event := webhook.EventOrderUpdated
+	if state.Status == types.ORDER_CANCEL || state.Status == types.ORDER_FLEX_CANCEL {
+		event = webhook.EventOrderCancelled
+	}
+	s.dispatcher.Dispatch(webhook.Event{
+		Type:   event,
+		Owner:  state.RawOrder.Owner.Hex(),
+		Market: state.RawOrder.Market,
+		Data:   s.walletService.orderStateToJson(*state),
+	})
+	return nil
+}
+
+// handleOrdersUpdate dispatches orders updated at once one by one, webhooks receive an event per order
+func (s *WebhookServiceImpl) handleOrdersUpdate(input interface{}) error {
+	event := input.(*kafkaUtil.OrdersUpdatedEvent)
+	for i := range event.Orders {
+		s.handleOrderUpdate(&event.Orders[i])
+	}
+	return nil
+}
+
+func (s *WebhookServiceImpl) handleFill(input interface{}) error {
+	fill := input.(*dao.FillEvent)
+	s.dispatcher.Dispatch(webhook.Event{Type: webhook.EventOrderFilled, Owner: fill.Owner, Market: fill.Market, Data: fill})
+	return nil
+}
+
+func (s *WebhookServiceImpl) handleCutoff(input interface{}) error {
+	evt := input.(*types.CutoffEvent)
+	s.dispatchCutoff(evt.TxInfo, evt.Owner, "", evt.Cutoff, evt.OrderHashList)
+	return nil
+}
+
+func (s *WebhookServiceImpl) handleCutoffPair(input interface{}) error {
+	evt := input.(*types.CutoffPairEvent)
+	market, _ := util.WrapMarketByAddress(evt.Token1.Hex(), evt.Token2.Hex())
+	s.dispatchCutoff(evt.TxInfo, evt.Owner, market, evt.Cutoff, evt.OrderHashList)
+	return nil
+}
+
+func (s *WebhookServiceImpl) dispatchCutoff(tx types.TxInfo, owner common.Address, market string, cutoff *big.Int, hashes []common.Hash) {
+	data := WebhookCutoff{Owner: owner.Hex(), Market: market, TxHash: tx.TxHash.Hex(), OrderHashes: make([]string, 0, len(hashes))}
+	if cutoff != nil {
+		data.Cutoff = cutoff.Int64()
+	}
+	if tx.BlockNumber != nil {
+		data.BlockNumber = tx.BlockNumber.Int64()
+	}
+	for _, hash := range hashes {
+		data.OrderHashes = append(data.OrderHashes, hash.Hex())
+	}
+	s.dispatcher.Dispatch(webhook.Event{Type: webhook.EventCutoff, Owner: data.Owner, Market: market, Data: data})
+}
+
+func (s *WebhookServiceImpl) handleFlexCancel(input interface{}) error {
+	evt := input.(*types.FlexCancelOrderEvent)
+	if evt.Type == types.FLEX_CANCEL_BY_HASH {
+		return nil
+	}
+	data := WebhookFlexCancel{Owner: evt.Owner.Hex(), CutoffTime: evt.CutoffTime, Type: uint8(evt.Type)}
+	if evt.Type == types.FLEX_CANCEL_BY_MARKET {
+		data.Market, _ = util.WrapMarketByAddress(evt.TokenS.Hex(), evt.TokenB.Hex())
+	}
+	s.dispatcher.Dispatch(webhook.Event{Type: webhook.EventOrderCancelled, Owner: data.Owner, Market: data.Market, Data: data})
+	return nil
+}
+
+// client returns the id of the api client, which is the hash of its api key, so keys aren't saved in mysql
+func (s *WebhookServiceImpl) client(apiKey string) (string, error) {
+	if !s.enabled {
+		return "", errors.New("webhook is disabled")
+	}
+	if len(apiKey) == 0 || !s.apiKeys[apiKey] {
+		return "", errors.New("invalid api key")
+	}
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+func (s *WebhookServiceImpl) RegisterWebhook(req *WebhookRegisterReq) (res WebhookResult, err error) {
+	client, err := s.client(req.ApiKey)
+	if err != nil {
+		return res, err
+	}
+
+	if err = webhook.ValidateUrl(req.Url); err != nil {
+		return res, err
+	}
+	for i, owner := range req.Owners {
+		if !common.IsHexAddress(owner) {
+			return res, fmt.Errorf("owner %s is illegal", owner)
+		}
+		req.Owners[i] = strings.ToLower(owner)
+	}
+	for i, market := range req.Markets {
+		if !util.IsSupportedMarket(market) {
+			return res, fmt.Errorf("market %s is unsupported", market)
+		}
+		req.Markets[i] = strings.ToUpper(market)
+	}
+	for _, event := range req.Events {
+		if !webhook.IsEvent(event) {
+			return res, fmt.Errorf("event %s is unsupported, events are %s", event, strings.Join(webhook.Events, ","))
+		}
+	}
+
+	count, err := s.rds.CountWebhooksByClient(client)
+	if err != nil {
+		return res, err
+	}
+	if count >= maxWebhooksPerClient {
+		return res, fmt.Errorf("an api key can register %d webhooks at most", maxWebhooksPerClient)
+	}
+
+	hook := dao.Webhook{
+		Client:  client,
+		Url:     req.Url,
+		Secret:  randomHex(webhookSecretLength),
+		Owners:  strings.Join(req.Owners, ","),
+		Markets: strings.Join(req.Markets, ","),
+		Events:  strings.Join(req.Events, ","),
+	}
+	if err = s.rds.AddWebhook(&hook); err != nil {
+		return res, err
+	}
+	s.dispatcher.AddHook(hook)
+
+	res = webhookResult(hook)
+	res.Secret = hook.Secret
+	return res, nil
+}
+
+func (s *WebhookServiceImpl) GetWebhooks(req *WebhookReq) (res []WebhookResult, err error) {
+	client, err := s.client(req.ApiKey)
+	if err != nil {
+		return res, err
+	}
+	hooks, err := s.rds.GetWebhooksByClient(client)
+	if err != nil {
+		return res, err
+	}
+	res = make([]WebhookResult, 0, len(hooks))
+	for _, hook := range hooks {
+		res = append(res, webhookResult(hook))
+	}
+	return res, nil
+}
+
+func (s *WebhookServiceImpl) DeleteWebhook(req *WebhookReq) (id int, err error) {
+	client, err := s.client(req.ApiKey)
+	if err != nil {
+		return id, err
+	}
+	if err = s.rds.DeleteWebhook(client, req.WebhookId); err != nil {
+		return id, err
+	}
+	// the other nodes remove it when they consume the event, or reload the webhooks at the latest
+	s.dispatcher.RemoveHook(req.WebhookId)
+	if err := kafkaUtil.ProducerNormalMessage(Kafka_Topic_Webhook_Deleted, &WebhookDeletedEvent{Id: req.WebhookId}); err != nil {
+		log.Errorf("webhook,send deletion of webhook:%d error:%s", req.WebhookId, err.Error())
+	}
+	return req.WebhookId, nil
+}
+
+func (s *WebhookServiceImpl) GetWebhookDeadLetters(req *WebhookReq) (res dao.PageResult, err error) {
+	client, err := s.client(req.ApiKey)
+	if err != nil {
+		return res, err
+	}
+	if _, err = s.rds.GetWebhook(client, req.WebhookId); err != nil {
+		return res, err
+	}
+	if req.PageIndex <= 0 {
+		req.PageIndex = 1
+	}
+	if req.PageSize <= 0 || req.PageSize > 50 {
+		req.PageSize = 50
+	}
+	return s.rds.WebhookDeadLetterPageQuery(req.WebhookId, req.PageIndex, req.PageSize)
+}
+
+// ReplayWebhookDeadLetters posts the dead letters in Ids again, or the first 100 if Ids is empty,
+// a letter is deleted when it's delivered, otherwise its attempts and last error are updated
+func (s *WebhookServiceImpl) ReplayWebhookDeadLetters(req *WebhookReq) (res WebhookReplayResult, err error) {
+	client, err := s.client(req.ApiKey)
+	if err != nil {
+		return res, err
+	}
+	hook, err := s.rds.GetWebhook(client, req.WebhookId)
+	if err != nil {
+		return res, err
+	}
+	if len(req.Ids) > maxReplayLetters {
+		return res, fmt.Errorf("%d dead letters can be replayed at most once", maxReplayLetters)
+	}
+	letters, err := s.rds.GetWebhookDeadLetters(hook.ID, req.Ids, maxReplayLetters)
+	if err != nil {
+		return res, err
+	}
+	// the webhook may be registered by another node after the last reload
+	s.dispatcher.AddHook(*hook)
+	res.Queued = s.dispatcher.Replay(letters)
+	return res, nil
+}
+
+func webhookResult(hook dao.Webhook) WebhookResult {
+	res := WebhookResult{Id: hook.ID, Url: hook.Url, CreateTime: hook.CreateTime}
+	res.Owners = append([]string{}, webhook.SplitFilter(hook.Owners)...)
+	res.Markets = append([]string{}, webhook.SplitFilter(hook.Markets)...)
+	res.Events = append([]string{}, webhook.SplitFilter(hook.Events)...)
+	return res
+}
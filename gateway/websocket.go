@@ -16,72 +16,627 @@
 
 */
 
-// deprecate
 package gateway
 
 import (
-	"fmt"
-	"github.com/Loopring/relay-cluster/accountmanager"
-	"github.com/Loopring/relay-cluster/market"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Loopring/relay-cluster/gateway/inproc"
+	"github.com/Loopring/relay-cluster/gateway/ratelimit"
+	"github.com/Loopring/relay-cluster/metrics"
 	"github.com/Loopring/relay-lib/log"
-	"github.com/Loopring/relay-lib/marketcap"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/gorilla/websocket"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wsRpcVersion            = "2.0"
+	wsRpcSubscribeMethod    = "loopring_subscribe"
+	wsRpcUnsubscribeMethod  = "loopring_unsubscribe"
+	wsRpcAuthChallenge      = "loopring_authChallenge"
+	wsRpcAuth               = "loopring_auth"
+	wsRpcNotificationMethod = "loopring_subscription"
+	wsRpcParseError         = -32700
+	wsRpcInvalidRequest     = -32600
+	wsRpcInvalidParams      = -32602
+	wsRpcServerError        = -32000
+	wsRpcUnauthorized       = -32003
+	wsMaxMessageSize        = 512 * 1024
+	wsSendBufferSize        = 256
+	wsMaxConcurrentRequests = 16
+	wsWriteWait             = 10 * time.Second
+	wsPongWait              = 60 * time.Second
+	wsPingPeriod            = wsPongWait * 9 / 10
 )
 
 type WebsocketService interface {
-	Start(port string)
+	Start()
 	Stop()
 }
 
+// WebsocketOptions is used by socket.io and the json-rpc websocket.
+// PrivateEvents are the event types whose owner must be authenticated by the connection subscribing them,
+// they are set in the options of socket.io, which the json-rpc websocket subscribes through
 type WebsocketOptions struct {
-	Port string
+	Port           string
+	AllowedOrigins []string
+	TlsCertFile    string
+	TlsKeyFile     string
+	PrivateEvents  []string
 }
 
+// WebsocketServiceImpl serves json-rpc 2.0 over plain websocket at /ws, for clients without a socket.io library.
+// every method of the jsonrpc endpoint can be called, loopring_subscribe and loopring_unsubscribe
+// stream the topics of socket.io as loopring_subscription notifications
 type WebsocketServiceImpl struct {
-	port     string
-	upgrader websocket.Upgrader
-}
+	port               string
+	allowedOrigins     []string
+	tlsCertFile        string
+	tlsKeyFile         string
+	shutdownTimeout    time.Duration
+	socketIOService    *SocketIOServiceImpl
+	walletService      *WalletServiceImpl
+	ringTrackerService *RingTrackerServiceImpl
+	contestRankService *ContestRankServiceImpl
+	webhookService     *WebhookServiceImpl
+	rateLimiter        *ratelimit.RateLimiter
 
-type WebsocketRequest map[string]string
+	upgrader     websocket.Upgrader
+	rpcServer    *rpc.Server
+	knownMethods map[string]bool
+	httpServer   *http.Server
+	conns        sync.Map
+}
 
-// this code is not stable
-func NewWebsocketService(port string, trendManager market.TrendManager, accountManager accountmanager.AccountManager, capProvider marketcap.MarketCapProvider) *WebsocketServiceImpl {
-	l := &WebsocketServiceImpl{}
-	l.port = port
-	l.upgrader = websocket.Upgrader{
-		CheckOrigin:     func(r *http.Request) bool { return true },
+func NewWebsocketService(options *WebsocketOptions, shutdownTimeout time.Duration, socketIOService *SocketIOServiceImpl, walletService *WalletServiceImpl, ringTrackerService *RingTrackerServiceImpl, contestRankService *ContestRankServiceImpl, webhookService *WebhookServiceImpl, rateLimiter *ratelimit.RateLimiter) *WebsocketServiceImpl {
+	ws := &WebsocketServiceImpl{}
+	ws.port = options.Port
+	ws.allowedOrigins = allowedOriginsOrDefault(options.AllowedOrigins)
+	ws.tlsCertFile = options.TlsCertFile
+	ws.tlsKeyFile = options.TlsKeyFile
+	ws.shutdownTimeout = shutdownTimeout
+	ws.socketIOService = socketIOService
+	ws.walletService = walletService
+	ws.ringTrackerService = ringTrackerService
+	ws.contestRankService = contestRankService
+	ws.webhookService = webhookService
+	ws.rateLimiter = rateLimiter
+	ws.upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || originAllowed(ws.allowedOrigins, origin)
+		},
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
-	return l
+	return ws
 }
 
 func (ws *WebsocketServiceImpl) Start() {
+	handler := rpc.NewServer()
+	for _, receiver := range []interface{}{ws.walletService, ws.ringTrackerService, ws.contestRankService, ws.webhookService} {
+		if err := handler.RegisterName("loopring", receiver); err != nil {
+			log.Errorf("websocket, register rpc service error:%s", err.Error())
+			return
+		}
+	}
+	ws.rpcServer = handler
+	ws.knownMethods = rpcMethodNames("loopring", ws.walletService, ws.ringTrackerService, ws.contestRankService, ws.webhookService)
+	ws.knownMethods[wsRpcSubscribeMethod] = true
+	ws.knownMethods[wsRpcUnsubscribeMethod] = true
+	ws.knownMethods[wsRpcAuthChallenge] = true
+	ws.knownMethods[wsRpcAuth] = true
 
-	node := newSocketNode()
-	go node.run()
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		ws.serve(node, w, r)
+	useTls, err := checkTlsFiles(ws.tlsCertFile, ws.tlsKeyFile)
+	if nil != err {
+		log.Fatalf("websocket, %s", err.Error())
+	}
+	listener, err := net.Listen("tcp", ":"+ws.port)
+	if err != nil {
+		log.Errorf("websocket, listen on port:%s error:%s", ws.port, err.Error())
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ws.serve)
+	ws.httpServer = &http.Server{Handler: mux}
+	go func() {
+		if err := serveHttp(ws.httpServer, listener, ws.tlsCertFile, ws.tlsKeyFile); err != nil && err != http.ErrServerClosed {
+			log.Errorf("websocket, serve error:%s", err.Error())
+		}
+	}()
+	if useTls {
+		log.Info("WSS endpoint opened on " + ws.port)
+	} else {
+		log.Info("WS endpoint opened on " + ws.port)
+	}
+}
+
+// Stop closes the listener, then the connections, which are hijacked and not tracked by http.Server
+func (ws *WebsocketServiceImpl) Stop() {
+	if ws.httpServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ws.shutdownTimeout)
+	defer cancel()
+	if err := ws.httpServer.Shutdown(ctx); err != nil {
+		log.Errorf("websocket, shutdown error:%s", err.Error())
+	}
+	ws.conns.Range(func(key, value interface{}) bool {
+		value.(*wsConn).Close()
+		return true
 	})
+	ws.rpcServer.Stop()
+	log.Info("WS endpoint closed on " + ws.port)
+}
 
-	err := http.ListenAndServe(":"+ws.port, nil)
+func (ws *WebsocketServiceImpl) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Fatal("ListenAndServe Websocket Error : " + err.Error())
+		log.Errorf("websocket, upgrade connection error:%s", err.Error())
+		return
 	}
+	c := newWsConn(conn, r)
+	ws.conns.Store(c.ID(), c)
+	go c.write()
+	ws.read(c)
 
-	return
+	// c is closed before it's disconnected, a subscription made after that is dropped by its after func
+	ws.conns.Delete(c.ID())
+	c.Close()
+	ws.socketIOService.disconnect(c.ID())
 }
 
-func (ws *WebsocketServiceImpl) serve(node *SocketNode, w http.ResponseWriter, r *http.Request) {
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
-	fmt.Print(conn.LocalAddr())
+// read handles the requests of c until it's closed, at most wsMaxConcurrentRequests of them at the same time
+func (ws *WebsocketServiceImpl) read(c *wsConn) {
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	pending := make(chan struct{}, wsMaxConcurrentRequests)
+	for {
+		_, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		pending <- struct{}{}
+		go func() {
+			defer func() { <-pending }()
+			ws.handleMessage(c, msg)
+		}()
+	}
+}
+
+type wsRpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type wsRpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type wsRpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *wsRpcError     `json:"error,omitempty"`
+}
+
+type wsRpcNotification struct {
+	Version string                  `json:"jsonrpc"`
+	Method  string                  `json:"method"`
+	Params  wsRpcSubscriptionResult `json:"params"`
+}
+
+type wsRpcSubscriptionResult struct {
+	Subscription string          `json:"subscription"`
+	Topic        string          `json:"topic"`
+	Result       json.RawMessage `json:"result"`
+}
+
+var wsNullId = json.RawMessage("null")
+
+// handleMessage answers a request or a batch of requests in one message,
+// the subscriptions are started after the responses are sent so that their first data follows.
+// the calls of a message are limited like a json-rpc http request from the client of c
+func (ws *WebsocketServiceImpl) handleMessage(c *wsConn, msg []byte) {
+	msg = bytes.TrimSpace(msg)
+	var (
+		reqs    []wsRpcRequest
+		isBatch = len(msg) > 0 && msg[0] == '['
+	)
+	if isBatch {
+		if err := json.Unmarshal(msg, &reqs); err != nil || len(reqs) == 0 {
+			c.sendJson(newWsRpcError(wsNullId, wsRpcParseError, "invalid batch request"))
+			return
+		}
+	} else {
+		var req wsRpcRequest
+		if err := json.Unmarshal(msg, &req); err != nil {
+			c.sendJson(newWsRpcError(wsNullId, wsRpcParseError, err.Error()))
+			return
+		}
+		reqs = append(reqs, req)
+	}
+
+	if rejected := ws.rateLimit(c, reqs); len(rejected) > 0 {
+		if isBatch {
+			c.sendJson(rejected)
+		} else {
+			c.sendJson(rejected[0])
+		}
+		return
+	}
+
+	var (
+		resps      []*wsRpcResponse
+		afterFuncs []func()
+	)
+	for _, req := range reqs {
+		start := time.Now()
+		resp, after := ws.handleRequest(c, req)
+		ws.observe(req.Method, time.Since(start))
+		// notifications have no response
+		if len(req.Id) > 0 {
+			resps = append(resps, resp)
+		}
+		if after != nil {
+			afterFuncs = append(afterFuncs, after)
+		}
+	}
+
+	if isBatch && len(resps) > 0 {
+		c.sendJson(resps)
+	} else if !isBatch && len(resps) > 0 {
+		c.sendJson(resps[0])
+	}
+	for _, fn := range afterFuncs {
+		fn()
+	}
+}
+
+// rateLimit checks every call of a message, a batch is rejected as a whole if any call of it is rejected
+func (ws *WebsocketServiceImpl) rateLimit(c *wsConn, reqs []wsRpcRequest) []*wsRpcResponse {
+	if ws.rateLimiter == nil {
+		return nil
+	}
+	var (
+		errs     = make([]*ratelimit.RpcError, len(reqs))
+		rejected = false
+	)
+	for i, req := range reqs {
+		if errs[i] = ws.rateLimiter.CheckHeader(req.Method, c.RemoteAddr().String(), c.RemoteHeader()); errs[i] != nil {
+			rejected = true
+		}
+	}
+	if !rejected {
+		return nil
+	}
+
+	resps := make([]*wsRpcResponse, len(reqs))
+	for i, req := range reqs {
+		id := req.Id
+		if len(id) == 0 {
+			id = wsNullId
+		}
+		if errs[i] != nil {
+			resps[i] = newWsRpcError(id, errs[i].Code, errs[i].Message)
+		} else {
+			resps[i] = newWsRpcError(id, ratelimit.ErrCodeLimitExceeded, "batch rejected by rate limit")
+		}
+	}
+	return resps
+}
+
+func (ws *WebsocketServiceImpl) observe(method string, cost time.Duration) {
+	if !ws.knownMethods[method] {
+		method = "unknown"
+	}
+	metrics.RpcDuration.Observe(method, cost.Seconds())
+}
+
+func (ws *WebsocketServiceImpl) handleRequest(c *wsConn, req wsRpcRequest) (*wsRpcResponse, func()) {
+	id := req.Id
+	if len(id) == 0 {
+		id = wsNullId
+	}
+	if req.Version != wsRpcVersion || len(req.Method) == 0 {
+		return newWsRpcError(id, wsRpcInvalidRequest, "invalid request"), nil
+	}
+
+	var params []json.RawMessage
+	if len(req.Params) > 0 && !bytes.Equal(req.Params, wsNullId) {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return newWsRpcError(id, wsRpcInvalidParams, "params should be an array"), nil
+		}
+	}
+
+	switch req.Method {
+	case wsRpcSubscribeMethod:
+		return ws.handleSubscribe(c, id, params)
+	case wsRpcUnsubscribeMethod:
+		return ws.handleUnsubscribe(c, id, params), nil
+	case wsRpcAuthChallenge:
+		result, _ := json.Marshal(ws.socketIOService.newChallenge(c.ID()))
+		return newWsRpcResult(id, result), nil
+	case wsRpcAuth:
+		return ws.handleAuth(c, id, params), nil
+	}
+
+	result, err := inproc.Call(ws.rpcServer, req.Method, params)
 	if err != nil {
-		log.Error("get ws connection error , " + err.Error())
+		code := wsRpcServerError
+		if rpcErr, ok := err.(*inproc.Error); ok {
+			code = rpcErr.Code
+		}
+		return newWsRpcError(id, code, err.Error()), nil
+	}
+	return newWsRpcResult(id, result), nil
+}
+
+// handleSubscribe subscribes topic with query, which is the msg of the topic's socket.io _req event,
+// it can be a json string or a json object. one connection has at most one subscription of a topic,
+// the query of the topic is replaced by subscribing it again
+func (ws *WebsocketServiceImpl) handleSubscribe(c *wsConn, id json.RawMessage, params []json.RawMessage) (*wsRpcResponse, func()) {
+	if len(params) == 0 || len(params) > 2 {
+		return newWsRpcError(id, wsRpcInvalidParams, "params should be [topic, query]"), nil
+	}
+	var topic string
+	if err := json.Unmarshal(params[0], &topic); err != nil || !ws.socketIOService.isEventType(topic) {
+		return newWsRpcError(id, wsRpcInvalidParams, "unsupported topic"), nil
+	}
+	query := ""
+	if len(params) == 2 {
+		if err := json.Unmarshal(params[1], &query); err != nil {
+			query = string(params[1])
+		}
+	}
+
+	if err := ws.socketIOService.authorize(c.ID(), topic, query); err != nil {
+		return newWsRpcError(id, wsRpcUnauthorized, err.Error()), nil
+	}
+
+	subId, err := c.subscribe(topic)
+	if err != nil {
+		return newWsRpcError(id, wsRpcServerError, err.Error()), nil
+	}
+	result, _ := json.Marshal(subId)
+	return newWsRpcResult(id, result), func() {
+		if c.isClosed() {
+			return
+		}
+		ws.socketIOService.subscribe(c, topic, query)
+		// c closed while subscribing may have been disconnected before, it isn't left in the subscriptions
+		if c.isClosed() {
+			ws.socketIOService.disconnect(c.ID())
+		}
+	}
+}
+
+func (ws *WebsocketServiceImpl) handleUnsubscribe(c *wsConn, id json.RawMessage, params []json.RawMessage) *wsRpcResponse {
+	var subId string
+	if len(params) != 1 || json.Unmarshal(params[0], &subId) != nil {
+		return newWsRpcError(id, wsRpcInvalidParams, "params should be [subscription]")
+	}
+	topic, ok := c.unsubscribe(subId)
+	if ok {
+		ws.socketIOService.unsubscribe(c, topic)
+	}
+	result, _ := json.Marshal(ok)
+	return newWsRpcResult(id, result)
+}
+
+// handleAuth binds the owner of the sign of the connection's challenge to it, like the auth event of socket.io
+func (ws *WebsocketServiceImpl) handleAuth(c *wsConn, id json.RawMessage, params []json.RawMessage) *wsRpcResponse {
+	var req AuthRequest
+	if len(params) != 1 || json.Unmarshal(params[0], &req) != nil {
+		return newWsRpcError(id, wsRpcInvalidParams, "params should be [{sign}]")
+	}
+	authResult, err := ws.socketIOService.authenticate(c.ID(), req.Sign)
+	if err != nil {
+		return newWsRpcError(id, wsRpcUnauthorized, err.Error())
+	}
+	result, _ := json.Marshal(authResult)
+	return newWsRpcResult(id, result)
+}
+
+func newWsRpcResult(id json.RawMessage, result json.RawMessage) *wsRpcResponse {
+	if len(result) == 0 {
+		result = wsNullId
+	}
+	return &wsRpcResponse{Version: wsRpcVersion, Id: id, Result: result}
+}
+
+func newWsRpcError(id json.RawMessage, code int, message string) *wsRpcResponse {
+	return &wsRpcResponse{Version: wsRpcVersion, Id: id, Error: &wsRpcError{Code: code, Message: message}}
+}
+
+// wsConn is a websocket connection of WebsocketServiceImpl, it implements socketio.Conn,
+// so that socket.io pushes the topics it subscribes like to its own connections
+type wsConn struct {
+	id     string
+	conn   *websocket.Conn
+	url    url.URL
+	header http.Header
+	send   chan []byte
+	closed chan struct{}
+	once   sync.Once
+
+	mtx           sync.Mutex
+	done          bool // closed, read under mtx
+	context       map[string]string
+	subscriptions map[string]string // topic to subscription id
+}
+
+func newWsConn(conn *websocket.Conn, r *http.Request) *wsConn {
+	c := &wsConn{}
+	c.id = "ws_" + randomHex(16)
+	c.conn = conn
+	c.url = *r.URL
+	c.header = r.Header
+	c.send = make(chan []byte, wsSendBufferSize)
+	c.closed = make(chan struct{})
+	c.subscriptions = make(map[string]string)
+	return c
+}
+
+func randomHex(n int) string {
+	bs := make([]byte, n)
+	rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+func (c *wsConn) ID() string                { return c.id }
+func (c *wsConn) URL() url.URL              { return c.url }
+func (c *wsConn) LocalAddr() net.Addr       { return c.conn.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr      { return c.conn.RemoteAddr() }
+func (c *wsConn) RemoteHeader() http.Header { return c.header }
+func (c *wsConn) Namespace() string         { return "/" }
+
+func (c *wsConn) Close() error {
+	c.once.Do(func() {
+		c.mtx.Lock()
+		c.done = true
+		c.mtx.Unlock()
+		close(c.closed)
+		c.conn.Close()
+	})
+	return nil
+}
+
+func (c *wsConn) isClosed() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.done
+}
+
+// Context returns a copy of the queries of subscribed topics, since socket.io reads it from the cron and kafka goroutines
+func (c *wsConn) Context() interface{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.context == nil {
+		return nil
+	}
+	context := make(map[string]string, len(c.context))
+	for k, v := range c.context {
+		context[k] = v
+	}
+	return context
+}
+
+func (c *wsConn) SetContext(v interface{}) {
+	context, _ := v.(map[string]string)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.context = context
+}
+
+// Emit sends the data pushed by socket.io to the subscription of its topic
+func (c *wsConn) Emit(msg string, v ...interface{}) {
+	topic := strings.TrimSuffix(msg, EventPostfixRes)
+	c.mtx.Lock()
+	subId, ok := c.subscriptions[topic]
+	c.mtx.Unlock()
+	if !ok || len(v) == 0 {
+		return
+	}
+
+	var result json.RawMessage
+	if s, isString := v[0].(string); isString && json.Valid([]byte(s)) {
+		result = json.RawMessage(s)
+	} else if bs, err := json.Marshal(v[0]); err == nil {
+		result = bs
+	} else {
+		log.Errorf("websocket, marshal data of topic:%s error:%s", topic, err.Error())
+		return
+	}
+	c.sendJson(&wsRpcNotification{
+		Version: wsRpcVersion,
+		Method:  wsRpcNotificationMethod,
+		Params:  wsRpcSubscriptionResult{Subscription: subId, Topic: topic, Result: result},
+	})
+}
+
+func (c *wsConn) subscribe(topic string) (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if subId, ok := c.subscriptions[topic]; ok {
+		return subId, nil
+	}
+	select {
+	case <-c.closed:
+		return "", errors.New("connection closed")
+	default:
+	}
+	subId := "0x" + randomHex(16)
+	c.subscriptions[topic] = subId
+	return subId, nil
+}
+
+func (c *wsConn) unsubscribe(subId string) (string, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for topic, v := range c.subscriptions {
+		if v == subId {
+			delete(c.subscriptions, topic)
+			return topic, true
+		}
+	}
+	return "", false
+}
+
+// sendJson queues v to the writer, the connection is closed if the client can't keep up with it
+func (c *wsConn) sendJson(v interface{}) {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		log.Errorf("websocket, marshal message error:%s", err.Error())
 		return
 	}
-	client := &SocketClient{node: node, conn: conn}
-	client.node.register <- client
-	go client.write()
-	go client.read()
+	select {
+	case <-c.closed:
+	case c.send <- bs:
+	default:
+		log.Infof("websocket, connection:%s is too slow, closing it", c.id)
+		c.Close()
+	}
+}
+
+func (c *wsConn) write() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case bs := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, bs); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
 }
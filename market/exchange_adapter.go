@@ -0,0 +1,450 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	binance  = "binance"
+	okex     = "okex"
+	huobi    = "huobi"
+	bittrex  = "bittrex"
+	poloniex = "poloniex"
+)
+
+// ExchangeAdapter maps markets to symbols of an exchange and parses its ticker responses,
+// the collector requests the url of the adapter and saves tickers of supported markets
+type ExchangeAdapter interface {
+	Name() string
+	// DefaultUrl is used if url of the exchange isn't set in ticker_collector
+	DefaultUrl() string
+	// PerSymbol returns true if the url contains %s and must be requested with the symbol of every market,
+	// otherwise the url returns tickers of all markets
+	PerSymbol() bool
+	// Symbol converts market, e.g. LRC-WETH, to the symbol of exchange
+	Symbol(market string) string
+	// Market converts symbol of exchange to market, ok is false if it isn't an eth market
+	Market(symbol string) (market string, ok bool)
+	// ParseTickers parses the response body, Market of tickers is the symbol of exchange.
+	// symbol is the requested one if PerSymbol, otherwise empty
+	ParseTickers(symbol string, body []byte) ([]Ticker, error)
+}
+
+var (
+	exchangeAdapters    = make(map[string]ExchangeAdapter)
+	exchangeAdaptersMtx sync.RWMutex
+)
+
+// RegisterExchangeAdapter makes an adapter available to ticker_collector.exchanges,
+// it must be called before NewCollector
+func RegisterExchangeAdapter(adapter ExchangeAdapter) error {
+	exchangeAdaptersMtx.Lock()
+	defer exchangeAdaptersMtx.Unlock()
+
+	if adapter == nil || adapter.Name() == "" {
+		return fmt.Errorf("market,register exchange adapter,adapter and name can't be empty")
+	}
+	if _, exists := exchangeAdapters[adapter.Name()]; exists {
+		return fmt.Errorf("market,register exchange adapter,adapter:%s has been registered", adapter.Name())
+	}
+	exchangeAdapters[adapter.Name()] = adapter
+	return nil
+}
+
+func getExchangeAdapter(name string) (ExchangeAdapter, bool) {
+	exchangeAdaptersMtx.RLock()
+	defer exchangeAdaptersMtx.RUnlock()
+	adapter, ok := exchangeAdapters[name]
+	return adapter, ok
+}
+
+func init() {
+	RegisterExchangeAdapter(&BinanceAdapter{})
+	RegisterExchangeAdapter(&OkexAdapter{})
+	RegisterExchangeAdapter(&HuobiAdapter{})
+	RegisterExchangeAdapter(&BittrexAdapter{})
+	RegisterExchangeAdapter(&PoloniexAdapter{})
+}
+
+// splitMarket returns token and base of market, WETH is ETH on exchanges
+func splitMarket(market string) (token, base string) {
+	pair := strings.SplitN(strings.ToUpper(market), "-", 2)
+	if len(pair) != 2 {
+		return pair[0], ""
+	}
+	token, base = pair[0], pair[1]
+	if base == "WETH" {
+		base = "ETH"
+	}
+	return token, base
+}
+
+// ethMarket returns market of token in eth market
+func ethMarket(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	return strings.ToUpper(token) + "-WETH", true
+}
+
+func formatChange(percent float64) string {
+	if percent > 0 {
+		return fmt.Sprintf("+%.2f%%", percent)
+	}
+	return fmt.Sprintf("%.2f%%", percent)
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+type BinanceTicker struct {
+	Symbol    string `json:"symbol"`
+	Change    string `json:"priceChangePercent"`
+	Close     string `json:"prevClosePrice"`
+	Open      string `json:"openPrice"`
+	High      string `json:"highPrice"`
+	Low       string `json:"lowPrice"`
+	LastPrice string `json:"lastPrice"`
+	Amount    string `json:"volume"`
+	Vol       string `json:"quoteVolume"`
+	Ask       string `json:"askPrice"`
+	Bid       string `json:"bidPrice"`
+}
+
+// BinanceAdapter uses tickers of all symbols, symbol is like LRCETH
+type BinanceAdapter struct{}
+
+func (a *BinanceAdapter) Name() string { return binance }
+
+func (a *BinanceAdapter) DefaultUrl() string { return "https://api.binance.com/api/v1/ticker/24hr" }
+
+func (a *BinanceAdapter) PerSymbol() bool { return false }
+
+func (a *BinanceAdapter) Symbol(market string) string {
+	token, base := splitMarket(market)
+	return token + base
+}
+
+func (a *BinanceAdapter) Market(symbol string) (string, bool) {
+	if !strings.HasSuffix(symbol, "ETH") {
+		return "", false
+	}
+	return ethMarket(strings.TrimSuffix(symbol, "ETH"))
+}
+
+func (a *BinanceAdapter) ParseTickers(symbol string, body []byte) ([]Ticker, error) {
+	var binanceTickers []BinanceTicker
+	if err := json.Unmarshal(body, &binanceTickers); nil != err {
+		return nil, err
+	}
+	if len(binanceTickers) == 0 {
+		return nil, fmt.Errorf("fetch ticker from binance failed")
+	}
+
+	tickers := make([]Ticker, 0)
+	for _, binanceTicker := range binanceTickers {
+		ticker := Ticker{}
+		ticker.Market = binanceTicker.Symbol
+		ticker.Amount = parseFloat(binanceTicker.Amount)
+		ticker.Open = parseFloat(binanceTicker.Open)
+		ticker.Close = parseFloat(binanceTicker.Close)
+		ticker.Last = parseFloat(binanceTicker.LastPrice)
+		ticker.Change = formatChange(parseFloat(binanceTicker.Change))
+		ticker.Vol = parseFloat(binanceTicker.Vol)
+		ticker.High = parseFloat(binanceTicker.High)
+		ticker.Low = parseFloat(binanceTicker.Low)
+		ticker.Buy = parseFloat(binanceTicker.Bid)
+		ticker.Sell = parseFloat(binanceTicker.Ask)
+		tickers = append(tickers, ticker)
+	}
+	return tickers, nil
+}
+
+type OkexFullTicker struct {
+	Code int              `json:"code"`
+	Data []OkexTickerElem `json:"data"`
+	Msg  string           `json:"msg"`
+}
+
+type OkexTickerElem struct {
+	Buy    string `json:"buy"`
+	Sell   string `json:"sell"`
+	Last   string `json:"last"`
+	Vol    string `json:"volume"`
+	Symbol string `json:"symbol"`
+	High   string `json:"high"`
+	Low    string `json:"low"`
+	Change string `json:"changePercentage"`
+}
+
+// OkexAdapter uses tickers of all symbols, symbol is like lrc_eth
+type OkexAdapter struct{}
+
+func (a *OkexAdapter) Name() string { return okex }
+
+func (a *OkexAdapter) DefaultUrl() string { return "https://www.okex.com/v2/markets/tickers" }
+
+func (a *OkexAdapter) PerSymbol() bool { return false }
+
+func (a *OkexAdapter) Symbol(market string) string {
+	token, base := splitMarket(market)
+	return strings.ToLower(token + "_" + base)
+}
+
+func (a *OkexAdapter) Market(symbol string) (string, bool) {
+	if !strings.HasSuffix(symbol, "_eth") {
+		return "", false
+	}
+	return ethMarket(strings.TrimSuffix(symbol, "_eth"))
+}
+
+func (a *OkexAdapter) ParseTickers(symbol string, body []byte) ([]Ticker, error) {
+	var okexOutTicker OkexFullTicker
+	if err := json.Unmarshal(body, &okexOutTicker); nil != err {
+		return nil, err
+	}
+	if okexOutTicker.Code != 0 {
+		return nil, fmt.Errorf("get ticker from okex error:%s", okexOutTicker.Msg)
+	}
+
+	tickers := make([]Ticker, 0)
+	for _, v := range okexOutTicker.Data {
+		ticker := Ticker{}
+		ticker.Market = v.Symbol
+		ticker.Last = parseFloat(v.Last)
+		ticker.Change = v.Change
+		ticker.Amount = parseFloat(v.Vol)
+		ticker.Vol = ticker.Amount * ticker.Last
+		ticker.High = parseFloat(v.High)
+		ticker.Low = parseFloat(v.Low)
+		ticker.Buy = parseFloat(v.Buy)
+		ticker.Sell = parseFloat(v.Sell)
+		tickers = append(tickers, ticker)
+	}
+	return tickers, nil
+}
+
+type HuobiTicker struct {
+	Timestamp int64            `json:"ts"`
+	ErrorCode string           `json:"err-code"`
+	Status    string           `json:"status"`
+	Tick      HuobiInnerTicker `json:"tick"`
+}
+
+type HuobiInnerTicker struct {
+	Close  float64   `json:"close"`
+	Open   float64   `json:"open"`
+	High   float64   `json:"high"`
+	Low    float64   `json:"low"`
+	Amount float64   `json:"amount"`
+	Count  int       `json:"count"`
+	Vol    float64   `json:"vol"`
+	Ask    []float64 `json:"ask"`
+	Bid    []float64 `json:"bid"`
+}
+
+// HuobiAdapter requests the merged ticker of every symbol, symbol is like lrceth
+type HuobiAdapter struct{}
+
+func (a *HuobiAdapter) Name() string { return huobi }
+
+func (a *HuobiAdapter) DefaultUrl() string {
+	return "https://api.huobi.pro/market/detail/merged?symbol=%s"
+}
+
+func (a *HuobiAdapter) PerSymbol() bool { return true }
+
+func (a *HuobiAdapter) Symbol(market string) string {
+	token, base := splitMarket(market)
+	return strings.ToLower(token + base)
+}
+
+func (a *HuobiAdapter) Market(symbol string) (string, bool) {
+	if !strings.HasSuffix(symbol, "eth") {
+		return "", false
+	}
+	return ethMarket(strings.TrimSuffix(symbol, "eth"))
+}
+
+func (a *HuobiAdapter) ParseTickers(symbol string, body []byte) ([]Ticker, error) {
+	var huobiTicker HuobiTicker
+	if err := json.Unmarshal(body, &huobiTicker); nil != err {
+		return nil, err
+	}
+	if huobiTicker.Status == "error" {
+		return nil, fmt.Errorf("get ticker from huobi error:%s", huobiTicker.ErrorCode)
+	}
+
+	ticker := Ticker{}
+	innerTicker := huobiTicker.Tick
+	ticker.Market = symbol
+	ticker.Amount = innerTicker.Amount
+	ticker.Open = innerTicker.Open
+	ticker.Close = innerTicker.Close
+	if len(innerTicker.Bid) > 0 {
+		ticker.Last = innerTicker.Bid[0]
+		ticker.Buy = innerTicker.Bid[0]
+	}
+	if len(innerTicker.Ask) > 0 {
+		ticker.Sell = innerTicker.Ask[0]
+	}
+	if ticker.Open > 0 {
+		ticker.Change = formatChange(100 * (ticker.Last - ticker.Open) / ticker.Open)
+	}
+	ticker.Vol = innerTicker.Vol
+	ticker.High = innerTicker.High
+	ticker.Low = innerTicker.Low
+	return []Ticker{ticker}, nil
+}
+
+type BittrexSummaries struct {
+	Success bool             `json:"success"`
+	Message string           `json:"message"`
+	Result  []BittrexSummary `json:"result"`
+}
+
+type BittrexSummary struct {
+	MarketName string  `json:"MarketName"`
+	High       float64 `json:"High"`
+	Low        float64 `json:"Low"`
+	Volume     float64 `json:"Volume"`
+	Last       float64 `json:"Last"`
+	BaseVolume float64 `json:"BaseVolume"`
+	Bid        float64 `json:"Bid"`
+	Ask        float64 `json:"Ask"`
+	PrevDay    float64 `json:"PrevDay"`
+}
+
+// BittrexAdapter uses summaries of all markets, symbol is like ETH-LRC
+type BittrexAdapter struct{}
+
+func (a *BittrexAdapter) Name() string { return bittrex }
+
+func (a *BittrexAdapter) DefaultUrl() string {
+	return "https://bittrex.com/api/v1.1/public/getmarketsummaries"
+}
+
+func (a *BittrexAdapter) PerSymbol() bool { return false }
+
+func (a *BittrexAdapter) Symbol(market string) string {
+	token, base := splitMarket(market)
+	return base + "-" + token
+}
+
+func (a *BittrexAdapter) Market(symbol string) (string, bool) {
+	if !strings.HasPrefix(symbol, "ETH-") {
+		return "", false
+	}
+	return ethMarket(strings.TrimPrefix(symbol, "ETH-"))
+}
+
+func (a *BittrexAdapter) ParseTickers(symbol string, body []byte) ([]Ticker, error) {
+	var summaries BittrexSummaries
+	if err := json.Unmarshal(body, &summaries); nil != err {
+		return nil, err
+	}
+	if !summaries.Success {
+		return nil, fmt.Errorf("get ticker from bittrex error:%s", summaries.Message)
+	}
+
+	tickers := make([]Ticker, 0)
+	for _, v := range summaries.Result {
+		ticker := Ticker{}
+		ticker.Market = v.MarketName
+		ticker.Open = v.PrevDay
+		ticker.Last = v.Last
+		if v.PrevDay > 0 {
+			ticker.Change = formatChange(100 * (v.Last - v.PrevDay) / v.PrevDay)
+		}
+		ticker.Amount = v.Volume
+		ticker.Vol = v.BaseVolume
+		ticker.High = v.High
+		ticker.Low = v.Low
+		ticker.Buy = v.Bid
+		ticker.Sell = v.Ask
+		tickers = append(tickers, ticker)
+	}
+	return tickers, nil
+}
+
+type PoloniexTicker struct {
+	Last          string `json:"last"`
+	LowestAsk     string `json:"lowestAsk"`
+	HighestBid    string `json:"highestBid"`
+	PercentChange string `json:"percentChange"`
+	BaseVolume    string `json:"baseVolume"`
+	QuoteVolume   string `json:"quoteVolume"`
+	High          string `json:"high24hr"`
+	Low           string `json:"low24hr"`
+}
+
+// PoloniexAdapter uses tickers of all markets keyed by symbol, symbol is like ETH_LRC
+type PoloniexAdapter struct{}
+
+func (a *PoloniexAdapter) Name() string { return poloniex }
+
+func (a *PoloniexAdapter) DefaultUrl() string {
+	return "https://poloniex.com/public?command=returnTicker"
+}
+
+func (a *PoloniexAdapter) PerSymbol() bool { return false }
+
+func (a *PoloniexAdapter) Symbol(market string) string {
+	token, base := splitMarket(market)
+	return base + "_" + token
+}
+
+func (a *PoloniexAdapter) Market(symbol string) (string, bool) {
+	if !strings.HasPrefix(symbol, "ETH_") {
+		return "", false
+	}
+	return ethMarket(strings.TrimPrefix(symbol, "ETH_"))
+}
+
+func (a *PoloniexAdapter) ParseTickers(symbol string, body []byte) ([]Ticker, error) {
+	var poloniexTickers map[string]PoloniexTicker
+	if err := json.Unmarshal(body, &poloniexTickers); nil != err {
+		return nil, err
+	}
+
+	tickers := make([]Ticker, 0)
+	for sym, v := range poloniexTickers {
+		ticker := Ticker{}
+		ticker.Market = sym
+		ticker.Last = parseFloat(v.Last)
+		// percentChange of poloniex is a ratio
+		ticker.Change = formatChange(100 * parseFloat(v.PercentChange))
+		ticker.Amount = parseFloat(v.QuoteVolume)
+		ticker.Vol = parseFloat(v.BaseVolume)
+		ticker.High = parseFloat(v.High)
+		ticker.Low = parseFloat(v.Low)
+		ticker.Buy = parseFloat(v.HighestBid)
+		ticker.Sell = parseFloat(v.LowestAsk)
+		tickers = append(tickers, ticker)
+	}
+	return tickers, nil
+}
@@ -401,6 +401,12 @@ func (g *GlobalMarket) Start() {
 	}()
 }
 
+// Stop stops the cron jobs and releases the zklock if it is held by this node
+func (g *GlobalMarket) Stop() {
+	g.cron.Stop()
+	zklock.ReleaseLock(GMCLock)
+}
+
 func syncData(redisKey string, syncFunc func(token string) ([]byte, []byte, error)) {
 	if GM == nil {
 		return
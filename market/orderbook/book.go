@@ -0,0 +1,149 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package orderbook
+
+import (
+	"bytes"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// side is the open orders selling tokenS for tokenB of a delegate, sorted by price desc like dao.GetOrderBook.
+// states are replaced rather than modified, so the ones returned by orders can be read without lock
+type side struct {
+	orders []*types.OrderState
+
+	// truncated is set when the seed query hit its limit, orders beyond it are only in db
+	truncated bool
+}
+
+// drained reports whether a truncated side lost half of its seed, it's seeded again to take the orders left in db
+func (s *side) drained() bool {
+	return s.truncated && len(s.orders) < seedLength/2
+}
+
+func sideKey(delegate, tokenS, tokenB common.Address) string {
+	return strings.ToLower(delegate.Hex() + "_" + tokenS.Hex() + "_" + tokenB.Hex())
+}
+
+func stateSideKey(state *types.OrderState) string {
+	return sideKey(state.RawOrder.DelegateAddress, state.RawOrder.TokenS, state.RawOrder.TokenB)
+}
+
+// isOpen reports whether state should be in the book, it's the status and type filter of dao.GetOrderBook
+func isOpen(state *types.OrderState) bool {
+	if state.RawOrder.OrderType != types.ORDER_TYPE_MARKET {
+		return false
+	}
+	return state.Status == types.ORDER_NEW || state.Status == types.ORDER_PARTIAL
+}
+
+// isValidAt is the time filter of dao.GetOrderBook
+func isValidAt(state *types.OrderState, now int64) bool {
+	since, until := state.RawOrder.ValidSince, state.RawOrder.ValidUntil
+	if since == nil || until == nil {
+		return false
+	}
+	return since.Int64() < now && until.Int64() >= now
+}
+
+func orderPrice(state *types.OrderState) *big.Rat {
+	amountS, amountB := state.RawOrder.AmountS, state.RawOrder.AmountB
+	if amountS == nil || amountB == nil || amountB.Sign() == 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(amountS, amountB)
+}
+
+// less orders by price desc, then earlier valid since and order hash so that the order is stable
+func less(a, b *types.OrderState) bool {
+	if c := orderPrice(a).Cmp(orderPrice(b)); c != 0 {
+		return c > 0
+	}
+	if a.RawOrder.ValidSince != nil && b.RawOrder.ValidSince != nil {
+		if c := a.RawOrder.ValidSince.Cmp(b.RawOrder.ValidSince); c != 0 {
+			return c < 0
+		}
+	}
+	return bytes.Compare(a.RawOrder.Hash.Bytes(), b.RawOrder.Hash.Bytes()) < 0
+}
+
+func newSide(states []*types.OrderState, truncated bool) *side {
+	s := &side{truncated: truncated}
+	for _, state := range states {
+		s.put(state)
+	}
+	return s
+}
+
+// put inserts state at its price, replacing the state of the same order
+func (s *side) put(state *types.OrderState) {
+	s.remove(state.RawOrder.Hash)
+	i := sort.Search(len(s.orders), func(i int) bool { return less(state, s.orders[i]) })
+	s.orders = append(s.orders, nil)
+	copy(s.orders[i+1:], s.orders[i:])
+	s.orders[i] = state
+}
+
+func (s *side) remove(hash common.Hash) bool {
+	for i, state := range s.orders {
+		if state.RawOrder.Hash == hash {
+			s.orders = append(s.orders[:i], s.orders[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// removeIf removes the orders matched by fn and returns their hashes
+func (s *side) removeIf(fn func(state *types.OrderState) bool) []common.Hash {
+	var (
+		kept    = s.orders[:0]
+		removed []common.Hash
+	)
+	for _, state := range s.orders {
+		if fn(state) {
+			removed = append(removed, state.RawOrder.Hash)
+		} else {
+			kept = append(kept, state)
+		}
+	}
+	for i := len(kept); i < len(s.orders); i++ {
+		s.orders[i] = nil
+	}
+	s.orders = kept
+	return removed
+}
+
+// list returns at most length orders valid at now, the best priced first
+func (s *side) list(length int, now int64) []types.OrderState {
+	list := make([]types.OrderState, 0)
+	for _, state := range s.orders {
+		if length > 0 && len(list) >= length {
+			break
+		}
+		if isValidAt(state, now) {
+			list = append(list, *state)
+		}
+	}
+	return list
+}
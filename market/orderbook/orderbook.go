@@ -0,0 +1,344 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package orderbook keeps the open market orders of every delegate and market in memory,
+// so depth and order book queries don't read mysql.
+// a side is seeded by dao.GetOpenOrderBook when it's read first, the delegates and markets known at start are seeded
+// by Start, then it's updated by the order states, cutoffs and flex cancels published to kafka by order manager
+// and gateway. every node consumes all partitions of them without a consumer group, since each node only handles
+// part of the chain events and the book is seeded from db again after a restart
+package orderbook
+
+import (
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	notify "github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-lib/eth/loopringaccessor"
+	"github.com/Loopring/relay-lib/kafka"
+	"github.com/Loopring/relay-lib/log"
+	util "github.com/Loopring/relay-lib/marketutil"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"sync"
+	"time"
+)
+
+const seedLength = 1000
+
+type OrderBook struct {
+	rds      *dao.RdsService
+	brokers  []string
+	consumer *notify.PartitionConsumer
+	load     func(delegate, tokenS, tokenB common.Address) ([]*types.OrderState, error)
+
+	mtx      sync.RWMutex
+	sides    map[string]*side
+	keys     map[common.Hash]string // order hash to side key
+	seedings map[string]*seeding
+}
+
+// seeding is a side being seeded, the updates of it are recorded by ops while db is queried
+// and applied to the seeded side before it replaces the old one
+type seeding struct {
+	ops  []func(s *side)
+	done chan struct{}
+	side *side
+	err  error
+}
+
+func NewOrderBook(rds *dao.RdsService, brokers []string) *OrderBook {
+	ob := newOrderBook()
+	ob.rds = rds
+	ob.brokers = brokers
+	ob.load = ob.loadFromDb
+	return ob
+}
+
+func newOrderBook() *OrderBook {
+	ob := &OrderBook{}
+	ob.sides = make(map[string]*side)
+	ob.keys = make(map[common.Hash]string)
+	ob.seedings = make(map[string]*seeding)
+	return ob
+}
+
+func (ob *OrderBook) Start() {
+	consumer, err := notify.NewPartitionConsumer(ob.brokers)
+	if err != nil {
+		log.Fatalf("orderbook,create kafka consumer error:%s", err.Error())
+	}
+	ob.consumer = consumer
+
+	topics := map[string]struct {
+		data    interface{}
+		handler kafka.HandlerFunc
+	}{
+		kafka.Kafka_Topic_SocketIO_Order_Updated:       {types.OrderState{}, ob.handleOrderUpdate},
+		notify.Kafka_Topic_SocketIO_Orders_Updated:     {notify.OrdersUpdatedEvent{}, ob.handleOrdersUpdate},
+		kafka.Kafka_Topic_SocketIO_Cutoff:              {types.CutoffEvent{}, ob.handleCutoff},
+		kafka.Kafka_Topic_SocketIO_Cutoff_Pair:         {types.CutoffPairEvent{}, ob.handleCutoffPair},
+		kafka.Kafka_Topic_OrderManager_FlexCancelOrder: {types.FlexCancelOrderEvent{}, ob.handleFlexCancel},
+	}
+	for topic, v := range topics {
+		if err := ob.consumer.Register(topic, v.data, v.handler); err != nil {
+			log.Fatalf("orderbook,register kafka consumer of %s error:%s", topic, err.Error())
+		}
+	}
+
+	for delegate := range loopringaccessor.DelegateAddresses() {
+		for _, pair := range util.AllTokenPairs {
+			if _, err := ob.seed(delegate, pair.TokenS, pair.TokenB); err != nil {
+				log.Errorf("orderbook,seed delegate:%s tokenS:%s tokenB:%s error:%s", delegate.Hex(), pair.TokenS.Hex(), pair.TokenB.Hex(), err.Error())
+			}
+		}
+	}
+}
+
+func (ob *OrderBook) Stop() {
+	if ob.consumer != nil {
+		ob.consumer.Close()
+	}
+}
+
+// GetOrderBook returns at most length open orders selling tokenS for tokenB, which are valid now, the best priced first.
+// it has the same result as OrderViewer.GetOrderBook
+func (ob *OrderBook) GetOrderBook(delegate, tokenS, tokenB common.Address, length int) ([]types.OrderState, error) {
+	key := sideKey(delegate, tokenS, tokenB)
+
+	ob.mtx.RLock()
+	s, ok := ob.sides[key]
+	seeded := ok && !s.drained()
+	var list []types.OrderState
+	if seeded {
+		list = s.list(length, time.Now().Unix())
+	}
+	ob.mtx.RUnlock()
+
+	if seeded {
+		return list, nil
+	}
+
+	s, err := ob.seed(delegate, tokenS, tokenB)
+	if err != nil {
+		return nil, err
+	}
+	ob.mtx.RLock()
+	defer ob.mtx.RUnlock()
+	return s.list(length, time.Now().Unix()), nil
+}
+
+// seed replaces the side with the orders in db. db is queried without the lock,
+// the updates of the side that come meanwhile are recorded and applied to the seeded side.
+// callers seeding the same side at the same time wait for the first one
+func (ob *OrderBook) seed(delegate, tokenS, tokenB common.Address) (*side, error) {
+	key := sideKey(delegate, tokenS, tokenB)
+
+	ob.mtx.Lock()
+	if sd, ok := ob.seedings[key]; ok {
+		ob.mtx.Unlock()
+		<-sd.done
+		return sd.side, sd.err
+	}
+	sd := &seeding{done: make(chan struct{})}
+	ob.seedings[key] = sd
+	ob.mtx.Unlock()
+
+	defer close(sd.done)
+	states, err := ob.load(delegate, tokenS, tokenB)
+
+	ob.mtx.Lock()
+	defer ob.mtx.Unlock()
+	delete(ob.seedings, key)
+	if err != nil {
+		sd.err = err
+		return nil, err
+	}
+
+	s := newSide(states, len(states) >= seedLength)
+	for _, op := range sd.ops {
+		op(s)
+	}
+	if old, ok := ob.sides[key]; ok {
+		for _, state := range old.orders {
+			delete(ob.keys, state.RawOrder.Hash)
+		}
+	}
+	for _, state := range s.orders {
+		ob.keys[state.RawOrder.Hash] = key
+	}
+	ob.sides[key] = s
+	sd.side = s
+	return s, nil
+}
+
+// loadFromDb reads the open orders of a side including the ones valid since a future time,
+// they're filtered by time when the side is read
+func (ob *OrderBook) loadFromDb(delegate, tokenS, tokenB common.Address) ([]*types.OrderState, error) {
+	models, err := ob.rds.GetOpenOrderBook(delegate, tokenS, tokenB, seedLength)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*types.OrderState, 0, len(models))
+	for _, v := range models {
+		state := &types.OrderState{}
+		if err := v.ConvertUp(state); err != nil {
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// record adds op to the seeding of key, it's called with the lock held
+func (ob *OrderBook) record(key string, op func(s *side)) {
+	if sd, ok := ob.seedings[key]; ok {
+		sd.ops = append(sd.ops, op)
+	}
+}
+
+// ApplyOrderState puts state into its side if the order is open, otherwise removes it.
+// sides that aren't seeded are skipped, they read db when they are
+func (ob *OrderBook) ApplyOrderState(state *types.OrderState) {
+	ob.mtx.Lock()
+	defer ob.mtx.Unlock()
+
+	key := stateSideKey(state)
+	if oldKey, ok := ob.keys[state.RawOrder.Hash]; ok && oldKey != key {
+		ob.removeOrder(state.RawOrder.Hash)
+	}
+
+	ob.record(key, func(s *side) { applyToSide(s, state) })
+	s, ok := ob.sides[key]
+	if !ok {
+		return
+	}
+	if applyToSide(s, state) {
+		ob.keys[state.RawOrder.Hash] = key
+	} else {
+		delete(ob.keys, state.RawOrder.Hash)
+	}
+}
+
+// applyToSide puts state into s if the order is open, otherwise removes it, it returns whether state is put
+func applyToSide(s *side, state *types.OrderState) bool {
+	if isOpen(state) {
+		s.put(state)
+		return true
+	}
+	s.remove(state.RawOrder.Hash)
+	return false
+}
+
+// RemoveOrders removes the orders of owner matched by fn from all sides
+func (ob *OrderBook) RemoveOrders(owner common.Address, fn func(state *types.OrderState) bool) int {
+	ob.mtx.Lock()
+	defer ob.mtx.Unlock()
+
+	match := func(state *types.OrderState) bool {
+		return state.RawOrder.Owner == owner && fn(state)
+	}
+	for key := range ob.seedings {
+		ob.record(key, func(s *side) { s.removeIf(match) })
+	}
+
+	removed := 0
+	for _, s := range ob.sides {
+		hashes := s.removeIf(match)
+		for _, hash := range hashes {
+			delete(ob.keys, hash)
+		}
+		removed += len(hashes)
+	}
+	return removed
+}
+
+func (ob *OrderBook) removeOrder(hash common.Hash) {
+	key, ok := ob.keys[hash]
+	if !ok {
+		return
+	}
+	if s, ok := ob.sides[key]; ok {
+		s.remove(hash)
+	}
+	delete(ob.keys, hash)
+}
+
+func (ob *OrderBook) handleOrderUpdate(input interface{}) error {
+	state := input.(*types.OrderState)
+	ob.ApplyOrderState(state)
+	return nil
+}
+
+func (ob *OrderBook) handleOrdersUpdate(input interface{}) error {
+	event := input.(*notify.OrdersUpdatedEvent)
+	for i := range event.Orders {
+		ob.ApplyOrderState(&event.Orders[i])
+	}
+	return nil
+}
+
+// handleCutoff removes orders of owner valid since before cutoff, like dao.GetCutoffOrders
+func (ob *OrderBook) handleCutoff(input interface{}) error {
+	event := input.(*types.CutoffEvent)
+	if event.Cutoff == nil {
+		return fmt.Errorf("orderbook,cutoff event of owner:%s has no cutoff", event.Owner.Hex())
+	}
+	cutoff := event.Cutoff.Int64()
+	ob.RemoveOrders(event.Owner, func(state *types.OrderState) bool {
+		return state.RawOrder.ValidSince.Int64() < cutoff
+	})
+	return nil
+}
+
+// handleCutoffPair removes orders of owner between token1 and token2 valid since before cutoff, like dao.GetCutoffPairOrders
+func (ob *OrderBook) handleCutoffPair(input interface{}) error {
+	event := input.(*types.CutoffPairEvent)
+	if event.Cutoff == nil {
+		return fmt.Errorf("orderbook,cutoff pair event of owner:%s has no cutoff", event.Owner.Hex())
+	}
+	cutoff := event.Cutoff.Int64()
+	ob.RemoveOrders(event.Owner, func(state *types.OrderState) bool {
+		return isPair(state, event.Token1, event.Token2) && state.RawOrder.ValidSince.Int64() < cutoff
+	})
+	return nil
+}
+
+// handleFlexCancel removes the orders cancelled by manager.FlexCancelOrder
+func (ob *OrderBook) handleFlexCancel(input interface{}) error {
+	event := input.(*types.FlexCancelOrderEvent)
+
+	var fn func(state *types.OrderState) bool
+	switch event.Type {
+	case types.FLEX_CANCEL_BY_HASH:
+		fn = func(state *types.OrderState) bool { return state.RawOrder.Hash == event.OrderHash }
+	case types.FLEX_CANCEL_BY_OWNER, types.FLEX_CANCEL_BY_TIME:
+		fn = func(state *types.OrderState) bool { return true }
+	case types.FLEX_CANCEL_BY_MARKET:
+		fn = func(state *types.OrderState) bool { return isPair(state, event.TokenS, event.TokenB) }
+	default:
+		return fmt.Errorf("orderbook,flex cancel type:%d invalid", event.Type)
+	}
+	ob.RemoveOrders(event.Owner, fn)
+	return nil
+}
+
+func isPair(state *types.OrderState, token1, token2 common.Address) bool {
+	tokenS, tokenB := state.RawOrder.TokenS, state.RawOrder.TokenB
+	return (tokenS == token1 && tokenB == token2) || (tokenS == token2 && tokenB == token1)
+}
@@ -0,0 +1,223 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package orderbook
+
+import (
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var (
+	delegate = common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	owner    = common.HexToAddress("0x1b978a1d302335a6f2ebe4b8823b5e17c3c84135")
+	lrc      = common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	weth     = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+)
+
+func newState(hash string, amountS, amountB int64, status types.OrderStatus) *types.OrderState {
+	now := time.Now().Unix()
+	state := &types.OrderState{Status: status}
+	state.RawOrder.Hash = common.HexToHash(hash)
+	state.RawOrder.Owner = owner
+	state.RawOrder.DelegateAddress = delegate
+	state.RawOrder.TokenS = lrc
+	state.RawOrder.TokenB = weth
+	state.RawOrder.AmountS = big.NewInt(amountS)
+	state.RawOrder.AmountB = big.NewInt(amountB)
+	state.RawOrder.ValidSince = big.NewInt(now - 60)
+	state.RawOrder.ValidUntil = big.NewInt(now + 3600)
+	state.RawOrder.OrderType = types.ORDER_TYPE_MARKET
+	return state
+}
+
+func seeded(states ...*types.OrderState) *OrderBook {
+	ob := newOrderBook()
+	key := sideKey(delegate, lrc, weth)
+	ob.sides[key] = newSide(states, false)
+	for _, state := range states {
+		ob.keys[state.RawOrder.Hash] = key
+	}
+	return ob
+}
+
+func hashes(list []types.OrderState) []common.Hash {
+	var res []common.Hash
+	for _, state := range list {
+		res = append(res, state.RawOrder.Hash)
+	}
+	return res
+}
+
+func assertHashes(t *testing.T, list []types.OrderState, expected ...string) {
+	got := hashes(list)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d orders, got %d", len(expected), len(got))
+	}
+	for i, hash := range expected {
+		if got[i] != common.HexToHash(hash) {
+			t.Fatalf("order %d expected %s, got %s", i, common.HexToHash(hash).Hex(), got[i].Hex())
+		}
+	}
+}
+
+func TestSortedByPriceDesc(t *testing.T) {
+	ob := seeded(
+		newState("0x1", 100, 1, types.ORDER_NEW),
+		newState("0x2", 300, 1, types.ORDER_NEW),
+		newState("0x3", 200, 1, types.ORDER_PARTIAL),
+	)
+
+	list, err := ob.GetOrderBook(delegate, lrc, weth, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHashes(t, list, "0x2", "0x3", "0x1")
+
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 2)
+	assertHashes(t, list, "0x2", "0x3")
+}
+
+func TestApplyOrderState(t *testing.T) {
+	ob := seeded(newState("0x1", 100, 1, types.ORDER_NEW))
+
+	ob.ApplyOrderState(newState("0x2", 200, 1, types.ORDER_NEW))
+	list, _ := ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x2", "0x1")
+
+	partial := newState("0x1", 100, 1, types.ORDER_PARTIAL)
+	partial.DealtAmountS = big.NewInt(50)
+	ob.ApplyOrderState(partial)
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x2", "0x1")
+	if list[1].DealtAmountS.Int64() != 50 {
+		t.Fatalf("expected order replaced by the update")
+	}
+
+	ob.ApplyOrderState(newState("0x2", 200, 1, types.ORDER_FINISHED))
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x1")
+	if _, ok := ob.keys[common.HexToHash("0x2")]; ok {
+		t.Fatalf("expected finished order removed from index")
+	}
+
+	p2p := newState("0x3", 300, 1, types.ORDER_NEW)
+	p2p.RawOrder.OrderType = types.ORDER_TYPE_P2P
+	ob.ApplyOrderState(p2p)
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x1")
+}
+
+// order manager publishes the pending statuses of a ring or a cancel submitted, and the status settled after it
+func TestPendingRoundTrip(t *testing.T) {
+	ob := seeded(newState("0x1", 100, 1, types.ORDER_NEW), newState("0x2", 200, 1, types.ORDER_NEW))
+
+	for _, status := range []types.OrderStatus{types.ORDER_PENDING, types.ORDER_CANCELLING, types.ORDER_CUTOFFING} {
+		ob.ApplyOrderState(newState("0x2", 200, 1, status))
+		list, _ := ob.GetOrderBook(delegate, lrc, weth, 10)
+		assertHashes(t, list, "0x1")
+
+		ob.ApplyOrderState(newState("0x2", 200, 1, types.ORDER_NEW))
+		list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+		assertHashes(t, list, "0x2", "0x1")
+	}
+}
+
+func TestExpiredOrdersSkipped(t *testing.T) {
+	expired := newState("0x1", 200, 1, types.ORDER_NEW)
+	expired.RawOrder.ValidUntil = big.NewInt(time.Now().Unix() - 1)
+	ob := seeded(expired, newState("0x2", 100, 1, types.ORDER_NEW))
+
+	list, _ := ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x2")
+}
+
+func TestCutoffAndFlexCancel(t *testing.T) {
+	other := newState("0x3", 300, 1, types.ORDER_NEW)
+	other.RawOrder.Owner = common.HexToAddress("0x2")
+	ob := seeded(newState("0x1", 100, 1, types.ORDER_NEW), newState("0x2", 200, 1, types.ORDER_NEW), other)
+
+	ob.handleFlexCancel(&types.FlexCancelOrderEvent{Owner: owner, OrderHash: common.HexToHash("0x1"), Type: types.FLEX_CANCEL_BY_HASH})
+	list, _ := ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x3", "0x2")
+
+	ob.handleCutoffPair(&types.CutoffPairEvent{Owner: owner, Token1: weth, Token2: lrc, Cutoff: big.NewInt(time.Now().Unix())})
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x3")
+
+	ob.handleCutoff(&types.CutoffEvent{Owner: common.HexToAddress("0x2"), Cutoff: big.NewInt(time.Now().Unix() - 3600)})
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x3")
+
+	ob.handleCutoff(&types.CutoffEvent{Owner: common.HexToAddress("0x2"), Cutoff: big.NewInt(time.Now().Unix())})
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list)
+}
+
+func TestSeedAppliesUpdatesDuringQuery(t *testing.T) {
+	ob := newOrderBook()
+	querying := make(chan struct{})
+	release := make(chan struct{})
+	ob.load = func(delegate, tokenS, tokenB common.Address) ([]*types.OrderState, error) {
+		close(querying)
+		<-release
+		return []*types.OrderState{newState("0x1", 100, 1, types.ORDER_NEW), newState("0x2", 200, 1, types.ORDER_NEW)}, nil
+	}
+
+	done := make(chan []types.OrderState)
+	go func() {
+		list, err := ob.GetOrderBook(delegate, lrc, weth, 10)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- list
+	}()
+
+	<-querying
+	ob.ApplyOrderState(newState("0x3", 300, 1, types.ORDER_NEW))
+	ob.ApplyOrderState(newState("0x2", 200, 1, types.ORDER_FINISHED))
+	close(release)
+
+	assertHashes(t, <-done, "0x3", "0x1")
+	if _, ok := ob.keys[common.HexToHash("0x2")]; ok {
+		t.Fatalf("expected finished order not indexed")
+	}
+}
+
+func TestFutureOrdersServedWhenValid(t *testing.T) {
+	future := newState("0x1", 200, 1, types.ORDER_NEW)
+	future.RawOrder.ValidSince = big.NewInt(time.Now().Unix() + 2)
+	loads := 0
+	ob := newOrderBook()
+	ob.load = func(delegate, tokenS, tokenB common.Address) ([]*types.OrderState, error) {
+		loads++
+		return []*types.OrderState{future, newState("0x2", 100, 1, types.ORDER_NEW)}, nil
+	}
+
+	list, _ := ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x2")
+	time.Sleep(3 * time.Second)
+	list, _ = ob.GetOrderBook(delegate, lrc, weth, 10)
+	assertHashes(t, list, "0x1", "0x2")
+	if loads != 1 {
+		t.Fatalf("expected a seeded side not seeded again, loads:%d", loads)
+	}
+}
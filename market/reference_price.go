@@ -0,0 +1,128 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	defaultReferenceMaxAge       = 300 // seconds
+	defaultReferenceMaxDeviation = 0.1
+	defaultReferenceMinSources   = 1
+)
+
+// ReferencePriceOptions is ticker_collector.reference_price in relay.toml.
+// tickers older than MaxAge seconds are stale, tickers whose last price deviates from
+// the median by more than MaxDeviation are outliers, both are dropped
+type ReferencePriceOptions struct {
+	MaxAge       int64
+	MaxDeviation float64
+	MinSources   int
+}
+
+// ReferencePrice is the price of market weighted by volume of the collected exchange tickers
+type ReferencePrice struct {
+	Market    string   `json:"market"`
+	Price     float64  `json:"price"`
+	Vol       float64  `json:"vol"`
+	Sources   []string `json:"sources"`
+	Dropped   []string `json:"dropped"`
+	UpdatedAt int64    `json:"updatedAt"`
+}
+
+func (o ReferencePriceOptions) withDefault() ReferencePriceOptions {
+	if o.MaxAge <= 0 {
+		o.MaxAge = defaultReferenceMaxAge
+	}
+	if o.MaxDeviation <= 0 {
+		o.MaxDeviation = defaultReferenceMaxDeviation
+	}
+	if o.MinSources <= 0 {
+		o.MinSources = defaultReferenceMinSources
+	}
+	return o
+}
+
+// CalculateReferencePrice weights last prices of tickers by their vol,
+// UpdatedAt of the result is the latest one of the used tickers
+func CalculateReferencePrice(market string, tickers []Ticker, now int64, options ReferencePriceOptions) (ReferencePrice, error) {
+	options = options.withDefault()
+	rst := ReferencePrice{Market: market, Sources: make([]string, 0), Dropped: make([]string, 0)}
+
+	fresh := make([]Ticker, 0)
+	for _, t := range tickers {
+		if t.Last <= 0 || t.Vol <= 0 || now-t.UpdatedAt > options.MaxAge {
+			rst.Dropped = append(rst.Dropped, t.Exchange)
+			continue
+		}
+		fresh = append(fresh, t)
+	}
+	if len(fresh) == 0 {
+		return rst, fmt.Errorf("no fresh ticker of market:%s", market)
+	}
+
+	median := medianOfLast(fresh)
+	var sumVol, sumValue float64
+	for _, t := range fresh {
+		if deviation := (t.Last - median) / median; deviation > options.MaxDeviation || deviation < -options.MaxDeviation {
+			rst.Dropped = append(rst.Dropped, t.Exchange)
+			continue
+		}
+		sumVol += t.Vol
+		sumValue += t.Vol * t.Last
+		rst.Sources = append(rst.Sources, t.Exchange)
+		if t.UpdatedAt > rst.UpdatedAt {
+			rst.UpdatedAt = t.UpdatedAt
+		}
+	}
+	if len(rst.Sources) < options.MinSources {
+		return rst, fmt.Errorf("sources of market:%s are less than %d", market, options.MinSources)
+	}
+
+	rst.Price = sumValue / sumVol
+	rst.Vol = sumVol
+	sort.Strings(rst.Sources)
+	sort.Strings(rst.Dropped)
+	return rst, nil
+}
+
+func medianOfLast(tickers []Ticker) float64 {
+	prices := make([]float64, len(tickers))
+	for i, t := range tickers {
+		prices[i] = t.Last
+	}
+	sort.Float64s(prices)
+	mid := len(prices) / 2
+	if len(prices)%2 == 0 {
+		return (prices[mid-1] + prices[mid]) / 2
+	}
+	return prices[mid]
+}
+
+// GetReferencePrice calculates reference price of market with tickers of all enabled exchanges
+func (c *CollectorImpl) GetReferencePrice(market string) (ReferencePrice, error) {
+	tickers, err := c.GetTickers(market)
+	if nil != err {
+		return ReferencePrice{}, err
+	}
+	return CalculateReferencePrice(market, tickers, time.Now().Unix(), c.referencePrice)
+}
@@ -31,37 +31,46 @@ import (
 	"io/ioutil"
 	"net/http"
 	"qiniupkg.com/x/errors.v7"
-	"strconv"
 	"strings"
 	"time"
 )
 
 type ExchangeType string
 
-const (
-	binance = "binance"
-	okex    = "okex"
-	huobi   = "huobi"
-)
-
 // only support eth market now
 var supportedMarkets = make([]string, 0)
 
 const cachePreKey = "TICKER_EX_"
 
-//TODO (xiaolu)  add more exchanges to this list
-var exchanges = map[string]string{
-	binance: "https://api.binance.com/api/v1/ticker/24hr?symbol=%s",
-	okex:    "https://www.okex.com/api/v1/ticker.do?symbol=%s",
-	huobi:   "https://api.huobi.pro/market/detail/merged?symbol=%s",
-}
-
 const defaultSyncInterval = 5 // minutes
 const tickerCollectorCronJobZkLock = "tickerCollectorZkLock"
 const tryLockFailedMsg = "ticker collector try lock failed"
 
-type Exchange interface {
-	updateCache()
+// defaultExchanges are used if ticker_collector.exchanges isn't set in relay.toml
+var defaultExchanges = map[string]ExchangeOptions{
+	binance: {Enabled: true, PollInterval: 20},
+	okex:    {Enabled: true, PollInterval: 5},
+	huobi:   {Enabled: true, PollInterval: 5},
+}
+
+const (
+	defaultPollInterval  = 5 // seconds
+	tickerRequestTimeout = 10 * time.Second
+)
+
+var tickerHttpClient = &http.Client{Timeout: tickerRequestTimeout}
+
+// ExchangeOptions is an exchange in ticker_collector.exchanges, the key is the name of adapter.
+// default url of the adapter is used if Url is empty, PollInterval is in seconds
+type ExchangeOptions struct {
+	Enabled      bool
+	Url          string
+	PollInterval int64
+}
+
+type TickerCollectorOptions struct {
+	Exchanges      map[string]ExchangeOptions
+	ReferencePrice ReferencePriceOptions
 }
 
 type TickerField struct {
@@ -70,8 +79,10 @@ type TickerField struct {
 }
 
 type ExchangeImpl struct {
-	name      string
-	tickerUrl string
+	name         string
+	tickerUrl    string
+	pollInterval int64
+	adapter      ExchangeAdapter
 }
 
 type Collector interface {
@@ -80,27 +91,102 @@ type Collector interface {
 }
 
 type CollectorImpl struct {
-	exs          []ExchangeImpl
-	syncInterval int
-	cron         *cron.Cron
-	localCache   *gocache.Cache
+	exs            []ExchangeImpl
+	syncInterval   int
+	referencePrice ReferencePriceOptions
+	cron           *cron.Cron
+	localCache     *gocache.Cache
 }
 
-func NewExchange(name, tickerUrl string) ExchangeImpl {
-	return ExchangeImpl{name, tickerUrl}
+func NewExchange(adapter ExchangeAdapter, options ExchangeOptions) ExchangeImpl {
+	e := ExchangeImpl{name: adapter.Name(), tickerUrl: options.Url, pollInterval: options.PollInterval, adapter: adapter}
+	if "" == e.tickerUrl {
+		e.tickerUrl = adapter.DefaultUrl()
+	}
+	if e.pollInterval <= 0 {
+		e.pollInterval = defaultPollInterval
+	}
+	return e
 }
 
 func (e *ExchangeImpl) updateCache() {
-	log.Info("step in update cache method........")
-
-	switch e.name {
-	case binance:
-		updateCacheByExchange(e.name, GetTickerFromBinance)
-	case okex:
-		updateCacheByExchange(e.name, GetTickerFromOkex)
-	case huobi:
-		updateCacheByExchange(e.name, GetTickerFromHuobi)
+	tickers, err := e.fetchTickers()
+	if nil != err {
+		log.Errorf("ticker collector, fetch tickers from %s error:%s", e.name, err.Error())
+		return
+	}
+
+	tkFields := make([]TickerField, 0)
+	for _, t := range tickers {
+		tkField, err := buildTickerField(t.Market, t)
+		if err == nil {
+			tkFields = append(tkFields, tkField)
+		}
+	}
+	if len(tkFields) > 0 {
+		setHMCache(e.name, tkFields)
+	}
+}
+
+// fetchTickers returns tickers of supported markets, market of tickers is converted from symbol by the adapter
+func (e *ExchangeImpl) fetchTickers() ([]Ticker, error) {
+	tickers := make([]Ticker, 0)
+	if e.adapter.PerSymbol() {
+		for _, mkt := range supportedMarkets {
+			symbol := e.adapter.Symbol(mkt)
+			body, err := getTickerBody(fmt.Sprintf(e.tickerUrl, symbol))
+			if nil != err {
+				log.Debugf("ticker collector, get ticker of %s from %s error:%s", symbol, e.name, err.Error())
+				continue
+			}
+			tks, err := e.adapter.ParseTickers(symbol, body)
+			if nil != err {
+				log.Debugf("ticker collector, parse ticker of %s from %s error:%s", symbol, e.name, err.Error())
+				continue
+			}
+			tickers = append(tickers, e.supportedTickers(tks)...)
+		}
+		return tickers, nil
+	}
+
+	body, err := getTickerBody(e.tickerUrl)
+	if nil != err {
+		return tickers, err
+	}
+	tks, err := e.adapter.ParseTickers("", body)
+	if nil != err {
+		return tickers, err
+	}
+	return e.supportedTickers(tks), nil
+}
+
+func (e *ExchangeImpl) supportedTickers(tks []Ticker) []Ticker {
+	now := time.Now().Unix()
+	tickers := make([]Ticker, 0)
+	for _, t := range tks {
+		mkt, ok := e.adapter.Market(t.Market)
+		if !ok || !stringInSlice(mkt, supportedMarkets) {
+			continue
+		}
+		t.Market = mkt
+		t.Exchange = e.name
+		t.UpdatedAt = now
+		tickers = append(tickers, t)
+	}
+	return tickers
+}
+
+func getTickerBody(url string) ([]byte, error) {
+	resp, err := tickerHttpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("response status:%s", resp.Status)
 	}
+	return ioutil.ReadAll(resp.Body)
 }
 
 func mockUpdateCache() {
@@ -127,64 +213,6 @@ func mockUpdateCache() {
 	}
 }
 
-func updateBinanceCache() {
-	//updateCacheByExchange("binance", GetTickerFromBinance)
-	tickers, err := GetAllTickerFromBinance()
-	if err == nil && len(tickers) > 0 {
-		tkFields := make([]TickerField, 0)
-		for _, t := range tickers {
-			tkField, err := buildTickerField(t.Market, t)
-			if err == nil {
-				tkFields = append(tkFields, tkField)
-			}
-			//setCache(binance, t.Market, t)
-		}
-		setHMCache(binance, tkFields)
-	}
-}
-func updateOkexCache() {
-	tickers, err := GetAllTickerFromOkex()
-	if err == nil && len(tickers) > 0 {
-		tkFields := make([]TickerField, 0)
-		for _, t := range tickers {
-			tkField, err := buildTickerField(t.Market, t)
-			if err == nil {
-				tkFields = append(tkFields, tkField)
-			}
-			//setCache(okex, t.Market, t)
-		}
-		setHMCache(okex, tkFields)
-	}
-}
-func updateHuobiCache() {
-	updateCacheByExchange(huobi, GetTickerFromHuobi)
-}
-
-func updateCacheByExchange(exchange string, getter func(mkt string) (ticker Ticker, err error)) {
-
-	tkFields := make([]TickerField, 0)
-	for _, v := range util.AllMarkets {
-
-		if !stringInSlice(v, supportedMarkets) {
-			continue
-		}
-
-		vv := strings.ToUpper(v)
-		vv = strings.Replace(vv, "WETH", "ETH", 1)
-		ticker, err := getter(vv)
-		if err != nil {
-			//log.Debug("get ticker error " + err.Error())
-		} else {
-			//setCache(exchange, v, ticker)
-			tkField, err := buildTickerField(v, ticker)
-			if err == nil {
-				tkFields = append(tkFields, tkField)
-			}
-		}
-	}
-	setHMCache(exchange, tkFields)
-}
-
 func setCache(exchange, market string, ticker Ticker) {
 	cacheKey := cachePreKey + exchange
 	tickerByte, err := json.Marshal(ticker)
@@ -223,18 +251,29 @@ func setHMCache(exchange string, tickers []TickerField) {
 	cache.HMSet(cacheKey, 3600*24*30, data...)
 }
 
-func NewCollector() *CollectorImpl {
+func NewCollector(options TickerCollectorOptions) *CollectorImpl {
 	rst := &CollectorImpl{exs: make([]ExchangeImpl, 0), syncInterval: defaultSyncInterval, cron: cron.New()}
 	rst.localCache = gocache.New(5*time.Second, 5*time.Minute)
+	rst.referencePrice = options.ReferencePrice
 	for _, v := range util.AllMarkets {
 		if strings.HasSuffix(v, "ETH") {
 			supportedMarkets = append(supportedMarkets, v)
 		}
 	}
 
-	for k, v := range exchanges {
-		exchange := NewExchange(k, v)
-		rst.exs = append(rst.exs, exchange)
+	exchanges := options.Exchanges
+	if len(exchanges) == 0 {
+		exchanges = defaultExchanges
+	}
+	for name, exOptions := range exchanges {
+		if !exOptions.Enabled {
+			continue
+		}
+		adapter, ok := getExchangeAdapter(name)
+		if !ok {
+			log.Fatalf("ticker collector, exchange adapter:%s isn't registered", name)
+		}
+		rst.exs = append(rst.exs, NewExchange(adapter, exOptions))
 	}
 	return rst
 }
@@ -242,12 +281,11 @@ func NewCollector() *CollectorImpl {
 func (c *CollectorImpl) Start() {
 	go func() {
 		if zklock.TryLock(tickerCollectorCronJobZkLock) == nil {
-			updateBinanceCache()
-			updateOkexCache()
-			updateHuobiCache()
-			c.cron.AddFunc("@every 20s", updateBinanceCache)
-			c.cron.AddFunc("@every 5s", updateOkexCache)
-			c.cron.AddFunc("@every 5s", updateHuobiCache)
+			for i := range c.exs {
+				e := &c.exs[i]
+				e.updateCache()
+				c.cron.AddFunc(fmt.Sprintf("@every %ds", e.pollInterval), e.updateCache)
+			}
 			log.Info("start collect cron jobs......... ")
 			c.cron.Start()
 		} else {
@@ -259,6 +297,12 @@ func (c *CollectorImpl) Start() {
 	}()
 }
 
+// Stop stops the cron jobs and releases the zklock if it is held by this node
+func (c *CollectorImpl) Stop() {
+	c.cron.Stop()
+	zklock.ReleaseLock(tickerCollectorCronJobZkLock)
+}
+
 func (c *CollectorImpl) GetTickers(market string) ([]Ticker, error) {
 
 	result := make([]Ticker, 0)
@@ -339,313 +383,6 @@ func getAllMarketFromRedis(exchange string) (tickers map[string]Ticker, err erro
 	return
 }
 
-type HuobiTicker struct {
-	Timestamp int64            `json:"ts"`
-	ErrorCode string           `json:"err-code"`
-	Status    string           `json:"status"`
-	Tick      HuobiInnerTicker `json:"tick"`
-}
-
-type HuobiInnerTicker struct {
-	Close  float64   `json:"close"`
-	Open   float64   `json:"open"`
-	High   float64   `json:"high"`
-	Low    float64   `json:"low"`
-	Amount float64   `json:"amount"`
-	Count  int       `json:"count"`
-	Vol    float64   `json:"vol"`
-	Ask    []float64 `json:"ask"`
-	Bid    []float64 `json:"bid"`
-}
-
-type BinanceTicker struct {
-	Symbol    string `json:"symbol"`
-	Change    string `json:"priceChangePercent"`
-	Close     string `json:"prevClosePrice"`
-	Open      string `json:"openPrice"`
-	High      string `json:"highPrice"`
-	Low       string `json:"lowPrice"`
-	LastPrice string `json:"lastPrice"`
-	Amount    string `json:"volume"`
-	Vol       string `json:"quoteVolume"`
-	Ask       string `json:"askPrice"`
-	Bid       string `json:"bidPrice"`
-}
-
-type OkexTicker struct {
-	Date   string          `json:"date"`
-	Ticker OkexInnerTicker `json:"ticker"`
-}
-
-type OkexInnerTicker struct {
-	High      string `json:"high"`
-	Low       string `json:"low"`
-	LastPrice string `json:"last"`
-	Vol       string `json:"vol"`
-	Ask       string `json:"sell"`
-	Bid       string `json:"buy"`
-}
-
-func GetTickerFromHuobi(market string) (ticker Ticker, err error) {
-
-	huobiMarket := strings.Replace(market, "-", "", 1)
-	huobiMarket = strings.ToLower(huobiMarket)
-	url := fmt.Sprintf(exchanges[huobi], huobiMarket)
-	resp, err := http.Get(url)
-	if err != nil {
-		return ticker, err
-	}
-	defer func() {
-		if nil != resp && nil != resp.Body {
-			resp.Body.Close()
-		}
-	}()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if nil != err {
-		return ticker, err
-	} else {
-		var huobiTicker *HuobiTicker
-		if err := json.Unmarshal(body, &huobiTicker); nil != err {
-			return ticker, err
-		} else {
-
-			if huobiTicker.Status == "error" {
-				return ticker, errors.New("get ticker from huobi error" + huobiTicker.ErrorCode)
-			}
-
-			ticker = Ticker{}
-			innerTicker := huobiTicker.Tick
-			ticker.Market = market
-			ticker.Amount = innerTicker.Amount
-			ticker.Open = innerTicker.Open
-			ticker.Close = innerTicker.Close
-			if len(innerTicker.Bid) > 0 {
-				ticker.Last = innerTicker.Bid[0]
-			}
-			if ticker.Last-ticker.Open > 0 {
-				ticker.Change = fmt.Sprintf("+%.2f%%", 100*(ticker.Last-ticker.Open)/ticker.Open)
-			} else {
-				ticker.Change = fmt.Sprintf("%.2f%%", 100*(ticker.Last-ticker.Open)/ticker.Open)
-			}
-			ticker.Exchange = huobi
-			ticker.Vol = innerTicker.Vol
-			ticker.High = innerTicker.High
-			ticker.Low = innerTicker.Low
-
-			return ticker, nil
-		}
-	}
-}
-
-func GetTickerFromBinance(market string) (ticker Ticker, err error) {
-
-	binanceMarket := strings.Replace(market, "-", "", 1)
-	binanceMarket = strings.ToUpper(binanceMarket)
-	url := fmt.Sprintf(exchanges[binance], binanceMarket)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return ticker, err
-	}
-	defer func() {
-		if nil != resp && nil != resp.Body {
-			resp.Body.Close()
-		}
-	}()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if nil != err {
-		return ticker, err
-	} else {
-		var binanceTicker *BinanceTicker
-		if err := json.Unmarshal(body, &binanceTicker); nil != err {
-			return ticker, err
-		} else {
-			ticker = Ticker{}
-			//ticker.Market = market
-			ticker.Market = market
-			ticker.Amount, _ = strconv.ParseFloat(binanceTicker.Amount, 64)
-			ticker.Open, _ = strconv.ParseFloat(binanceTicker.Open, 64)
-			ticker.Close, _ = strconv.ParseFloat(binanceTicker.Close, 64)
-			ticker.Last, _ = strconv.ParseFloat(binanceTicker.LastPrice, 64)
-			change, _ := strconv.ParseFloat(binanceTicker.Change, 64)
-			if change > 0 {
-				ticker.Change = fmt.Sprintf("+%.2f%%", change)
-			} else {
-				ticker.Change = fmt.Sprintf("%.2f%%", change)
-			}
-			ticker.Exchange = binance
-			ticker.Vol, _ = strconv.ParseFloat(binanceTicker.Vol, 64)
-			ticker.High, _ = strconv.ParseFloat(binanceTicker.High, 64)
-			ticker.Low, _ = strconv.ParseFloat(binanceTicker.Low, 64)
-			return ticker, nil
-		}
-	}
-}
-
-func GetAllTickerFromBinance() (tickers []Ticker, err error) {
-
-	resp, err := http.Get("https://api.binance.com/api/v1/ticker/24hr")
-	if err != nil {
-		return tickers, err
-	}
-	defer func() {
-		if nil != resp && nil != resp.Body {
-			resp.Body.Close()
-		}
-	}()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if nil != err {
-		return tickers, err
-	} else {
-		var binanceTickers []BinanceTicker
-		if err := json.Unmarshal(body, &binanceTickers); nil != err {
-			return tickers, err
-		} else {
-
-			if len(binanceTickers) == 0 {
-				return tickers, errors.New("fetch ticker from binance failed")
-			}
-
-			tickers = make([]Ticker, 0)
-			for _, binanceTicker := range binanceTickers {
-
-				if !strings.HasSuffix(binanceTicker.Symbol, "ETH") {
-					// only handle eth market tickers
-					continue
-				}
-
-				ticker := Ticker{}
-				//ticker.Market = market
-				marketPre := binanceTicker.Symbol[0 : len(binanceTicker.Symbol)-len("ETH")]
-
-				ticker.Market = marketPre + "-" + "WETH"
-				ticker.Amount, _ = strconv.ParseFloat(binanceTicker.Amount, 64)
-				ticker.Open, _ = strconv.ParseFloat(binanceTicker.Open, 64)
-				ticker.Close, _ = strconv.ParseFloat(binanceTicker.Close, 64)
-				ticker.Last, _ = strconv.ParseFloat(binanceTicker.LastPrice, 64)
-				change, _ := strconv.ParseFloat(binanceTicker.Change, 64)
-				if change > 0 {
-					ticker.Change = fmt.Sprintf("+%.2f%%", change)
-				} else {
-					ticker.Change = fmt.Sprintf("%.2f%%", change)
-				}
-				ticker.Exchange = binance
-				ticker.Vol, _ = strconv.ParseFloat(binanceTicker.Vol, 64)
-				ticker.High, _ = strconv.ParseFloat(binanceTicker.High, 64)
-				ticker.Low, _ = strconv.ParseFloat(binanceTicker.Low, 64)
-				tickers = append(tickers, ticker)
-			}
-			return tickers, nil
-		}
-	}
-}
-
-func GetTickerFromOkex(market string) (ticker Ticker, err error) {
-
-	okexMarket := strings.Replace(market, "-", "_", 1)
-	okexMarket = strings.ToLower(okexMarket)
-	url := fmt.Sprintf(exchanges[okex], okexMarket)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return ticker, err
-	}
-	defer func() {
-		if nil != resp && nil != resp.Body {
-			resp.Body.Close()
-		}
-	}()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if nil != err {
-		return ticker, err
-	} else {
-		var okexOutTicker *OkexTicker
-		if err := json.Unmarshal(body, &okexOutTicker); nil != err {
-			return ticker, err
-		} else {
-			ticker = Ticker{}
-			okexTicker := okexOutTicker.Ticker
-			ticker.Market = market
-			ticker.Last, _ = strconv.ParseFloat(okexTicker.LastPrice, 64)
-			if 100*(ticker.Last-ticker.Open)/ticker.Open > 0 {
-				ticker.Change = fmt.Sprintf("+%.2f%%", 100*(ticker.Last-ticker.Open)/ticker.Open)
-			} else {
-				ticker.Change = fmt.Sprintf("%.2f%%", 100*(ticker.Last-ticker.Open)/ticker.Open)
-			}
-			ticker.Exchange = okex
-			ticker.Amount, _ = strconv.ParseFloat(okexTicker.Vol, 64)
-			ticker.Vol = ticker.Amount * ticker.Last
-			ticker.High, _ = strconv.ParseFloat(okexTicker.High, 64)
-			ticker.Low, _ = strconv.ParseFloat(okexTicker.Low, 64)
-			return ticker, nil
-		}
-	}
-}
-
-type OkexFullTicker struct {
-	Code int              `json:"code"`
-	Data []OkexTickerElem `json:"data"`
-	Msg  string           `json:"msg"`
-}
-
-type OkexTickerElem struct {
-	Buy    string `json:"buy"`
-	Last   string `json:"last"`
-	Vol    string `json:"volume"`
-	Symbol string `json:"symbol"`
-	High   string `json:"high"`
-	Low    string `json:"low"`
-	Change string `json:"changePercentage"`
-}
-
-func GetAllTickerFromOkex() (tickers []Ticker, err error) {
-	tickers = make([]Ticker, 0)
-	resp, err := http.Get("https://www.okex.com/v2/markets/tickers")
-	if err != nil {
-		return tickers, err
-	}
-	defer func() {
-		if nil != resp && nil != resp.Body {
-			resp.Body.Close()
-		}
-	}()
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if nil != err {
-		return tickers, err
-	} else {
-		var okexOutTicker *OkexFullTicker
-		if err := json.Unmarshal(body, &okexOutTicker); nil != err {
-			return tickers, err
-		} else {
-
-			for _, v := range okexOutTicker.Data {
-				ticker := Ticker{}
-				okexMarket := strings.Replace(v.Symbol, "_", "-", 1)
-				okexMarket = strings.ToUpper(okexMarket)
-				okexMarket = strings.Replace(okexMarket, "ETH", "WETH", 1)
-				if stringInSlice(okexMarket, supportedMarkets) {
-					ticker.Market = okexMarket
-					ticker.Last, _ = strconv.ParseFloat(v.Last, 64)
-					ticker.Change = v.Change
-					ticker.Exchange = okex
-					ticker.Amount, _ = strconv.ParseFloat(v.Vol, 64)
-					ticker.Vol = ticker.Amount * ticker.Last
-					ticker.High, _ = strconv.ParseFloat(v.High, 64)
-					ticker.Low, _ = strconv.ParseFloat(v.Low, 64)
-					tickers = append(tickers, ticker)
-				}
-			}
-
-			return tickers, nil
-		}
-	}
-}
-
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
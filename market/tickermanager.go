@@ -113,6 +113,12 @@ func (c *GetTickerImpl) Start() {
 	}()
 }
 
+// Stop stops the cron jobs and releases the zklock if it is held by this node
+func (c *GetTickerImpl) Stop() {
+	c.cron.Stop()
+	zklock.ReleaseLock(tickerManagerCronJobZkLock)
+}
+
 func refreshMarkets() {
 	for _, mkt := range util.AllMarkets {
 		market := strings.Split(mkt, SPLIT_MARK)
@@ -0,0 +1,109 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market
+
+import (
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	"strings"
+	"time"
+)
+
+// TrendRebuildResult reports the candles of an interval recomputed by RebuildTrends
+type TrendRebuildResult struct {
+	Interval string
+	Start    int64
+	End      int64
+	Candles  int
+	Changed  int
+}
+
+// RebuildTrends recomputes the candles of market which start in [start, end] from lpr_fills.
+// 1Hr and shorter candles are aggregated from fills, longer ones from 1Hr candles,
+// so 1Hr is rebuilt first if it's in intervals. only candles differ from the saved ones are saved,
+// rebuilding a range again changes nothing. the default intervals are used if intervals is empty.
+// the open candle of an interval is left to the schedule update, the range stops at the last closed one,
+// and the trend caches of rebuilt intervals are refreshed afterwards
+func RebuildTrends(rds *dao.RdsService, mkt string, intervals []string, start, end int64) ([]TrendRebuildResult, error) {
+	if len(intervals) == 0 {
+		intervals = allInterval
+	}
+	if start <= 0 || end < start {
+		return nil, fmt.Errorf("invalid time range:%d-%d", start, end)
+	}
+	for _, interval := range intervals {
+		if !stringInSlice(interval, supportedIntervals) {
+			return nil, fmt.Errorf("trend interval:%s isn't supported", interval)
+		}
+	}
+
+	t := &TrendManager{rds: rds}
+	mkt = strings.ToUpper(mkt)
+	results := make([]TrendRebuildResult, 0)
+	now := time.Now().Unix()
+	for _, interval := range supportedIntervals {
+		if !stringInSlice(interval, intervals) {
+			continue
+		}
+
+		intervalEndTs := end
+		if closed := intervalBoundary(interval, now); intervalEndTs > closed {
+			intervalEndTs = closed
+		}
+		result := TrendRebuildResult{Interval: interval, Start: intervalBoundary(interval, start) + 1}
+		if intervalEndTs < result.Start {
+			results = append(results, result)
+			continue
+		}
+		result.End = intervalEnd(interval, intervalBoundary(interval, intervalEndTs-1)+1)
+		for candleStart := result.Start; candleStart <= intervalEndTs; candleStart = intervalEnd(interval, candleStart) + 1 {
+			result.Candles++
+		}
+
+		if getTsInterval(interval) <= tsOneHour {
+			changed, err := t.insertTrendsByFills(interval, mkt, result.Start, intervalEndTs)
+			result.Changed = changed
+			if err != nil {
+				return results, err
+			}
+		} else {
+			for candleStart := result.Start; candleStart <= intervalEndTs; candleStart = intervalEnd(interval, candleStart) + 1 {
+				trend, err := t.aggregateHourTrends(interval, candleStart, mkt)
+				if err != nil {
+					return results, err
+				}
+				changed, err := t.saveTrendIfChanged(trend)
+				if err != nil {
+					return results, err
+				}
+				if changed {
+					result.Changed++
+				}
+			}
+		}
+		results = append(results, result)
+
+		if interval == OneHour {
+			t.refreshMinIntervalCache()
+		} else {
+			t.refreshCacheByInterval(interval)
+		}
+	}
+	return results, nil
+}
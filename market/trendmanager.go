@@ -44,27 +44,69 @@ import (
 )
 
 const (
-	OneHour  = "1Hr"
-	TwoHour  = "2Hr"
-	FourHour = "4Hr"
-	OneDay   = "1Day"
-	OneWeek  = "1Week"
-	//OneMonth = "1Month"
+	OneMinute     = "1Min"
+	FiveMinute    = "5Min"
+	FifteenMinute = "15Min"
+	OneHour       = "1Hr"
+	TwoHour       = "2Hr"
+	FourHour      = "4Hr"
+	OneDay        = "1Day"
+	OneWeek       = "1Week"
+	OneMonth      = "1Month"
 	//OneYear = "1Year"
 
-	//TwoHour = "2Hr"
-	//OneDay = "1Day"
-
+	tsOneMinute      = 60
+	tsFiveMinute     = 5 * tsOneMinute
+	tsFifteenMinute  = 15 * tsOneMinute
 	tsOneHour        = 60 * 60
 	tsTwoHour        = 2 * tsOneHour
 	tsFourHour       = 4 * tsOneHour
 	tsOneDay         = 24 * tsOneHour
 	tsOneWeek        = 7 * tsOneDay
+	tsOneMonth       = 30 * tsOneDay // only used to order intervals, month candles use calendar months
 	localCacheTicker = "LocalCacheTicker"
+
+	defaultBackfillDays = 7
 )
 
+// allInterval is used if trend.intervals isn't set in relay.toml
 var allInterval = []string{OneHour, TwoHour, FourHour, OneDay, OneWeek}
 
+var supportedIntervals = []string{OneMinute, FiveMinute, FifteenMinute, OneHour, TwoHour, FourHour, OneDay, OneWeek, OneMonth}
+
+func IsSupportedInterval(interval string) bool {
+	return stringInSlice(interval, supportedIntervals)
+}
+
+// TrendOptions is trend in relay.toml. 1Hr is always enabled, candles shorter than it are aggregated from fills
+// and longer ones from 1Hr candles. ProofRead backfills BackfillDays of an interval which has no candle yet
+type TrendOptions struct {
+	Intervals    []string
+	BackfillDays int64
+}
+
+// intervals returns the enabled intervals ordered by length
+func (o TrendOptions) intervals() ([]string, error) {
+	if len(o.Intervals) == 0 {
+		return allInterval, nil
+	}
+
+	enabled := map[string]bool{OneHour: true}
+	for _, interval := range o.Intervals {
+		if !stringInSlice(interval, supportedIntervals) {
+			return nil, fmt.Errorf("trend interval:%s isn't supported", interval)
+		}
+		enabled[interval] = true
+	}
+	intervals := make([]string, 0)
+	for _, interval := range supportedIntervals {
+		if enabled[interval] {
+			intervals = append(intervals, interval)
+		}
+	}
+	return intervals, nil
+}
+
 type Ticker struct {
 	Market    string  `json:"market"`
 	Exchange  string  `json:"exchange"`
@@ -79,6 +121,7 @@ type Ticker struct {
 	Buy       float64 `json:"buy"`
 	Sell      float64 `json:"sell"`
 	Change    string  `json:"change"`
+	UpdatedAt int64   `json:"updatedAt,omitempty"`
 }
 
 type Cache struct {
@@ -101,12 +144,14 @@ type Trend struct {
 }
 
 type TrendManager struct {
-	cacheReady  bool
-	proofReady  bool
-	rds         *dao.RdsService
-	cron        *cron.Cron
-	localCache  *gocache.Cache
-	orderViewer viewer.OrderViewer
+	cacheReady   bool
+	proofReady   bool
+	rds          *dao.RdsService
+	cron         *cron.Cron
+	localCache   *gocache.Cache
+	orderViewer  viewer.OrderViewer
+	intervals    []string
+	backfillDays int64
 }
 
 type TrendUpdateMsg struct {
@@ -122,10 +167,19 @@ const tickerKey = "lpr_ticker_view_"
 const trendCronJobZkLock = "trendZkLock"
 const snsNotifyMsg = "trendmanager try lock failed"
 
-func NewTrendManager(dao *dao.RdsService, orderViewer viewer.OrderViewer) TrendManager {
+func NewTrendManager(dao *dao.RdsService, orderViewer viewer.OrderViewer, options TrendOptions) TrendManager {
 
 	once.Do(func() {
 		trendManager = TrendManager{rds: dao, cron: cron.New(), orderViewer: orderViewer}
+		intervals, err := options.intervals()
+		if err != nil {
+			log.Fatalf("trend manager, %s", err.Error())
+		}
+		trendManager.intervals = intervals
+		trendManager.backfillDays = options.BackfillDays
+		if trendManager.backfillDays <= 0 {
+			trendManager.backfillDays = defaultBackfillDays
+		}
 		trendManager.localCache = gocache.New(5*time.Second, 5*time.Minute)
 		trendManager.LoadCache()
 
@@ -159,10 +213,15 @@ func (t *TrendManager) ProofRead() {
 	for _, mkt := range util.AllMarkets {
 		copyOfMkt := mkt
 		go func(market string) {
-			for _, interval := range allInterval {
-				err := t.proofByInterval(market, interval, checkPoint.CheckPoint)
+			for _, interval := range t.intervals {
+				// backfill the interval which is enabled recently
+				proofPoint := checkPoint.CheckPoint
+				if latest, _ := t.rds.TrendQueryLatest(dao.Trend{Market: market, Intervals: interval}, 1, 1); len(latest) == 0 {
+					proofPoint = time.Now().Unix() - t.backfillDays*tsOneDay
+				}
+				err := t.proofByInterval(market, interval, proofPoint)
 				if err != nil {
-					log.Fatalf("proof by interval error occurs, %s, %s, %d ", err.Error(), interval, checkPoint.CheckPoint)
+					log.Fatalf("proof by interval error occurs, %s, %s, %d ", err.Error(), interval, proofPoint)
 				}
 			}
 		}(copyOfMkt)
@@ -185,15 +244,19 @@ func (t *TrendManager) proofByInterval(mkt string, interval string, checkPoint i
 	now := time.Now()
 	//for ;
 
+	if isShortInterval(interval) {
+		_, err := t.insertTrendsByFills(interval, mkt, intervalBoundary(interval, checkPoint)+1, intervalBoundary(interval, now.Unix()))
+		return err
+	}
+
 	starts := make([]int64, 0)
-	tsInterval := getTsInterval(interval)
 
-	firstStart := (checkPoint/tsInterval)*tsInterval + 1
+	firstStart := intervalBoundary(interval, checkPoint) + 1
 	firstSecondThisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 1, 0, now.Location())
 
 	for firstStart < firstSecondThisHour.Unix() {
 		starts = append(starts, firstStart)
-		firstStart = firstStart + tsInterval
+		firstStart = intervalEnd(interval, firstStart) + 1
 	}
 
 	trends, err := t.rds.TrendQueryForProof(mkt, interval, checkPoint)
@@ -257,8 +320,8 @@ func (t *TrendManager) refreshCacheByInterval(interval string) {
 func (t *TrendManager) LoadCache() {
 	t.refreshMinIntervalCache()
 	intervals := make([]string, 0)
-	for k, v := range allInterval {
-		if k == 0 {
+	for _, v := range t.intervals {
+		if v == OneHour {
 			continue
 		}
 		intervals = append(intervals, v)
@@ -413,10 +476,22 @@ func calculateTicker(market string, fills []dao.FillEvent, trends []Trend, now t
 
 func (t *TrendManager) startScheduleUpdate() {
 	t.cron.AddFunc("10 1 * * * *", t.ScheduleUpdate)
+	for _, interval := range t.intervals {
+		if isShortInterval(interval) {
+			t.cron.AddFunc("5 * * * * *", t.ScheduleShortUpdate)
+			break
+		}
+	}
 	t.cron.AddFunc("0 30 1 * * *", t.ProofRead)
 	t.cron.Start()
 }
 
+// Stop stops the cron jobs started in NewTrendManager and releases the zklock if it is held by this node
+func (t *TrendManager) Stop() {
+	t.cron.Stop()
+	zklock.ReleaseLock(trendCronJobZkLock)
+}
+
 func (t *TrendManager) insertTrendByInterval(interval string) error {
 	if !isTimeToInsert(interval) {
 		log.Info("no need to insert trend by interval " + interval)
@@ -426,76 +501,33 @@ func (t *TrendManager) insertTrendByInterval(interval string) error {
 	if interval == OneHour {
 		//t.InsertTrend()
 		return nil
-	} else {
-		return t.insertByTrend(interval)
 	}
-}
-
-func (t *TrendManager) insertByTrend(interval string) error {
-
-	now := time.Now()
-	end := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
-	start := end.Unix() - getTsInterval(interval) + 1
-	//multiple := tsInterval / tsOneHour
 
+	start := lastClosedStart(interval, time.Now().Unix())
 	for _, mkt := range util.AllMarkets {
-
-		trends, err := t.rds.TrendQueryByInterval(OneHour, mkt, start, end.Unix())
-
-		if err != nil {
+		if err := t.insertByTrendV2(interval, start, mkt); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		toInsert := &dao.Trend{}
-
-		var (
-			vol    float64 = 0
-			amount float64 = 0
-			high   float64 = 0
-			low    float64 = 0
-			open   float64 = 0
-		)
-
-		for _, t := range trends {
-			vol += t.Vol
-			amount += t.Amount
-			if low == 0 || low > t.Low {
-				low = t.Low
-			}
-			if high == 0 || high < t.High {
-				high = t.High
-			}
-
-			if open == 0 {
-				open = t.Open
-			}
+// ScheduleShortUpdate inserts the just closed candles of intervals shorter than 1Hr
+func (t *TrendManager) ScheduleShortUpdate() {
+	now := time.Now().Unix()
+	for _, interval := range t.intervals {
+		if !isShortInterval(interval) || now-intervalBoundary(interval, now) >= tsOneMinute {
+			continue
 		}
 
-		if len(trends) == 0 {
-			toInsert.Open = 0
-			toInsert.Close = 0
-		} else {
-			toInsert.Close = trends[len(trends)-1].Close
-		}
-		toInsert.Vol = vol
-		toInsert.Amount = amount
-		toInsert.High = high
-		toInsert.Low = low
-		toInsert.Start = start
-		toInsert.End = end.Unix()
-		toInsert.Market = mkt
-		toInsert.Intervals = interval
-		toInsert.CreateTime = now.Unix()
-		toInsert.UpdateTime = now.Unix()
-		toInsert.Open = open
-
-		if err := t.rds.Add(toInsert); err != nil {
-			log.Info(err.Error())
-			return err
+		start := lastClosedStart(interval, now)
+		for _, mkt := range util.AllMarkets {
+			if err := t.insertByTrendV2(interval, start, mkt); err != nil {
+				log.Errorf("insert %s trend of %s error:%s", interval, mkt, err.Error())
+			}
 		}
+		t.refreshCacheByInterval(interval)
 	}
-
-	return nil
 }
 
 func (t *TrendManager) insertMinIntervalTrend(interval string, start int64, mkt string) (err error) {
@@ -589,12 +621,29 @@ func (t *TrendManager) insertMinIntervalTrend(interval string, start int64, mkt
 
 func (t *TrendManager) insertByTrendV2(interval string, start int64, mkt string) error {
 
-	end := start + getTsInterval(interval) - 1
-	trends, err := t.rds.TrendQueryByInterval(OneHour, mkt, start, end)
+	end := intervalEnd(interval, start)
+	if isShortInterval(interval) {
+		_, err := t.insertTrendsByFills(interval, mkt, start, end)
+		return err
+	}
 
+	toInsert, err := t.aggregateHourTrends(interval, start, mkt)
 	if err != nil {
 		return err
 	}
+	_, err = t.saveTrendIfChanged(toInsert)
+	return err
+}
+
+// aggregateHourTrends aggregates the candle of interval starting at start from 1Hr candles
+func (t *TrendManager) aggregateHourTrends(interval string, start int64, mkt string) (*dao.Trend, error) {
+
+	end := intervalEnd(interval, start)
+	trends, err := t.rds.TrendQueryByInterval(OneHour, mkt, start, end)
+
+	if err != nil {
+		return nil, err
+	}
 
 	toInsert := &dao.Trend{}
 
@@ -638,9 +687,16 @@ func (t *TrendManager) insertByTrendV2(interval string, start int64, mkt string)
 	toInsert.Intervals = interval
 	toInsert.CreateTime = time.Now().Unix()
 	toInsert.UpdateTime = toInsert.CreateTime
+	return toInsert, nil
+}
 
-	exists, _ := t.rds.TrendQueryByTime(interval, mkt, start, end)
+// saveTrendIfChanged saves the candle unless the saved one with the same start is equal to it
+func (t *TrendManager) saveTrendIfChanged(toInsert *dao.Trend) (bool, error) {
+	exists, _ := t.rds.TrendQueryByTime(toInsert.Intervals, toInsert.Market, toInsert.Start, toInsert.End)
 	if len(exists) > 0 {
+		if sameCandle(exists[0], *toInsert) {
+			return false, nil
+		}
 		log.Info("insert by trend, current interval trend exsit")
 		toInsert.ID = exists[0].ID
 		toInsert.CreateTime = exists[0].CreateTime
@@ -648,10 +704,9 @@ func (t *TrendManager) insertByTrendV2(interval string, start int64, mkt string)
 
 	if err := t.rds.Save(toInsert); err != nil {
 		log.Info(err.Error())
-		return err
+		return false, err
 	}
-
-	return nil
+	return true, nil
 }
 
 func getTsInterval(interval string) int64 {
@@ -666,13 +721,155 @@ func getTsInterval(interval string) int64 {
 		return tsOneDay
 	case OneWeek:
 		return tsOneWeek
+	case OneMinute:
+		return tsOneMinute
+	case FiveMinute:
+		return tsFiveMinute
+	case FifteenMinute:
+		return tsFifteenMinute
+	case OneMonth:
+		return tsOneMonth
 	default:
 		return 0
 	}
 }
 
+func isShortInterval(interval string) bool {
+	return getTsInterval(interval) < tsOneHour
+}
+
+// intervalBoundary returns the boundary of the candle which ts is in,
+// a candle starts one second after a boundary and ends at the next one
+func intervalBoundary(interval string, ts int64) int64 {
+	if interval == OneMonth {
+		tm := time.Unix(ts, 0).UTC()
+		return time.Date(tm.Year(), tm.Month(), 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+	tsInterval := getTsInterval(interval)
+	return (ts / tsInterval) * tsInterval
+}
+
+// intervalEnd returns the end of the candle starting at start
+func intervalEnd(interval string, start int64) int64 {
+	if interval == OneMonth {
+		tm := time.Unix(start, 0).UTC()
+		return time.Date(tm.Year(), tm.Month()+1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	}
+	return start + getTsInterval(interval) - 1
+}
+
+// lastClosedStart returns the start of the latest candle which is closed at now
+func lastClosedStart(interval string, now int64) int64 {
+	return intervalBoundary(interval, intervalBoundary(interval, now)-1) + 1
+}
+
 func isTimeToInsert(interval string) bool {
-	return time.Now().Unix()%getTsInterval(interval) < tsOneHour
+	now := time.Now().Unix()
+	return now-intervalBoundary(interval, now) < tsOneHour
+}
+
+// insertTrendsByFills aggregates the candles of interval starting from start to end with fills of market,
+// only the candles which differ from the saved ones are saved and counted
+func (t *TrendManager) insertTrendsByFills(interval, mkt string, start, end int64) (int, error) {
+	lastEnd := intervalEnd(interval, intervalBoundary(interval, end-1)+1)
+	fills, err := t.rds.QueryFillsForTrend(mkt, start, lastEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	exists, err := t.rds.TrendQueryByInterval(interval, mkt, start, lastEnd)
+	if err != nil {
+		return 0, err
+	}
+	existMap := make(map[int64]dao.Trend)
+	for _, v := range exists {
+		existMap[v.Start] = v
+	}
+
+	var lastClose float64
+	prevStart := intervalBoundary(interval, start-1) + 1
+	if lastTrends, _ := t.rds.TrendQueryByTime(interval, mkt, prevStart, intervalEnd(interval, prevStart)); len(lastTrends) > 0 {
+		lastClose = lastTrends[0].Close
+	}
+
+	changed := 0
+	for _, trend := range aggregateFills(interval, mkt, fills, start, end, lastClose, time.Now().Unix()) {
+		if exist, ok := existMap[trend.Start]; ok {
+			if sameCandle(exist, trend) {
+				continue
+			}
+			trend.ID = exist.ID
+			trend.CreateTime = exist.CreateTime
+		}
+		toSave := trend
+		if err := t.rds.Save(&toSave); err != nil {
+			log.Info(err.Error())
+			return changed, err
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// aggregateFills splits fills into candles of interval from start to end,
+// a candle opens at the close of the previous one, which is lastClose for the first candle
+func aggregateFills(interval, mkt string, fills []dao.FillEvent, start, end int64, lastClose float64, now int64) []dao.Trend {
+	sort.Slice(fills, func(i, j int) bool {
+		return fills[i].CreateTime < fills[j].CreateTime
+	})
+
+	trends := make([]dao.Trend, 0)
+	i := 0
+	for candleStart := start; candleStart <= end; candleStart = intervalEnd(interval, candleStart) + 1 {
+		candleEnd := intervalEnd(interval, candleStart)
+		trend := dao.Trend{
+			Intervals:  interval,
+			Market:     mkt,
+			CreateTime: now,
+			UpdateTime: now,
+			Start:      candleStart,
+			End:        candleEnd,
+			Open:       lastClose,
+			Close:      lastClose,
+			High:       lastClose,
+			Low:        lastClose,
+		}
+
+		for ; i < len(fills) && fills[i].CreateTime <= candleEnd; i++ {
+			data := fills[i]
+			if data.CreateTime < candleStart {
+				continue
+			}
+			if data.Side == "" {
+				data.Side = util.GetSide(data.TokenS, data.TokenB)
+			}
+
+			trend.Vol, trend.Amount = addAmount(trend.Vol, trend.Amount, data)
+			price := util.CalculatePrice(data.AmountS, data.AmountB, data.TokenS, data.TokenB)
+
+			if trend.Open == 0 && price != 0 {
+				trend.Open = price
+			}
+			if trend.High == 0 || trend.High < price {
+				trend.High = price
+			}
+			if trend.Low == 0 || (trend.Low > price && price > 0) {
+				trend.Low = price
+			}
+			if price > 0 {
+				trend.Close = price
+			}
+		}
+
+		lastClose = trend.Close
+		trends = append(trends, trend)
+	}
+	return trends
+}
+
+func sameCandle(a, b dao.Trend) bool {
+	return a.Open == b.Open && a.Close == b.Close && a.High == b.High && a.Low == b.Low &&
+		a.Vol == b.Vol && a.Amount == b.Amount && a.End == b.End
 }
 
 func (t *TrendManager) ScheduleUpdate() {
@@ -787,8 +984,8 @@ func (t *TrendManager) ScheduleUpdate() {
 	wg.Wait()
 	var wgInterval sync.WaitGroup
 	intervals := make([]string, 0)
-	for k, v := range allInterval {
-		if k == 0 {
+	for _, v := range t.intervals {
+		if getTsInterval(v) <= tsOneHour {
 			continue
 		}
 		intervals = append(intervals, v)
@@ -0,0 +1,271 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metrics of relay, exposed at /metrics of jsonrpc in the prometheus text format.
+// only counter, gauge and histogram with at most one label are supported, which is all relay needs
+
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	collectors    []collector
+	collectorsMtx sync.RWMutex
+)
+
+// register adds c to the output of /metrics, a collector with the same name is replaced
+func register(c collector) {
+	collectorsMtx.Lock()
+	defer collectorsMtx.Unlock()
+
+	for i, exists := range collectors {
+		if exists.name() == c.name() {
+			collectors[i] = c
+			return
+		}
+	}
+	collectors = append(collectors, c)
+}
+
+type CounterVec struct {
+	metricName string
+	help       string
+	label      string
+	values     map[string]float64
+	mtx        sync.Mutex
+}
+
+func NewCounterVec(name, help, label string) *CounterVec {
+	c := &CounterVec{metricName: name, help: help, label: label, values: make(map[string]float64)}
+	register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+func (c *CounterVec) Add(labelValue string, v float64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.values[labelValue] += v
+}
+
+func (c *CounterVec) Value(labelValue string) float64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *CounterVec) name() string { return c.metricName }
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	writeHeader(w, c.metricName, c.help, "counter")
+	for _, lv := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", c.metricName, c.label, escapeLabel(lv), formatFloat(c.values[lv]))
+	}
+}
+
+type GaugeFunc struct {
+	metricName string
+	help       string
+	value      func() float64
+}
+
+// NewGaugeFunc creates a gauge whose value is read by value when /metrics is requested
+func NewGaugeFunc(name, help string, value func() float64) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, value: value}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatFloat(g.value()))
+}
+
+type GaugeVecFunc struct {
+	metricName string
+	help       string
+	label      string
+	values     func() map[string]float64
+}
+
+// NewGaugeVecFunc creates a gauge whose values of every label value are read by values when /metrics is requested
+func NewGaugeVecFunc(name, help, label string, values func() map[string]float64) *GaugeVecFunc {
+	g := &GaugeVecFunc{metricName: name, help: help, label: label, values: values}
+	register(g)
+	return g
+}
+
+func (g *GaugeVecFunc) name() string { return g.metricName }
+
+func (g *GaugeVecFunc) write(w io.Writer) {
+	values := g.values()
+	writeHeader(w, g.metricName, g.help, "gauge")
+	for _, lv := range sortedKeys(values) {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %s\n", g.metricName, g.label, escapeLabel(lv), formatFloat(values[lv]))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	metricName string
+	help       string
+	label      string
+	buckets    []float64
+	values     map[string]*histogram
+	mtx        sync.Mutex
+}
+
+func NewHistogramVec(name, help, label string, buckets []float64) *HistogramVec {
+	sorted := make([]float64, len(buckets))
+	copy(sorted, buckets)
+	sort.Float64s(sorted)
+
+	h := &HistogramVec{metricName: name, help: help, label: label, buckets: sorted, values: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(labelValue string, v float64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	hist, ok := h.values[labelValue]
+	if !ok {
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[labelValue] = hist
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.sum += v
+	hist.count++
+}
+
+// Count returns how many values of labelValue have been observed
+func (h *HistogramVec) Count(labelValue string) uint64 {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if hist, ok := h.values[labelValue]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) name() string { return h.metricName }
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+	for _, lv := range sortedKeys(h.values) {
+		hist := h.values[lv]
+		label := fmt.Sprintf("%s=\"%s\"", h.label, escapeLabel(lv))
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", h.metricName, label, formatFloat(upper), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.metricName, label, hist.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.metricName, label, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.metricName, label, hist.count)
+	}
+}
+
+// WritePrometheus writes all metrics in the prometheus text format
+func WritePrometheus(w io.Writer) {
+	collectorsMtx.RLock()
+	defer collectorsMtx.RUnlock()
+
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		WritePrometheus(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(buf.Bytes())
+	})
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelReplacer.Replace(v)
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch values := m.(type) {
+	case map[string]float64:
+		for k := range values {
+			keys = append(keys, k)
+		}
+	case map[string]*histogram:
+		for k := range values {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
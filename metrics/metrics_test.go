@@ -0,0 +1,77 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"github.com/Loopring/relay-lib/types"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	counter := NewCounterVec("test_rejections_total", "test counter.", "filter")
+	counter.Inc("pow")
+	counter.Add("base", 2)
+
+	hist := NewHistogramVec("test_duration_seconds", "test histogram.", "method", []float64{1, 0.1})
+	hist.Observe("loopring_getDepth", 0.05)
+	hist.Observe("loopring_getDepth", 0.5)
+
+	NewGaugeFunc("test_connections", "test gauge.", func() float64 { return 3 })
+	NewGaugeVecFunc("test_subscriptions", "test gauge vec.", "topic", func() map[string]float64 {
+		return map[string]float64{"depth": 2, "trades": 1}
+	})
+
+	var buf bytes.Buffer
+	WritePrometheus(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE test_rejections_total counter",
+		`test_rejections_total{filter="base"} 2`,
+		`test_rejections_total{filter="pow"} 1`,
+		`test_duration_seconds_bucket{method="loopring_getDepth",le="0.1"} 1`,
+		`test_duration_seconds_bucket{method="loopring_getDepth",le="1"} 2`,
+		`test_duration_seconds_bucket{method="loopring_getDepth",le="+Inf"} 2`,
+		`test_duration_seconds_count{method="loopring_getDepth"} 2`,
+		"test_connections 3",
+		"# TYPE test_subscriptions gauge",
+		`test_subscriptions{topic="depth"} 2`,
+		`test_subscriptions{topic="trades"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("output should contain %s, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestBlockTimeOf(t *testing.T) {
+	event := &types.TransferEvent{}
+	event.BlockTime = 1530000000
+	if blockTime := blockTimeOf(event); blockTime != event.BlockTime {
+		t.Fatalf("block time of transfer event expect %d got %d", event.BlockTime, blockTime)
+	}
+	if blockTime := blockTimeOf(&types.BlockEvent{BlockTime: 1}); blockTime != 1 {
+		t.Fatalf("block time of block event expect 1 got %d", blockTime)
+	}
+	if blockTime := blockTimeOf(nil); blockTime != 0 {
+		t.Fatalf("block time of nil expect 0 got %d", blockTime)
+	}
+}
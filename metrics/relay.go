@@ -0,0 +1,102 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package metrics
+
+import (
+	"github.com/Loopring/relay-lib/eventemitter"
+	"reflect"
+	"time"
+)
+
+var (
+	RpcDuration = NewHistogramVec("relay_rpc_duration_seconds",
+		"Latency of loopring json-rpc calls.", "method", DefBuckets)
+
+	EventHandlingLag = NewHistogramVec("relay_event_handling_lag_seconds",
+		"Seconds between the block time of an on-chain event and its handling by the relay.", "topic",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 600, 1800})
+
+	OrderFilterRejections = NewCounterVec("relay_order_filter_rejections_total",
+		"Orders rejected by the gateway filters.", "filter")
+)
+
+// DefaultLagTopics are the eventemitter topics of on-chain events emitted by extractor
+var DefaultLagTopics = []string{
+	eventemitter.Block_New,
+	eventemitter.Block_End,
+	eventemitter.Transfer,
+	eventemitter.EthTransfer,
+	eventemitter.Approve,
+	eventemitter.WethDeposit,
+	eventemitter.WethWithdrawal,
+	eventemitter.RingMined,
+	eventemitter.OrderFilled,
+	eventemitter.CancelOrder,
+	eventemitter.CutoffAll,
+	eventemitter.CutoffPair,
+	eventemitter.UnsupportedContract,
+	eventemitter.Miner_SubmitRing_Method,
+}
+
+// EventLagWatcher observes EventHandlingLag of events which carry a block time
+type EventLagWatcher struct {
+	topics   []string
+	watchers map[string]*eventemitter.Watcher
+}
+
+func NewEventLagWatcher(topics []string) *EventLagWatcher {
+	return &EventLagWatcher{topics: topics, watchers: make(map[string]*eventemitter.Watcher)}
+}
+
+func (l *EventLagWatcher) Start() {
+	for _, topic := range l.topics {
+		copyOfTopic := topic
+		l.watchers[topic] = &eventemitter.Watcher{Concurrent: true, Handle: func(input eventemitter.EventData) error {
+			if blockTime := blockTimeOf(input); blockTime > 0 {
+				EventHandlingLag.Observe(copyOfTopic, float64(time.Now().Unix()-blockTime))
+			}
+			return nil
+		}}
+		eventemitter.On(topic, l.watchers[topic])
+	}
+}
+
+func (l *EventLagWatcher) Stop() {
+	for topic, watcher := range l.watchers {
+		eventemitter.Un(topic, watcher)
+	}
+}
+
+// events of relay-lib embed types.TxInfo or have their own BlockTime field
+func blockTimeOf(input eventemitter.EventData) int64 {
+	v := reflect.ValueOf(input)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+	if f := v.FieldByName("BlockTime"); f.IsValid() && f.Kind() == reflect.Int64 {
+		return f.Int()
+	}
+	return 0
+}
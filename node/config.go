@@ -24,7 +24,10 @@ import (
 	"reflect"
 
 	"github.com/Loopring/relay-cluster/accountmanager"
+	"github.com/Loopring/relay-cluster/dao/envelope"
 	"github.com/Loopring/relay-cluster/gateway"
+	"github.com/Loopring/relay-cluster/gateway/order_difficulty"
+	"github.com/Loopring/relay-cluster/gateway/webhook"
 	"github.com/Loopring/relay-cluster/market"
 	ordermanager "github.com/Loopring/relay-cluster/ordermanager/common"
 	"github.com/Loopring/relay-cluster/usermanager"
@@ -64,6 +67,7 @@ func LoadConfig(file string) *GlobalConfig {
 
 type GlobalConfig struct {
 	Title            string `required:"true"`
+	ShutdownTimeout  int64  // seconds to wait for the pending requests when the node stops
 	Log              zap.Config
 	Mysql            dao.MysqlOptions
 	Redis            redis.RedisOptions
@@ -74,6 +78,7 @@ type GlobalConfig struct {
 	Market           util.MarketOptions
 	MarketCap        marketcap.MarketCapOptions
 	GatewayFilters   gateway.GatewayFiltersOptions
+	OrderDifficulty  order_difficulty.OrderDifficultyOptions
 	UserManager      usermanager.UserManagerOptions
 	ZkLock           zklock.ZkLockConfig
 	Sns              sns.SnsConfig
@@ -81,8 +86,14 @@ type GlobalConfig struct {
 	MotanServer      motan.MotanServerOptions
 	Jsonrpc          gateway.JsonrpcOptions
 	Websocket        gateway.WebsocketOptions
+	WebsocketRpc     gateway.WebsocketOptions
+	Webhook          webhook.WebhookOptions
+	Envelope         envelope.EnvelopeOptions
+	Sign             gateway.SignOptions
 	AccountManager   accountmanager.AccountManagerOptions
 	MyToken          market.MyTokenConfig
+	TickerCollector  market.TickerCollectorOptions
+	Trend            market.TrendOptions
 	CloudWatch       cloudwatch.CloudWatchConfig
 }
 
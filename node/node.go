@@ -20,12 +20,18 @@ package node
 
 import (
 	"sync"
+	"time"
 
 	"fmt"
 	"github.com/Loopring/relay-cluster/accountmanager"
 	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/dao/envelope"
 	"github.com/Loopring/relay-cluster/gateway"
+	"github.com/Loopring/relay-cluster/gateway/order_difficulty"
+	"github.com/Loopring/relay-cluster/gateway/ratelimit"
 	"github.com/Loopring/relay-cluster/market"
+	"github.com/Loopring/relay-cluster/market/orderbook"
+	"github.com/Loopring/relay-cluster/metrics"
 	ordermanager "github.com/Loopring/relay-cluster/ordermanager/manager"
 	orderviewer "github.com/Loopring/relay-cluster/ordermanager/viewer"
 	ringtrackerviewer "github.com/Loopring/relay-cluster/ringtrackermanager/viewer"
@@ -38,12 +44,14 @@ import (
 	"github.com/Loopring/relay-lib/crypto"
 	"github.com/Loopring/relay-lib/eth/accessor"
 	"github.com/Loopring/relay-lib/eth/loopringaccessor"
+	"github.com/Loopring/relay-lib/eventemitter"
 	"github.com/Loopring/relay-lib/extractor"
 	"github.com/Loopring/relay-lib/kafka"
 	"github.com/Loopring/relay-lib/log"
 	"github.com/Loopring/relay-lib/marketcap"
 	util "github.com/Loopring/relay-lib/marketutil"
 	"github.com/Loopring/relay-lib/sns"
+	"github.com/Loopring/relay-lib/types"
 	"github.com/Loopring/relay-lib/zklock"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"go.uber.org/zap"
@@ -62,26 +70,61 @@ type Node struct {
 	tickerCollector   market.CollectorImpl
 	tickerManager     market.GetTickerImpl
 	globalMarket      market.GlobalMarket
+	orderBook         *orderbook.OrderBook
 	jsonRpcService    gateway.JsonrpcServiceImpl
 	websocketService  gateway.WebsocketServiceImpl
 	socketIOService   gateway.SocketIOServiceImpl
 	walletService     gateway.WalletServiceImpl
+	triggerEngine     *gateway.TriggerEngine
 	txManager         txmanager.TransactionManager
-	motanService      *gateway.MotanService
-
-	wg     *sync.WaitGroup
-	logger *zap.Logger
+	motanServer       *gateway.MotanServer
+	orderDifficulty   *order_difficulty.OrderDifficultyEvaluator
+	healthService     *gateway.HealthService
+	eventLagWatcher   *metrics.EventLagWatcher
+	extractor         *kafka.ConsumerRegister
+
+	services []Service
+	mtx      sync.Mutex // held by Start, so Stop waits for the services started
+	stopped  bool
+	stopOnce sync.Once
+	wg       *sync.WaitGroup
+	logger   *zap.Logger
 
 	ringTrackerViewer  ringtrackerviewer.RingTrackerViewer
 	ringTrackerService gateway.RingTrackerServiceImpl
 	contestRankService gateway.ContestRankServiceImpl
+	webhookService     gateway.WebhookServiceImpl
+	adminService       gateway.AdminServiceImpl
+}
+
+// Service is implemented by the components started by node,
+// they are started in order and stopped in reverse order
+type Service interface {
+	Start()
+	Stop()
 }
 
+// serviceFuncs adapts components whose Start and Stop can't be methods,
+// e.g. every exported method of WalletServiceImpl is exported by jsonrpc
+type serviceFuncs struct {
+	start func()
+	stop  func()
+}
+
+func (s *serviceFuncs) Start() { s.start() }
+func (s *serviceFuncs) Stop()  { s.stop() }
+
+const (
+	defaultShutdownTimeout = 10
+	healthCheckTimeout     = 5 * time.Second
+)
+
 func NewNode(logger *zap.Logger, globalConfig *GlobalConfig) *Node {
 	n := &Node{}
 	n.logger = logger
 	n.globalConfig = globalConfig
 	n.wg = new(sync.WaitGroup)
+	n.wg.Add(1)
 	// register
 	n.registerZklock()
 	n.registerSocketIOProducer()
@@ -100,6 +143,7 @@ func NewNode(logger *zap.Logger, globalConfig *GlobalConfig) *Node {
 	n.registerOrderViewer()
 
 	n.registerAccountManager()
+	n.registerOrderDifficultyEvaluator()
 	n.registerGateway()
 	n.registerCrypto(nil)
 
@@ -109,13 +153,20 @@ func NewNode(logger *zap.Logger, globalConfig *GlobalConfig) *Node {
 	n.registerTrendManager()
 	n.registerTickerCollector()
 	n.registerGlobalMarket()
+	n.registerOrderBook()
 	n.registerWalletService()
+	n.registerTriggerEngine()
+	n.registerWebhookService()
+	n.registerAdminService()
+	n.registerHealthService()
 	n.registerJsonRpcService()
-	n.registerWebsocketService()
 	n.registerSocketIOService()
+	n.registerWebsocketService()
+	n.registerMotanServer()
 
 	n.registerExtractor()
 	n.registerCloudWatch()
+	n.registerEventLagWatcher()
 
 	n.registerRingTrackerViewer()
 	n.registerRingTrackerService()
@@ -123,34 +174,73 @@ func NewNode(logger *zap.Logger, globalConfig *GlobalConfig) *Node {
 	return n
 }
 
+// Start does nothing once the node is stopped, a signal during starting stops the node after Start returns
 func (n *Node) Start() {
-	n.orderManager.Start()
-	n.tickerManager.Start()
-	n.marketCapProvider.Start()
-	n.accountManager.Start()
-	n.txManager.Start()
-	//gateway.NewJsonrpcService("8080").Start()
-	fmt.Println("step in relay node start")
-	n.tickerCollector.Start()
-	n.globalMarket.Start()
-	go n.jsonRpcService.Start()
-	//n.websocketService.Start()
-	go n.socketIOService.Start()
-	go n.walletService.Start()
-	gateway.StartMotanService(n.globalConfig.MotanServer, n.accountManager, n.orderViewer)
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if n.stopped {
+		return
+	}
 
-	n.wg.Add(1)
+	n.services = []Service{
+		n.orderManager,
+		&n.tickerManager,
+		n.marketCapProvider,
+		&n.accountManager,
+		&n.txManager,
+		n.orderDifficulty,
+		n.eventLagWatcher,
+		&n.tickerCollector,
+		&n.globalMarket,
+		n.orderBook,
+		&n.jsonRpcService,
+		&n.socketIOService,
+		&n.websocketService,
+		&serviceFuncs{start: n.walletService.Start, stop: func() { gateway.StopWalletService(&n.walletService) }},
+		n.triggerEngine,
+		&serviceFuncs{start: func() { gateway.StartWebhookService(&n.webhookService) }, stop: func() { gateway.StopWebhookService(&n.webhookService) }},
+		n.motanServer,
+	}
+
+	fmt.Println("step in relay node start")
+	for _, service := range n.services {
+		service.Start()
+	}
+	n.healthService.SetReady(true)
 }
 
 func (n *Node) Wait() {
 	n.wg.Wait()
 }
 
-// todo release zklock and kafka producers and consumers
+// Stop stops services in reverse order, so listeners are drained before the managers behind them stop,
+// then releases the zklocks and kafka producers held by the components created in NewNode.
+// the extractor consumer is closed first so no chain event reaches a stopped component
 func (n *Node) Stop() {
-	n.orderManager.Stop()
-	n.txManager.Stop()
-	n.wg.Done()
+	n.stopOnce.Do(func() {
+		n.mtx.Lock()
+		defer n.mtx.Unlock()
+		n.stopped = true
+
+		n.healthService.SetReady(false)
+		if nil != n.extractor {
+			n.extractor.Close()
+		}
+		for i := len(n.services) - 1; i >= 0; i-- {
+			n.services[i].Stop()
+		}
+		n.trendManager.Stop()
+		n.ringTrackerViewer.Stop()
+
+		if err := socketioutil.Close(); nil != err {
+			log.Errorf("node stop, close socketio producer error:%s", err.Error())
+		}
+		if nil != zklock.ZkClient {
+			zklock.ZkClient.Close()
+		}
+		log.Info("node stopped")
+		n.wg.Done()
+	})
 }
 
 func (n *Node) registerCrypto(ks *keystore.KeyStore) {
@@ -160,6 +250,23 @@ func (n *Node) registerCrypto(ks *keystore.KeyStore) {
 
 func (n *Node) registerMysql() {
 	n.rdsService = dao.NewDb(&n.globalConfig.Mysql)
+	n.registerEnvelope()
+}
+
+// registerEnvelope enables encryption of auth private keys, orders are read and written by dao after it.
+// priv_key is widened by `order-keys migrate`, the node doesn't alter the table when it starts
+func (n *Node) registerEnvelope() {
+	if !n.globalConfig.Envelope.Enabled {
+		return
+	}
+	if width, err := n.rdsService.GetOrderPrivateKeyWidth(); err != nil {
+		log.Fatalf("get width of priv_key of orders error:%s", err.Error())
+	} else if width < dao.OrderPrivateKeyWidth {
+		log.Fatalf("priv_key of orders can't hold encrypted keys, run `order-keys migrate` before enabling envelope")
+	}
+	if err := envelope.Initialize(n.globalConfig.Envelope, n.rdsService); err != nil {
+		log.Fatalf("initialize envelope error:%s", err.Error())
+	}
 }
 
 func (n *Node) registerCache() {
@@ -187,7 +294,7 @@ func (n *Node) registerOrderViewer() {
 }
 
 func (n *Node) registerTrendManager() {
-	n.trendManager = market.NewTrendManager(n.rdsService, n.orderViewer)
+	n.trendManager = market.NewTrendManager(n.rdsService, n.orderViewer, n.globalConfig.Trend)
 }
 
 func (n *Node) registerAccountManager() {
@@ -203,7 +310,7 @@ func (n *Node) registerTransactionViewer() {
 }
 
 func (n *Node) registerTickerCollector() {
-	n.tickerCollector = *market.NewCollector()
+	n.tickerCollector = *market.NewCollector(n.globalConfig.TickerCollector)
 }
 
 func (n *Node) registerTickerManager() {
@@ -214,21 +321,61 @@ func (n *Node) registerGlobalMarket() {
 	n.globalMarket = market.NewGlobalMarket(n.globalConfig.MyToken)
 }
 
+func (n *Node) registerOrderBook() {
+	n.orderBook = orderbook.NewOrderBook(n.rdsService, n.globalConfig.Kafka.Brokers)
+}
+
 func (n *Node) registerWalletService() {
+	gateway.InitializeSign(n.globalConfig.Sign)
 	n.walletService = *gateway.NewWalletService(n.trendManager, n.orderViewer,
-		n.accountManager, n.marketCapProvider, n.tickerCollector, n.tickerManager, n.rdsService, n.globalConfig.Market.OldVersionWethAddress, n.globalMarket)
+		n.accountManager, n.marketCapProvider, n.tickerCollector, n.tickerManager, n.rdsService, n.globalConfig.Market.OldVersionWethAddress, n.globalMarket,
+		n.orderBook, n.globalConfig.Market.MarketFile)
+}
+
+func (n *Node) registerTriggerEngine() {
+	n.triggerEngine = gateway.NewTriggerEngine(n.globalConfig.Gateway.ConditionalOrder, n.rdsService, &n.trendManager, &n.tickerCollector)
+}
+
+func (n *Node) registerWebhookService() {
+	n.webhookService = *gateway.NewWebhookService(n.globalConfig.Webhook, n.rdsService, &n.walletService, n.globalConfig.Kafka.Brokers, n.globalConfig.Jsonrpc.RateLimit.ApiKeys)
+}
+
+func (n *Node) registerAdminService() {
+	n.adminService = *gateway.NewAdminService(n.rdsService, n.globalConfig.Jsonrpc.AdminKeys)
 }
 
 func (n *Node) registerJsonRpcService() {
-	n.jsonRpcService = *gateway.NewJsonrpcService(n.globalConfig.Jsonrpc.Port, &n.walletService, &n.ringTrackerService, &n.contestRankService)
+	n.jsonRpcService = *gateway.NewJsonrpcService(&n.globalConfig.Jsonrpc, n.shutdownTimeout(), &n.walletService, &n.ringTrackerService, &n.contestRankService, &n.webhookService, &n.adminService, n.healthService, n.rateLimiter())
+}
+
+func (n *Node) rateLimiter() *ratelimit.RateLimiter {
+	return ratelimit.NewRateLimiter(n.globalConfig.Jsonrpc.RateLimit, ratelimit.NewRedisStore(n.globalConfig.Redis))
+}
+
+func (n *Node) registerHealthService() {
+	n.healthService = gateway.NewHealthService(healthCheckTimeout)
+	n.healthService.RegisterChecker("mysql", gateway.MysqlChecker(n.rdsService))
+	n.healthService.RegisterChecker("redis", gateway.RedisChecker())
+	n.healthService.RegisterChecker("kafka", gateway.KafkaChecker(n.globalConfig.Kafka.Brokers))
+	n.healthService.RegisterChecker("zookeeper", gateway.ZookeeperChecker())
+	n.healthService.RegisterChecker("accessor", gateway.AccessorChecker())
 }
 
 func (n *Node) registerWebsocketService() {
-	n.websocketService = *gateway.NewWebsocketService(n.globalConfig.Websocket.Port, n.trendManager, n.accountManager, n.marketCapProvider)
+	n.websocketService = *gateway.NewWebsocketService(&n.globalConfig.WebsocketRpc, n.shutdownTimeout(), &n.socketIOService, &n.walletService, &n.ringTrackerService, &n.contestRankService, &n.webhookService, n.rateLimiter())
 }
 
 func (n *Node) registerSocketIOService() {
-	n.socketIOService = *gateway.NewSocketIOService(n.globalConfig.Websocket.Port, n.walletService, n.globalConfig.Kafka.Brokers)
+	n.socketIOService = *gateway.NewSocketIOService(&n.globalConfig.Websocket, n.shutdownTimeout(), n.walletService, n.globalConfig.Kafka.Brokers)
+}
+
+func (n *Node) registerMotanServer() {
+	n.motanServer = gateway.NewMotanServer(n.globalConfig.MotanServer, n.shutdownTimeout(), n.accountManager, n.orderViewer)
+}
+
+func (n *Node) registerOrderDifficultyEvaluator() {
+	baseDifficulty := types.HexToBigint(n.globalConfig.GatewayFilters.PowFilter.Difficulty)
+	n.orderDifficulty = order_difficulty.Initialize(n.globalConfig.OrderDifficulty, baseDifficulty)
 }
 
 func (n *Node) registerGateway() {
@@ -261,12 +408,33 @@ func (n *Node) registerSnsNotifier() {
 	sns.Initialize(n.globalConfig.Sns)
 }
 
+// registerExtractor consumes the chain events like extractor.Initialize does,
+// but keeps the consumer so that it can be closed when the node stops
 func (n *Node) registerExtractor() {
-	if err := extractor.Initialize(n.globalConfig.Kafka, kafka.Kafka_Group_RelayCluster_EventOnChain); err != nil {
+	n.extractor = &kafka.ConsumerRegister{}
+	n.extractor.Initialize(n.globalConfig.Kafka.Brokers)
+	if err := n.extractor.RegisterTopicAndHandler(kafka.Kafka_Topic_Extractor_EventOnChain, kafka.Kafka_Group_RelayCluster_EventOnChain, types.KafkaOnChainEvent{}, handleOnChainEvent); err != nil {
 		log.Fatalf("node start, register extractor error:%s", err.Error())
 	}
 }
 
+func handleOnChainEvent(input interface{}) error {
+	src, ok := input.(*types.KafkaOnChainEvent)
+	if !ok {
+		return fmt.Errorf("extractor,input type should be *KafkaOnChainEvent")
+	}
+	event, err := extractor.Disassemble(src)
+	if err != nil {
+		return fmt.Errorf("extractor, disassemble error:%s", err.Error())
+	}
+	eventemitter.Emit(src.Topic, event)
+	return nil
+}
+
+func (n *Node) registerEventLagWatcher() {
+	n.eventLagWatcher = metrics.NewEventLagWatcher(metrics.DefaultLagTopics)
+}
+
 func (n *Node) registerCloudWatch() {
 	cloudwatch.Initialize(n.globalConfig.CloudWatch)
 }
@@ -282,3 +450,11 @@ func (n *Node) registerRingTrackerService() {
 func (n *Node) registerContestRankService() {
 	n.contestRankService = *gateway.NewContestRankService(n.orderViewer)
 }
+
+func (n *Node) shutdownTimeout() time.Duration {
+	timeout := n.globalConfig.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return time.Duration(timeout) * time.Second
+}
@@ -3,4 +3,23 @@ package common
 type OrderManagerOptions struct {
 	CutoffCacheExpireTime int64
 	CutoffCacheCleanTime  int64
+	Reconciler            ReconcilerOptions
+	ExpirySweeper         ExpirySweeperOptions
+}
+
+// ReconcilerOptions configures the checker comparing open orders with cancelledOrFilled and cutoffs of delegates
+type ReconcilerOptions struct {
+	Enabled     bool
+	Interval    int  // seconds between rounds
+	SampleSize  int  // open orders checked in a round, rounds go through all open orders in turn
+	BatchSize   int  // orders queried by one batch of eth_call
+	BlockLag    int  // orders are compared at latest-BlockLag, orders updated after it are skipped
+	AutoCorrect bool // update drifting orders, otherwise they are only reported
+}
+
+// ExpirySweeperOptions configures the sweeper moving open orders past valid_until to ORDER_EXPIRE
+type ExpirySweeperOptions struct {
+	Enabled   bool
+	Interval  int // seconds between rounds, a round sweeps until no expired order is left
+	BatchSize int // orders read from lpr_orders at a time
 }
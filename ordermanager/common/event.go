@@ -0,0 +1,27 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package common
+
+import "github.com/Loopring/relay-lib/types"
+
+// GatewayOrders is the NewOrder event of orders submitted in a batch,
+// they're saved together and pushed once per owner instead of once per order
+type GatewayOrders struct {
+	States []*types.OrderState
+}
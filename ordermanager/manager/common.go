@@ -48,7 +48,9 @@ func NewOrderEntity(state *types.OrderState, blockNumber *big.Int) (*dao.Order,
 
 	// convert order
 	model := &dao.Order{}
-	model.ConvertDown(state)
+	if err := model.ConvertDown(state); err != nil {
+		return nil, err
+	}
 
 	return model, nil
 }
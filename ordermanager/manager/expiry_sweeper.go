@@ -0,0 +1,125 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package manager
+
+import (
+	"github.com/Loopring/relay-cluster/metrics"
+	omcm "github.com/Loopring/relay-cluster/ordermanager/common"
+	notify "github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-cluster/util/zkjob"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/Loopring/relay-lib/types"
+	"time"
+)
+
+const ExpirySweeperZkLock = "zklock_order_expiry_sweeper"
+
+var sweptOrders = metrics.NewCounterVec("relay_order_expiry_swept_total", "Orders past valid_until handled by the expiry sweeper by result.", "result")
+
+// orders pending or cancelling are settled by their txs, the sweeper expires them after they're back to open
+var expirableStatus = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+
+// ExpirySweeper moves orders past valid_until from NEW and PARTIAL to ORDER_EXPIRE and pushes them to wallets,
+// the node holding ExpirySweeperZkLock runs it
+type ExpirySweeper struct {
+	options omcm.ExpirySweeperOptions
+	job     *zkjob.Job
+}
+
+func NewExpirySweeper(options omcm.ExpirySweeperOptions) *ExpirySweeper {
+	if options.Interval <= 0 {
+		options.Interval = 60
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 200
+	}
+	s := &ExpirySweeper{options: options}
+	s.job = zkjob.New(ExpirySweeperZkLock, time.Duration(options.Interval)*time.Second, func() {
+		if err := s.Sweep(); err != nil {
+			log.Errorf("order expiry sweeper, sweep error:%s", err.Error())
+		}
+	})
+	return s
+}
+
+func (s *ExpirySweeper) Start() {
+	if !s.options.Enabled {
+		return
+	}
+	s.job.Start()
+}
+
+func (s *ExpirySweeper) Stop() {
+	if !s.options.Enabled {
+		return
+	}
+	s.job.Stop()
+}
+
+// Sweep expires orders batch by batch until none is left, it stops early if no order of a batch can be expired
+func (s *ExpirySweeper) Sweep() error {
+	for {
+		select {
+		case <-s.job.Stopping():
+			return nil
+		default:
+		}
+
+		now := time.Now().Unix()
+		models, err := rds.GetExpiredOrders(now, expirableStatus, s.options.BatchSize)
+		if err != nil {
+			return err
+		}
+		expired := 0
+		for _, model := range models {
+			state := &types.OrderState{}
+			if err := model.ConvertUp(state); err != nil {
+				sweptOrders.Inc("failed")
+				log.Errorf("order expiry sweeper, convert order:%s error:%s", model.OrderHash, err.Error())
+				continue
+			}
+			if ok := expireOrder(state, now); ok {
+				expired++
+			}
+		}
+		log.Debugf("order expiry sweeper, expired %d of %d orders", expired, len(models))
+		if len(models) < s.options.BatchSize || expired == 0 {
+			return nil
+		}
+	}
+}
+
+func expireOrder(state *types.OrderState, now int64) bool {
+	hash := state.RawOrder.Hash
+	ok, err := rds.ExpireOrder(hash, now, expirableStatus, types.ORDER_EXPIRE)
+	if err != nil {
+		sweptOrders.Inc("failed")
+		log.Errorf("order expiry sweeper, expire order:%s error:%s", hash.Hex(), err.Error())
+		return false
+	}
+	if !ok {
+		sweptOrders.Inc("skipped")
+		return false
+	}
+	sweptOrders.Inc("expired")
+
+	state.Status = types.ORDER_EXPIRE
+	notify.NotifyOrderUpdate(state)
+	return true
+}
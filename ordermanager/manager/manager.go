@@ -38,6 +38,8 @@ type OrderManagerImpl struct {
 	options                    *common.OrderManagerOptions
 	brokers                    []string
 	processor                  *ForkProcessor
+	reconciler                 *Reconciler
+	expirySweeper              *ExpirySweeper
 	newOrderWatcher            *eventemitter.Watcher
 	ringMinedWatcher           *eventemitter.Watcher
 	fillOrderWatcher           *eventemitter.Watcher
@@ -71,6 +73,8 @@ func NewOrderManager(
 	om.options = options
 	om.brokers = brokers
 	om.processor = NewForkProcess()
+	om.reconciler = NewReconciler(options.Reconciler)
+	om.expirySweeper = NewExpirySweeper(options.ExpirySweeper)
 	cutoffcache = common.NewCutoffCache(options.CutoffCacheCleanTime)
 
 	marketCapProvider = market
@@ -123,6 +127,13 @@ func (om *OrderManagerImpl) Start() {
 
 	eventemitter.On(eventemitter.ChainForkDetected, om.forkWatcher)
 	eventemitter.On(eventemitter.ExtractorWarning, om.warningWatcher)
+
+	om.reconciler.Start()
+	om.expirySweeper.Start()
+
+	if err := RebuildP2POrderRelations(); err != nil {
+		log.Errorf("order manager, rebuild p2p relations error:%s", err.Error())
+	}
 }
 
 func (om *OrderManagerImpl) Stop() {
@@ -143,6 +154,9 @@ func (om *OrderManagerImpl) Stop() {
 
 	eventemitter.Un(eventemitter.ChainForkDetected, om.forkWatcher)
 	eventemitter.Un(eventemitter.ExtractorWarning, om.warningWatcher)
+
+	om.reconciler.Stop()
+	om.expirySweeper.Stop()
 }
 
 func (om *OrderManagerImpl) handleFork(input eventemitter.EventData) error {
@@ -169,6 +183,8 @@ func (om *OrderManagerImpl) HandlerOrderRelatedEvent(input eventemitter.EventDat
 	switch event := input.(type) {
 	case *types.OrderState:
 		err = HandleGatewayOrder(event)
+	case *common.GatewayOrders:
+		err = HandleGatewayOrders(event)
 	case *types.SubmitRingMethodEvent:
 		err = HandleSubmitRingMethodEvent(event)
 		err = HandleP2PSubmitRing(event)
@@ -24,6 +24,7 @@ import (
 	"github.com/Loopring/relay-cluster/ordermanager/cache"
 	omcm "github.com/Loopring/relay-cluster/ordermanager/common"
 	omtyp "github.com/Loopring/relay-cluster/ordermanager/types"
+	notify "github.com/Loopring/relay-cluster/util"
 	"github.com/Loopring/relay-lib/types"
 	"github.com/ethereum/go-ethereum/common"
 )
@@ -195,7 +196,7 @@ func (handler *OrderTxHandler) setOrderStatus(list []omtyp.OrderTx) error {
 			return nil
 		}
 		SettleOrderStatus(state, false)
-		return rds.UpdateOrderStatus(event.OrderHash, state.Status)
+		return updateOrderStatus(state, state.Status)
 	}
 
 	// order owner cancelling/cutoffing
@@ -203,8 +204,7 @@ func (handler *OrderTxHandler) setOrderStatus(list []omtyp.OrderTx) error {
 		if state.Status == list[0].OrderStatus {
 			return nil
 		}
-		state.Status = list[0].OrderStatus
-		return rds.UpdateOrderStatus(event.OrderHash, state.Status)
+		return updateOrderStatus(state, list[0].OrderStatus)
 	}
 
 	// miner submit ring pending
@@ -212,12 +212,23 @@ func (handler *OrderTxHandler) setOrderStatus(list []omtyp.OrderTx) error {
 		if omcm.IsPendingStatus(state.Status) {
 			return nil
 		}
-		return rds.UpdateOrderStatus(event.OrderHash, list[0].OrderStatus)
+		return updateOrderStatus(state, list[0].OrderStatus)
 	}
 
 	return nil
 }
 
+// updateOrderStatus saves status of the order and publishes it,
+// so the order books of gateways drop the order while it's pending and take it again after
+func updateOrderStatus(state *types.OrderState, status types.OrderStatus) error {
+	state.Status = status
+	if err := rds.UpdateOrderStatus(state.RawOrder.Hash, status); err != nil {
+		return err
+	}
+	notify.NotifyOrderUpdate(state)
+	return nil
+}
+
 func (handler *OrderTxHandler) fullFilled(orderhash common.Hash) {
 	handler.Event.OrderHash = orderhash
 }
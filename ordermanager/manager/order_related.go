@@ -48,6 +48,41 @@ func HandleGatewayOrder(state *types.OrderState) error {
 	return notify.NotifyOrderUpdate(state)
 }
 
+// HandleGatewayOrders saves orders submitted in a batch, orders saved are notified once per owner
+func HandleGatewayOrders(event *omcm.GatewayOrders) error {
+	var owners []common.Address
+	saved := make(map[common.Address][]*types.OrderState)
+	count := 0
+	for _, state := range event.States {
+		model, err := NewOrderEntity(state, nil)
+		if err != nil {
+			log.Errorf("order manager,handle gateway order:%s error:%s", state.RawOrder.Hash.Hex(), err.Error())
+			continue
+		}
+		if err = rds.Add(model); err != nil {
+			log.Errorf("order manager,save gateway order:%s error:%s", state.RawOrder.Hash.Hex(), err.Error())
+			continue
+		}
+
+		owner := state.RawOrder.Owner
+		if _, ok := saved[owner]; !ok {
+			owners = append(owners, owner)
+		}
+		saved[owner] = append(saved[owner], state)
+		count++
+	}
+
+	log.Debugf("order manager,handle gateway orders,saved %d of %d orders", count, len(event.States))
+
+	var err error
+	for _, owner := range owners {
+		if e := notify.NotifyOrdersUpdate(owner, saved[owner]); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
 func HandleSubmitRingMethodEvent(event *types.SubmitRingMethodEvent) error {
 	if event.Status != types.TX_STATUS_PENDING && event.Status != types.TX_STATUS_FAILED {
 		return fmt.Errorf("order manager, submitRingHandler, tx:%s, txstatus:%s invalid", event.TxHash.Hex(), types.StatusStr(event.Status))
@@ -181,7 +216,7 @@ func HandleOrderFilledEvent(event *types.OrderFilledEvent) error {
 
 	notify.NotifyOrderFilled(newFillModel)
 
-	return nil
+	return notify.NotifyOrderUpdate(state)
 }
 
 func HandleOrderCancelledEvent(event *types.OrderCancelledEvent) error {
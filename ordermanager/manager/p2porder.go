@@ -19,27 +19,12 @@
 package manager
 
 import (
-	"encoding/json"
-	"github.com/Loopring/relay-lib/cache"
-	"github.com/Loopring/relay-lib/log"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/ordermanager/p2p"
 	"github.com/Loopring/relay-lib/types"
 	"math/big"
-	"strconv"
-	"strings"
-	"time"
 )
 
-const p2pRelationPreKey = "P2P_RELATION_"
-const splitMark = "_"
-const p2pTakerPreKey = "P2P_TAKERS_"
-
-type P2pOrderRelation struct {
-	Txhash         string
-	Makerorderhash string
-	Takerorderhash string
-	PendingAmount  string
-}
-
 func init() {
 	//submitRingMethodWatcher := &eventemitter.Watcher{Concurrent: false, Handle: HandleP2PSubmitRing}
 	//eventemitter.On(eventemitter.Miner_SubmitRing_Method, submitRingMethodWatcher)
@@ -48,44 +33,20 @@ func init() {
 	//eventemitter.On(eventemitter.OrderFilled, p2pRingMinedWatcher)
 }
 
-func SaveP2POrderRelation(takerOwner, taker, makerOwner, maker, txHash, pendingAmount, validUntil string) error {
-
-	takerOwner = strings.ToLower(takerOwner)
-	taker = strings.ToLower(taker)
-	makerOwner = strings.ToLower(makerOwner)
-	maker = strings.ToLower(maker)
-	txHash = strings.ToLower(txHash)
-
-	untilTime, _ := strconv.ParseInt(validUntil, 10, 64)
-	nowTime := time.Now().Unix()
-	takerExpiredTime := untilTime - nowTime
-	cache.ZAdd(p2pTakerPreKey+maker, takerExpiredTime, []byte(strconv.FormatInt(nowTime, 10)), []byte(txHash+splitMark+pendingAmount))
-
-	//save txhash,maker,taker relations
-	p2pOrderRelationStr, _ := GetP2pOrderRelation(maker, taker, txHash, pendingAmount)
-	cache.Set(p2pRelationPreKey+txHash, p2pOrderRelationStr, takerExpiredTime)
-	cache.Set(p2pRelationPreKey+taker, []byte(txHash), takerExpiredTime)
+func p2pRelations() *p2p.Relations {
+	return p2p.NewRelations(rds, p2p.RedisCache{})
+}
 
-	return nil
+// SaveP2POrderRelation saves the relation in mysql before caching it, its ring mustn't be broadcast if it fails
+func SaveP2POrderRelation(takerOwner, taker, makerOwner, maker, txHash, pendingAmount, validUntil string) error {
+	return p2pRelations().Save(takerOwner, taker, makerOwner, maker, txHash, pendingAmount, validUntil)
 }
 
 // status failed/pending
 func HandleP2PSubmitRing(evt *types.SubmitRingMethodEvent) error {
 	//release taker's failed p2porder pengdingAmount
 	if evt != nil && evt.Status == types.TX_STATUS_FAILED {
-		txHash := strings.ToLower(evt.TxHash.Hex())
-		keyExist, _ := cache.Exists(p2pRelationPreKey + strings.ToLower(txHash))
-		if keyExist == true {
-			jsonStr, _ := cache.Get(p2pRelationPreKey + txHash)
-			p2pOrderRelation := P2pOrderRelation{}
-			if err := json.Unmarshal(jsonStr, &p2pOrderRelation); nil != err {
-				log.Errorf("mehtod HandleP2PSubmitRing of p2pOrderRelation syncFromCache err:%s", err.Error())
-				return err
-			} else {
-				maker := p2pOrderRelation.Makerorderhash
-				cache.ZRem(p2pTakerPreKey+maker, []byte(txHash+splitMark+p2pOrderRelation.PendingAmount))
-			}
-		}
+		return p2pRelations().Release(evt.TxHash.Hex(), dao.P2pRelationFailed, evt.BlockNumber)
 	}
 	return nil
 }
@@ -94,51 +55,22 @@ func HandleP2PSubmitRing(evt *types.SubmitRingMethodEvent) error {
 func HandleP2POrderFilled(evt *types.OrderFilledEvent) error {
 	//release taker's successed p2porder pengdingAmount
 	if evt != nil && evt.Status == types.TX_STATUS_SUCCESS {
-		txHash := strings.ToLower(evt.TxHash.Hex())
-		keyExist, _ := cache.Exists(p2pRelationPreKey + strings.ToLower(txHash))
-		if keyExist == true {
-			jsonStr, _ := cache.Get(p2pRelationPreKey + txHash)
-			p2pOrderRelation := P2pOrderRelation{}
-			if err := json.Unmarshal(jsonStr, &p2pOrderRelation); nil != err {
-				log.Errorf("metho of HandleP2POrderFilled p2pOrderRelation syncFromCache err:%s", err.Error())
-				return err
-			} else {
-				maker := p2pOrderRelation.Makerorderhash
-				cache.ZRem(p2pTakerPreKey+maker, []byte(txHash+splitMark+p2pOrderRelation.PendingAmount))
-			}
-			cache.Del(p2pRelationPreKey + p2pOrderRelation.Takerorderhash)
-		}
+		return p2pRelations().Release(evt.TxHash.Hex(), dao.P2pRelationSettled, evt.BlockNumber)
 	}
 	return nil
 }
 
-func GetP2PPendingAmount(maker string) (pendingAmount *big.Rat, err error) {
-	pendingAmount = new(big.Rat)
-	maker = strings.ToLower(maker)
-	if data, err := cache.ZRange(p2pTakerPreKey+maker, 0, -1, false); nil != err {
-		return pendingAmount, err
-	} else {
-		for _, v := range data {
-			pendData, _ := new(big.Int).SetString(strings.Split(string(v), splitMark)[1], 0)
-			pendingAmount = pendingAmount.Add(pendingAmount, new(big.Rat).SetInt(pendData))
-		}
-		return pendingAmount, nil
-	}
+// GetP2PPendingAmount is the sum of the pending relations of maker in the cache and mysql, merged by tx hash
+func GetP2PPendingAmount(maker string) (*big.Rat, error) {
+	pendingAmount, _, err := p2pRelations().PendingAmount(maker)
+	return pendingAmount, err
 }
 
-func GetP2pOrderRelation(maker, taker, txHash, pendingAmount string) ([]byte, error) {
-	var p2pOrderRelation P2pOrderRelation
-	p2pOrderRelation.Makerorderhash = maker
-	p2pOrderRelation.Takerorderhash = taker
-	p2pOrderRelation.Txhash = txHash
-	p2pOrderRelation.PendingAmount = pendingAmount
-	return json.Marshal(p2pOrderRelation)
+// RebuildP2POrderRelations restores pending relations into the cache, the ones filled or past validUntil are closed instead
+func RebuildP2POrderRelations() error {
+	return p2pRelations().Rebuild()
 }
 
 func IsP2PTakerLocked(taker string) bool {
-	exist, err := cache.Exists(p2pRelationPreKey + strings.ToLower(taker))
-	if nil != err || exist == true {
-		return true
-	}
-	return false
+	return p2pRelations().IsTakerLocked(taker)
 }
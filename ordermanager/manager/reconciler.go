@@ -0,0 +1,387 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-cluster/metrics"
+	omcm "github.com/Loopring/relay-cluster/ordermanager/common"
+	notify "github.com/Loopring/relay-cluster/util"
+	"github.com/Loopring/relay-cluster/util/zkjob"
+	"github.com/Loopring/relay-lib/eth/accessor"
+	"github.com/Loopring/relay-lib/eth/loopringaccessor"
+	ethtyp "github.com/Loopring/relay-lib/eth/types"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"time"
+)
+
+const ReconcilerZkLock = "zklock_order_reconciler"
+
+var reconciledOrders = metrics.NewCounterVec("relay_order_reconciled_total", "Orders checked by the reconciler by result.", "result")
+
+// the reconciler checks orders NEW and PARTIAL, pending ones are settled by their txs
+var reconcileStatus = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+
+// orders flex cancelled or expired are closed by the relay, not by the chain, so they can't be reconciled
+var unreconcilableStatus = []types.OrderStatus{types.ORDER_UNKNOWN, types.ORDER_FLEX_CANCEL, types.ORDER_EXPIRE}
+
+var reconcilerOptions omcm.ReconcilerOptions
+
+// Reconciler compares open orders in lpr_orders with cancelled, cancelledOrFilled and cutoffs of their delegates,
+// the node holding ReconcilerZkLock runs it, drifts are saved as dao.OrderDrift and corrected if AutoCorrect
+type Reconciler struct {
+	options omcm.ReconcilerOptions
+	afterId int
+	job     *zkjob.Job
+}
+
+func NewReconciler(options omcm.ReconcilerOptions) *Reconciler {
+	if options.Interval <= 0 {
+		options.Interval = 600
+	}
+	if options.SampleSize <= 0 {
+		options.SampleSize = 500
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 50
+	}
+	if options.BlockLag <= 0 {
+		options.BlockLag = 12
+	}
+	reconcilerOptions = options
+
+	r := &Reconciler{options: options}
+	r.job = zkjob.New(ReconcilerZkLock, time.Duration(options.Interval)*time.Second, func() {
+		if err := r.Reconcile(); err != nil {
+			log.Errorf("order reconciler, reconcile error:%s", err.Error())
+		}
+	})
+	return r
+}
+
+func (r *Reconciler) Start() {
+	if !r.options.Enabled {
+		return
+	}
+	r.job.Start()
+}
+
+func (r *Reconciler) Stop() {
+	if !r.options.Enabled {
+		return
+	}
+	r.job.Stop()
+}
+
+// Reconcile checks the next SampleSize open orders after the ones checked last round
+func (r *Reconciler) Reconcile() error {
+	models, err := rds.GetOpenOrdersAfter(r.afterId, reconcileStatus, r.options.SampleSize)
+	if err != nil {
+		return err
+	}
+	if len(models) < r.options.SampleSize {
+		r.afterId = 0
+	} else {
+		r.afterId = models[len(models)-1].ID
+	}
+
+	blockNumber, err := reconcileBlockNumber(r.options.BlockLag)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(models); start += r.options.BatchSize {
+		end := start + r.options.BatchSize
+		if end > len(models) {
+			end = len(models)
+		}
+		if _, _, err := reconcileOrders(models[start:end], blockNumber, r.options.AutoCorrect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconcileOrder checks an order against the chain now, it's used by admins to check or correct an order reported,
+// it returns nil if the order doesn't drift
+func ReconcileOrder(orderHash common.Hash, correct bool) (*dao.OrderDrift, error) {
+	model, err := rds.GetOrderByHash(orderHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range unreconcilableStatus {
+		if types.OrderStatus(model.Status) == status {
+			return nil, fmt.Errorf("order:%s is closed by the relay, it can't be reconciled", orderHash.Hex())
+		}
+	}
+	blockNumber, err := reconcileBlockNumber(reconcilerOptions.BlockLag)
+	if err != nil {
+		return nil, err
+	}
+	if model.UpdatedBlock > blockNumber.Int64() {
+		return nil, fmt.Errorf("order:%s is updated after block:%s, check it later", orderHash.Hex(), blockNumber.String())
+	}
+	drifts, skipped, err := reconcileOrders([]dao.Order{*model}, blockNumber, correct)
+	if err != nil {
+		return nil, err
+	}
+	if skipped > 0 {
+		return nil, fmt.Errorf("order:%s is updated while reconciling, check it later", orderHash.Hex())
+	}
+	if len(drifts) == 0 {
+		return nil, nil
+	}
+	return drifts[0], nil
+}
+
+func reconcileBlockNumber(blockLag int) (*big.Int, error) {
+	var latest types.Big
+	if err := accessor.BlockNumber(&latest); err != nil {
+		return nil, err
+	}
+	blockNumber := new(big.Int).Sub(latest.BigInt(), big.NewInt(int64(blockLag)))
+	if blockNumber.Sign() <= 0 {
+		return nil, errors.New("chain is shorter than block lag")
+	}
+	return blockNumber, nil
+}
+
+// reconcileOrders queries the chain values of models at blockNumber by a batch,
+// and returns the drifts of orders which haven't been updated after blockNumber.
+// an order updated while it's being corrected is skipped, it's checked again by the next round
+func reconcileOrders(models []dao.Order, blockNumber *big.Int, correct bool) (drifts []*dao.OrderDrift, skipped int, err error) {
+	blockParameter := fmt.Sprintf("0x%x", blockNumber)
+	var reqs chainOrderReqs
+	for _, model := range models {
+		state := &types.OrderState{}
+		if err := model.ConvertUp(state); err != nil {
+			continue
+		}
+		if state.UpdatedBlock != nil && state.UpdatedBlock.Cmp(blockNumber) > 0 {
+			reconciledOrders.Inc("skipped")
+			continue
+		}
+		if !loopringaccessor.SupportedDelegateAddress(state.RawOrder.DelegateAddress) {
+			continue
+		}
+		reqs = append(reqs, &chainOrderReq{state: state, blockParameter: blockParameter})
+	}
+	if len(reqs) == 0 {
+		return nil, 0, nil
+	}
+	if err := accessor.BatchCall("latest", []accessor.BatchReq{reqs}); err != nil {
+		return nil, 0, err
+	}
+
+	for _, req := range reqs {
+		hash := req.state.RawOrder.Hash.Hex()
+		if req.err != nil {
+			reconciledOrders.Inc("failed")
+			log.Errorf("order reconciler, query order:%s error:%s", hash, req.err.Error())
+			continue
+		}
+		expected, err := req.expectedState(blockNumber)
+		if err != nil {
+			reconciledOrders.Inc("failed")
+			log.Errorf("order reconciler, order:%s error:%s", hash, err.Error())
+			continue
+		}
+		drift := newOrderDrift(req.state, expected, req.cutoff(), blockNumber)
+		if drift == nil {
+			reconciledOrders.Inc("matched")
+			if err := rds.DeleteOrderDrift(hash); err != nil {
+				log.Errorf("order reconciler, delete drift of order:%s error:%s", hash, err.Error())
+			}
+			continue
+		}
+
+		log.Warnf("order reconciler, order:%s drifts at block:%s, status:%d chain status:%d, dealt:%s chain dealt:%s, cancelled:%s chain cancelled:%s",
+			hash, blockNumber.String(), drift.LocalStatus, drift.ChainStatus, drift.LocalDealtAmount, drift.ChainDealtAmount, drift.LocalCancelledAmount, drift.ChainCancelledAmount)
+		if correct {
+			updated, err := correctOrder(req.state, expected)
+			if err != nil {
+				log.Errorf("order reconciler, correct order:%s error:%s", hash, err.Error())
+			} else if !updated {
+				skipped++
+				reconciledOrders.Inc("skipped")
+				log.Infof("order reconciler, order:%s is updated while reconciling, skip it", hash)
+				continue
+			} else {
+				drift.Corrected = true
+			}
+		}
+		if drift.Corrected {
+			reconciledOrders.Inc("corrected")
+		} else {
+			reconciledOrders.Inc("drifted")
+		}
+		if err := rds.SaveOrderDrift(drift); err != nil {
+			log.Errorf("order reconciler, save drift of order:%s error:%s", hash, err.Error())
+		}
+		drifts = append(drifts, drift)
+	}
+	return drifts, skipped, nil
+}
+
+// correctOrder updates local to expected only if local is still the order in lpr_orders,
+// it reports false if the order has been updated since local was read
+func correctOrder(local, expected *types.OrderState) (bool, error) {
+	var localUpdatedBlock int64
+	if local.UpdatedBlock != nil {
+		localUpdatedBlock = local.UpdatedBlock.Int64()
+	}
+	updated, err := rds.UpdateOrderWhileReconcile(expected.RawOrder.Hash, local.Status, localUpdatedBlock, expected.Status, expected.DealtAmountS, expected.DealtAmountB, expected.CancelledAmountS, expected.CancelledAmountB, expected.UpdatedBlock)
+	if err != nil || !updated {
+		return false, err
+	}
+	if err := notify.NotifyOrderUpdate(expected); err != nil {
+		log.Errorf("order reconciler, notify corrected order:%s error:%s", expected.RawOrder.Hash.Hex(), err.Error())
+	}
+	return true, nil
+}
+
+// newOrderDrift returns nil if local matches expected
+func newOrderDrift(local, expected *types.OrderState, cutoff, blockNumber *big.Int) *dao.OrderDrift {
+	localDealt, localCancelled := settledAmounts(local)
+	chainDealt, chainCancelled := settledAmounts(expected)
+	if local.Status == expected.Status && localDealt.Cmp(chainDealt) == 0 && localCancelled.Cmp(chainCancelled) == 0 {
+		return nil
+	}
+	return &dao.OrderDrift{
+		OrderHash:            local.RawOrder.Hash.Hex(),
+		Owner:                local.RawOrder.Owner.Hex(),
+		Market:               local.RawOrder.Market,
+		DelegateAddress:      local.RawOrder.DelegateAddress.Hex(),
+		LocalStatus:          uint8(local.Status),
+		ChainStatus:          uint8(expected.Status),
+		LocalDealtAmount:     localDealt.String(),
+		ChainDealtAmount:     chainDealt.String(),
+		LocalCancelledAmount: localCancelled.String(),
+		ChainCancelledAmount: chainCancelled.String(),
+		Cutoff:               cutoff.Int64(),
+		BlockNumber:          blockNumber.Int64(),
+	}
+}
+
+// settledAmounts returns the dealt and cancelled amounts of the side the delegate settles
+func settledAmounts(state *types.OrderState) (dealt, cancelled *big.Int) {
+	if state.RawOrder.BuyNoMoreThanAmountB {
+		return state.DealtAmountB, state.CancelledAmountB
+	}
+	return state.DealtAmountS, state.CancelledAmountS
+}
+
+type chainOrderReq struct {
+	state             *types.OrderState
+	blockParameter    string
+	cancelled         types.Big
+	cancelledOrFilled types.Big
+	cutoffAll         types.Big
+	cutoffPair        types.Big
+	err               error
+}
+
+// the calls of a req, in the order of ToBatchElem
+const chainOrderCalls = 4
+
+type chainOrderReqs []*chainOrderReq
+
+func (reqs chainOrderReqs) ToBatchElem() []rpc.BatchElem {
+	delegateAbi := loopringaccessor.DelegateAbi()
+	elems := make([]rpc.BatchElem, 0, chainOrderCalls*len(reqs))
+	for _, req := range reqs {
+		order := req.state.RawOrder
+		calls := []struct {
+			result *types.Big
+			method string
+			args   []interface{}
+		}{
+			{&req.cancelled, "cancelled", []interface{}{order.Hash}},
+			{&req.cancelledOrFilled, "cancelledOrFilled", []interface{}{order.Hash}},
+			{&req.cutoffAll, "cutoffs", []interface{}{order.Owner}},
+			{&req.cutoffPair, "getTradingPairCutoffs", []interface{}{order.Owner, order.TokenS, order.TokenB}},
+		}
+		for _, call := range calls {
+			data, _ := delegateAbi.Pack(call.method, call.args...)
+			arg := &ethtyp.CallArg{}
+			arg.To = order.DelegateAddress
+			arg.Data = common.ToHex(data)
+			elems = append(elems, rpc.BatchElem{
+				Method: "eth_call",
+				Args:   []interface{}{arg, req.blockParameter},
+				Result: call.result,
+			})
+		}
+	}
+	return elems
+}
+
+func (reqs chainOrderReqs) FromBatchElem(elems []rpc.BatchElem) {
+	for idx, elem := range elems {
+		if elem.Error != nil {
+			reqs[idx/chainOrderCalls].err = elem.Error
+		}
+	}
+}
+
+// cutoff returns the later one of the cutoffs of owner and of the pair
+func (req *chainOrderReq) cutoff() *big.Int {
+	if req.cutoffAll.BigInt().Cmp(req.cutoffPair.BigInt()) > 0 {
+		return req.cutoffAll.BigInt()
+	}
+	return req.cutoffPair.BigInt()
+}
+
+// expectedState returns a copy of the local state whose amounts and status are settled by the chain values,
+// the same way as SettleOrderAmountOnChain and SettleOrderStatus
+func (req *chainOrderReq) expectedState(blockNumber *big.Int) (*types.OrderState, error) {
+	local := req.state
+	cancelled := req.cancelled.BigInt()
+	cancelledOrFilled := req.cancelledOrFilled.BigInt()
+	if cancelledOrFilled.Cmp(cancelled) < 0 {
+		return nil, fmt.Errorf("cancelledOrFilled:%s < cancelled:%s", cancelledOrFilled.String(), cancelled.String())
+	}
+	dealt := new(big.Int).Sub(cancelledOrFilled, cancelled)
+
+	expected := *local
+	expected.DealtAmountS = new(big.Int).Set(local.DealtAmountS)
+	expected.DealtAmountB = new(big.Int).Set(local.DealtAmountB)
+	expected.CancelledAmountS = new(big.Int).Set(local.CancelledAmountS)
+	expected.CancelledAmountB = new(big.Int).Set(local.CancelledAmountB)
+	expected.UpdatedBlock = new(big.Int).Set(blockNumber)
+	if local.RawOrder.BuyNoMoreThanAmountB {
+		expected.DealtAmountB = dealt
+		expected.CancelledAmountB = cancelled
+	} else {
+		expected.DealtAmountS = dealt
+		expected.CancelledAmountS = cancelled
+	}
+
+	if req.cutoff().Cmp(local.RawOrder.ValidSince) > 0 {
+		expected.Status = types.ORDER_CUTOFF
+	} else {
+		SettleOrderStatus(&expected, cancelled.Sign() > 0)
+	}
+	return &expected, nil
+}
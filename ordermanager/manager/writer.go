@@ -26,6 +26,7 @@ import (
 	util "github.com/Loopring/relay-lib/marketutil"
 	"github.com/Loopring/relay-lib/types"
 	"github.com/ethereum/go-ethereum/common"
+	"time"
 )
 
 func MinerOrders(delegate, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState {
@@ -69,6 +70,65 @@ func MinerOrders(delegate, tokenS, tokenB common.Address, length int, reservedTi
 	return list
 }
 
+// FlexCancelOrdersByHash flex cancels orders of owner by hashes at once and returns the orders cancelled,
+// others are not found, not of owner or not valid any more
+func FlexCancelOrdersByHash(owner common.Address, hashes []common.Hash) ([]*types.OrderState, error) {
+	if types.IsZeroAddress(owner) {
+		return nil, fmt.Errorf("params owner invalid")
+	}
+
+	models, err := rds.GetOrdersByHashes(hashes)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	var (
+		cancellable       []string
+		cancellableHashes []common.Hash
+	)
+	for _, model := range models {
+		if model.Owner != owner.Hex() || model.ValidUntil < now || !isFlexCancellable(types.OrderStatus(model.Status)) {
+			continue
+		}
+		cancellable = append(cancellable, model.OrderHash)
+		cancellableHashes = append(cancellableHashes, common.HexToHash(model.OrderHash))
+	}
+	if len(cancellable) == 0 {
+		return nil, nil
+	}
+
+	if nums := rds.FlexCancelOrdersByHashes(owner, cancellable, cm.ValidFlexCancelStatus, types.ORDER_FLEX_CANCEL); nums == 0 {
+		return nil, nil
+	}
+
+	// orders settled between the query and the update are not cancelled
+	if models, err = rds.GetOrdersByHashes(cancellableHashes); err != nil {
+		return nil, err
+	}
+	var list []*types.OrderState
+	for _, model := range models {
+		if types.OrderStatus(model.Status) != types.ORDER_FLEX_CANCEL {
+			continue
+		}
+		state := &types.OrderState{}
+		if err := model.ConvertUp(state); err != nil {
+			log.Errorf("order manager,flex cancel orders,convert order:%s error:%s", model.OrderHash, err.Error())
+			continue
+		}
+		list = append(list, state)
+	}
+	return list, nil
+}
+
+func isFlexCancellable(status types.OrderStatus) bool {
+	for _, v := range cm.ValidFlexCancelStatus {
+		if status == v {
+			return true
+		}
+	}
+	return false
+}
+
 func UpdateBroadcastTimeByHash(hash common.Hash, bt int) error {
 	return rds.UpdateBroadcastTimeByHash(hash.Hex(), bt)
 }
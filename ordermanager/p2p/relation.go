@@ -0,0 +1,288 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package p2p keeps the relations of p2p rings, a taker order matched with a maker order by a ring submitted.
+// a relation is saved before its ring is broadcast, in mysql first and then in redis, mysql is the one they're
+// rebuilt from. the pending amount of a maker is the union of both by tx hash
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-lib/cache"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const (
+	relationPreKey   = "P2P_RELATION_"
+	takerPreKey      = "P2P_TAKERS_"
+	splitMark        = "_"
+	rebuildBatchSize = 200
+	maxPageSize      = 50
+)
+
+// Store is the mysql of relations, it's implemented by dao.RdsService
+type Store interface {
+	AddP2pRelation(r *dao.P2pRelation) error
+	GetP2pRelationByTxHash(txHash string) (*dao.P2pRelation, error)
+	GetPendingP2pRelations(afterId, limit int) ([]dao.P2pRelation, error)
+	GetPendingP2pRelationsOfMaker(makerOrderHash string, now int64) ([]dao.P2pRelation, error)
+	SettleP2pRelation(txHash, status string, blockNumber int64) (bool, error)
+	P2pRelationPageQuery(query map[string]interface{}, statuses []string, pageIndex, pageSize int) (dao.PageResult, error)
+	FindFillEvent(txhash string, FillIndex int64) (*dao.FillEvent, error)
+}
+
+// Cache is the redis of relations
+type Cache interface {
+	Set(key string, value []byte, ttl int64) error
+	Get(key string) ([]byte, error)
+	Del(key string) error
+	Exists(key string) (bool, error)
+	ZAdd(key string, ttl int64, args ...[]byte) error
+	ZRange(key string, start, stop int64, withScores bool) ([][]byte, error)
+	ZRem(key string, members ...[]byte) (int64, error)
+}
+
+// RedisCache is the Cache of relay-lib cache
+type RedisCache struct{}
+
+func (RedisCache) Set(key string, value []byte, ttl int64) error { return cache.Set(key, value, ttl) }
+func (RedisCache) Get(key string) ([]byte, error)                { return cache.Get(key) }
+func (RedisCache) Del(key string) error                          { return cache.Del(key) }
+func (RedisCache) Exists(key string) (bool, error)               { return cache.Exists(key) }
+func (RedisCache) ZAdd(key string, ttl int64, args ...[]byte) error {
+	return cache.ZAdd(key, ttl, args...)
+}
+func (RedisCache) ZRange(key string, start, stop int64, withScores bool) ([][]byte, error) {
+	return cache.ZRange(key, start, stop, withScores)
+}
+func (RedisCache) ZRem(key string, members ...[]byte) (int64, error) {
+	return cache.ZRem(key, members...)
+}
+
+// OrderRelation is the relation cached by its tx hash
+type OrderRelation struct {
+	Txhash         string
+	Makerorderhash string
+	Takerorderhash string
+	PendingAmount  string
+}
+
+// Query filters relations, hashes and owners are matched in lower case
+type Query struct {
+	MakerOrderHash string
+	MakerOwner     string
+	TakerOwner     string
+	PageIndex      int
+	PageSize       int
+}
+
+type Relations struct {
+	store Store
+	cache Cache
+	now   func() int64
+}
+
+func NewRelations(store Store, c Cache) *Relations {
+	return &Relations{store: store, cache: c, now: func() int64 { return time.Now().Unix() }}
+}
+
+// TxHash returns the hash of the signed transaction rawTx, so the relation of a ring is saved before it's broadcast
+func TxHash(rawTx string) (string, error) {
+	tx := new(ethtypes.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(rawTx), tx); err != nil {
+		return "", fmt.Errorf("invalid raw transaction, %s", err.Error())
+	}
+	return tx.Hash().Hex(), nil
+}
+
+// Save saves the relation of the ring in txHash to mysql, then caches it. it's called before the ring is broadcast,
+// nothing is cached if mysql fails and the ring mustn't be broadcast then. a relation failed to be cached is counted from mysql
+func (r *Relations) Save(takerOwner, taker, makerOwner, maker, txHash, pendingAmount, validUntil string) error {
+	untilTime, _ := strconv.ParseInt(validUntil, 10, 64)
+	relation := &dao.P2pRelation{
+		TxHash:         strings.ToLower(txHash),
+		MakerOrderHash: strings.ToLower(maker),
+		MakerOwner:     strings.ToLower(makerOwner),
+		TakerOrderHash: strings.ToLower(taker),
+		TakerOwner:     strings.ToLower(takerOwner),
+		PendingAmount:  pendingAmount,
+		ValidUntil:     untilTime,
+	}
+	if err := r.store.AddP2pRelation(relation); err != nil {
+		log.Errorf("save p2p relation of tx:%s error:%s", relation.TxHash, err.Error())
+		return err
+	}
+	r.cacheRelation(relation, r.now())
+	return nil
+}
+
+// cacheRelation caches relation until its maker expires, the takers of a maker are scored by the time relation is created
+func (r *Relations) cacheRelation(relation *dao.P2pRelation, createTime int64) {
+	ttl := relation.ValidUntil - r.now()
+	if err := r.cache.ZAdd(takerPreKey+relation.MakerOrderHash, ttl, []byte(strconv.FormatInt(createTime, 10)), []byte(relation.TxHash+splitMark+relation.PendingAmount)); err != nil {
+		log.Errorf("cache p2p taker of tx:%s error:%s", relation.TxHash, err.Error())
+	}
+	cached, _ := json.Marshal(OrderRelation{Txhash: relation.TxHash, Makerorderhash: relation.MakerOrderHash, Takerorderhash: relation.TakerOrderHash, PendingAmount: relation.PendingAmount})
+	if err := r.cache.Set(relationPreKey+relation.TxHash, cached, ttl); err != nil {
+		log.Errorf("cache p2p relation of tx:%s error:%s", relation.TxHash, err.Error())
+	}
+	if err := r.cache.Set(relationPreKey+relation.TakerOrderHash, []byte(relation.TxHash), ttl); err != nil {
+		log.Errorf("cache p2p taker lock of tx:%s error:%s", relation.TxHash, err.Error())
+	}
+}
+
+// Release moves the relation of txHash to status and releases the pending amount of its maker and its taker.
+// relations cached but not in mysql, such as the ones saved before relations were persisted, are read from the cache
+func (r *Relations) Release(txHash, status string, blockNumber *big.Int) error {
+	txHash = strings.ToLower(txHash)
+	cached := OrderRelation{}
+	if relation, err := r.store.GetP2pRelationByTxHash(txHash); err == nil {
+		var number int64
+		if blockNumber != nil {
+			number = blockNumber.Int64()
+		}
+		if _, err := r.store.SettleP2pRelation(txHash, status, number); err != nil {
+			log.Errorf("p2p relation of tx:%s to %s error:%s", txHash, status, err.Error())
+			return err
+		}
+		cached = OrderRelation{Txhash: txHash, Makerorderhash: relation.MakerOrderHash, Takerorderhash: relation.TakerOrderHash, PendingAmount: relation.PendingAmount}
+	} else if exists, _ := r.cache.Exists(relationPreKey + txHash); exists {
+		data, _ := r.cache.Get(relationPreKey + txHash)
+		if err := json.Unmarshal(data, &cached); nil != err {
+			log.Errorf("p2p relation of tx:%s syncFromCache err:%s", txHash, err.Error())
+			return err
+		}
+	} else {
+		return nil
+	}
+
+	r.cache.ZRem(takerPreKey+cached.Makerorderhash, []byte(txHash+splitMark+cached.PendingAmount))
+	r.cache.Del(relationPreKey + cached.Takerorderhash)
+	r.cache.Del(relationPreKey + txHash)
+	return nil
+}
+
+// PendingAmount returns the sum of the pending amounts of maker and how many takers they're of.
+// relations are merged by tx hash from the cache and mysql, the cache holds relations saved before they're persisted,
+// and mysql holds the ones lost by the cache
+func (r *Relations) PendingAmount(maker string) (*big.Rat, int, error) {
+	maker = strings.ToLower(maker)
+	amounts := make(map[string]string)
+	data, err := r.cache.ZRange(takerPreKey+maker, 0, -1, false)
+	if nil != err {
+		return new(big.Rat), 0, err
+	}
+	for _, v := range data {
+		parts := strings.SplitN(string(v), splitMark, 2)
+		if len(parts) == 2 {
+			amounts[parts[0]] = parts[1]
+		}
+	}
+
+	relations, err := r.store.GetPendingP2pRelationsOfMaker(maker, r.now())
+	if err != nil {
+		return new(big.Rat), 0, err
+	}
+	for _, relation := range relations {
+		amounts[relation.TxHash] = relation.PendingAmount
+	}
+
+	pendingAmount := new(big.Rat)
+	for _, amount := range amounts {
+		if v, ok := new(big.Int).SetString(amount, 0); ok {
+			pendingAmount.Add(pendingAmount, new(big.Rat).SetInt(v))
+		}
+	}
+	return pendingAmount, len(amounts), nil
+}
+
+// Rebuild restores pending relations into the cache, the ones filled or past validUntil are closed instead
+func (r *Relations) Rebuild() error {
+	now := r.now()
+	afterId, restored := 0, 0
+	for {
+		relations, err := r.store.GetPendingP2pRelations(afterId, rebuildBatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range relations {
+			relation := &relations[i]
+			afterId = relation.ID
+			if relation.ValidUntil <= now {
+				r.store.SettleP2pRelation(relation.TxHash, dao.P2pRelationExpired, 0)
+				continue
+			}
+			if fill, err := r.store.FindFillEvent(relation.TxHash, 0); err == nil {
+				r.Release(relation.TxHash, dao.P2pRelationSettled, big.NewInt(fill.BlockNumber))
+				continue
+			}
+			r.cacheRelation(relation, relation.CreateTime)
+			restored++
+		}
+		if len(relations) < rebuildBatchSize {
+			log.Infof("p2p relations, %d pending relations restored into the cache", restored)
+			return nil
+		}
+	}
+}
+
+// IsTakerLocked reports whether taker is in a pending ring, it's locked as well if the cache can't be read
+func (r *Relations) IsTakerLocked(taker string) bool {
+	exists, err := r.cache.Exists(relationPreKey + strings.ToLower(taker))
+	return nil != err || exists
+}
+
+// Takers returns the pending relations of a maker order, latest first
+func (r *Relations) Takers(query Query) (dao.PageResult, error) {
+	return r.pageQuery(query, []string{dao.P2pRelationPending})
+}
+
+// History returns the relations which are settled, failed or expired, latest first
+func (r *Relations) History(query Query) (dao.PageResult, error) {
+	return r.pageQuery(query, []string{dao.P2pRelationSettled, dao.P2pRelationFailed, dao.P2pRelationExpired})
+}
+
+func (r *Relations) pageQuery(query Query, statuses []string) (dao.PageResult, error) {
+	filters := make(map[string]interface{})
+	if len(query.MakerOrderHash) > 0 {
+		filters["maker_order_hash"] = strings.ToLower(query.MakerOrderHash)
+	}
+	if len(query.MakerOwner) > 0 {
+		filters["maker_owner"] = strings.ToLower(query.MakerOwner)
+	}
+	if len(query.TakerOwner) > 0 {
+		filters["taker_owner"] = strings.ToLower(query.TakerOwner)
+	}
+	if query.PageIndex <= 0 {
+		query.PageIndex = 1
+	}
+	if query.PageSize <= 0 || query.PageSize > maxPageSize {
+		query.PageSize = maxPageSize
+	}
+	return r.store.P2pRelationPageQuery(filters, statuses, query.PageIndex, query.PageSize)
+}
@@ -0,0 +1,303 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package p2p
+
+import (
+	"errors"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/Loopring/relay-cluster/dao"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"go.uber.org/zap"
+)
+
+const (
+	maker      = "0xmaker"
+	makerOwner = "0xmakerowner"
+	now        = 1530000000
+)
+
+func init() {
+	cfg := zap.NewDevelopmentConfig()
+	cfg.OutputPaths = []string{"stderr"}
+	log.Initialize(cfg)
+}
+
+type memoryStore struct {
+	relations []dao.P2pRelation
+	fills     map[string]int64
+	addErr    error
+	filters   map[string]interface{}
+	statuses  []string
+	page      [2]int
+}
+
+func (s *memoryStore) AddP2pRelation(r *dao.P2pRelation) error {
+	if s.addErr != nil {
+		return s.addErr
+	}
+	r.ID = len(s.relations) + 1
+	r.CreateTime = now
+	r.Status = dao.P2pRelationPending
+	s.relations = append(s.relations, *r)
+	return nil
+}
+
+func (s *memoryStore) GetP2pRelationByTxHash(txHash string) (*dao.P2pRelation, error) {
+	for i := range s.relations {
+		if s.relations[i].TxHash == txHash {
+			return &s.relations[i], nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (s *memoryStore) GetPendingP2pRelations(afterId, limit int) ([]dao.P2pRelation, error) {
+	var list []dao.P2pRelation
+	for _, r := range s.relations {
+		if r.ID > afterId && r.Status == dao.P2pRelationPending && len(list) < limit {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) GetPendingP2pRelationsOfMaker(makerOrderHash string, now int64) ([]dao.P2pRelation, error) {
+	var list []dao.P2pRelation
+	for _, r := range s.relations {
+		if r.MakerOrderHash == makerOrderHash && r.Status == dao.P2pRelationPending && r.ValidUntil > now {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+func (s *memoryStore) SettleP2pRelation(txHash, status string, blockNumber int64) (bool, error) {
+	for i := range s.relations {
+		if s.relations[i].TxHash == txHash && s.relations[i].Status == dao.P2pRelationPending {
+			s.relations[i].Status = status
+			s.relations[i].BlockNumber = blockNumber
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *memoryStore) P2pRelationPageQuery(query map[string]interface{}, statuses []string, pageIndex, pageSize int) (dao.PageResult, error) {
+	s.filters, s.statuses, s.page = query, statuses, [2]int{pageIndex, pageSize}
+	return dao.PageResult{PageIndex: pageIndex, PageSize: pageSize}, nil
+}
+
+func (s *memoryStore) FindFillEvent(txhash string, FillIndex int64) (*dao.FillEvent, error) {
+	if block, ok := s.fills[txhash]; ok {
+		return &dao.FillEvent{TxHash: txhash, BlockNumber: block}, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+type memoryCache struct {
+	values map[string][]byte
+	sets   map[string]map[string]int64
+}
+
+func newMemoryCache() *memoryCache {
+	return &memoryCache{values: make(map[string][]byte), sets: make(map[string]map[string]int64)}
+}
+
+func (c *memoryCache) Set(key string, value []byte, ttl int64) error {
+	c.values[key] = value
+	return nil
+}
+
+func (c *memoryCache) Get(key string) ([]byte, error) { return c.values[key], nil }
+
+func (c *memoryCache) Del(key string) error {
+	delete(c.values, key)
+	return nil
+}
+
+func (c *memoryCache) Exists(key string) (bool, error) {
+	_, ok := c.values[key]
+	return ok, nil
+}
+
+func (c *memoryCache) ZAdd(key string, ttl int64, args ...[]byte) error {
+	if c.sets[key] == nil {
+		c.sets[key] = make(map[string]int64)
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		score, _ := new(big.Int).SetString(string(args[i]), 10)
+		c.sets[key][string(args[i+1])] = score.Int64()
+	}
+	return nil
+}
+
+func (c *memoryCache) ZRange(key string, start, stop int64, withScores bool) ([][]byte, error) {
+	var members []string
+	for member := range c.sets[key] {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	list := make([][]byte, 0, len(members))
+	for _, member := range members {
+		list = append(list, []byte(member))
+	}
+	return list, nil
+}
+
+func (c *memoryCache) ZRem(key string, members ...[]byte) (int64, error) {
+	var removed int64
+	for _, member := range members {
+		if _, ok := c.sets[key][string(member)]; ok {
+			delete(c.sets[key], string(member))
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func newTestRelations(store *memoryStore, c *memoryCache) *Relations {
+	r := NewRelations(store, c)
+	r.now = func() int64 { return now }
+	return r
+}
+
+func save(t *testing.T, r *Relations, taker, txHash, amount string) {
+	if err := r.Save("0xtakerowner", taker, makerOwner, maker, txHash, amount, "1530003600"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTxHash(t *testing.T) {
+	tx := ethtypes.NewTransaction(7, common.HexToAddress("0x1"), big.NewInt(1), 21000, big.NewInt(1), nil)
+	raw, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := TxHash(common.ToHex(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != tx.Hash().Hex() {
+		t.Fatalf("tx hash %s, want %s", hash, tx.Hash().Hex())
+	}
+	if _, err := TxHash("0x1234"); err == nil {
+		t.Fatal("invalid raw tx is hashed")
+	}
+}
+
+func TestSaveWritesMysqlFirst(t *testing.T) {
+	store, c := &memoryStore{addErr: errors.New("mysql is down")}, newMemoryCache()
+	r := newTestRelations(store, c)
+	if err := r.Save("0xtakerowner", "0xTaker", makerOwner, maker, "0xTx1", "100", "1530003600"); err == nil {
+		t.Fatalf("expected the error of mysql")
+	}
+	if len(c.values) != 0 || len(c.sets) != 0 {
+		t.Fatalf("expected nothing cached when mysql fails")
+	}
+	if r.IsTakerLocked("0xtaker") {
+		t.Fatalf("expected the taker not locked")
+	}
+
+	store.addErr = nil
+	save(t, r, "0xTaker", "0xTx1", "100")
+	if !r.IsTakerLocked("0xtaker") || store.relations[0].TxHash != "0xtx1" {
+		t.Fatalf("expected the relation saved in lower case and the taker locked")
+	}
+}
+
+func TestPendingAmountMergesCacheAndMysql(t *testing.T) {
+	store, c := &memoryStore{}, newMemoryCache()
+	r := newTestRelations(store, c)
+	save(t, r, "0xtaker1", "0xtx1", "100")
+	save(t, r, "0xtaker2", "0xtx2", "200")
+
+	// tx1 is lost by the cache, tx3 is only cached like the relations saved before they're persisted
+	c.ZRem(takerPreKey+maker, []byte("0xtx1_100"))
+	c.ZAdd(takerPreKey+maker, 3600, []byte("1"), []byte("0xtx3_50"))
+
+	amount, takers, err := r.PendingAmount("0xMAKER")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount.Cmp(big.NewRat(350, 1)) != 0 || takers != 3 {
+		t.Fatalf("expected 350 of 3 takers, got %s of %d", amount.FloatString(0), takers)
+	}
+
+	if err := r.Release("0xtx2", dao.P2pRelationSettled, big.NewInt(10)); err != nil {
+		t.Fatal(err)
+	}
+	if amount, takers, _ := r.PendingAmount(maker); amount.Cmp(big.NewRat(150, 1)) != 0 || takers != 2 {
+		t.Fatalf("expected 150 of 2 takers after settled, got %s of %d", amount.FloatString(0), takers)
+	}
+	if r.IsTakerLocked("0xtaker2") {
+		t.Fatalf("expected the taker of the settled ring unlocked")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	store := &memoryStore{fills: map[string]int64{"0xfilled": 20}}
+	store.relations = []dao.P2pRelation{
+		{ID: 1, TxHash: "0xpending", MakerOrderHash: maker, TakerOrderHash: "0xtaker1", PendingAmount: "100", ValidUntil: now + 3600, Status: dao.P2pRelationPending, CreateTime: now - 60},
+		{ID: 2, TxHash: "0xexpired", MakerOrderHash: maker, TakerOrderHash: "0xtaker2", PendingAmount: "200", ValidUntil: now - 1, Status: dao.P2pRelationPending},
+		{ID: 3, TxHash: "0xfilled", MakerOrderHash: maker, TakerOrderHash: "0xtaker3", PendingAmount: "300", ValidUntil: now + 3600, Status: dao.P2pRelationPending},
+		{ID: 4, TxHash: "0xfailed", MakerOrderHash: maker, TakerOrderHash: "0xtaker4", PendingAmount: "400", ValidUntil: now + 3600, Status: dao.P2pRelationFailed},
+	}
+	c := newMemoryCache()
+	r := newTestRelations(store, c)
+	if err := r.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"0xpending": dao.P2pRelationPending, "0xexpired": dao.P2pRelationExpired, "0xfilled": dao.P2pRelationSettled, "0xfailed": dao.P2pRelationFailed}
+	for _, relation := range store.relations {
+		if relation.Status != expected[relation.TxHash] {
+			t.Fatalf("relation of %s: expected %s, got %s", relation.TxHash, expected[relation.TxHash], relation.Status)
+		}
+	}
+	if members, _ := c.ZRange(takerPreKey+maker, 0, -1, false); len(members) != 1 || string(members[0]) != "0xpending_100" {
+		t.Fatalf("expected only the pending relation cached, got %s", members)
+	}
+	if !r.IsTakerLocked("0xtaker1") || r.IsTakerLocked("0xtaker2") || r.IsTakerLocked("0xtaker3") {
+		t.Fatalf("expected only the taker of the pending relation locked")
+	}
+	if amount, takers, _ := r.PendingAmount(maker); amount.Cmp(big.NewRat(100, 1)) != 0 || takers != 1 {
+		t.Fatalf("expected 100 of 1 taker, got %s of %d", amount.FloatString(0), takers)
+	}
+}
+
+func TestTakersAndHistoryQuery(t *testing.T) {
+	store := &memoryStore{}
+	r := newTestRelations(store, newMemoryCache())
+
+	r.Takers(Query{MakerOrderHash: "0xABC"})
+	if store.filters["maker_order_hash"] != "0xabc" || len(store.statuses) != 1 || store.statuses[0] != dao.P2pRelationPending || store.page != [2]int{1, maxPageSize} {
+		t.Fatalf("unexpected takers query %v %v %v", store.filters, store.statuses, store.page)
+	}
+
+	r.History(Query{MakerOwner: "0xDEF", TakerOwner: "0x123", PageIndex: 2, PageSize: 100})
+	if store.filters["maker_owner"] != "0xdef" || store.filters["taker_owner"] != "0x123" || len(store.statuses) != 3 || store.page != [2]int{2, maxPageSize} {
+		t.Fatalf("unexpected history query %v %v %v", store.filters, store.statuses, store.page)
+	}
+}
@@ -52,6 +52,7 @@ type RingTrackerViewer interface {
 	GetAllRelays(currency types.Currency, sort types.Indicator, pageIndex, pageSize int) dao.PageResult
 	GetAllDexs(currency types.Currency, sort types.Indicator, pageIndex, pageSize int) dao.PageResult
 	GetTokensByRelay(currency types.Currency, relayer string) ([]types.TokenFill, error)
+	Stop()
 }
 
 type RingTrackerViewerImpl struct {
@@ -85,6 +86,12 @@ func NewRingTrackerViewer(rds *dao.RdsService, mc marketcap.MarketCapProvider) *
 	return &viewer
 }
 
+// Stop stops the cron jobs and releases the zklock if it is held by this node
+func (r *RingTrackerViewerImpl) Stop() {
+	r.cron.Stop()
+	zklock.ReleaseLock(RING_TRACKER_CronJob_ZkLock)
+}
+
 func (r *RingTrackerViewerImpl) GetAmount() types.AmountResp {
 	return r.rds.GetAmount()
 }
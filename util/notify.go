@@ -24,8 +24,18 @@ import (
 	"github.com/Loopring/relay-lib/kafka"
 	"github.com/Loopring/relay-lib/log"
 	libTypes "github.com/Loopring/relay-lib/types"
+	"github.com/ethereum/go-ethereum/common"
 )
 
+// Kafka_Topic_SocketIO_Orders_Updated carries orders of an owner updated at once, such as a batch submitted or flex cancelled,
+// consumers of Kafka_Topic_SocketIO_Order_Updated should consume it as well
+const Kafka_Topic_SocketIO_Orders_Updated = "Kafka_Topic_SocketIO_Orders_Updated"
+
+type OrdersUpdatedEvent struct {
+	Owner  string                `json:"owner"`
+	Orders []libTypes.OrderState `json:"orders"`
+}
+
 // todo delete return after test
 
 func NotifyOrderUpdate(o *libTypes.OrderState) error {
@@ -38,6 +48,18 @@ func NotifyOrderUpdate(o *libTypes.OrderState) error {
 	return err
 }
 
+func NotifyOrdersUpdate(owner common.Address, states []*libTypes.OrderState) error {
+	event := &OrdersUpdatedEvent{Owner: owner.Hex()}
+	for _, state := range states {
+		event.Orders = append(event.Orders, *state)
+	}
+	err := ProducerSocketIOMessage(Kafka_Topic_SocketIO_Orders_Updated, event)
+	if err != nil {
+		log.Errorf("notify %d orders of %s failed", len(states), event.Owner)
+	}
+	return err
+}
+
 func NotifyOrderFilled(f *dao.FillEvent) error {
 	err := ProducerSocketIOMessage(kafka.Kafka_Topic_SocketIO_Trades_Updated, f)
 	if err != nil {
@@ -0,0 +1,90 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"github.com/Loopring/relay-lib/kafka"
+	"github.com/Loopring/relay-lib/log"
+	"github.com/Shopify/sarama"
+	"reflect"
+	"sync"
+)
+
+// PartitionConsumer consumes every partition of its topics from the newest offset without a consumer group,
+// so every node receives all the messages and leaves no group or offset in kafka.
+// it's used for the messages every node applies to its memory, e.g. order book updates
+type PartitionConsumer struct {
+	consumer   sarama.Consumer
+	mtx        sync.Mutex
+	partitions []sarama.PartitionConsumer
+}
+
+func NewPartitionConsumer(brokers []string) (*PartitionConsumer, error) {
+	consumer, err := sarama.NewConsumer(brokers, sarama.NewConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionConsumer{consumer: consumer}, nil
+}
+
+// Register decodes the messages of topic into new values of the type of data and passes them to handler
+func (c *PartitionConsumer) Register(topic string, data interface{}, handler kafka.HandlerFunc) error {
+	partitions, err := c.consumer.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	for _, partition := range partitions {
+		pc, err := c.consumer.ConsumePartition(topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return err
+		}
+		c.mtx.Lock()
+		c.partitions = append(c.partitions, pc)
+		c.mtx.Unlock()
+
+		go func(pc sarama.PartitionConsumer) {
+			for msg := range pc.Messages() {
+				event := reflect.New(reflect.TypeOf(data)).Interface()
+				if err := json.Unmarshal(msg.Value, event); err != nil {
+					log.Errorf("kafka,unmarshal message of %s error:%s", topic, err.Error())
+					continue
+				}
+				if err := handler(event); err != nil {
+					log.Errorf("kafka,handle message of %s error:%s", topic, err.Error())
+				}
+			}
+		}(pc)
+	}
+	return nil
+}
+
+func (c *PartitionConsumer) Close() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, pc := range c.partitions {
+		if err := pc.Close(); err != nil {
+			log.Errorf("kafka,close partition consumer error:%s", err.Error())
+		}
+	}
+	c.partitions = nil
+	if err := c.consumer.Close(); err != nil {
+		log.Errorf("kafka,close consumer error:%s", err.Error())
+	}
+}
@@ -43,3 +43,11 @@ func ProducerNormalMessage(topic string, data interface{}) error {
 	_, _, err := socketIOProducer.SendMessage(topic, data, "1")
 	return err
 }
+
+// Close sends the pending messages and closes the producer, it's called when the node stops
+func Close() error {
+	if socketIOProducer == nil {
+		return nil
+	}
+	return socketIOProducer.Close()
+}
@@ -0,0 +1,125 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package zkjob
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay-lib/log"
+	"github.com/Loopring/relay-lib/zklock"
+)
+
+// replaced in tests
+var (
+	tryLock     = zklock.TryLock
+	releaseLock = zklock.ReleaseLock
+)
+
+// Job runs task every interval on the node which holds the zklock of lockName,
+// the other nodes wait in TryLock and take over after the holder releases the lock
+type Job struct {
+	lockName string
+	interval time.Duration
+	task     func()
+
+	stopChan   chan struct{}
+	doneChan   chan struct{}
+	mtx        sync.Mutex
+	stopped    bool
+	lockHolded bool
+}
+
+func New(lockName string, interval time.Duration, task func()) *Job {
+	return &Job{
+		lockName: lockName,
+		interval: interval,
+		task:     task,
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+func (j *Job) Start() {
+	go j.run()
+}
+
+func (j *Job) run() {
+	defer close(j.doneChan)
+
+	if err := tryLock(j.lockName); err != nil {
+		log.Errorf("zkjob, try lock %s error:%s", j.lockName, err.Error())
+		return
+	}
+	j.mtx.Lock()
+	if j.stopped {
+		// Stop can't interrupt TryLock, the lock acquired after it is released here
+		j.mtx.Unlock()
+		j.release()
+		return
+	}
+	j.lockHolded = true
+	j.mtx.Unlock()
+
+	defer j.release()
+	for {
+		select {
+		case <-j.stopChan:
+			return
+		case <-time.After(j.interval):
+			j.task()
+		}
+	}
+}
+
+func (j *Job) release() {
+	if err := releaseLock(j.lockName); err != nil {
+		log.Errorf("zkjob, release lock %s error:%s", j.lockName, err.Error())
+	}
+}
+
+// Stop stops the job, the lock is released before it returns if the job holds it.
+// a job still waiting in TryLock releases the lock once it's acquired
+func (j *Job) Stop() {
+	j.mtx.Lock()
+	if j.stopped {
+		j.mtx.Unlock()
+		return
+	}
+	j.stopped = true
+	lockHolded := j.lockHolded
+	close(j.stopChan)
+	j.mtx.Unlock()
+
+	if lockHolded {
+		<-j.doneChan
+	}
+}
+
+// Stopping is closed when Stop is called, long tasks should return early on it
+func (j *Job) Stopping() <-chan struct{} {
+	return j.stopChan
+}
+
+// LockHolded returns whether this node runs the job now
+func (j *Job) LockHolded() bool {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return j.lockHolded && !j.stopped
+}
@@ -0,0 +1,91 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package zkjob
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func stubLock(acquire chan struct{}) *int32 {
+	var released int32
+	tryLock = func(lockName string) error {
+		<-acquire
+		return nil
+	}
+	releaseLock = func(lockName string) error {
+		atomic.AddInt32(&released, 1)
+		return nil
+	}
+	return &released
+}
+
+func TestJobRunsTaskAfterLock(t *testing.T) {
+	acquire := make(chan struct{})
+	released := stubLock(acquire)
+
+	var runs int32
+	job := New("test", 10*time.Millisecond, func() { atomic.AddInt32(&runs, 1) })
+	job.Start()
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != 0 {
+		t.Fatalf("task ran before the lock was acquired")
+	}
+
+	close(acquire)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&runs) == 0 {
+		t.Fatalf("task didn't run after the lock was acquired")
+	}
+	if !job.LockHolded() {
+		t.Fatalf("lock should be holded")
+	}
+
+	job.Stop()
+	if atomic.LoadInt32(released) != 1 {
+		t.Fatalf("lock should be released once by Stop, released:%d", atomic.LoadInt32(released))
+	}
+	job.Stop()
+	if atomic.LoadInt32(released) != 1 {
+		t.Fatalf("second Stop shouldn't release again")
+	}
+}
+
+func TestJobReleasesLockAcquiredAfterStop(t *testing.T) {
+	acquire := make(chan struct{})
+	released := stubLock(acquire)
+
+	var runs int32
+	job := New("test", time.Millisecond, func() { atomic.AddInt32(&runs, 1) })
+	job.Start()
+	job.Stop()
+	if atomic.LoadInt32(released) != 0 {
+		t.Fatalf("lock isn't acquired yet, nothing to release")
+	}
+
+	close(acquire)
+	<-job.doneChan
+	if atomic.LoadInt32(released) != 1 {
+		t.Fatalf("lock acquired after Stop should be released, released:%d", atomic.LoadInt32(released))
+	}
+	if atomic.LoadInt32(&runs) != 0 {
+		t.Fatalf("task shouldn't run after Stop")
+	}
+}